@@ -1,24 +1,37 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"log/slog"
 	"math/rand"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/cache"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/client"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/control"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/display"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/health"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/hooks"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/installer"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/logging"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/mtls"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/scanner"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/selfupdate"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/service"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/system"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/tracing"
 	"github.com/google/uuid"
 )
 
@@ -47,13 +60,28 @@ func getCurrentPollingInterval(cfg *config.Config) int {
 		cfg.RapidPollingUntil = time.Time{}
 		// Save the updated config to clean up expired rapid mode
 		if err := cfg.Save(getConfigPath()); err != nil {
-			log.Printf("Warning: Failed to cleanup expired rapid polling mode: %v", err)
+			slog.Warn("failed to clean up expired rapid polling mode", "error", err)
 		}
 	}
 
 	return cfg.CheckInInterval // Normal polling: 5 minutes (300 seconds) by default
 }
 
+// agentCommands are the command types the check-in loop handles
+var agentCommands = []string{
+	"scan_updates", "collect_specs", "dry_run_update", "install_updates", "confirm_dependencies",
+	"rollback_update", "enable_heartbeat", "disable_heartbeat", "reboot", "update_agent",
+}
+
+// agentCapabilities reports what this agent can run on this host
+func agentCapabilities() *client.Capabilities {
+	return &client.Capabilities{
+		Commands:   agentCommands,
+		Scanners:   scanner.Available(),
+		Installers: installer.AvailablePackageTypes(),
+	}
+}
+
 // getDefaultServerURL returns the default server URL with environment variable support
 func getDefaultServerURL() string {
 	// Check environment variable first
@@ -70,6 +98,29 @@ func getDefaultServerURL() string {
 }
 
 func main() {
+	// Subcommands that talk to the running agent over its control socket
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "status", "scan-now", "check-in-now":
+			if err := handleControlCommand(os.Args[1], os.Args[2:]); err != nil {
+				log.Fatalf("%s failed: %v", os.Args[1], err)
+			}
+			return
+		case installer.PodmanRestartCommand:
+			// Root helper for the Podman installer; see installer.RestartPodmanUnit
+			if err := installer.RestartPodmanUnit(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("%s failed: %v", os.Args[1], err)
+			}
+			return
+		case installer.ComposeUpdateCommand:
+			// Root helper for the Docker installer; see installer.UpdateComposeService
+			if err := installer.UpdateComposeService(os.Args[2:], os.Stdout); err != nil {
+				log.Fatalf("%s failed: %v", os.Args[1], err)
+			}
+			return
+		}
+	}
+
 	// Define CLI flags
 	registerCmd := flag.Bool("register", false, "Register agent with server")
 	scanCmd := flag.Bool("scan", false, "Scan for updates and display locally")
@@ -88,6 +139,7 @@ func main() {
 	displayName := flag.String("name", "", "Display name for agent")
 	insecureTLS := flag.Bool("insecure-tls", false, "Skip TLS certificate verification")
 	exportFormat := flag.String("export", "", "Export format: json, csv")
+	applyUpdateCmd := flag.Bool("apply-update", false, "Apply a staged agent update (run by redflag-agent-update.service)")
 
 	// Windows service management commands
 	installServiceCmd := flag.Bool("install-service", false, "Install as Windows service")
@@ -182,6 +234,20 @@ func main() {
 		log.Fatal("Failed to load configuration:", err)
 	}
 
+	// Every server and registry request goes through the configured proxy/TLS/retry transport
+	if err := client.ConfigureTransport(cfg); err != nil {
+		log.Fatal("Failed to configure HTTP transport:", err)
+	}
+
+	// Handle staged self-update (runs as root from the update unit)
+	if *applyUpdateCmd {
+		if err := selfupdate.Apply(selfupdate.DefaultDir(), AgentVersion); err != nil {
+			log.Fatalf("Agent update failed: %v", err)
+		}
+		slog.Info("agent update applied and confirmed")
+		os.Exit(0)
+	}
+
 	// Handle registration
 	if *registerCmd {
 		// Validate server URL for Windows users
@@ -212,6 +278,9 @@ func main() {
 		fmt.Printf("📋 Agent ID: %s\n", cfg.AgentID)
 		fmt.Printf("🌐 Server: %s\n", cfg.ServerURL)
 		fmt.Printf("⏱️  Check-in Interval: %ds\n", cfg.CheckInInterval)
+		if cfg.TLS.ServerIssued {
+			fmt.Printf("🔐 mTLS Certificate: %s\n", cfg.TLS.CertFile)
+		}
 		fmt.Println("==================================================================")
 		fmt.Println("💡 Save this Agent ID for your records!")
 		fmt.Println("🚀 You can now start the agent without flags")
@@ -248,6 +317,24 @@ func main() {
 		log.Fatal("Agent not registered. Run with -register flag first.")
 	}
 
+	// The long-running agent logs structured JSON (CLI commands above keep plain output)
+	logCloser, err := logging.Setup(cfg.Logging)
+	if err != nil {
+		log.Fatal("Failed to set up logging:", err)
+	}
+	defer logCloser.Close()
+	logging.AddSecret(cfg.Token, cfg.RefreshToken, cfg.RegistrationToken)
+
+	shutdownTracing, err := tracing.Setup(cfg.Tracing, cfg.AgentID.String(), AgentVersion)
+	if err != nil {
+		log.Fatal("Failed to set up tracing:", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+
 	// Check if running as Windows service
 	if runtime.GOOS == "windows" && service.IsService() {
 		// Run as Windows service
@@ -267,7 +354,7 @@ func registerAgent(cfg *config.Config, serverURL string) error {
 	// Get detailed system information
 	sysInfo, err := system.GetSystemInfo(AgentVersion)
 	if err != nil {
-		log.Printf("Warning: Failed to get detailed system info: %v\n", err)
+		slog.Warn("failed to get detailed system info", "error", err)
 		// Fall back to basic detection
 		hostname, _ := os.Hostname()
 		osType, osVersion, osArch := client.DetectSystem()
@@ -323,6 +410,19 @@ func registerAgent(cfg *config.Config, serverURL string) error {
 		OSArchitecture: sysInfo.OSArchitecture,
 		AgentVersion:   sysInfo.AgentVersion,
 		Metadata:       metadata,
+		ProtocolVersion: client.ProtocolVersion,
+		Capabilities:    agentCapabilities(),
+	}
+
+	// Ask for an mTLS client certificate; servers without mTLS ignore the CSR
+	var certReq *mtls.Request
+	if mtls.NeedsCertificate(cfg) {
+		certReq, err = mtls.NewRequest(sysInfo.Hostname)
+		if err != nil {
+			slog.Warn("failed to create certificate request", "error", err)
+		} else {
+			req.CSR = certReq.CSR
+		}
 	}
 
 	resp, err := apiClient.Register(req)
@@ -343,40 +443,141 @@ func registerAgent(cfg *config.Config, serverURL string) error {
 		cfg.CheckInInterval = 300 // Default 5 minutes
 	}
 
+	// Self-update only trusts a root-owned key, so it can only be installed
+	// when registering as root; the agent's own config is not trusted for it
+	if key, ok := resp.Config["update_public_key"].(string); ok && key != "" {
+		if os.Geteuid() == 0 && runtime.GOOS == "linux" {
+			if err := selfupdate.InstallTrustedKey(key); err != nil {
+				fmt.Printf("⚠️  Failed to install update public key: %v\n", err)
+			}
+		} else if _, err := selfupdate.TrustedKey(); err != nil && runtime.GOOS == "linux" {
+			fmt.Printf("⚠️  Self-update disabled until root installs the update public key in %s\n", selfupdate.KeyFile)
+		}
+	}
+
+	if resp.Certificate != nil && certReq != nil {
+		return installCertificate(cfg, certReq, resp.Certificate)
+	}
+
 	// Save configuration
 	return cfg.Save(getConfigPath())
 }
 
+// installCertificate stores a server-issued mTLS client certificate with the
+// config and rebuilds the transport so new clients present it
+func installCertificate(cfg *config.Config, certReq *mtls.Request, cert *client.AgentCertificate) error {
+	if err := certReq.Install(cfg, filepath.Dir(getConfigPath()), cert.Certificate); err != nil {
+		return fmt.Errorf("failed to install client certificate: %w", err)
+	}
+	if err := cfg.Save(getConfigPath()); err != nil {
+		return err
+	}
+	slog.Info("client certificate installed", "expires", cert.NotAfter.Format(time.RFC3339))
+	return client.ConfigureTransport(cfg)
+}
+
+// renewCertificateIfNeeded renews the server-issued mTLS client certificate
+// once less than a third of its lifetime is left
+func renewCertificateIfNeeded(apiClient *client.Client, cfg *config.Config) {
+	if !mtls.NeedsRenewal(cfg) {
+		return
+	}
+
+	certReq, err := mtls.NewRequest(cfg.AgentID.String())
+	if err != nil {
+		slog.Error("failed to create certificate request", "error", err)
+		return
+	}
+	cert, err := apiClient.RenewCertificate(cfg.AgentID, certReq.CSR)
+	if err != nil {
+		slog.Error("failed to renew client certificate", "error", err)
+		return
+	}
+	if err := installCertificate(cfg, certReq, cert); err != nil {
+		slog.Error("failed to save renewed client certificate", "error", err)
+	}
+}
+
+// applyServerConfig applies the configuration profile delivered with the last
+// check-in, if it differs from the one already applied. The result is
+// acknowledged in the next check-in's metrics.
+func applyServerConfig(apiClient *client.Client, cfg *config.Config) error {
+	managed := apiClient.ManagedConfig
+	if managed == nil {
+		return nil
+	}
+	if cfg.Managed == nil && managed.ProfileID == "" {
+		return nil
+	}
+	if cfg.Managed != nil && cfg.Managed.ProfileID == managed.ProfileID && cfg.Managed.Version == managed.Version {
+		return nil
+	}
+
+	if err := cfg.ApplyManaged(managed); err != nil {
+		return fmt.Errorf("profile %s version %d: %w", managed.ProfileID, managed.Version, err)
+	}
+	logging.SetLevel(cfg.Logging.Level)
+	if err := cfg.Save(getConfigPath()); err != nil {
+		slog.Warn("failed to save server configuration", "error", err)
+	}
+
+	if managed.ProfileID == "" {
+		slog.Info("server configuration profile removed, using local settings")
+	} else {
+		slog.Info("applied server configuration profile", "profile", managed.ProfileName, "version", managed.Version)
+	}
+	return nil
+}
+
 // renewTokenIfNeeded handles 401 errors by renewing the agent token using refresh token
 func renewTokenIfNeeded(apiClient *client.Client, cfg *config.Config, err error) (*client.Client, error) {
 	if err != nil && strings.Contains(err.Error(), "401 Unauthorized") {
-		log.Printf("🔄 Access token expired - attempting renewal with refresh token...")
+		slog.Info("access token expired, renewing with refresh token")
 
 		// Check if we have a refresh token
 		if cfg.RefreshToken == "" {
-			log.Printf("❌ No refresh token available - re-registration required")
+			slog.Error("no refresh token available, re-registration required")
 			return nil, fmt.Errorf("refresh token missing - please re-register agent")
 		}
 
 		// Create temporary client without token for renewal
 		tempClient := client.NewClient(cfg.ServerURL, "")
 
+		// Without a usable client certificate the 401 may be the server
+		// requiring mTLS, so ask for one along with the token
+		var certReq *mtls.Request
+		var csr string
+		if mtls.NeedsCertificate(cfg) {
+			if r, err := mtls.NewRequest(cfg.AgentID.String()); err == nil {
+				certReq, csr = r, r.CSR
+			}
+		}
+
 		// Attempt to renew access token using refresh token
-		if err := tempClient.RenewToken(cfg.AgentID, cfg.RefreshToken); err != nil {
-			log.Printf("❌ Refresh token renewal failed: %v", err)
-			log.Printf("💡 Refresh token may be expired (>90 days) - re-registration required")
+		cert, err := tempClient.RenewTokenWithCSR(cfg.AgentID, cfg.RefreshToken, csr)
+		if err != nil {
+			slog.Error("refresh token renewal failed, the refresh token may have expired and re-registration may be required", "error", err)
 			return nil, fmt.Errorf("refresh token renewal failed: %w - please re-register agent", err)
 		}
 
 		// Update config with new access token (agent ID and refresh token stay the same!)
 		cfg.Token = tempClient.GetToken()
+		logging.AddSecret(cfg.Token)
+
+		if cert != nil && certReq != nil {
+			if err := installCertificate(cfg, certReq, cert); err != nil {
+				slog.Warn("failed to install client certificate", "error", err)
+			}
+			// Pick up the transport presenting the new certificate
+			tempClient = client.NewClient(cfg.ServerURL, cfg.Token)
+		}
 
 		// Save updated config
 		if err := cfg.Save(getConfigPath()); err != nil {
-			log.Printf("⚠️  Warning: Failed to save renewed access token: %v", err)
+			slog.Warn("failed to save renewed access token", "error", err)
 		}
 
-		log.Printf("✅ Access token renewed successfully - agent ID maintained: %s", cfg.AgentID)
+		slog.Info("access token renewed", "agent_id", cfg.AgentID)
 		return tempClient, nil
 	}
 
@@ -385,21 +586,44 @@ func renewTokenIfNeeded(apiClient *client.Client, cfg *config.Config, err error)
 }
 
 func runAgent(cfg *config.Config) error {
-	log.Printf("🚩 RedFlag Agent v%s starting...\n", AgentVersion)
-	log.Printf("==================================================================")
-	log.Printf("📋 AGENT ID: %s", cfg.AgentID)
-	log.Printf("🌐 SERVER: %s", cfg.ServerURL)
-	log.Printf("⏱️  CHECK-IN INTERVAL: %ds", cfg.CheckInInterval)
-	log.Printf("==================================================================")
-	log.Printf("💡 Tip: Use this Agent ID to identify this agent in the web UI")
-	log.Printf("")
+	// Use the agent ID to identify this agent in the web UI
+	slog.Info("RedFlag Agent starting", "version", AgentVersion, "agent_id", cfg.AgentID,
+		"server", cfg.ServerURL, "check_in_interval_seconds", cfg.CheckInInterval)
+
+	// Reports that fail to upload wait in the outbox for the next check-in
+	if err := client.EnableOutbox(client.DefaultOutboxDir()); err != nil {
+		slog.Warn("offline outbox disabled", "error", err)
+	}
 
 	apiClient := client.NewClient(cfg.ServerURL, cfg.Token)
 
+	// What the agent is doing, for the local control socket
+	tracker := control.NewTracker(cfg.AgentID.String(), AgentVersion, os.Getpid(), client.OutboxDepth)
+	if cfg.Managed != nil {
+		tracker.SetConfig(cfg.Managed.ProfileName, cfg.Managed.Version, "")
+	}
+	if !cfg.Control.Disabled {
+		if listener, err := control.Listen(cfg.Control.Socket, cfg.Control.Group); err != nil {
+			slog.Warn("control socket disabled", "error", err)
+		} else {
+			defer listener.Close()
+			go func() {
+				if err := control.Serve(listener, tracker); err != nil {
+					slog.Error("control socket stopped", "error", err)
+				}
+			}()
+		}
+	}
+
 	// Initialize scanners
 	aptScanner := scanner.NewAPTScanner()
 	dnfScanner := scanner.NewDNFScanner()
 	dockerScanner, _ := scanner.NewDockerScanner()
+	if dockerScanner != nil {
+		dockerScanner.SetTagTracking(cfg.Docker.TagTracking)
+	}
+	podmanScanner := scanner.NewPodmanScanner()
+	podmanScanner.SetTagTracking(cfg.Docker.TagTracking)
 	windowsUpdateScanner := scanner.NewWindowsUpdateScanner()
 	wingetScanner := scanner.NewWingetScanner()
 
@@ -407,24 +631,129 @@ func runAgent(cfg *config.Config) error {
 	var lastSystemInfoUpdate time.Time
 	const systemInfoUpdateInterval = 1 * time.Hour // Update detailed system info every hour
 
+	// Reported with check-ins; refreshed with the system info in case tools were installed
+	capabilities := agentCapabilities()
+
+	// mTLS certificate renewal is checked on the same cadence
+	var lastCertificateCheck time.Time
+
+	// Why the last server configuration profile was rejected, reported with check-ins
+	var configError string
+
+	// runCommands processes commands received at a check-in or from a long poll
+	runCommands := func(commands []client.Command) {
+		for _, cmd := range commands {
+			slog.Info("processing command", "command_type", cmd.Type, "command_id", cmd.ID)
+
+			// Continue the server's trace for this command; requests made while
+			// handling it carry the trace so the whole install can be followed
+			ctx, span := tracing.StartCommand(cmd.ID, cmd.Type, cmd.Params)
+			cmdClient := apiClient.WithContext(ctx)
+			var cmdErr error
+			tracker.SetState(control.StateForCommand(cmd.Type), cmd.Type, cmd.ID)
+
+			switch cmd.Type {
+			case "scan_updates":
+				if cmdErr = handleScanUpdates(cmdClient, cfg, aptScanner, dnfScanner, dockerScanner, podmanScanner, windowsUpdateScanner, wingetScanner, cmd.ID); cmdErr != nil {
+					slog.Error("failed to scan for updates", "command_id", cmd.ID, "error", cmdErr)
+				}
+
+			case "collect_specs":
+				if cmdErr = handleCollectSpecs(cmdClient, cfg, cmd.ID); cmdErr != nil {
+					slog.Error("failed to collect system specs", "command_id", cmd.ID, "error", cmdErr)
+				}
+
+			case "dry_run_update":
+				if cmdErr = handleDryRunUpdate(cmdClient, cfg, cmd.ID, cmd.Params); cmdErr != nil {
+					slog.Error("failed to dry run update", "command_id", cmd.ID, "error", cmdErr)
+				}
+
+			case "install_updates":
+				if cmdErr = handleInstallUpdates(cmdClient, cfg, cmd.ID, cmd.Params); cmdErr != nil {
+					slog.Error("failed to install updates", "command_id", cmd.ID, "error", cmdErr)
+				}
+
+			case "confirm_dependencies":
+				if cmdErr = handleConfirmDependencies(cmdClient, cfg, cmd.ID, cmd.Params); cmdErr != nil {
+					slog.Error("failed to confirm dependencies", "command_id", cmd.ID, "error", cmdErr)
+				}
+
+			case "rollback_update":
+				if cmdErr = handleRollbackUpdate(cmdClient, cfg, cmd.ID, cmd.Params); cmdErr != nil {
+					slog.Error("failed to roll back update", "command_id", cmd.ID, "error", cmdErr)
+				}
+
+			case "enable_heartbeat":
+				if cmdErr = handleEnableHeartbeat(cmdClient, cfg, cmd.ID, cmd.Params); cmdErr != nil {
+					slog.Error("failed to enable heartbeat", "command_id", cmd.ID, "error", cmdErr)
+				}
+
+			case "disable_heartbeat":
+				if cmdErr = handleDisableHeartbeat(cmdClient, cfg, cmd.ID); cmdErr != nil {
+					slog.Error("failed to disable heartbeat", "command_id", cmd.ID, "error", cmdErr)
+				}
+
+			case "reboot":
+				if cmdErr = handleReboot(cmdClient, cfg, cmd.ID, cmd.Params); cmdErr != nil {
+					slog.Error("failed to process reboot command", "command_id", cmd.ID, "error", cmdErr)
+				}
+
+			case "update_agent":
+				if cmdErr = handleUpdateAgent(cmdClient, cfg, cmd.ID, cmd.Params); cmdErr != nil {
+					slog.Error("failed to update agent", "command_id", cmd.ID, "error", cmdErr)
+				}
+			default:
+				slog.Warn("unknown command type", "command_type", cmd.Type, "command_id", cmd.ID)
+			}
+			tracing.End(span, cmdErr)
+			if cmdErr != nil {
+				tracker.RecordError(fmt.Errorf("%s %s: %w", cmd.Type, cmd.ID, cmdErr))
+			}
+		}
+	}
+
+	// Set when the last wait was cut short by a scan-now or check-in-now request
+	requested := false
+
 	// Main check-in loop
 	for {
-		// Add jitter to prevent thundering herd
-		jitter := time.Duration(rand.Intn(30)) * time.Second
-		time.Sleep(jitter)
+		// Add jitter to prevent thundering herd (not for a requested check-in)
+		if !requested {
+			jitter := time.Duration(rand.Intn(30)) * time.Second
+			requested = tracker.Sleep(jitter)
+		}
+		requested = false
+
+		// Scan requested over the control socket; results are reported like a scheduled scan
+		if tracker.TakeScanRequest() {
+			tracker.SetState(control.StateScanning, "scan_updates", "")
+			if err := handleScanUpdates(apiClient, cfg, aptScanner, dnfScanner, dockerScanner, podmanScanner, windowsUpdateScanner, wingetScanner, ""); err != nil {
+				slog.Error("failed to scan for updates", "error", err)
+				tracker.RecordError(fmt.Errorf("requested scan: %w", err))
+			}
+			tracker.SetIdle()
+		}
 
 		// Check if we need to send detailed system info update
 		if time.Since(lastSystemInfoUpdate) >= systemInfoUpdateInterval {
-			log.Printf("Updating detailed system information...")
+			slog.Debug("updating detailed system information")
 			if err := reportSystemInfo(apiClient, cfg); err != nil {
-				log.Printf("Failed to report system info: %v\n", err)
+				slog.Error("failed to report system info", "error", err)
+				tracker.RecordError(err)
 			} else {
 				lastSystemInfoUpdate = time.Now()
-				log.Printf("✓ System information updated\n")
+				slog.Info("system information updated")
 			}
+			capabilities = agentCapabilities()
 		}
 
-		log.Printf("Checking in with server... (Agent v%s)", AgentVersion)
+		if time.Since(lastCertificateCheck) >= systemInfoUpdateInterval {
+			renewCertificateIfNeeded(apiClient, cfg)
+			lastCertificateCheck = time.Now()
+		}
+
+		slog.Debug("checking in with server", "version", AgentVersion)
+		tracker.SetState(control.StateCheckingIn, "", "")
 
 		// Collect lightweight system metrics
 		sysMetrics, err := system.GetLightweightMetrics()
@@ -440,6 +769,14 @@ func runAgent(cfg *config.Config) error {
 				DiskPercent:   sysMetrics.DiskPercent,
 				Uptime:        sysMetrics.Uptime,
 				Version:       AgentVersion,
+				ConfigError:   configError,
+
+				ProtocolVersion: client.ProtocolVersion,
+				Capabilities:    capabilities,
+			}
+			if cfg.Managed != nil {
+				metrics.ConfigProfileID = cfg.Managed.ProfileID
+				metrics.ConfigVersion = cfg.Managed.Version
 			}
 		}
 
@@ -467,105 +804,172 @@ func runAgent(cfg *config.Config) error {
 			// Try to renew token if we got a 401 error
 			newClient, renewErr := renewTokenIfNeeded(apiClient, cfg, err)
 			if renewErr != nil {
-				log.Printf("Check-in unsuccessful and token renewal failed: %v\n", renewErr)
-				time.Sleep(time.Duration(getCurrentPollingInterval(cfg)) * time.Second)
+				slog.Error("check-in unsuccessful and token renewal failed", "error", renewErr)
+				tracker.RecordError(fmt.Errorf("check-in: %w", renewErr))
+				tracker.SetIdle()
+				requested = tracker.Sleep(time.Duration(getCurrentPollingInterval(cfg)) * time.Second)
 				continue
 			}
 
 			// If token was renewed, update client and retry
 			if newClient != apiClient {
-				log.Printf("🔄 Retrying check-in with renewed token...")
+				slog.Info("retrying check-in with renewed token")
 				apiClient = newClient
 				commands, err = apiClient.GetCommands(cfg.AgentID, metrics)
 				if err != nil {
-					log.Printf("Check-in unsuccessful even after token renewal: %v\n", err)
-					time.Sleep(time.Duration(getCurrentPollingInterval(cfg)) * time.Second)
+					slog.Error("check-in unsuccessful even after token renewal", "error", err)
+					tracker.RecordError(fmt.Errorf("check-in: %w", err))
+					tracker.SetIdle()
+					requested = tracker.Sleep(time.Duration(getCurrentPollingInterval(cfg)) * time.Second)
 					continue
 				}
 			} else {
-				log.Printf("Check-in unsuccessful: %v\n", err)
-				time.Sleep(time.Duration(getCurrentPollingInterval(cfg)) * time.Second)
+				slog.Warn("check-in unsuccessful", "error", err)
+				tracker.RecordError(fmt.Errorf("check-in: %w", err))
+				tracker.SetIdle()
+				requested = tracker.Sleep(time.Duration(getCurrentPollingInterval(cfg)) * time.Second)
 				continue
 			}
 		}
+		tracker.CheckedIn()
+
+		// Deliver results queued while the server was unreachable
+		if sent, err := apiClient.FlushOutbox(cfg.AgentID); err != nil {
+			slog.Warn("outbox replay stopped", "sent", sent, "error", err)
+			tracker.RecordError(fmt.Errorf("outbox replay: %w", err))
+		} else if sent > 0 {
+			slog.Info("delivered queued reports from the outbox", "sent", sent)
+		}
 
-		if len(commands) == 0 {
-			log.Printf("Check-in successful - no new commands")
+		if err := applyServerConfig(apiClient, cfg); err != nil {
+			slog.Warn("rejected server configuration", "error", err)
+			configError = err.Error()
 		} else {
-			log.Printf("Check-in successful - received %d command(s)", len(commands))
+			configError = ""
+		}
+		if cfg.Managed != nil {
+			tracker.SetConfig(cfg.Managed.ProfileName, cfg.Managed.Version, configError)
+		} else {
+			tracker.SetConfig("", 0, configError)
 		}
 
-		// Process each command
-		for _, cmd := range commands {
-			log.Printf("Processing command: %s (%s)\n", cmd.Type, cmd.ID)
-
-			switch cmd.Type {
-			case "scan_updates":
-				if err := handleScanUpdates(apiClient, cfg, aptScanner, dnfScanner, dockerScanner, windowsUpdateScanner, wingetScanner, cmd.ID); err != nil {
-					log.Printf("Error scanning updates: %v\n", err)
-				}
-
-			case "collect_specs":
-				log.Println("Spec collection not yet implemented")
-
-			case "dry_run_update":
-				if err := handleDryRunUpdate(apiClient, cfg, cmd.ID, cmd.Params); err != nil {
-					log.Printf("Error dry running update: %v\n", err)
-				}
-
-			case "install_updates":
-				if err := handleInstallUpdates(apiClient, cfg, cmd.ID, cmd.Params); err != nil {
-					log.Printf("Error installing updates: %v\n", err)
-				}
+		// A freshly updated binary confirms itself once it can check in
+		reportSelfUpdateResult(apiClient, cfg)
+		runPostRebootChecks(apiClient, cfg)
 
-			case "confirm_dependencies":
-				if err := handleConfirmDependencies(apiClient, cfg, cmd.ID, cmd.Params); err != nil {
-					log.Printf("Error confirming dependencies: %v\n", err)
-				}
+		if len(commands) == 0 {
+			slog.Debug("check-in successful, no new commands")
+		} else {
+			slog.Info("check-in successful", "commands", len(commands))
+		}
 
-			case "enable_heartbeat":
-				if err := handleEnableHeartbeat(apiClient, cfg, cmd.ID, cmd.Params); err != nil {
-					log.Printf("[Heartbeat] Error enabling heartbeat: %v\n", err)
-				}
+		runCommands(commands)
+		tracker.SetIdle()
 
-			case "disable_heartbeat":
-				if err := handleDisableHeartbeat(apiClient, cfg, cmd.ID); err != nil {
-					log.Printf("[Heartbeat] Error disabling heartbeat: %v\n", err)
-				}
+		// Wait for the next check-in, running commands the server pushes meanwhile
+		requested = waitForNextCheckIn(apiClient, cfg, tracker, runCommands)
+	}
+}
 
+// waitForNextCheckIn waits until the next check-in is due. When the server
+// supports long polling the wait is spent in command polls the server holds
+// open, so commands queued meanwhile run within a second instead of at the
+// next check-in. Returns true if a control socket request cut the wait short.
+func waitForNextCheckIn(apiClient *client.Client, cfg *config.Config, tracker *control.Tracker, runCommands func([]client.Command)) bool {
+	next := time.Now().Add(time.Duration(getCurrentPollingInterval(cfg)) * time.Second)
 
-			case "reboot":
-				if err := handleReboot(apiClient, cfg, cmd.ID, cmd.Params); err != nil {
-					log.Printf("[Reboot] Error processing reboot command: %v\n", err)
-				}
-			default:
-				log.Printf("Unknown command type: %s\n", cmd.Type)
+	for {
+		done := pendingPoll
+		if done == nil {
+			if cfg.Network.DisableLongPoll || !time.Now().After(longPollRetryAt) {
+				break
+			}
+			wait := time.Until(next)
+			if wait < time.Second {
+				return false
 			}
+			done = make(chan pollResult, 1)
+			go func() {
+				commands, supported, err := apiClient.WaitForCommands(cfg.AgentID, wait)
+				done <- pollResult{commands, supported, err}
+			}()
+		}
+		pendingPoll = nil
+		tracker.SetNextCheckIn(next)
+
+		// The server marks commands sent as it answers, so a poll is never
+		// cancelled: one still open when the wait ends is picked up by the next
+		timer := time.NewTimer(time.Until(next))
+		var result pollResult
+		select {
+		case result = <-done:
+			timer.Stop()
+		case <-timer.C:
+			pendingPoll = done
+			return false
+		case <-tracker.Woken():
+			timer.Stop()
+			pendingPoll = done
+			return true
 		}
 
-		// Wait for next check-in
-		time.Sleep(time.Duration(getCurrentPollingInterval(cfg)) * time.Second)
+		if len(result.commands) > 0 {
+			slog.Info("received commands while waiting for the next check-in", "count", len(result.commands))
+			runCommands(result.commands)
+			tracker.SetIdle()
+		}
+		if result.err != nil {
+			// Wait out the interval; the check-in retries and renews the token if needed
+			slog.Warn("long poll unsuccessful", "error", result.err)
+			break
+		}
+		if !result.supported {
+			slog.Info("server doesn't support long polling, polling for commands at each check-in")
+			longPollRetryAt = time.Now().Add(longPollRetryInterval)
+			break
+		}
 	}
+
+	return tracker.Sleep(time.Until(next))
 }
 
-func handleScanUpdates(apiClient *client.Client, cfg *config.Config, aptScanner *scanner.APTScanner, dnfScanner *scanner.DNFScanner, dockerScanner *scanner.DockerScanner, windowsUpdateScanner *scanner.WindowsUpdateScanner, wingetScanner *scanner.WingetScanner, commandID string) error {
-	log.Println("Scanning for updates...")
+// pollResult is the outcome of a long poll for commands
+type pollResult struct {
+	commands  []client.Command
+	supported bool
+	err       error
+}
+
+// pendingPoll is a long poll left open when a wait ended before the server
+// answered; the next wait collects its commands
+var pendingPoll chan pollResult
+
+// After a server answers a long poll without supporting it, the agent only
+// polls at check-ins until longPollRetryAt, in case the server is upgraded
+const longPollRetryInterval = time.Hour
+
+var longPollRetryAt time.Time
+
+func handleScanUpdates(apiClient *client.Client, cfg *config.Config, aptScanner *scanner.APTScanner, dnfScanner *scanner.DNFScanner, dockerScanner *scanner.DockerScanner, podmanScanner *scanner.PodmanScanner, windowsUpdateScanner *scanner.WindowsUpdateScanner, wingetScanner *scanner.WingetScanner, commandID string) error {
+	slog.Info("scanning for updates")
 
 	var allUpdates []client.UpdateReportItem
 	var scanErrors []string
 	var scanResults []string
 
 	// Scan APT updates
-	if aptScanner.IsAvailable() {
-		log.Println("  - Scanning APT packages...")
+	if !cfg.ScannerEnabled("apt") {
+		scanResults = append(scanResults, "APT scanner disabled by configuration")
+	} else if aptScanner.IsAvailable() {
+		slog.Info("scanning apt packages")
 		updates, err := aptScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("APT scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("apt scan failed", "error", err)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d APT updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("apt scan complete", "updates", len(updates))
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
 		}
@@ -574,16 +978,18 @@ func handleScanUpdates(apiClient *client.Client, cfg *config.Config, aptScanner
 	}
 
 	// Scan DNF updates
-	if dnfScanner.IsAvailable() {
-		log.Println("  - Scanning DNF packages...")
+	if !cfg.ScannerEnabled("dnf") {
+		scanResults = append(scanResults, "DNF scanner disabled by configuration")
+	} else if dnfScanner.IsAvailable() {
+		slog.Info("scanning dnf packages")
 		updates, err := dnfScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("DNF scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("dnf scan failed", "error", err)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d DNF updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("dnf scan complete", "updates", len(updates))
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
 		}
@@ -592,16 +998,18 @@ func handleScanUpdates(apiClient *client.Client, cfg *config.Config, aptScanner
 	}
 
 	// Scan Docker updates
-	if dockerScanner != nil && dockerScanner.IsAvailable() {
-		log.Println("  - Scanning Docker images...")
+	if !cfg.ScannerEnabled("docker") {
+		scanResults = append(scanResults, "Docker scanner disabled by configuration")
+	} else if dockerScanner != nil && dockerScanner.IsAvailable() {
+		slog.Info("scanning docker images")
 		updates, err := dockerScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("Docker scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("docker scan failed", "error", err)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d Docker image updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("docker scan complete", "updates", len(updates))
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
 		}
@@ -609,17 +1017,39 @@ func handleScanUpdates(apiClient *client.Client, cfg *config.Config, aptScanner
 		scanResults = append(scanResults, "Docker scanner not available")
 	}
 
+	// Scan Podman updates
+	if !cfg.ScannerEnabled("podman") {
+		scanResults = append(scanResults, "Podman scanner disabled by configuration")
+	} else if podmanScanner.IsAvailable() {
+		slog.Info("scanning podman images")
+		updates, err := podmanScanner.Scan()
+		if err != nil {
+			errorMsg := fmt.Sprintf("Podman scan failed: %v", err)
+			slog.Error("podman scan failed", "error", err)
+			scanErrors = append(scanErrors, errorMsg)
+		} else {
+			resultMsg := fmt.Sprintf("Found %d Podman image updates", len(updates))
+			slog.Info("podman scan complete", "updates", len(updates))
+			scanResults = append(scanResults, resultMsg)
+			allUpdates = append(allUpdates, updates...)
+		}
+	} else {
+		scanResults = append(scanResults, "Podman scanner not available")
+	}
+
 	// Scan Windows updates
-	if windowsUpdateScanner.IsAvailable() {
-		log.Println("  - Scanning Windows updates...")
+	if !cfg.ScannerEnabled("windows_update") {
+		scanResults = append(scanResults, "Windows Update scanner disabled by configuration")
+	} else if windowsUpdateScanner.IsAvailable() {
+		slog.Info("scanning windows updates")
 		updates, err := windowsUpdateScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("Windows Update scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("windows update scan failed", "error", err)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d Windows updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("windows update scan complete", "updates", len(updates))
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
 		}
@@ -628,16 +1058,18 @@ func handleScanUpdates(apiClient *client.Client, cfg *config.Config, aptScanner
 	}
 
 	// Scan Winget packages
-	if wingetScanner.IsAvailable() {
-		log.Println("  - Scanning Winget packages...")
+	if !cfg.ScannerEnabled("winget") {
+		scanResults = append(scanResults, "Winget scanner disabled by configuration")
+	} else if wingetScanner.IsAvailable() {
+		slog.Info("scanning winget packages")
 		updates, err := wingetScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("Winget scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("winget scan failed", "error", err)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d Winget package updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("winget scan complete", "updates", len(updates))
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
 		}
@@ -679,7 +1111,7 @@ func handleScanUpdates(apiClient *client.Client, cfg *config.Config, aptScanner
 
 	// Report the scan log
 	if err := apiClient.ReportLog(cfg.AgentID, logReport); err != nil {
-		log.Printf("Failed to report scan log: %v\n", err)
+		slog.Error("failed to report scan log", "error", err)
 		// Continue anyway - updates are more important
 	}
 
@@ -695,9 +1127,9 @@ func handleScanUpdates(apiClient *client.Client, cfg *config.Config, aptScanner
 			return fmt.Errorf("failed to report updates: %w", err)
 		}
 
-		log.Printf("✓ Reported %d updates to server\n", len(allUpdates))
+		slog.Info("reported updates to server", "updates", len(allUpdates))
 	} else {
-		log.Println("✓ No updates found")
+		slog.Info("no updates found")
 	}
 
 	// Return error if there were any scan failures
@@ -714,6 +1146,11 @@ func handleScanCommand(cfg *config.Config, exportFormat string) error {
 	aptScanner := scanner.NewAPTScanner()
 	dnfScanner := scanner.NewDNFScanner()
 	dockerScanner, _ := scanner.NewDockerScanner()
+	if dockerScanner != nil {
+		dockerScanner.SetTagTracking(cfg.Docker.TagTracking)
+	}
+	podmanScanner := scanner.NewPodmanScanner()
+	podmanScanner.SetTagTracking(cfg.Docker.TagTracking)
 	windowsUpdateScanner := scanner.NewWindowsUpdateScanner()
 	wingetScanner := scanner.NewWingetScanner()
 
@@ -756,6 +1193,18 @@ func handleScanCommand(cfg *config.Config, exportFormat string) error {
 		}
 	}
 
+	// Scan Podman updates
+	if podmanScanner.IsAvailable() {
+		fmt.Println("  - Scanning Podman images...")
+		updates, err := podmanScanner.Scan()
+		if err != nil {
+			fmt.Printf("    ⚠️  Podman scan failed: %v\n", err)
+		} else {
+			fmt.Printf("    ✓ Found %d Podman image updates\n", len(updates))
+			allUpdates = append(allUpdates, updates...)
+		}
+	}
+
 	// Scan Windows updates
 	if windowsUpdateScanner.IsAvailable() {
 		fmt.Println("  - Scanning Windows updates...")
@@ -845,6 +1294,71 @@ func handleStatusCommand(cfg *config.Config) error {
 	return nil
 }
 
+// handleControlCommand runs the status, scan-now and check-in-now
+// subcommands. "status" without --live shows the cached status like -status.
+func handleControlCommand(name string, args []string) error {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	live := fs.Bool("live", false, "Ask the running agent over its control socket (status only)")
+	jsonOutput := fs.Bool("json", false, "Print the live status as JSON (status only)")
+	configFile := fs.String("config", "", "Configuration file path")
+	socket := fs.String("socket", "", "Control socket path")
+	fs.Parse(args)
+
+	configPath := getConfigPath()
+	if *configFile != "" {
+		configPath = *configFile
+	}
+	// Falls back to defaults when the config isn't readable (e.g. run by a
+	// member of the redflag group rather than root)
+	cfg, err := config.Load(configPath, nil)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	if name == "status" && !*live {
+		return handleStatusCommand(cfg)
+	}
+
+	socketPath := *socket
+	if socketPath == "" {
+		socketPath = cfg.Control.Socket
+	}
+	if socketPath == "" {
+		socketPath = control.DefaultSocket
+	}
+	controlClient := control.NewClient(socketPath)
+
+	switch name {
+	case "status":
+		status, err := controlClient.Status()
+		if err != nil {
+			return err
+		}
+		if *jsonOutput {
+			data, err := json.MarshalIndent(status, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+		display.PrintLiveStatus(status)
+
+	case "scan-now":
+		if err := controlClient.ScanNow(); err != nil {
+			return err
+		}
+		fmt.Println("✓ Scan requested - the agent will scan for updates and check in now")
+
+	case "check-in-now":
+		if err := controlClient.CheckInNow(); err != nil {
+			return err
+		}
+		fmt.Println("✓ Check-in requested")
+	}
+	return nil
+}
+
 // handleListUpdatesCommand displays detailed update information
 func handleListUpdatesCommand(cfg *config.Config, exportFormat string) error {
 	// Load cache
@@ -872,7 +1386,7 @@ func handleListUpdatesCommand(cfg *config.Config, exportFormat string) error {
 
 // handleInstallUpdates handles install_updates command
 func handleInstallUpdates(apiClient *client.Client, cfg *config.Config, commandID string, params map[string]interface{}) error {
-	log.Println("Installing updates...")
+	slog.Info("installing updates", "command_id", commandID)
 
 	// Parse parameters
 	packageType := ""
@@ -901,40 +1415,68 @@ func handleInstallUpdates(apiClient *client.Client, cfg *config.Config, commandI
 		return fmt.Errorf("%s installer is not available on this system", packageType)
 	}
 
+	// Pre-install hooks (drain a node, stop services...) run before anything is touched
+	details := map[string]interface{}{}
+	hookEnv := hooks.Env(commandID, packageType, requestedPackages(packageName, params))
+	if err := runHooks(apiClient, cfg, commandID, hooks.PhasePreInstall, hookEnv, details); err != nil {
+		return err
+	}
+
+	// Snapshot the root filesystem first if configured
+	if err := takePreInstallSnapshot(cfg, packageType, details); err != nil {
+		logReport := client.LogReport{
+			CommandID: commandID,
+			Action:    "snapshot",
+			Result:    "failed",
+			Stderr:    err.Error(),
+			ExitCode:  1,
+			Details:   details,
+		}
+		if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
+			slog.Error("failed to report snapshot failure", "command_id", commandID, "error", reportErr)
+		}
+		return err
+	}
+
+	// Stream output to the server while the installer runs
+	output := client.NewOutputStream(apiClient, cfg.AgentID, commandID)
+	inst.SetOutputHandler(output.Write)
+
 	var result *installer.InstallResult
 	var action string
 
 	// Perform installation based on what's specified
 	if packageName != "" {
 		action = "update"
-		log.Printf("Updating package: %s (type: %s)", packageName, packageType)
+		slog.Info("updating package", "package", packageName, "package_type", packageType)
 		result, err = inst.UpdatePackage(packageName)
 	} else if len(params) > 1 {
 		// Multiple packages might be specified in various ways
-		var packageNames []string
-		for key, value := range params {
-			if key != "package_type" {
-				if name, ok := value.(string); ok && name != "" {
-					packageNames = append(packageNames, name)
-				}
-			}
-		}
+		packageNames := requestedPackages("", params)
 		if len(packageNames) > 0 {
 			action = "install_multiple"
-			log.Printf("Installing multiple packages: %v (type: %s)", packageNames, packageType)
+			slog.Info("installing packages", "packages", packageNames, "package_type", packageType)
 			result, err = inst.InstallMultiple(packageNames)
 		} else {
 			// Upgrade all packages if no specific packages named
 			action = "upgrade"
-			log.Printf("Upgrading all packages (type: %s)", packageType)
+			slog.Info("upgrading all packages", "package_type", packageType)
 			result, err = inst.Upgrade()
 		}
 	} else {
 		// Upgrade all packages if no specific packages named
 		action = "upgrade"
-		log.Printf("Upgrading all packages (type: %s)", packageType)
+		slog.Info("upgrading all packages", "package_type", packageType)
 		result, err = inst.Upgrade()
 	}
+	output.Close()
+	if result != nil && len(result.ContainersUpdated) > 0 {
+		details["containers_updated"] = result.ContainersUpdated
+	}
+
+	// Post-install hooks (smoke tests, restarting services...) see the outcome
+	hookEnv["REDFLAG_INSTALL_RESULT"] = map[bool]string{true: "failed", false: "success"}[err != nil]
+	postHookErr := runHooks(nil, cfg, commandID, hooks.PhasePostInstall, hookEnv, details)
 
 	if err != nil {
 		// Report installation failure with actual command output
@@ -946,10 +1488,12 @@ func handleInstallUpdates(apiClient *client.Client, cfg *config.Config, commandI
 			Stderr:          result.Stderr,
 			ExitCode:        result.ExitCode,
 			DurationSeconds: result.DurationSeconds,
+			Details:         details,
 		}
+		appendHookFailure(&logReport, postHookErr, details)
 
 		if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
-			log.Printf("Failed to report installation failure: %v\n", reportErr)
+			slog.Error("failed to report installation failure", "command_id", commandID, "error", reportErr)
 		}
 
 		return fmt.Errorf("installation failed: %w", err)
@@ -964,6 +1508,7 @@ func handleInstallUpdates(apiClient *client.Client, cfg *config.Config, commandI
 		Stderr:          result.Stderr,
 		ExitCode:        result.ExitCode,
 		DurationSeconds: result.DurationSeconds,
+		Details:         details,
 	}
 
 	// Add additional metadata to the log report
@@ -971,18 +1516,24 @@ func handleInstallUpdates(apiClient *client.Client, cfg *config.Config, commandI
 		logReport.Stdout += fmt.Sprintf("\nPackages installed: %v", result.PackagesInstalled)
 	}
 
+	appendHookFailure(&logReport, postHookErr, details)
+
+	// The install worked; make sure what it touched still does
+	if healthErr := runHealthChecks(cfg, details); healthErr != nil {
+		appendHealthFailure(&logReport, healthErr, details)
+	}
+
 	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
-		log.Printf("Failed to report installation success: %v\n", reportErr)
+		slog.Error("failed to report installation success", "command_id", commandID, "error", reportErr)
 	}
 
 	if result.Success {
-		log.Printf("✓ Installation completed successfully in %d seconds\n", result.DurationSeconds)
+		slog.Info("installation completed", "duration_seconds", result.DurationSeconds)
 		if len(result.PackagesInstalled) > 0 {
-			log.Printf("  Packages installed: %v\n", result.PackagesInstalled)
+			slog.Info("packages installed", "packages", result.PackagesInstalled)
 		}
 	} else {
-		log.Printf("✗ Installation failed after %d seconds\n", result.DurationSeconds)
-		log.Printf("  Error: %s\n", result.ErrorMessage)
+		slog.Error("installation failed", "duration_seconds", result.DurationSeconds, "error", result.ErrorMessage)
 	}
 
 	return nil
@@ -990,7 +1541,7 @@ func handleInstallUpdates(apiClient *client.Client, cfg *config.Config, commandI
 
 // handleDryRunUpdate handles dry_run_update command
 func handleDryRunUpdate(apiClient *client.Client, cfg *config.Config, commandID string, params map[string]interface{}) error {
-	log.Println("Performing dry run update...")
+	slog.Info("performing dry run update", "command_id", commandID)
 
 	// Parse parameters
 	packageType := ""
@@ -1019,9 +1570,14 @@ func handleDryRunUpdate(apiClient *client.Client, cfg *config.Config, commandID
 		return fmt.Errorf("%s installer is not available on this system", packageType)
 	}
 
+	// Stream output to the server while the installer runs
+	output := client.NewOutputStream(apiClient, cfg.AgentID, commandID)
+	inst.SetOutputHandler(output.Write)
+
 	// Perform dry run
-	log.Printf("Dry running package: %s (type: %s)", packageName, packageType)
+	slog.Info("dry running package", "package", packageName, "package_type", packageType)
 	result, err := inst.DryRun(packageName)
+	output.Close()
 	if err != nil {
 		// Report dry run failure
 		logReport := client.LogReport{
@@ -1035,7 +1591,7 @@ func handleDryRunUpdate(apiClient *client.Client, cfg *config.Config, commandID
 		}
 
 		if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
-			log.Printf("Failed to report dry run failure: %v\n", reportErr)
+			slog.Error("failed to report dry run failure", "command_id", commandID, "error", reportErr)
 		}
 
 		return fmt.Errorf("dry run failed: %w", err)
@@ -1066,7 +1622,7 @@ func handleDryRunUpdate(apiClient *client.Client, cfg *config.Config, commandID
 	}
 
 	if reportErr := apiClient.ReportDependencies(cfg.AgentID, depReport); reportErr != nil {
-		log.Printf("Failed to report dependencies: %v\n", reportErr)
+		slog.Error("failed to report dependencies", "command_id", commandID, "error", reportErr)
 		return fmt.Errorf("failed to report dependencies: %w", reportErr)
 	}
 
@@ -1086,19 +1642,18 @@ func handleDryRunUpdate(apiClient *client.Client, cfg *config.Config, commandID
 	}
 
 	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
-		log.Printf("Failed to report dry run success: %v\n", reportErr)
+		slog.Error("failed to report dry run success", "command_id", commandID, "error", reportErr)
 	}
 
 	if result.Success {
-		log.Printf("✓ Dry run completed successfully in %d seconds\n", result.DurationSeconds)
+		slog.Info("dry run completed", "duration_seconds", result.DurationSeconds)
 		if len(result.Dependencies) > 0 {
-			log.Printf("  Dependencies found: %v\n", result.Dependencies)
+			slog.Info("dependencies found", "dependencies", result.Dependencies)
 		} else {
-			log.Printf("  No additional dependencies found\n")
+			slog.Info("no additional dependencies found")
 		}
 	} else {
-		log.Printf("✗ Dry run failed after %d seconds\n", result.DurationSeconds)
-		log.Printf("  Error: %s\n", result.ErrorMessage)
+		slog.Error("dry run failed", "duration_seconds", result.DurationSeconds, "error", result.ErrorMessage)
 	}
 
 	return nil
@@ -1106,7 +1661,7 @@ func handleDryRunUpdate(apiClient *client.Client, cfg *config.Config, commandID
 
 // handleConfirmDependencies handles confirm_dependencies command
 func handleConfirmDependencies(apiClient *client.Client, cfg *config.Config, commandID string, params map[string]interface{}) error {
-	log.Println("Installing update with confirmed dependencies...")
+	slog.Info("installing update with confirmed dependencies", "command_id", commandID)
 
 	// Parse parameters
 	packageType := ""
@@ -1143,22 +1698,57 @@ func handleConfirmDependencies(apiClient *client.Client, cfg *config.Config, com
 		return fmt.Errorf("%s installer is not available on this system", packageType)
 	}
 
+	// Pre-install hooks (drain a node, stop services...) run before anything is touched
+	details := map[string]interface{}{}
+	hookEnv := hooks.Env(commandID, packageType, append([]string{packageName}, dependencies...))
+	if err := runHooks(apiClient, cfg, commandID, hooks.PhasePreInstall, hookEnv, details); err != nil {
+		return err
+	}
+
+	// Snapshot the root filesystem first if configured
+	if err := takePreInstallSnapshot(cfg, packageType, details); err != nil {
+		logReport := client.LogReport{
+			CommandID: commandID,
+			Action:    "snapshot",
+			Result:    "failed",
+			Stderr:    err.Error(),
+			ExitCode:  1,
+			Details:   details,
+		}
+		if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
+			slog.Error("failed to report snapshot failure", "command_id", commandID, "error", reportErr)
+		}
+		return err
+	}
+
+	// Stream output to the server while the installer runs
+	output := client.NewOutputStream(apiClient, cfg.AgentID, commandID)
+	inst.SetOutputHandler(output.Write)
+
 	var result *installer.InstallResult
 	var action string
 
 	// Perform installation with dependencies
 	if len(dependencies) > 0 {
 		action = "install_with_dependencies"
-		log.Printf("Installing package with dependencies: %s (dependencies: %v)", packageName, dependencies)
+		slog.Info("installing package with dependencies", "package", packageName, "dependencies", dependencies)
 		// Install main package + dependencies
 		allPackages := append([]string{packageName}, dependencies...)
 		result, err = inst.InstallMultiple(allPackages)
 	} else {
 		action = "upgrade"
-		log.Printf("Installing package: %s (no dependencies)", packageName)
+		slog.Info("installing package without dependencies", "package", packageName)
 		// Use UpdatePackage instead of Install to handle existing packages
 		result, err = inst.UpdatePackage(packageName)
 	}
+	output.Close()
+	if result != nil && len(result.ContainersUpdated) > 0 {
+		details["containers_updated"] = result.ContainersUpdated
+	}
+
+	// Post-install hooks (smoke tests, restarting services...) see the outcome
+	hookEnv["REDFLAG_INSTALL_RESULT"] = map[bool]string{true: "failed", false: "success"}[err != nil]
+	postHookErr := runHooks(nil, cfg, commandID, hooks.PhasePostInstall, hookEnv, details)
 
 	if err != nil {
 		// Report installation failure with actual command output
@@ -1170,10 +1760,12 @@ func handleConfirmDependencies(apiClient *client.Client, cfg *config.Config, com
 			Stderr:          result.Stderr,
 			ExitCode:        result.ExitCode,
 			DurationSeconds: result.DurationSeconds,
+			Details:         details,
 		}
+		appendHookFailure(&logReport, postHookErr, details)
 
 		if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
-			log.Printf("Failed to report installation failure: %v\n", reportErr)
+			slog.Error("failed to report installation failure", "command_id", commandID, "error", reportErr)
 		}
 
 		return fmt.Errorf("installation failed: %w", err)
@@ -1188,6 +1780,7 @@ func handleConfirmDependencies(apiClient *client.Client, cfg *config.Config, com
 		Stderr:          result.Stderr,
 		ExitCode:        result.ExitCode,
 		DurationSeconds: result.DurationSeconds,
+		Details:         details,
 	}
 
 	// Add additional metadata to the log report
@@ -1198,20 +1791,215 @@ func handleConfirmDependencies(apiClient *client.Client, cfg *config.Config, com
 		logReport.Stdout += fmt.Sprintf("\nDependencies included: %v", dependencies)
 	}
 
+	appendHookFailure(&logReport, postHookErr, details)
+
+	// The install worked; make sure what it touched still does
+	if healthErr := runHealthChecks(cfg, details); healthErr != nil {
+		appendHealthFailure(&logReport, healthErr, details)
+	}
+
 	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
-		log.Printf("Failed to report installation success: %v\n", reportErr)
+		slog.Error("failed to report installation success", "command_id", commandID, "error", reportErr)
 	}
 
 	if result.Success {
-		log.Printf("✓ Installation with dependencies completed successfully in %d seconds\n", result.DurationSeconds)
+		slog.Info("installation with dependencies completed", "duration_seconds", result.DurationSeconds)
 		if len(result.PackagesInstalled) > 0 {
-			log.Printf("  Packages installed: %v\n", result.PackagesInstalled)
+			slog.Info("packages installed", "packages", result.PackagesInstalled)
 		}
 	} else {
-		log.Printf("✗ Installation with dependencies failed after %d seconds\n", result.DurationSeconds)
-		log.Printf("  Error: %s\n", result.ErrorMessage)
+		slog.Error("installation with dependencies failed", "duration_seconds", result.DurationSeconds, "error", result.ErrorMessage)
+	}
+
+	return nil
+}
+
+// requestedPackages returns the packages an install command names: package_name,
+// or any other string parameters for multi-package installs
+func requestedPackages(packageName string, params map[string]interface{}) []string {
+	if packageName != "" {
+		return []string{packageName}
+	}
+	var packageNames []string
+	for key, value := range params {
+		if key != "package_type" {
+			if name, ok := value.(string); ok && name != "" {
+				packageNames = append(packageNames, name)
+			}
+		}
+	}
+	return packageNames
+}
+
+// runHooks runs a hook phase unless hooks are disabled, adding the results to
+// details. For pre-* phases a failure is reported to the server against the
+// command (when apiClient is set) and returned so the caller aborts.
+func runHooks(apiClient *client.Client, cfg *config.Config, commandID, phase string, env map[string]string, details map[string]interface{}) error {
+	if cfg.Hooks.Disabled {
+		return nil
+	}
+
+	runner := hooks.NewRunner(cfg.Hooks.Dir, time.Duration(cfg.Hooks.TimeoutSeconds)*time.Second)
+	results, err := runner.Run(phase, env)
+	if len(results) > 0 {
+		existing, _ := details["hooks"].([]hooks.Result)
+		details["hooks"] = append(existing, results...)
+	}
+	if err == nil || apiClient == nil {
+		return err
+	}
+
+	logReport := client.LogReport{
+		CommandID: commandID,
+		Action:    strings.ReplaceAll(phase, "-", "_") + "_hooks",
+		Result:    "failed",
+		Stdout:    hooks.Summary(results),
+		Stderr:    err.Error(),
+		ExitCode:  1,
+		Details:   details,
+	}
+	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
+		slog.Error("failed to report hook failure", "error", reportErr)
+	}
+	return err
+}
+
+// appendHookFailure notes failed post-* hooks in a log report. They don't change
+// the command's result: the operation itself has already happened.
+func appendHookFailure(logReport *client.LogReport, hookErr error, details map[string]interface{}) {
+	if hookErr == nil {
+		return
+	}
+	results, _ := details["hooks"].([]hooks.Result)
+	if logReport.Stderr != "" {
+		logReport.Stderr += "\n"
+	}
+	logReport.Stderr += hookErr.Error() + "\n" + hooks.Summary(results)
+}
+
+// runHealthChecks runs the configured health checks, recording the results and
+// an overall "passed"/"failed" verdict in details. The server marks the
+// packages of a successful install degraded when the verdict is "failed".
+func runHealthChecks(cfg *config.Config, details map[string]interface{}) error {
+	if len(cfg.HealthChecks) == 0 {
+		return nil
+	}
+
+	slog.Info("running health checks", "count", len(cfg.HealthChecks))
+	results, err := health.Run(cfg.HealthChecks)
+	details["health_checks"] = results
+	details["health"] = "passed"
+	if err != nil {
+		details["health"] = "failed"
+	}
+	return err
+}
+
+// appendHealthFailure notes failed health checks in a log report
+func appendHealthFailure(logReport *client.LogReport, healthErr error, details map[string]interface{}) {
+	results, _ := details["health_checks"].([]health.Result)
+	if logReport.Stderr != "" {
+		logReport.Stderr += "\n"
+	}
+	logReport.Stderr += healthErr.Error() + "\n" + health.Summary(results)
+}
+
+// takePreInstallSnapshot snapshots the root filesystem before a system package
+// install when enabled in config, recording the snapshot (or why it failed) in
+// details. An error is returned only when the config requires a snapshot.
+func takePreInstallSnapshot(cfg *config.Config, packageType string, details map[string]interface{}) error {
+	if !cfg.Snapshots.Enabled || runtime.GOOS != "linux" {
+		return nil
+	}
+	// Container images and Windows packages don't live on the snapshotted root
+	if packageType != "apt" && packageType != "dnf" {
+		return nil
+	}
+
+	snapshot, err := installer.NewSnapshotter(cfg.Snapshots.LVMSize).Take(cfg.Snapshots.Retention)
+	if err != nil {
+		details["snapshot_error"] = err.Error()
+		if cfg.Snapshots.Required {
+			return fmt.Errorf("pre-install snapshot failed: %w", err)
+		}
+		slog.Warn("pre-install snapshot failed, continuing with install", "error", err)
+		return nil
+	}
+
+	details["snapshot"] = snapshot
+	return nil
+}
+
+// handleRollbackUpdate reinstalls the previous version of a package
+func handleRollbackUpdate(apiClient *client.Client, cfg *config.Config, commandID string, params map[string]interface{}) error {
+	packageType, _ := params["package_type"].(string)
+	packageName, _ := params["package_name"].(string)
+	version, _ := params["version"].(string)
+
+	if packageType == "" || packageName == "" || version == "" {
+		return fmt.Errorf("package_type, package_name and version parameters are required")
+	}
+
+	slog.Info("rolling back package", "package", packageName, "package_type", packageType, "version", version)
+
+	inst, err := installer.InstallerFactory(packageType)
+	if err != nil {
+		return fmt.Errorf("failed to create installer for package type %s: %w", packageType, err)
+	}
+
+	if !inst.IsAvailable() {
+		return fmt.Errorf("%s installer is not available on this system", packageType)
+	}
+
+	output := client.NewOutputStream(apiClient, cfg.AgentID, commandID)
+	inst.SetOutputHandler(output.Write)
+	result, err := inst.InstallVersion(packageName, version)
+	output.Close()
+
+	if result == nil {
+		result = &installer.InstallResult{}
+	}
+
+	if err != nil {
+		// The server records the failure reason from stderr in the package history
+		stderr := result.Stderr
+		if stderr == "" {
+			stderr = result.ErrorMessage
+		}
+		if stderr == "" {
+			stderr = err.Error()
+		}
+
+		logReport := client.LogReport{
+			CommandID:       commandID,
+			Action:          "rollback",
+			Result:          "failed",
+			Stdout:          result.Stdout,
+			Stderr:          stderr,
+			ExitCode:        result.ExitCode,
+			DurationSeconds: result.DurationSeconds,
+		}
+		if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
+			slog.Error("failed to report rollback failure", "command_id", commandID, "error", reportErr)
+		}
+
+		return fmt.Errorf("rollback failed: %w", err)
 	}
 
+	logReport := client.LogReport{
+		CommandID:       commandID,
+		Action:          "rollback",
+		Result:          "success",
+		Stdout:          result.Stdout,
+		Stderr:          result.Stderr,
+		ExitCode:        result.ExitCode,
+		DurationSeconds: result.DurationSeconds,
+	}
+	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
+		slog.Error("failed to report rollback success", "command_id", commandID, "error", reportErr)
+	}
+
+	slog.Info("rolled back package", "package", packageName, "version", version)
 	return nil
 }
 
@@ -1228,7 +2016,7 @@ func handleEnableHeartbeat(apiClient *client.Client, cfg *config.Config, command
 	// Calculate when heartbeat should expire
 	expiryTime := time.Now().Add(time.Duration(durationMinutes) * time.Minute)
 
-	log.Printf("[Heartbeat] Enabling rapid polling for %d minutes (expires: %s)", durationMinutes, expiryTime.Format(time.RFC3339))
+	slog.Info("enabling rapid polling", "duration_minutes", durationMinutes, "expires", expiryTime.Format(time.RFC3339))
 
 	// Update agent config to enable rapid polling
 	cfg.RapidPollingEnabled = true
@@ -1236,7 +2024,7 @@ func handleEnableHeartbeat(apiClient *client.Client, cfg *config.Config, command
 
 	// Save config to persist heartbeat settings
 	if err := cfg.Save(getConfigPath()); err != nil {
-		log.Printf("[Heartbeat] Warning: Failed to save config: %v", err)
+		slog.Warn("failed to save heartbeat config", "error", err)
 	}
 
 	// Create log report for heartbeat enable
@@ -1251,11 +2039,11 @@ func handleEnableHeartbeat(apiClient *client.Client, cfg *config.Config, command
 	}
 
 	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
-		log.Printf("[Heartbeat] Failed to report heartbeat enable: %v", reportErr)
+		slog.Error("failed to report heartbeat enable", "command_id", commandID, "error", reportErr)
 	}
 
 	// Send immediate check-in to update heartbeat status in UI
-	log.Printf("[Heartbeat] Sending immediate check-in to update status")
+	slog.Debug("sending immediate check-in to update heartbeat status")
 	sysMetrics, err := system.GetLightweightMetrics()
 	if err == nil {
 		metrics := &client.SystemMetrics{
@@ -1278,21 +2066,21 @@ func handleEnableHeartbeat(apiClient *client.Client, cfg *config.Config, command
 		// Send immediate check-in with updated heartbeat status
 		_, checkinErr := apiClient.GetCommands(cfg.AgentID, metrics)
 		if checkinErr != nil {
-			log.Printf("[Heartbeat] Failed to send immediate check-in: %v", checkinErr)
+			slog.Warn("failed to send immediate check-in", "error", checkinErr)
 		} else {
-			log.Printf("[Heartbeat] Immediate check-in sent successfully")
+			slog.Debug("immediate check-in sent")
 		}
 	} else {
-		log.Printf("[Heartbeat] Failed to get system metrics for immediate check-in: %v", err)
+		slog.Warn("failed to get system metrics for immediate check-in", "error", err)
 	}
 
-	log.Printf("[Heartbeat] Rapid polling enabled successfully")
+	slog.Info("rapid polling enabled")
 	return nil
 }
 
 // handleDisableHeartbeat handles disable_heartbeat command
 func handleDisableHeartbeat(apiClient *client.Client, cfg *config.Config, commandID string) error {
-	log.Printf("[Heartbeat] Disabling rapid polling")
+	slog.Info("disabling rapid polling")
 
 	// Update agent config to disable rapid polling
 	cfg.RapidPollingEnabled = false
@@ -1300,7 +2088,7 @@ func handleDisableHeartbeat(apiClient *client.Client, cfg *config.Config, comman
 
 	// Save config to persist heartbeat settings
 	if err := cfg.Save(getConfigPath()); err != nil {
-		log.Printf("[Heartbeat] Warning: Failed to save config: %v", err)
+		slog.Warn("failed to save heartbeat config", "error", err)
 	}
 
 	// Create log report for heartbeat disable
@@ -1315,11 +2103,11 @@ func handleDisableHeartbeat(apiClient *client.Client, cfg *config.Config, comman
 	}
 
 	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
-		log.Printf("[Heartbeat] Failed to report heartbeat disable: %v", reportErr)
+		slog.Error("failed to report heartbeat disable", "command_id", commandID, "error", reportErr)
 	}
 
 	// Send immediate check-in to update heartbeat status in UI
-	log.Printf("[Heartbeat] Sending immediate check-in to update status")
+	slog.Debug("sending immediate check-in to update heartbeat status")
 	sysMetrics, err := system.GetLightweightMetrics()
 	if err == nil {
 		metrics := &client.SystemMetrics{
@@ -1342,15 +2130,87 @@ func handleDisableHeartbeat(apiClient *client.Client, cfg *config.Config, comman
 		// Send immediate check-in with updated heartbeat status
 		_, checkinErr := apiClient.GetCommands(cfg.AgentID, metrics)
 		if checkinErr != nil {
-			log.Printf("[Heartbeat] Failed to send immediate check-in: %v", checkinErr)
+			slog.Warn("failed to send immediate check-in", "error", checkinErr)
 		} else {
-			log.Printf("[Heartbeat] Immediate check-in sent successfully")
+			slog.Debug("immediate check-in sent")
 		}
 	} else {
-		log.Printf("[Heartbeat] Failed to get system metrics for immediate check-in: %v", err)
+		slog.Warn("failed to get system metrics for immediate check-in", "error", err)
 	}
 
-	log.Printf("[Heartbeat] Rapid polling disabled successfully")
+	slog.Info("rapid polling disabled")
+	return nil
+}
+
+// handleCollectSpecs collects the host's hardware and software inventory and
+// reports it to the server, which keeps a snapshot whenever it changes
+func handleCollectSpecs(apiClient *client.Client, cfg *config.Config, commandID string) error {
+	slog.Info("collecting system specs")
+	startTime := time.Now()
+
+	reportFailure := func(err error) error {
+		logReport := client.LogReport{
+			CommandID:       commandID,
+			Action:          "collect_specs",
+			Result:          "failed",
+			Stderr:          err.Error(),
+			ExitCode:        1,
+			DurationSeconds: int(time.Since(startTime).Seconds()),
+		}
+		if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
+			slog.Error("failed to report spec collection failure", "command_id", commandID, "error", reportErr)
+		}
+		return err
+	}
+
+	specs, err := system.CollectSpecs()
+	if err != nil {
+		return reportFailure(fmt.Errorf("failed to collect specs: %w", err))
+	}
+
+	resp, err := apiClient.ReportSpecs(cfg.AgentID, specs)
+	if err != nil {
+		return reportFailure(fmt.Errorf("failed to report specs: %w", err))
+	}
+
+	var stdout strings.Builder
+	fmt.Fprintf(&stdout, "%s, %d cores/%d threads, %d MB memory, %d disks, %d network interfaces",
+		specs.CPUModel, specs.CPUCores, specs.CPUThreads, specs.MemoryTotalMB, len(specs.Disks), len(specs.NetworkInterfaces))
+	if specs.Virtualization != "" && specs.Virtualization != "none" {
+		fmt.Fprintf(&stdout, ", virtualization: %s", specs.Virtualization)
+	}
+	switch {
+	case !resp.Changed:
+		stdout.WriteString("\nNo changes since the last snapshot")
+	case len(resp.Changes) == 0:
+		stdout.WriteString("\nRecorded first snapshot")
+	default:
+		fmt.Fprintf(&stdout, "\n%d changes since the last snapshot:", len(resp.Changes))
+		for _, change := range resp.Changes {
+			switch {
+			case change.From == nil:
+				fmt.Fprintf(&stdout, "\n  %s: added (%v)", change.Field, change.To)
+			case change.To == nil:
+				fmt.Fprintf(&stdout, "\n  %s: removed (%v)", change.Field, change.From)
+			default:
+				fmt.Fprintf(&stdout, "\n  %s: %v -> %v", change.Field, change.From, change.To)
+			}
+		}
+	}
+
+	logReport := client.LogReport{
+		CommandID:       commandID,
+		Action:          "collect_specs",
+		Result:          "success",
+		Stdout:          stdout.String(),
+		ExitCode:        0,
+		DurationSeconds: int(time.Since(startTime).Seconds()),
+	}
+	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
+		slog.Error("failed to report spec collection", "command_id", commandID, "error", reportErr)
+	}
+
+	slog.Info("reported system specs", "changed", resp.Changed)
 	return nil
 }
 
@@ -1408,7 +2268,7 @@ func reportSystemInfo(apiClient *client.Client, cfg *config.Config) error {
 
 // handleReboot handles reboot command
 func handleReboot(apiClient *client.Client, cfg *config.Config, commandID string, params map[string]interface{}) error {
-	log.Println("[Reboot] Processing reboot request...")
+	slog.Info("processing reboot request", "command_id", commandID)
 
 	// Parse parameters
 	delayMinutes := 1 // Default to 1 minute
@@ -1423,7 +2283,14 @@ func handleReboot(apiClient *client.Client, cfg *config.Config, commandID string
 		message = msg
 	}
 
-	log.Printf("[Reboot] Scheduling system reboot in %d minute(s): %s", delayMinutes, message)
+	slog.Info("scheduling system reboot", "delay_minutes", delayMinutes, "message", message)
+
+	// Pre-reboot hooks (drain, stop services...) can veto the reboot
+	details := map[string]interface{}{}
+	hookEnv := map[string]string{"REDFLAG_COMMAND_ID": commandID, "REDFLAG_REBOOT_DELAY_MINUTES": strconv.Itoa(delayMinutes)}
+	if err := runHooks(apiClient, cfg, commandID, hooks.PhasePreReboot, hookEnv, details); err != nil {
+		return err
+	}
 
 	var cmd *exec.Cmd
 
@@ -1437,7 +2304,7 @@ func handleReboot(apiClient *client.Client, cfg *config.Config, commandID string
 		cmd = exec.Command("shutdown", "/r", "/t", fmt.Sprintf("%d", delaySeconds), "/c", message)
 	} else {
 		err := fmt.Errorf("reboot not supported on platform: %s", runtime.GOOS)
-		log.Printf("[Reboot] Error: %v", err)
+		slog.Error("failed to schedule reboot", "error", err)
 
 		// Report failure
 		logReport := client.LogReport{
@@ -1456,8 +2323,7 @@ func handleReboot(apiClient *client.Client, cfg *config.Config, commandID string
 	// Execute reboot command
 	output, err := cmd.CombinedOutput()
 	if err != nil {
-		log.Printf("[Reboot] Failed to schedule reboot: %v", err)
-		log.Printf("[Reboot] Output: %s", string(output))
+		slog.Error("failed to schedule reboot", "output", string(output), "error", err)
 
 		// Report failure
 		logReport := client.LogReport{
@@ -1473,8 +2339,13 @@ func handleReboot(apiClient *client.Client, cfg *config.Config, commandID string
 		return err
 	}
 
-	log.Printf("[Reboot] System reboot scheduled successfully")
-	log.Printf("[Reboot] The system will reboot in %d minute(s)", delayMinutes)
+	// Post-reboot hooks and health checks run once the agent checks in after the reboot
+	if runtime.GOOS == "linux" && (!cfg.Hooks.Disabled || len(cfg.HealthChecks) > 0) {
+		if err := hooks.SavePendingReboot(hooks.DefaultRebootStateFile, commandID); err != nil {
+			slog.Warn("post-reboot hooks and health checks won't run", "error", err)
+		}
+	}
+	slog.Info("system reboot scheduled", "delay_minutes", delayMinutes)
 
 	// Report success
 	logReport := client.LogReport{
@@ -1485,15 +2356,158 @@ func handleReboot(apiClient *client.Client, cfg *config.Config, commandID string
 		Stderr:          "",
 		ExitCode:        0,
 		DurationSeconds: 0,
+		Details:         details,
 	}
 
 	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
-		log.Printf("[Reboot] Failed to report reboot command result: %v", reportErr)
+		slog.Error("failed to report reboot command result", "command_id", commandID, "error", reportErr)
 	}
 
 	return nil
 }
 
+// handleUpdateAgent downloads and verifies a signed agent binary, then hands
+// it to the root update unit. The command's result is reported by the new
+// binary once it checks in, or after the unit rolls the update back.
+func handleUpdateAgent(apiClient *client.Client, cfg *config.Config, commandID string, params map[string]interface{}) error {
+	version, _ := params["version"].(string)
+	downloadURL, _ := params["download_url"].(string)
+	sha, _ := params["sha256"].(string)
+	signature, _ := params["signature"].(string)
+	releaseSignature, _ := params["release_signature"].(string)
+
+	slog.Info("updating agent", "from_version", AgentVersion, "to_version", version)
+
+	reportFailure := func(err error) error {
+		slog.Error("agent update failed", "to_version", version, "error", err)
+		logReport := client.LogReport{
+			CommandID:       commandID,
+			Action:          "update_agent",
+			Result:          "failed",
+			Stdout:          "",
+			Stderr:          err.Error(),
+			ExitCode:        1,
+			DurationSeconds: 0,
+		}
+		if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
+			slog.Error("failed to report agent update failure", "command_id", commandID, "error", reportErr)
+		}
+		return err
+	}
+
+	if runtime.GOOS != "linux" {
+		return reportFailure(selfupdate.ErrUnsupported)
+	}
+	if version == "" || downloadURL == "" || sha == "" || signature == "" || releaseSignature == "" {
+		return reportFailure(fmt.Errorf("update_agent command is missing release parameters"))
+	}
+
+	window := selfupdate.RollbackWindow(time.Duration(cfg.CheckInInterval) * time.Second)
+	state := &selfupdate.State{
+		CommandID:        commandID,
+		FromVersion:      AgentVersion,
+		ToVersion:        version,
+		SHA256:           sha,
+		Signature:        signature,
+		ReleaseSignature: releaseSignature,
+		RollbackWindow:   int(window.Seconds()),
+	}
+
+	dir := selfupdate.DefaultDir()
+	err := selfupdate.Stage(dir, state, func(w io.Writer) error {
+		return apiClient.DownloadFile(downloadURL, w, selfupdate.MaxBinarySize)
+	})
+	if err != nil {
+		return reportFailure(err)
+	}
+	slog.Info("agent binary verified and staged", "version", version)
+
+	if err := selfupdate.TriggerApply(); err != nil {
+		selfupdate.Discard(dir)
+		return reportFailure(err)
+	}
+
+	slog.Info("update unit started, the agent will restart", "version", version, "rollback_window", window)
+	return nil
+}
+
+// reportSelfUpdateResult reports the outcome of a pending self-update, if any
+func reportSelfUpdateResult(apiClient *client.Client, cfg *config.Config) {
+	if runtime.GOOS != "linux" {
+		return
+	}
+	result := selfupdate.CheckIn(selfupdate.DefaultDir())
+	if result == nil {
+		return
+	}
+
+	logReport := client.LogReport{
+		CommandID: result.CommandID,
+		Action:    "update_agent",
+		Result:    "success",
+		Stdout:    result.Message,
+		ExitCode:  0,
+	}
+	if result.Success {
+		slog.Info("agent update confirmed", "command_id", result.CommandID, "message", result.Message)
+	} else {
+		slog.Error("agent update failed", "command_id", result.CommandID, "message", result.Message)
+		logReport.Result = "failed"
+		logReport.Stdout = ""
+		logReport.Stderr = result.Message
+		logReport.ExitCode = 1
+	}
+
+	if err := apiClient.ReportLog(cfg.AgentID, logReport); err != nil {
+		slog.Error("failed to report agent update result", "command_id", result.CommandID, "error", err)
+	}
+}
+
+// runPostRebootChecks runs post-reboot hooks and health checks once after a
+// reboot scheduled by a reboot command. The reboot command has already
+// completed, so the results are reported as a standalone log.
+func runPostRebootChecks(apiClient *client.Client, cfg *config.Config) {
+	if runtime.GOOS != "linux" || (cfg.Hooks.Disabled && len(cfg.HealthChecks) == 0) {
+		return
+	}
+	rebootCommandID := hooks.TakePendingReboot(hooks.DefaultRebootStateFile)
+	if rebootCommandID == "" {
+		return
+	}
+
+	details := map[string]interface{}{}
+	hookEnv := map[string]string{"REDFLAG_COMMAND_ID": rebootCommandID}
+	hookErr := runHooks(nil, cfg, rebootCommandID, hooks.PhasePostReboot, hookEnv, details)
+	healthErr := runHealthChecks(cfg, details)
+
+	hookResults, _ := details["hooks"].([]hooks.Result)
+	healthResults, _ := details["health_checks"].([]health.Result)
+	if len(hookResults) == 0 && len(healthResults) == 0 {
+		return
+	}
+
+	logReport := client.LogReport{
+		Action:   "post_reboot_checks",
+		Result:   "success",
+		Stdout:   fmt.Sprintf("Post-reboot checks for reboot command %s:\n%s%s", rebootCommandID, hooks.Summary(hookResults), health.Summary(healthResults)),
+		ExitCode: 0,
+		Details:  details,
+	}
+	for _, err := range []error{hookErr, healthErr} {
+		if err != nil {
+			logReport.Result = "failed"
+			logReport.ExitCode = 1
+			if logReport.Stderr != "" {
+				logReport.Stderr += "\n"
+			}
+			logReport.Stderr += err.Error()
+		}
+	}
+	if reportErr := apiClient.ReportLog(cfg.AgentID, logReport); reportErr != nil {
+		slog.Error("failed to report post-reboot checks", "error", reportErr)
+	}
+}
+
 // formatTimeSince formats a duration as "X time ago"
 func formatTimeSince(t time.Time) string {
 	duration := time.Since(t)
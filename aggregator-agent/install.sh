@@ -10,6 +10,8 @@ AGENT_HOME="/var/lib/redflag-agent"
 AGENT_BINARY="/usr/local/bin/redflag-agent"
 SUDOERS_FILE="/etc/sudoers.d/redflag-agent"
 SERVICE_FILE="/etc/systemd/system/redflag-agent.service"
+CONTROL_GROUP="redflag"
+UPDATE_SERVICE_FILE="/etc/systemd/system/redflag-agent-update.service"
 
 echo "=== RedFlag Agent Installation ==="
 echo ""
@@ -30,6 +32,14 @@ create_user() {
         echo "✓ User $AGENT_USER created"
     fi
 
+    # Members of the redflag group may use the agent's local control socket
+    # (redflag-agent status --live, scan-now, check-in-now)
+    if ! getent group "$CONTROL_GROUP" &>/dev/null; then
+        groupadd -r "$CONTROL_GROUP"
+        echo "✓ Group $CONTROL_GROUP created"
+    fi
+    usermod -aG "$CONTROL_GROUP" "$AGENT_USER"
+
     # Add user to docker group for Docker update scanning
     if getent group docker &>/dev/null; then
         echo "Adding $AGENT_USER to docker group..."
@@ -82,14 +92,71 @@ redflag-agent ALL=(root) NOPASSWD: /usr/bin/apt-get install --dry-run --yes *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf makecache
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf install -y *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf upgrade -y *
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf downgrade -y *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf install --assumeno --downloadonly *
 
 # Docker operations
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker pull *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker image inspect *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker manifest inspect *
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker tag *
+# Compose-managed containers are updated through the agent's root helper, which
+# only runs "pull <service>" or "up -d --no-deps <service>" with the compose
+# files an existing container of that service was deployed from. A rule for
+# docker compose itself would accept any compose file.
+redflag-agent ALL=(root) NOPASSWD: /usr/local/bin/redflag-agent update-compose-service *
+
+# Podman systemd-managed containers (restarted onto updated images). systemctl
+# itself would allow any unit, so restarts go through the agent's root helper,
+# which only restarts a unit that runs a Podman container
+redflag-agent ALL=(root) NOPASSWD: /usr/local/bin/redflag-agent restart-podman-unit *
+
+# Pre-install filesystem snapshots (btrfs, LVM, ZFS). Snapshot names are spelled
+# out as redflag-YYYYMMDDTHHMMSSZ: a sudoers * also matches spaces and "..", so
+# it must never appear in a rule that deletes something. LVM and ZFS rules name
+# the root volume or dataset and are added below when / is on one.
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/mkdir -p /.redflag-snapshots
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/btrfs subvolume snapshot -r / /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/btrfs subvolume snapshot -r / /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/btrfs subvolume delete /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/btrfs subvolume delete /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+# Hardware serial numbers for spec collection (root-only in sysfs)
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/dmidecode -s *
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/dmidecode -s *
+
+# Agent self-update (the swap runs in a separate root unit outside the agent's sandbox)
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/systemctl start --no-block redflag-agent-update.service
 EOF
 
+    # ZFS snapshot rules name the root dataset exactly - with a wildcard a crafted
+    # argument could reach other datasets and snapshots
+    ZFS_ROOT=$(awk '$2 == "/" { dev = $1; fs = $3 } END { if (fs == "zfs") print dev }' /proc/mounts)
+    if [ -n "$ZFS_ROOT" ] && echo "$ZFS_ROOT" | grep -Eq '^[A-Za-z0-9][A-Za-z0-9_./-]*$'; then
+        cat >> "$SUDOERS_FILE" <<SUDOERS_EOF
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/zfs snapshot $ZFS_ROOT@redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/zfs destroy $ZFS_ROOT@redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/zfs list -H -t snapshot -o name $ZFS_ROOT
+SUDOERS_EOF
+    fi
+
+    # LVM snapshot rules name the root device, volume group and vg/lv exactly, and
+    # the agent passes --size in whole gigabytes so it can be matched digit by digit
+    ROOT_DEVICE=$(awk '$2 == "/" { dev = $1; fs = $3 } END { if (fs != "btrfs" && fs != "zfs") print dev }' /proc/mounts)
+    if echo "$ROOT_DEVICE" | grep -Eq '^/dev/(mapper/[A-Za-z0-9+_.-]+|dm-[0-9]+)$'; then
+        LVM_ROOT=$(lvs --noheadings --separator / -o vg_name,lv_name "$ROOT_DEVICE" 2>/dev/null | tail -n 1 | tr -d ' ')
+        if echo "$LVM_ROOT" | grep -Eq '^[A-Za-z0-9+_.][A-Za-z0-9+_.-]*/[A-Za-z0-9+_.][A-Za-z0-9+_.-]*$'; then
+            LVM_VG="${LVM_ROOT%%/*}"
+            cat >> "$SUDOERS_FILE" <<SUDOERS_EOF
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvs --noheadings --separator / -o vg_name\,lv_name $ROOT_DEVICE
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvs --noheadings -o lv_name $LVM_VG
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvcreate --snapshot --name redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z --size [1-9]G $LVM_ROOT
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvcreate --snapshot --name redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z --size [1-9][0-9]G $LVM_ROOT
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvcreate --snapshot --name redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z --size [1-9][0-9][0-9]G $LVM_ROOT
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvremove -y --select lv_name\=redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+SUDOERS_EOF
+        fi
+    fi
+
     chmod 440 "$SUDOERS_FILE"
 
     # Validate sudoers file
@@ -119,11 +186,19 @@ ExecStart=$AGENT_BINARY
 Restart=always
 RestartSec=30
 
+# Control socket lives in /run/redflag-agent
+RuntimeDirectory=redflag-agent
+RuntimeDirectoryMode=0755
+
 # Security hardening
 # NoNewPrivileges=true - DISABLED: Prevents sudo from working
 ProtectSystem=strict
-ProtectHome=true
-ReadWritePaths=$AGENT_HOME /var/log /etc/aggregator
+# /run/user stays visible (read-only) so rootless Podman sockets can be reached
+ProtectHome=tmpfs
+BindReadOnlyPaths=-/run/user
+# Root helpers run through sudo inherit this sandbox: snapshot and LVM paths
+# must be writable for pre-install snapshots (the agent user still can't write them)
+ReadWritePaths=$AGENT_HOME /var/log /etc/aggregator /var/lib/aggregator -/.redflag-snapshots -/etc/lvm -/run/lock/lvm
 PrivateTmp=true
 
 [Install]
@@ -132,6 +207,55 @@ EOF
 
     chmod 644 "$SERVICE_FILE"
     echo "✓ Systemd service installed"
+
+    # Snapshots are written under /.redflag-snapshots, which the unit's
+    # ReadWritePaths opens up; create it now since / is read-only inside the sandbox
+    if [ "$(awk '$2 == "/" { fs = $3 } END { print fs }' /proc/mounts)" = "btrfs" ]; then
+        mkdir -p /.redflag-snapshots
+        chmod 700 /.redflag-snapshots
+    fi
+
+    # Rootless Podman: /run/user/<uid> is private to each user, so the agent can
+    # only reach the Podman API of users listed in $PODMAN_USERS_FILE (seeded from
+    # REDFLAG_PODMAN_USERS). Their podman.socket grants the agent an ACL on start.
+    PODMAN_USERS_FILE="/etc/redflag-agent/podman-users"
+    mkdir -p /etc/redflag-agent /etc/systemd/user/podman.socket.d
+    touch "$PODMAN_USERS_FILE"
+    chmod 644 "$PODMAN_USERS_FILE"
+    for podman_user in $REDFLAG_PODMAN_USERS; do
+        if id "$podman_user" >/dev/null 2>&1 && ! grep -qxF "$podman_user" "$PODMAN_USERS_FILE"; then
+            echo "$podman_user" >> "$PODMAN_USERS_FILE"
+        fi
+    done
+    cat > /etc/systemd/user/podman.socket.d/redflag-agent.conf <<'PODMAN_EOF'
+# Installed by RedFlag: lets the redflag-agent user reach the rootless Podman
+# API of users listed in /etc/redflag-agent/podman-users
+[Socket]
+ExecStartPost=-/bin/sh -c 'grep -qxF "%u" /etc/redflag-agent/podman-users || exit 0; setfacl -m u:redflag-agent:x "%t" && setfacl -m u:redflag-agent:rw "%t/podman/podman.sock"'
+PODMAN_EOF
+    chmod 644 /etc/systemd/user/podman.socket.d/redflag-agent.conf
+    while read -r podman_user; do
+        [ -n "$podman_user" ] || continue
+        systemctl --user -M "$podman_user@" daemon-reload 2>/dev/null || continue
+        systemctl --user -M "$podman_user@" try-restart podman.socket 2>/dev/null || true
+        echo "✓ Rootless Podman access granted for $podman_user"
+    done < "$PODMAN_USERS_FILE"
+
+    # Self-update unit: verifies the binary the agent staged, swaps it in, restarts
+    # the agent and rolls back if the new version doesn't check in
+    cat > "$UPDATE_SERVICE_FILE" <<EOF
+[Unit]
+Description=RedFlag Agent Self-Update
+Documentation=https://github.com/Fimeg/RedFlag
+
+[Service]
+Type=simple
+ExecStart=$AGENT_BINARY -apply-update
+SyslogIdentifier=redflag-agent-update
+EOF
+
+    chmod 644 "$UPDATE_SERVICE_FILE"
+    echo "✓ Self-update service installed"
 }
 
 # Function to start and enable service
@@ -174,6 +298,16 @@ register_agent() {
     # Create config directory
     mkdir -p /etc/aggregator
 
+    # State directory: scan cache and the outbox of reports awaiting upload
+    mkdir -p /var/lib/aggregator
+    chown "$AGENT_USER:$AGENT_USER" /var/lib/aggregator
+    chmod 700 /var/lib/aggregator
+
+    # Hook script directories (root-owned; see /etc/aggregator/hooks/<phase>.d)
+    for phase in pre-install post-install pre-reboot post-reboot; do
+        mkdir -p "/etc/aggregator/hooks/$phase.d"
+    done
+
     # Set SELinux context for config directory if SELinux is enabled
     if command -v getenforce >/dev/null 2>&1 && [ "$(getenforce)" != "Disabled" ]; then
         echo "Setting SELinux context for config directory..."
@@ -184,6 +318,13 @@ register_agent() {
     # Register agent (run as regular binary, not as service)
     if "$AGENT_BINARY" -register -server "$server_url"; then
         echo "✓ Agent registered successfully"
+        # Registering as root installs the server's release key; self-update
+        # trusts only this root-owned copy, never the agent's config
+        if [ -f /etc/redflag-agent/update.pub ]; then
+            echo "✓ Update public key installed in /etc/redflag-agent/update.pub"
+        else
+            echo "⚠ Server has no release signing key - agent self-update stays disabled"
+        fi
     else
         echo "ERROR: Agent registration failed"
         echo "Please ensure the RedFlag server is running at $server_url"
@@ -211,8 +352,15 @@ register_agent "$SERVER_URL"
 
 echo ""
 echo "Step 5: Setting config file permissions..."
-chown redflag-agent:redflag-agent /etc/aggregator/config.json
+# The agent rewrites its config and renews its mTLS certificate in place
+chown redflag-agent:redflag-agent /etc/aggregator /etc/aggregator/config.json
 chmod 600 /etc/aggregator/config.json
+for cert_file in /etc/aggregator/agent.crt /etc/aggregator/agent.key; do
+    if [ -f "$cert_file" ]; then
+        chown redflag-agent:redflag-agent "$cert_file"
+        chmod 600 "$cert_file"
+    fi
+done
 
 echo ""
 echo "Step 6: Installing sudoers configuration..."
@@ -235,11 +383,16 @@ echo ""
 echo "Useful commands:"
 echo "  - Check status:  sudo systemctl status redflag-agent"
 echo "  - View logs:     sudo journalctl -u redflag-agent -f"
+echo "  - Live status:   sudo redflag-agent status --live"
+echo "  - Scan now:      sudo redflag-agent scan-now"
 echo "  - Restart:       sudo systemctl restart redflag-agent"
 echo "  - Stop:          sudo systemctl stop redflag-agent"
 echo "  - Disable:       sudo systemctl disable redflag-agent"
 echo ""
 echo "Note: To re-register with a different server, edit /etc/aggregator/config.json"
+echo "Note: Pre-install snapshots go to /.redflag-snapshots (btrfs), writable through the unit's ReadWritePaths"
+echo "Note: To let the agent manage a user's rootless Podman, add them to /etc/redflag-agent/podman-users and run: systemctl --user -M USER@ restart podman.socket"
+echo "Note: Add users to the $CONTROL_GROUP group to use the live status commands without sudo"
 echo ""
 
 show_status
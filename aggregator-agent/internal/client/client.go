@@ -2,7 +2,9 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -11,6 +13,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/system"
 	"github.com/google/uuid"
 )
 
@@ -19,19 +23,42 @@ type Client struct {
 	baseURL               string
 	token                 string
 	http                  *http.Client
+	longPoll              *http.Client
+	ctx                   context.Context
+	outbox                *Outbox // Queues reports that fail to upload; nil disables queueing
 	RapidPollingEnabled   bool
 	RapidPollingUntil     time.Time
+	ManagedConfig         *config.ManagedConfig // Configuration profile delivered with the last check-in
 }
 
 // NewClient creates a new API client
 func NewClient(baseURL, token string) *Client {
+	transportMu.RLock()
+	outbox := sharedOutbox
+	transportMu.RUnlock()
+
 	return &Client{
-		baseURL: baseURL,
-		token:   token,
-		http: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		baseURL:  baseURL,
+		token:    token,
+		http:     HTTPClient(),
+		longPoll: longPollHTTPClient(),
+		outbox:   outbox,
+	}
+}
+
+// WithContext returns a copy of the client whose requests carry ctx, e.g. the
+// span of the command being executed
+func (c *Client) WithContext(ctx context.Context) *Client {
+	clone := *c
+	clone.ctx = ctx
+	return &clone
+}
+
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
 	}
+	return c.ctx
 }
 
 // GetToken returns the current JWT token
@@ -44,6 +71,18 @@ func (c *Client) SetToken(token string) {
 	c.token = token
 }
 
+// ProtocolVersion is the agent-server protocol this agent speaks. Version 1
+// added capability reporting; agents that send no version predate it.
+const ProtocolVersion = 1
+
+// Capabilities tells the server what this agent can run, so it won't queue
+// commands the agent would ignore
+type Capabilities struct {
+	Commands   []string `json:"commands"`   // Command types the agent handles
+	Scanners   []string `json:"scanners"`   // Scanners available on the host
+	Installers []string `json:"installers"` // Package types it can install
+}
+
 // RegisterRequest is the payload for agent registration
 type RegisterRequest struct {
 	Hostname         string            `json:"hostname"`
@@ -53,6 +92,9 @@ type RegisterRequest struct {
 	AgentVersion     string            `json:"agent_version"`
 	RegistrationToken string           `json:"registration_token,omitempty"` // Fallback method
 	Metadata         map[string]string `json:"metadata"`
+	CSR              string            `json:"csr,omitempty"` // PEM certificate request for an mTLS client certificate
+	ProtocolVersion  int               `json:"protocol_version,omitempty"`
+	Capabilities     *Capabilities     `json:"capabilities,omitempty"`
 }
 
 // RegisterResponse is returned after successful registration
@@ -61,6 +103,13 @@ type RegisterResponse struct {
 	Token        string                 `json:"token"`          // Short-lived access token (24h)
 	RefreshToken string                 `json:"refresh_token"`  // Long-lived refresh token (90d)
 	Config       map[string]interface{} `json:"config"`
+	Certificate  *AgentCertificate      `json:"certificate,omitempty"` // Set when the server issues mTLS certificates
+}
+
+// AgentCertificate is a client certificate issued by the server's CA
+type AgentCertificate struct {
+	Certificate string    `json:"certificate"` // PEM
+	NotAfter    time.Time `json:"not_after"`
 }
 
 // Register registers the agent with the server
@@ -78,7 +127,7 @@ func (c *Client) Register(req RegisterRequest) (*RegisterResponse, error) {
 		return nil, err
 	}
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	httpReq, err := http.NewRequestWithContext(c.context(), "POST", url, bytes.NewBuffer(body))
 	if err != nil {
 		return nil, err
 	}
@@ -116,53 +165,99 @@ func (c *Client) Register(req RegisterRequest) (*RegisterResponse, error) {
 type TokenRenewalRequest struct {
 	AgentID      uuid.UUID `json:"agent_id"`
 	RefreshToken string    `json:"refresh_token"`
+	CSR          string    `json:"csr,omitempty"` // Requests a client certificate when the agent has no usable one
 }
 
 // TokenRenewalResponse is returned after successful token renewal
 type TokenRenewalResponse struct {
-	Token string `json:"token"`  // New short-lived access token (24h)
+	Token       string            `json:"token"`  // New short-lived access token (24h)
+	Certificate *AgentCertificate `json:"certificate,omitempty"`
 }
 
 // RenewToken uses refresh token to get a new access token (proper implementation)
 func (c *Client) RenewToken(agentID uuid.UUID, refreshToken string) error {
+	_, err := c.RenewTokenWithCSR(agentID, refreshToken, "")
+	return err
+}
+
+// RenewTokenWithCSR renews the access token and, if the server issues mTLS
+// certificates, gets a certificate for csr. The certificate is nil when the
+// server didn't issue one.
+func (c *Client) RenewTokenWithCSR(agentID uuid.UUID, refreshToken, csr string) (*AgentCertificate, error) {
 	url := fmt.Sprintf("%s/api/v1/agents/renew", c.baseURL)
 
 	renewalReq := TokenRenewalRequest{
 		AgentID:      agentID,
 		RefreshToken: refreshToken,
+		CSR:          csr,
 	}
 
 	body, err := json.Marshal(renewalReq)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	httpReq, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	httpReq, err := http.NewRequestWithContext(c.context(), "POST", url, bytes.NewBuffer(body))
 	if err != nil {
-		return err
+		return nil, err
 	}
 	httpReq.Header.Set("Content-Type", "application/json")
 
 	resp, err := c.http.Do(httpReq)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("token renewal failed: %s - %s", resp.Status, string(bodyBytes))
+		return nil, fmt.Errorf("token renewal failed: %s - %s", resp.Status, string(bodyBytes))
 	}
 
 	var result TokenRenewalResponse
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
-		return err
+		return nil, err
 	}
 
 	// Update client token
 	c.token = result.Token
 
-	return nil
+	return result.Certificate, nil
+}
+
+// RenewCertificate gets a new mTLS client certificate for csr before the
+// current one expires
+func (c *Client) RenewCertificate(agentID uuid.UUID, csr string) (*AgentCertificate, error) {
+	url := fmt.Sprintf("%s/api/v1/agents/%s/certificate", c.baseURL, agentID)
+
+	body, err := json.Marshal(map[string]string{"csr": csr})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(c.context(), "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("certificate renewal failed: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var result AgentCertificate
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
 }
 
 // Command represents a command from the server
@@ -174,8 +269,10 @@ type Command struct {
 
 // CommandsResponse contains pending commands
 type CommandsResponse struct {
-	Commands     []Command           `json:"commands"`
-	RapidPolling *RapidPollingConfig `json:"rapid_polling,omitempty"`
+	Commands     []Command             `json:"commands"`
+	RapidPolling *RapidPollingConfig   `json:"rapid_polling,omitempty"`
+	Config       *config.ManagedConfig `json:"config,omitempty"` // Sent until the agent acknowledges the profile version
+	LongPoll     int                   `json:"long_poll,omitempty"` // Set by servers that held the request; older servers leave it out
 }
 
 // RapidPollingConfig contains rapid polling configuration from server
@@ -196,6 +293,15 @@ type SystemMetrics struct {
 	Uptime        string                    `json:"uptime,omitempty"`
 	Version       string                    `json:"version,omitempty"`        // Agent version
 	Metadata      map[string]interface{} `json:"metadata,omitempty"`      // Additional metadata
+
+	// Acknowledges the server configuration profile the agent has applied
+	ConfigProfileID string `json:"config_profile_id,omitempty"`
+	ConfigVersion   int    `json:"config_version,omitempty"`
+	ConfigError     string `json:"config_error,omitempty"` // Why the last profile delivered was rejected
+
+	// What the agent can run; sent with full check-ins
+	ProtocolVersion int           `json:"protocol_version,omitempty"`
+	Capabilities    *Capabilities `json:"capabilities,omitempty"`
 }
 
 // GetCommands retrieves pending commands from the server
@@ -212,13 +318,13 @@ func (c *Client) GetCommands(agentID uuid.UUID, metrics *SystemMetrics) ([]Comma
 		if err != nil {
 			return nil, err
 		}
-		req, err = http.NewRequest("GET", url, bytes.NewBuffer(body))
+		req, err = http.NewRequestWithContext(c.context(), "GET", url, bytes.NewBuffer(body))
 		if err != nil {
 			return nil, err
 		}
 		req.Header.Set("Content-Type", "application/json")
 	} else {
-		req, err = http.NewRequest("GET", url, nil)
+		req, err = http.NewRequestWithContext(c.context(), "GET", url, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -251,10 +357,44 @@ func (c *Client) GetCommands(agentID uuid.UUID, metrics *SystemMetrics) ([]Comma
 			c.RapidPollingUntil = until
 		}
 	}
+	c.ManagedConfig = result.Config
 
 	return result.Commands, nil
 }
 
+// WaitForCommands long-polls for commands: the server holds the request until
+// a command is queued for the agent or wait (at most LongPollWait) runs out.
+// supported is false if the server answered right away without long polling;
+// any commands it returned must still be run.
+func (c *Client) WaitForCommands(agentID uuid.UUID, wait time.Duration) (commands []Command, supported bool, err error) {
+	wait = min(wait, LongPollWait)
+	url := fmt.Sprintf("%s/api/v1/agents/%s/commands?wait=%d", c.baseURL, agentID, int(wait/time.Second))
+
+	req, err := http.NewRequestWithContext(c.context(), "GET", url, nil)
+	if err != nil {
+		return nil, false, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.longPoll.Do(req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, false, fmt.Errorf("failed to wait for commands: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var result CommandsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, false, err
+	}
+
+	return result.Commands, result.LongPoll > 0, nil
+}
+
 // UpdateReport represents discovered updates
 type UpdateReport struct {
 	CommandID string               `json:"command_id"`
@@ -277,21 +417,92 @@ type UpdateReportItem struct {
 	Metadata           map[string]interface{} `json:"metadata"`
 }
 
-// ReportUpdates sends discovered updates to the server
+// ReportUpdates sends discovered updates to the server. If the server can't
+// be reached the report is queued in the outbox.
 func (c *Client) ReportUpdates(agentID uuid.UUID, report UpdateReport) error {
-	url := fmt.Sprintf("%s/api/v1/agents/%s/updates", c.baseURL, agentID)
+	body, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	return c.postReport(agentID, "updates", report.CommandID, body)
+}
 
+// LogReport represents an execution log
+type LogReport struct {
+	CommandID       string `json:"command_id"`
+	Action          string `json:"action"`
+	Result          string `json:"result"`
+	Stdout          string `json:"stdout"`
+	Stderr          string `json:"stderr"`
+	ExitCode        int    `json:"exit_code"`
+	DurationSeconds int    `json:"duration_seconds"`
+
+	// Structured extras (e.g. pre-install snapshot) stored in the command result
+	Details map[string]interface{} `json:"details,omitempty"`
+}
+
+// ReportLog sends an execution log to the server. If the server can't be
+// reached the log is queued in the outbox.
+func (c *Client) ReportLog(agentID uuid.UUID, report LogReport) error {
 	body, err := json.Marshal(report)
 	if err != nil {
 		return err
 	}
+	return c.postReport(agentID, "log", report.CommandID, body)
+}
+
+// reportStatusError is a report the server answered with a non-200 status
+type reportStatusError struct {
+	kind       string
+	status     string
+	statusCode int
+	body       string
+}
+
+func (e *reportStatusError) Error() string {
+	return fmt.Sprintf("failed to report %s: %s - %s", e.kind, e.status, e.body)
+}
+
+// retryableReportError reports whether a failed report may succeed later:
+// the server was unreachable, overloaded, or the token needs renewing
+func retryableReportError(err error) bool {
+	var statusErr *reportStatusError
+	if !errors.As(err, &statusErr) {
+		return true
+	}
+	switch statusErr.statusCode {
+	case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+	return statusErr.statusCode >= 500
+}
+
+// postReport sends a report, queueing it in the outbox if that fails in a
+// way a later attempt may not
+func (c *Client) postReport(agentID uuid.UUID, kind, commandID string, body []byte) error {
+	err := c.sendReport(agentID, kind, body, false)
+	if err == nil || c.outbox == nil || !retryableReportError(err) {
+		return err
+	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	if queueErr := c.outbox.Add(kind, commandID, body); queueErr != nil {
+		return fmt.Errorf("%w (queueing for retry failed: %v)", err, queueErr)
+	}
+	return fmt.Errorf("%w (queued for retry)", err)
+}
+
+func (c *Client) sendReport(agentID uuid.UUID, kind string, body []byte, replay bool) error {
+	url := fmt.Sprintf("%s/api/v1/agents/%s/%s", c.baseURL, agentID, reportEndpoints[kind])
+
+	req, err := http.NewRequestWithContext(c.context(), "POST", url, bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+c.token)
+	if replay {
+		req.Header.Set(ReplayHeader, "true")
+	}
 
 	resp, err := c.http.Do(req)
 	if err != nil {
@@ -301,33 +512,67 @@ func (c *Client) ReportUpdates(agentID uuid.UUID, report UpdateReport) error {
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to report updates: %s - %s", resp.Status, string(bodyBytes))
+		return &reportStatusError{kind: kind, status: resp.Status, statusCode: resp.StatusCode, body: string(bodyBytes)}
 	}
 
 	return nil
 }
 
-// LogReport represents an execution log
-type LogReport struct {
-	CommandID       string `json:"command_id"`
-	Action          string `json:"action"`
-	Result          string `json:"result"`
-	Stdout          string `json:"stdout"`
-	Stderr          string `json:"stderr"`
-	ExitCode        int    `json:"exit_code"`
-	DurationSeconds int    `json:"duration_seconds"`
+// DownloadFile streams a file served by the server (e.g. an agent binary at
+// /api/v1/downloads/{platform}) into w. maxBytes caps the download size.
+func (c *Client) DownloadFile(path string, w io.Writer, maxBytes int64) error {
+	url := c.baseURL + path
+
+	// Binaries can take longer than the regular request timeout on slow links
+	ctx, cancel := context.WithTimeout(c.context(), 10*time.Minute)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	httpClient := &http.Client{Transport: c.http.Transport}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("failed to download %s: %s - %s", path, resp.Status, string(bodyBytes))
+	}
+
+	n, err := io.Copy(w, io.LimitReader(resp.Body, maxBytes+1))
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", path, err)
+	}
+	if n > maxBytes {
+		return fmt.Errorf("download %s exceeds %d bytes", path, maxBytes)
+	}
+
+	return nil
 }
 
-// ReportLog sends an execution log to the server
-func (c *Client) ReportLog(agentID uuid.UUID, report LogReport) error {
-	url := fmt.Sprintf("%s/api/v1/agents/%s/logs", c.baseURL, agentID)
+// OutputChunk is a piece of live command output sent while a command is running
+type OutputChunk struct {
+	Seq   int    `json:"seq"`   // Monotonic per command, starting at 1
+	Data  string `json:"data"`
+	Final bool   `json:"final"` // Last chunk for this command
+}
 
-	body, err := json.Marshal(report)
+// ReportCommandOutput sends a chunk of live output for a running command
+func (c *Client) ReportCommandOutput(agentID uuid.UUID, commandID string, chunk OutputChunk) error {
+	url := fmt.Sprintf("%s/api/v1/agents/%s/commands/%s/output", c.baseURL, agentID, commandID)
+
+	body, err := json.Marshal(chunk)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(c.context(), "POST", url, bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
@@ -342,7 +587,7 @@ func (c *Client) ReportLog(agentID uuid.UUID, report LogReport) error {
 
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to report log: %s - %s", resp.Status, string(bodyBytes))
+		return fmt.Errorf("failed to report command output: %s - %s", resp.Status, string(bodyBytes))
 	}
 
 	return nil
@@ -372,34 +617,14 @@ type InstallResult struct {
 	IsDryRun         bool     `json:"is_dry_run"`
 }
 
-// ReportDependencies sends dependency report to the server
+// ReportDependencies sends dependency report to the server. If the server
+// can't be reached the report is queued in the outbox.
 func (c *Client) ReportDependencies(agentID uuid.UUID, report DependencyReport) error {
-	url := fmt.Sprintf("%s/api/v1/agents/%s/dependencies", c.baseURL, agentID)
-
 	body, err := json.Marshal(report)
 	if err != nil {
 		return err
 	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+c.token)
-
-	resp, err := c.http.Do(req)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("failed to report dependencies: %s - %s", resp.Status, string(bodyBytes))
-	}
-
-	return nil
+	return c.postReport(agentID, "dependencies", report.UpdateID, body)
 }
 
 // SystemInfoReport represents system information updates
@@ -426,7 +651,7 @@ func (c *Client) ReportSystemInfo(agentID uuid.UUID, report SystemInfoReport) er
 		return err
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(c.context(), "POST", url, bytes.NewBuffer(body))
 	if err != nil {
 		return err
 	}
@@ -448,6 +673,55 @@ func (c *Client) ReportSystemInfo(agentID uuid.UUID, report SystemInfoReport) er
 	return nil
 }
 
+// SpecChange is a spec field the server saw change since the previous snapshot
+type SpecChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
+
+// SpecsResponse is the server's answer to a specs report
+type SpecsResponse struct {
+	SnapshotID string       `json:"snapshot_id"`
+	Changed    bool         `json:"changed"`
+	Changes    []SpecChange `json:"changes"`
+}
+
+// ReportSpecs sends the host's hardware and software inventory to the server
+func (c *Client) ReportSpecs(agentID uuid.UUID, specs *system.Specs) (*SpecsResponse, error) {
+	url := fmt.Sprintf("%s/api/v1/agents/%s/specs", c.baseURL, agentID)
+
+	body, err := json.Marshal(specs)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(c.context(), "POST", url, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to report specs: %s - %s", resp.Status, string(bodyBytes))
+	}
+
+	var result SpecsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode specs response: %w", err)
+	}
+
+	return &result, nil
+}
+
 // DetectSystem returns basic system information (deprecated, use system.GetSystemInfo instead)
 func DetectSystem() (osType, osVersion, osArch string) {
 	osType = runtime.GOOS
@@ -0,0 +1,249 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outbox limits; the oldest reports are dropped first when either is exceeded
+const (
+	outboxMaxEntries = 500
+	outboxMaxBytes   = 16 << 20
+)
+
+// ReplayHeader marks a report sent from the outbox, so the server can ignore
+// one it already recorded before the agent saw the response
+const ReplayHeader = "X-RedFlag-Replay"
+
+// Report kinds and the agent endpoint each is posted to
+var reportEndpoints = map[string]string{
+	"log":          "logs",
+	"updates":      "updates",
+	"dependencies": "dependencies",
+}
+
+var sharedOutbox *Outbox
+
+// DefaultOutboxDir returns the platform-specific outbox directory
+func DefaultOutboxDir() string {
+	if runtime.GOOS == "windows" {
+		return "C:\\ProgramData\\RedFlag\\outbox"
+	}
+	return "/var/lib/aggregator/outbox"
+}
+
+// EnableOutbox queues reports that fail to upload in dir. Clients created
+// afterwards use it.
+func EnableOutbox(dir string) error {
+	outbox, err := OpenOutbox(dir)
+	if err != nil {
+		return err
+	}
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	sharedOutbox = outbox
+	return nil
+}
+
+// OutboxDepth returns how many reports are waiting in the outbox
+func OutboxDepth() int {
+	transportMu.RLock()
+	outbox := sharedOutbox
+	transportMu.RUnlock()
+	if outbox == nil {
+		return 0
+	}
+	return outbox.Len()
+}
+
+// Outbox is a durable on-disk queue of reports that failed to upload. Each
+// report is one file, named so that sorting the names gives queue order.
+type Outbox struct {
+	dir string
+	mu  sync.Mutex
+}
+
+// outboxEntry is a queued report
+type outboxEntry struct {
+	Kind      string          `json:"kind"`
+	CommandID string          `json:"command_id,omitempty"`
+	QueuedAt  time.Time       `json:"queued_at"`
+	Body      json.RawMessage `json:"body"`
+}
+
+// OpenOutbox opens the outbox in dir, creating the directory if needed
+func OpenOutbox(dir string) (*Outbox, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create outbox directory: %w", err)
+	}
+	return &Outbox{dir: dir}, nil
+}
+
+// Add queues a report. A report identical to one already queued is not
+// queued again.
+func (o *Outbox) Add(kind, commandID string, body []byte) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	sum := sha256.Sum256(append([]byte(kind+"\n"), body...))
+	digest := hex.EncodeToString(sum[:8])
+
+	names, err := o.list()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if strings.HasSuffix(name, "-"+digest+".json") {
+			return nil
+		}
+	}
+
+	data, err := json.Marshal(outboxEntry{Kind: kind, CommandID: commandID, QueuedAt: time.Now(), Body: body})
+	if err != nil {
+		return err
+	}
+	if len(data) > outboxMaxBytes {
+		return fmt.Errorf("report of %d bytes exceeds the outbox limit", len(data))
+	}
+
+	if err := o.evict(names, len(data)); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%020d-%s.json", time.Now().UnixNano(), digest)
+	return writeFileSync(filepath.Join(o.dir, name), data)
+}
+
+// Len returns how many reports are queued
+func (o *Outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	names, err := o.list()
+	if err != nil {
+		return 0
+	}
+	return len(names)
+}
+
+// evict drops the oldest reports until one more of size bytes fits
+func (o *Outbox) evict(names []string, size int) error {
+	sizes := make([]int64, len(names))
+	total := int64(size)
+	for i, name := range names {
+		if info, err := os.Stat(filepath.Join(o.dir, name)); err == nil {
+			sizes[i] = info.Size()
+			total += info.Size()
+		}
+	}
+
+	for i := 0; i < len(names) && (len(names)-i >= outboxMaxEntries || total > outboxMaxBytes); i++ {
+		slog.Warn("outbox full, dropping oldest queued report", "report", names[i])
+		if err := os.Remove(filepath.Join(o.dir, names[i])); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to drop queued report: %w", err)
+		}
+		total -= sizes[i]
+	}
+	return nil
+}
+
+// list returns the queued report file names, oldest first
+func (o *Outbox) list() ([]string, error) {
+	entries, err := os.ReadDir(o.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read outbox: %w", err)
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".json") && !strings.HasPrefix(entry.Name(), ".") {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// FlushOutbox replays queued reports in order. It stops at the first report
+// that still can't be delivered; reports the server rejects outright are
+// dropped so they don't block the queue. Returns how many were delivered.
+func (c *Client) FlushOutbox(agentID uuid.UUID) (int, error) {
+	o := c.outbox
+	if o == nil {
+		return 0, nil
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	names, err := o.list()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, name := range names {
+		path := filepath.Join(o.dir, name)
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return sent, fmt.Errorf("failed to read queued report: %w", err)
+		}
+
+		var entry outboxEntry
+		if err := json.Unmarshal(data, &entry); err != nil || reportEndpoints[entry.Kind] == "" {
+			slog.Warn("dropping unreadable queued report", "report", name)
+			os.Remove(path)
+			continue
+		}
+
+		err = c.sendReport(agentID, entry.Kind, entry.Body, true)
+		if err != nil && retryableReportError(err) {
+			return sent, err
+		}
+		if err != nil {
+			slog.Warn("server rejected queued report, dropping it", "kind", entry.Kind, "command_id", entry.CommandID, "error", err)
+		} else {
+			sent++
+		}
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return sent, fmt.Errorf("failed to remove delivered report: %w", err)
+		}
+	}
+	return sent, nil
+}
+
+// writeFileSync writes a file via a temp file and fsync so a crash never
+// leaves a partial report in the queue
+func writeFileSync(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to queue report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to queue report: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to queue report: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to queue report: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to queue report: %w", err)
+	}
+	return nil
+}
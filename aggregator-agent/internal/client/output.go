@@ -0,0 +1,144 @@
+package client
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+)
+
+// Chunks share the server's per-agent report rate limit with scan results and
+// logs, so output is sent at most every few seconds unless a lot is buffered
+const (
+	outputFlushInterval = 5 * time.Second
+	outputFlushSize     = 48 * 1024 // Flush early once this much output is buffered
+	outputMaxChunk      = 60 * 1024 // Server rejects chunks over 64KB
+	outputMaxPending    = 256 * 1024
+)
+
+// OutputStream batches live command output and sends it to the server in
+// ordered chunks. Delivery is best effort: the complete output is still sent
+// with the final ReportLog, so a chunk that fails to send is dropped rather
+// than holding up the command.
+type OutputStream struct {
+	client    *Client
+	agentID   uuid.UUID
+	commandID string
+
+	mu      sync.Mutex
+	pending []byte
+	seq     int
+	dropped int
+
+	flushNow chan struct{}
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// NewOutputStream starts streaming output for a command
+func NewOutputStream(c *Client, agentID uuid.UUID, commandID string) *OutputStream {
+	s := &OutputStream{
+		client:    c,
+		agentID:   agentID,
+		commandID: commandID,
+		flushNow:  make(chan struct{}, 1),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go s.run()
+	return s
+}
+
+// Write buffers output for the next chunk; it never blocks on the network
+func (s *OutputStream) Write(p []byte) {
+	s.mu.Lock()
+	s.pending = append(s.pending, p...)
+	if over := len(s.pending) - outputMaxPending; over > 0 {
+		// Server is unreachable or slow - keep the most recent output
+		s.pending = s.pending[over:]
+		s.dropped += over
+	}
+	size := len(s.pending)
+	s.mu.Unlock()
+
+	if size >= outputFlushSize {
+		select {
+		case s.flushNow <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Close flushes remaining output and sends the final chunk
+func (s *OutputStream) Close() {
+	close(s.done)
+	<-s.stopped
+}
+
+func (s *OutputStream) run() {
+	defer close(s.stopped)
+
+	ticker := time.NewTicker(outputFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush(false)
+		case <-s.flushNow:
+			s.flush(false)
+		case <-s.done:
+			s.flush(true)
+			return
+		}
+	}
+}
+
+func (s *OutputStream) flush(final bool) {
+	s.mu.Lock()
+	data := s.pending
+	if len(data) > outputMaxChunk {
+		if final {
+			// Only one chunk left to send: keep the end of the output
+			start := len(data) - outputMaxChunk
+			for start < len(data) && !utf8.RuneStart(data[start]) {
+				start++
+			}
+			s.dropped += start
+			s.pending = s.pending[start:]
+			data = s.pending
+		} else {
+			data = data[:outputMaxChunk]
+		}
+	}
+	// Don't split a multi-byte character across chunks
+	if !final {
+		start := len(data) - 1
+		for start > 0 && start > len(data)-utf8.UTFMax && !utf8.RuneStart(data[start]) {
+			start--
+		}
+		if start >= 0 && !utf8.FullRune(data[start:]) {
+			data = data[:start]
+		}
+	}
+	if len(data) == 0 && !final {
+		s.mu.Unlock()
+		return
+	}
+	s.pending = append([]byte(nil), s.pending[len(data):]...)
+	dropped := s.dropped
+	s.dropped = 0
+	s.seq++
+	chunk := OutputChunk{Seq: s.seq, Data: string(data), Final: final}
+	s.mu.Unlock()
+
+	if dropped > 0 {
+		chunk.Data = "[... output truncated ...]\n" + chunk.Data
+	}
+
+	if err := s.client.ReportCommandOutput(s.agentID, s.commandID, chunk); err != nil {
+		slog.Warn("failed to stream command output", "command_id", s.commandID, "error", err)
+	}
+}
@@ -0,0 +1,367 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// maxRetryDelay caps the exponential backoff between attempts
+const maxRetryDelay = 60 * time.Second
+
+// LongPollWait is the longest the agent asks the server to hold a command
+// poll. It stays under the 60s idle timeout common in reverse proxies.
+const LongPollWait = 50 * time.Second
+
+var (
+	transportMu     sync.RWMutex
+	sharedTransport http.RoundTripper = otelhttp.NewTransport(http.DefaultTransport)
+	requestTimeout                    = 30 * time.Second
+
+	// Long polls get their own transport: the server holds them before
+	// answering, and the agent simply polls again rather than retrying
+	longPollTransport http.RoundTripper = otelhttp.NewTransport(http.DefaultTransport)
+	longPollTimeout                     = 30*time.Second + LongPollWait
+)
+
+// ConfigureTransport builds the agent's HTTP transport from the proxy, TLS and
+// network configuration. Clients created afterwards, including the registry
+// client, use it.
+func ConfigureTransport(cfg *config.Config) error {
+	base, err := newBaseTransport(cfg)
+	if err != nil {
+		return err
+	}
+
+	retries := &retryTransport{
+		next:     base,
+		attempts: cfg.Network.RetryCount + 1,
+		delay:    cfg.Network.RetryDelay,
+	}
+
+	longPoll := base.Clone()
+	if longPoll.ResponseHeaderTimeout > 0 {
+		longPoll.ResponseHeaderTimeout += LongPollWait
+	}
+	timeout := cfg.Network.Timeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	transportMu.Lock()
+	defer transportMu.Unlock()
+	// Sends traceparent headers so server spans join the command's trace
+	sharedTransport = otelhttp.NewTransport(retries)
+	requestTimeout = retries.budget(cfg.Network.Timeout)
+	longPollTransport = otelhttp.NewTransport(longPoll)
+	longPollTimeout = timeout + LongPollWait
+	return nil
+}
+
+// HTTPClient returns an http.Client on the shared transport whose timeout
+// covers every retry of a request
+func HTTPClient() *http.Client {
+	transportMu.RLock()
+	defer transportMu.RUnlock()
+	return &http.Client{Transport: sharedTransport, Timeout: requestTimeout}
+}
+
+// longPollHTTPClient returns an http.Client for command polls the server may
+// hold open for up to LongPollWait
+func longPollHTTPClient() *http.Client {
+	transportMu.RLock()
+	defer transportMu.RUnlock()
+	return &http.Client{Transport: longPollTransport, Timeout: longPollTimeout}
+}
+
+// newBaseTransport clones the default transport and applies the config to it
+func newBaseTransport(cfg *config.Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if cfg.Network.Timeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   cfg.Network.Timeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+		transport.ResponseHeaderTimeout = cfg.Network.Timeout
+	}
+	if cfg.Network.MaxIdleConn > 0 {
+		transport.MaxIdleConns = cfg.Network.MaxIdleConn
+		transport.MaxIdleConnsPerHost = cfg.Network.MaxIdleConn
+	}
+
+	if cfg.Proxy.Enabled {
+		proxy, err := proxyFunc(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		transport.Proxy = proxy
+	}
+
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+	transport.TLSClientConfig = tlsConfig
+
+	return transport, nil
+}
+
+// proxyFunc selects the configured proxy per request, honoring NoProxy.
+// Proxy credentials are sent as Proxy-Authorization.
+func proxyFunc(proxy config.ProxyConfig) (func(*http.Request) (*url.URL, error), error) {
+	withAuth := func(raw string) (string, error) {
+		if raw == "" {
+			return "", nil
+		}
+		proxyURL, err := url.Parse(raw)
+		if err != nil || proxyURL.Host == "" {
+			return "", fmt.Errorf("invalid proxy URL %q", raw)
+		}
+		if proxy.Username != "" {
+			proxyURL.User = url.UserPassword(proxy.Username, proxy.Password)
+		}
+		return proxyURL.String(), nil
+	}
+
+	httpProxy, err := withAuth(proxy.HTTP)
+	if err != nil {
+		return nil, err
+	}
+	httpsProxy, err := withAuth(proxy.HTTPS)
+	if err != nil {
+		return nil, err
+	}
+	if httpsProxy == "" {
+		// A single proxy usually serves both schemes
+		httpsProxy = httpProxy
+	}
+
+	selectProxy := (&httpproxy.Config{
+		HTTPProxy:  httpProxy,
+		HTTPSProxy: httpsProxy,
+		NoProxy:    proxy.NoProxy,
+	}).ProxyFunc()
+	return func(req *http.Request) (*url.URL, error) {
+		return selectProxy(req.URL)
+	}, nil
+}
+
+// newTLSConfig adds the CA bundle to the system roots and sets up the client
+// certificate, which is re-read whenever its files change so renewed
+// certificates are picked up without a restart
+func newTLSConfig(cfg config.TLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		if cfg.CertFile == "" || cfg.KeyFile == "" {
+			return nil, fmt.Errorf("tls cert_file and key_file must be set together")
+		}
+		certs := &clientCertificate{certFile: cfg.CertFile, keyFile: cfg.KeyFile}
+		// A server-issued certificate may not exist yet; the agent requests one
+		if _, err := certs.load(); err != nil && !cfg.ServerIssued {
+			return nil, err
+		}
+		tlsConfig.GetClientCertificate = certs.get
+	}
+
+	return tlsConfig, nil
+}
+
+// clientCertificate caches a client certificate, reloading it when the
+// certificate file is modified. If a reload fails the cached certificate
+// stays in use.
+type clientCertificate struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func (c *clientCertificate) load() (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		if c.cert != nil {
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to read client certificate: %w", err)
+	}
+	if c.cert != nil && info.ModTime().Equal(c.modTime) {
+		return c.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	}
+	if err != nil {
+		if c.cert != nil {
+			return c.cert, nil
+		}
+		return nil, fmt.Errorf("failed to load client certificate: %w", err)
+	}
+	c.cert = &cert
+	c.modTime = info.ModTime()
+	return c.cert, nil
+}
+
+// get presents the certificate only to servers that accept its issuer, so
+// registries asking for other certificates aren't sent ours. A missing or
+// expired certificate isn't presented either: the handshake would fail, and
+// without one the agent can still reach the server to get a new one.
+func (c *clientCertificate) get(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	cert, err := c.load()
+	if err != nil {
+		return &tls.Certificate{}, nil
+	}
+	if time.Now().After(cert.Leaf.NotAfter) {
+		return &tls.Certificate{}, nil
+	}
+	if err := info.SupportsCertificate(cert); err != nil {
+		return &tls.Certificate{}, nil
+	}
+	return cert, nil
+}
+
+// retryTransport retries failed requests with exponential backoff. Requests
+// that may have reached the server are only retried when repeating them is
+// safe: idempotent methods on transport errors and 429/502/503/504 responses.
+// Other methods are retried only when the connection couldn't be established.
+type retryTransport struct {
+	next     http.RoundTripper
+	attempts int
+	delay    time.Duration
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		resp, err := t.next.RoundTrip(req)
+		if attempt >= t.attempts || !t.shouldRetry(req, resp, err) {
+			return resp, err
+		}
+
+		wait := t.backoff(attempt)
+		if resp != nil {
+			if after := retryAfter(resp); after > 0 {
+				wait = after
+			}
+			io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+		}
+
+		if req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (t *retryTransport) shouldRetry(req *http.Request, resp *http.Response, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	// A consumed body can only be sent again if it can be recreated
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return false
+	}
+
+	if err != nil {
+		var opErr *net.OpError
+		if errors.As(err, &opErr) && opErr.Op == "dial" {
+			return true
+		}
+		return isIdempotent(req.Method)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return isIdempotent(req.Method)
+	}
+	return false
+}
+
+// backoff returns the wait before retry n: delay, 2*delay, 4*delay, ...
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	wait := t.delay
+	for i := 1; i < attempt && wait < maxRetryDelay; i++ {
+		wait *= 2
+	}
+	return min(wait, maxRetryDelay)
+}
+
+// budget is the overall request timeout: every attempt plus the waits between them
+func (t *retryTransport) budget(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	total := timeout * time.Duration(t.attempts)
+	for attempt := 1; attempt < t.attempts; attempt++ {
+		total += t.backoff(attempt)
+	}
+	return total
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// retryAfter parses a Retry-After header given in seconds, capped at maxRetryDelay
+func retryAfter(resp *http.Response) time.Duration {
+	seconds, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	return min(time.Duration(seconds)*time.Second, maxRetryDelay)
+}
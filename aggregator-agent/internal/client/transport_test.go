@@ -0,0 +1,416 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+)
+
+// testCA issues certificates for TLS tests
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issueClient writes a client certificate and key signed by ca to dir and
+// returns their paths
+func (ca *testCA) issueClient(t *testing.T, dir, name string) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, name+".crt")
+	keyFile = filepath.Join(dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, path, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// serverCAFile writes the httptest TLS server's certificate as a CA bundle
+func serverCAFile(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "ca.pem")
+	writePEM(t, path, "CERTIFICATE", srv.Certificate().Raw)
+	return path
+}
+
+func testHTTPClient(t *testing.T, cfg *config.Config) *http.Client {
+	t.Helper()
+	transport, err := newBaseTransport(cfg)
+	if err != nil {
+		t.Fatalf("newBaseTransport: %v", err)
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+	return &http.Client{Transport: transport, Timeout: 10 * time.Second}
+}
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	io.WriteString(w, "ok")
+}
+
+func TestTransportCustomCA(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(okHandler))
+	defer srv.Close()
+
+	// Without the CA the server's certificate isn't trusted
+	if _, err := testHTTPClient(t, &config.Config{}).Get(srv.URL); err == nil {
+		t.Fatal("request to a server with an untrusted certificate succeeded")
+	}
+
+	cfg := &config.Config{TLS: config.TLSConfig{CAFile: serverCAFile(t, srv)}}
+	resp, err := testHTTPClient(t, cfg).Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request with the CA bundle: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestTransportCAFileWithoutCertificates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(path, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newBaseTransport(&config.Config{TLS: config.TLSConfig{CAFile: path}}); err == nil {
+		t.Fatal("CA file without certificates was accepted")
+	}
+}
+
+func TestTransportMutualTLS(t *testing.T) {
+	serverCA := newTestCA(t, "RedFlag Agent CA")
+	otherCA := newTestCA(t, "Some Other CA")
+
+	var verified atomic.Value
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		verified.Store(r.TLS.PeerCertificates[0].Subject.CommonName)
+		okHandler(w, r)
+	}))
+	srv.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: serverCA.pool()}
+	srv.StartTLS()
+	defer srv.Close()
+	caFile := serverCAFile(t, srv)
+
+	dir := t.TempDir()
+	goodCert, goodKey := serverCA.issueClient(t, dir, "agent-good")
+	otherCert, otherKey := otherCA.issueClient(t, dir, "agent-other")
+
+	t.Run("accepted", func(t *testing.T) {
+		cfg := &config.Config{TLS: config.TLSConfig{CAFile: caFile, CertFile: goodCert, KeyFile: goodKey}}
+		resp, err := testHTTPClient(t, cfg).Get(srv.URL)
+		if err != nil {
+			t.Fatalf("mTLS request: %v", err)
+		}
+		resp.Body.Close()
+		if got, _ := verified.Load().(string); got != "agent-good" {
+			t.Fatalf("server saw client certificate %q", got)
+		}
+	})
+
+	t.Run("certificate from another CA", func(t *testing.T) {
+		// The certificate isn't presented to a server that doesn't accept its issuer
+		cfg := &config.Config{TLS: config.TLSConfig{CAFile: caFile, CertFile: otherCert, KeyFile: otherKey}}
+		if _, err := testHTTPClient(t, cfg).Get(srv.URL); err == nil {
+			t.Fatal("server accepted a client without a certificate it trusts")
+		}
+	})
+
+	t.Run("no certificate", func(t *testing.T) {
+		cfg := &config.Config{TLS: config.TLSConfig{CAFile: caFile}}
+		if _, err := testHTTPClient(t, cfg).Get(srv.URL); err == nil {
+			t.Fatal("server accepted a client without a certificate")
+		}
+	})
+
+	t.Run("missing server-issued certificate", func(t *testing.T) {
+		// An agent waiting for its first certificate still builds a transport
+		cfg := &config.Config{TLS: config.TLSConfig{
+			CAFile: caFile, CertFile: filepath.Join(dir, "missing.crt"), KeyFile: filepath.Join(dir, "missing.key"), ServerIssued: true,
+		}}
+		if _, err := testHTTPClient(t, cfg).Get(srv.URL); err == nil {
+			t.Fatal("server accepted a client without a certificate")
+		}
+		cfg.TLS.ServerIssued = false
+		if _, err := newBaseTransport(cfg); err == nil {
+			t.Fatal("missing client certificate was accepted")
+		}
+	})
+}
+
+// forwardProxy answers every request itself, recording what it was asked for
+type forwardProxy struct {
+	*httptest.Server
+	requests      atomic.Int32
+	authorization atomic.Value
+	target        atomic.Value
+}
+
+func newForwardProxy(t *testing.T) *forwardProxy {
+	t.Helper()
+	p := &forwardProxy{}
+	p.authorization.Store("")
+	p.target.Store("")
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.requests.Add(1)
+		p.authorization.Store(r.Header.Get("Proxy-Authorization"))
+		p.target.Store(r.URL.String())
+		io.WriteString(w, "via proxy")
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestTransportProxyAuth(t *testing.T) {
+	proxy := newForwardProxy(t)
+	cfg := &config.Config{Proxy: config.ProxyConfig{
+		Enabled: true, HTTP: proxy.URL, Username: "agent", Password: "p@ss:word",
+	}}
+
+	resp, err := testHTTPClient(t, cfg).Get("http://updates.example.com/api/v1/health")
+	if err != nil {
+		t.Fatalf("request through proxy: %v", err)
+	}
+	resp.Body.Close()
+
+	if proxy.requests.Load() != 1 {
+		t.Fatalf("proxy got %d requests, want 1", proxy.requests.Load())
+	}
+	if got := proxy.target.Load().(string); got != "http://updates.example.com/api/v1/health" {
+		t.Errorf("proxy asked for %q", got)
+	}
+	want := "Basic " + base64.StdEncoding.EncodeToString([]byte("agent:p@ss:word"))
+	if got := proxy.authorization.Load().(string); got != want {
+		t.Errorf("Proxy-Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestTransportNoProxyBypass(t *testing.T) {
+	proxy := newForwardProxy(t)
+	var direct atomic.Int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		direct.Add(1)
+		okHandler(w, r)
+	}))
+	defer target.Close()
+
+	cfg := &config.Config{Proxy: config.ProxyConfig{
+		Enabled: true, HTTP: proxy.URL, NoProxy: "internal.example.com,.corp.example",
+	}}
+	transport, err := newBaseTransport(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.CloseIdleConnections()
+
+	// Resolve the bypassed hosts to the target server
+	targetAddr := target.Listener.Addr().String()
+	dial := transport.DialContext
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, _ := net.SplitHostPort(addr); host == "internal.example.com" || strings.HasSuffix(host, ".corp.example") {
+			addr = targetAddr
+		}
+		return dial(ctx, network, addr)
+	}
+	httpClient := &http.Client{Transport: transport, Timeout: 10 * time.Second}
+
+	for _, u := range []string{"http://internal.example.com/", "http://redflag.corp.example/"} {
+		resp, err := httpClient.Get(u)
+		if err != nil {
+			t.Fatalf("GET %s: %v", u, err)
+		}
+		resp.Body.Close()
+	}
+	if direct.Load() != 2 || proxy.requests.Load() != 0 {
+		t.Fatalf("direct=%d proxied=%d, want NoProxy hosts reached directly", direct.Load(), proxy.requests.Load())
+	}
+
+	resp, err := httpClient.Get("http://updates.example.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if proxy.requests.Load() != 1 {
+		t.Fatalf("other hosts didn't go through the proxy")
+	}
+}
+
+func TestProxyFuncHTTPSFallsBackToHTTPProxy(t *testing.T) {
+	proxy, err := proxyFunc(config.ProxyConfig{HTTP: "http://proxy.corp:3128"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &http.Request{URL: &url.URL{Scheme: "https", Host: "updates.example.com"}}
+	got, err := proxy(req)
+	if err != nil || got == nil || got.Host != "proxy.corp:3128" {
+		t.Fatalf("https proxy = %v, %v; want the http proxy", got, err)
+	}
+
+	if _, err := proxyFunc(config.ProxyConfig{HTTP: "not a url"}); err == nil {
+		t.Fatal("invalid proxy URL was accepted")
+	}
+}
+
+// flakyServer answers 503 until it has been hit failures times
+func flakyServer(t *testing.T, failures int32) (*httptest.Server, *atomic.Int32) {
+	t.Helper()
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		if hits.Add(1) <= failures {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		okHandler(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &hits
+}
+
+func retryingClient(attempts int) *http.Client {
+	return &http.Client{Transport: &retryTransport{next: http.DefaultTransport, attempts: attempts, delay: time.Millisecond}}
+}
+
+func TestRetryTransportRetriesGetOn503(t *testing.T) {
+	srv, hits := flakyServer(t, 2)
+
+	resp, err := retryingClient(3).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || hits.Load() != 3 {
+		t.Fatalf("status %d after %d attempts, want 200 after 3", resp.StatusCode, hits.Load())
+	}
+}
+
+func TestRetryTransportGivesUpAfterAttempts(t *testing.T) {
+	srv, hits := flakyServer(t, 10)
+
+	resp, err := retryingClient(3).Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || hits.Load() != 3 {
+		t.Fatalf("status %d after %d attempts, want 503 after 3", resp.StatusCode, hits.Load())
+	}
+}
+
+func TestRetryTransportDoesNotRetryPostOn503(t *testing.T) {
+	srv, hits := flakyServer(t, 1)
+
+	resp, err := retryingClient(3).Post(srv.URL, "application/json", strings.NewReader(`{"result":"success"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || hits.Load() != 1 {
+		t.Fatalf("status %d after %d attempts; a POST that reached the server must not be repeated", resp.StatusCode, hits.Load())
+	}
+}
+
+func TestRetryTransportRetriesPostWhenDialFails(t *testing.T) {
+	// Reserve a port, then close it so connections are refused
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	var attempts atomic.Int32
+	transport := &retryTransport{
+		next: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			attempts.Add(1)
+			return http.DefaultTransport.RoundTrip(req)
+		}),
+		attempts: 3,
+		delay:    time.Millisecond,
+	}
+	_, err = (&http.Client{Transport: transport}).Post("http://"+addr+"/", "application/json", strings.NewReader(`{}`))
+	if err == nil {
+		t.Fatal("POST to a closed port succeeded")
+	}
+	if attempts.Load() != 3 {
+		t.Fatalf("POST was attempted %d times, want 3: the request never reached the server", attempts.Load())
+	}
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
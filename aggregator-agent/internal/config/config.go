@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 	"time"
 
@@ -26,6 +27,7 @@ type TLSConfig struct {
 	CertFile           string `json:"cert_file,omitempty"`   // Client certificate file
 	KeyFile            string `json:"key_file,omitempty"`    // Client key file
 	CAFile             string `json:"ca_file,omitempty"`     // CA certificate file
+	ServerIssued       bool   `json:"server_issued,omitempty"` // Client certificate is issued and renewed by the RedFlag server (mTLS)
 }
 
 // NetworkConfig holds network-related configuration
@@ -34,6 +36,8 @@ type NetworkConfig struct {
 	RetryCount  int           `json:"retry_count"`   // Number of retries
 	RetryDelay  time.Duration `json:"retry_delay"`   // Delay between retries
 	MaxIdleConn int           `json:"max_idle_conn"` // Maximum idle connections
+
+	DisableLongPoll bool `json:"disable_long_poll"` // Only poll for commands at each check-in
 }
 
 // LoggingConfig holds logging configuration
@@ -45,6 +49,82 @@ type LoggingConfig struct {
 	MaxAge     int    `json:"max_age"`     // Max age of log files in days
 }
 
+// TracingConfig holds OpenTelemetry tracing configuration
+type TracingConfig struct {
+	Exporter string `json:"exporter,omitempty"` // none, stdout or otlp
+	Endpoint string `json:"endpoint,omitempty"` // OTLP/HTTP URL (optional, defaults to OTEL_EXPORTER_OTLP_* env)
+}
+
+// SnapshotConfig holds pre-install filesystem snapshot configuration
+type SnapshotConfig struct {
+	Enabled   bool   `json:"enabled"`            // Snapshot the root filesystem before installing packages
+	Required  bool   `json:"required,omitempty"` // Abort the install if the snapshot fails
+	Retention int    `json:"retention,omitempty"` // RedFlag snapshots to keep (default 3)
+	LVMSize   string `json:"lvm_size,omitempty"`  // Copy-on-write size for LVM snapshots, rounded up to whole gigabytes (default 5G, max 999G)
+}
+
+// HooksConfig holds pre/post hook script configuration
+type HooksConfig struct {
+	Dir            string `json:"dir,omitempty"`             // Root of the <phase>.d directories (default /etc/aggregator/hooks)
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"` // Per-hook timeout unless the script sets its own (default 300)
+	Disabled       bool   `json:"disabled,omitempty"`        // Skip hooks entirely
+}
+
+// ControlConfig holds the local control socket configuration
+type ControlConfig struct {
+	Socket   string `json:"socket,omitempty"`   // Unix socket path (default /run/redflag-agent/control.sock)
+	Group    string `json:"group,omitempty"`    // Group allowed to use the socket besides root (default redflag)
+	Disabled bool   `json:"disabled,omitempty"` // Don't open the control socket
+}
+
+// HealthCheckConfig declares a check run after installs and reboots
+type HealthCheckConfig struct {
+	Name           string   `json:"name"`
+	Type           string   `json:"type"`                       // http, tcp, systemd or command
+	URL            string   `json:"url,omitempty"`              // http: URL to GET
+	ExpectStatus   int      `json:"expect_status,omitempty"`    // http: expected status code (default 200)
+	Address        string   `json:"address,omitempty"`          // tcp: host:port that must accept connections
+	Unit           string   `json:"unit,omitempty"`             // systemd: unit that must be active
+	Command        []string `json:"command,omitempty"`          // command: program and arguments
+	ExpectExitCode int      `json:"expect_exit_code,omitempty"` // command: expected exit code (default 0)
+	TimeoutSeconds int      `json:"timeout_seconds,omitempty"`  // Per-attempt timeout (default 10)
+	Retries        int      `json:"retries,omitempty"`          // Extra attempts, 5 seconds apart, while services come up
+}
+
+// DockerTagRule limits how far tag tracking may move images matching a pattern
+type DockerTagRule struct {
+	Image string `json:"image"` // Repository pattern without tag, e.g. "postgres" or "ghcr.io/org/*" (path.Match syntax)
+	Bump  string `json:"bump"`  // none, patch, minor or major
+}
+
+// DockerConfig holds Docker scanning options
+type DockerConfig struct {
+	TagTracking []DockerTagRule `json:"tag_tracking,omitempty"` // First matching rule wins; other images report up to major bumps
+}
+
+// ManagedConfig is a configuration profile pushed by the server. Its settings
+// only apply where the local configuration leaves the default value.
+type ManagedConfig struct {
+	ProfileID       string   `json:"profile_id"` // Empty when the server has no profile for this agent
+	ProfileName     string   `json:"profile_name,omitempty"`
+	Version         int      `json:"version"`
+	CheckInInterval int      `json:"check_in_interval,omitempty"`
+	LogLevel        string   `json:"log_level,omitempty"`
+	Scanners        []string `json:"scanners,omitempty"`
+}
+
+// managedSettings holds the settings a server profile can manage
+type managedSettings struct {
+	CheckInInterval int
+	LogLevel        string
+	Scanners        []string
+}
+
+// Scanner names accepted in Config.Scanners
+var validScanners = map[string]bool{
+	"apt": true, "dnf": true, "docker": true, "podman": true, "windows_update": true, "winget": true,
+}
+
 // Config holds agent configuration
 type Config struct {
 	// Server Configuration
@@ -75,6 +155,33 @@ type Config struct {
 	// Logging Configuration
 	Logging LoggingConfig `json:"logging,omitempty"`
 
+	// Tracing Configuration
+	Tracing TracingConfig `json:"tracing,omitempty"`
+
+	// Pre-install Snapshots
+	Snapshots SnapshotConfig `json:"snapshots,omitempty"`
+
+	// Install/Reboot Hooks
+	Hooks HooksConfig `json:"hooks,omitempty"`
+
+	// Local control socket for status and scan-now/check-in-now
+	Control ControlConfig `json:"control,omitempty"`
+
+	// Post-install/reboot Health Checks
+	HealthChecks []HealthCheckConfig `json:"health_checks,omitempty"`
+
+	// Docker image scanning
+	Docker DockerConfig `json:"docker,omitempty"`
+
+	// Scanners run by scan_updates (apt, dnf, docker, podman, windows_update, winget); empty runs all available
+	Scanners []string `json:"scanners,omitempty"`
+
+	// Server-managed configuration profile, as last applied
+	Managed *ManagedConfig `json:"managed,omitempty"`
+
+	// Local values of the managed settings, before the server profile is applied
+	local managedSettings
+
 	// Agent Metadata
 	Tags         []string          `json:"tags,omitempty"`         // User-defined tags
 	Metadata     map[string]string `json:"metadata,omitempty"`     // Custom metadata
@@ -104,6 +211,16 @@ func Load(configPath string, cliFlags *CLIFlags) (*Config, error) {
 		mergeConfig(config, loadFromFlags(cliFlags))
 	}
 
+	// Remember the local settings so a server profile only fills in defaults
+	config.local = managedSettings{
+		CheckInInterval: config.CheckInInterval,
+		LogLevel:        config.Logging.Level,
+		Scanners:        config.Scanners,
+	}
+	if config.Managed != nil {
+		config.applyManaged()
+	}
+
 	// Validate configuration
 	if err := validateConfig(config); err != nil {
 		return nil, fmt.Errorf("invalid configuration: %w", err)
@@ -196,11 +313,17 @@ func loadFromEnv() *Config {
 		config.Proxy.NoProxy = noProxy
 	}
 	if logLevel := os.Getenv("REDFLAG_LOG_LEVEL"); logLevel != "" {
-		if config.Logging == (LoggingConfig{}) {
-			config.Logging = LoggingConfig{}
-		}
 		config.Logging.Level = logLevel
 	}
+	if logFile := os.Getenv("REDFLAG_LOG_FILE"); logFile != "" {
+		config.Logging.File = logFile
+	}
+	if exporter := os.Getenv("REDFLAG_TRACING_EXPORTER"); exporter != "" {
+		config.Tracing.Exporter = exporter
+	}
+	if endpoint := os.Getenv("REDFLAG_TRACING_ENDPOINT"); endpoint != "" {
+		config.Tracing.Endpoint = endpoint
+	}
 	if org := os.Getenv("REDFLAG_ORGANIZATION"); org != "" {
 		config.Organization = org
 	}
@@ -283,8 +406,49 @@ func mergeConfig(target, source *Config) {
 	if source.TLS != (TLSConfig{}) {
 		target.TLS = source.TLS
 	}
-	if source.Logging != (LoggingConfig{}) {
-		target.Logging = source.Logging
+	if source.Snapshots != (SnapshotConfig{}) {
+		target.Snapshots = source.Snapshots
+	}
+	if source.Hooks != (HooksConfig{}) {
+		target.Hooks = source.Hooks
+	}
+	if source.Control != (ControlConfig{}) {
+		target.Control = source.Control
+	}
+	if source.HealthChecks != nil {
+		target.HealthChecks = source.HealthChecks
+	}
+	if source.Docker.TagTracking != nil {
+		target.Docker.TagTracking = source.Docker.TagTracking
+	}
+	if source.Scanners != nil {
+		target.Scanners = source.Scanners
+	}
+	if source.Managed != nil {
+		target.Managed = source.Managed
+	}
+	if source.Tracing.Exporter != "" {
+		target.Tracing.Exporter = source.Tracing.Exporter
+	}
+	if source.Tracing.Endpoint != "" {
+		target.Tracing.Endpoint = source.Tracing.Endpoint
+	}
+
+	// Merge logging field by field so a CLI/env log level doesn't reset rotation settings
+	if source.Logging.Level != "" {
+		target.Logging.Level = source.Logging.Level
+	}
+	if source.Logging.File != "" {
+		target.Logging.File = source.Logging.File
+	}
+	if source.Logging.MaxSize > 0 {
+		target.Logging.MaxSize = source.Logging.MaxSize
+	}
+	if source.Logging.MaxBackups > 0 {
+		target.Logging.MaxBackups = source.Logging.MaxBackups
+	}
+	if source.Logging.MaxAge > 0 {
+		target.Logging.MaxAge = source.Logging.MaxAge
 	}
 
 	// Merge metadata
@@ -338,13 +502,177 @@ func validateConfig(config *Config) error {
 	if config.Logging.Level != "" && !validLogLevels[config.Logging.Level] {
 		return fmt.Errorf("invalid log level: %s", config.Logging.Level)
 	}
+	if config.Logging.MaxSize < 0 || config.Logging.MaxBackups < 0 || config.Logging.MaxAge < 0 {
+		return fmt.Errorf("log rotation settings cannot be negative")
+	}
+
+	if config.Hooks.TimeoutSeconds < 0 {
+		return fmt.Errorf("hook timeout cannot be negative")
+	}
+	if config.Snapshots.Retention < 0 {
+		return fmt.Errorf("snapshot retention cannot be negative")
+	}
+	if err := validateHealthChecks(config.HealthChecks); err != nil {
+		return err
+	}
+	for i, rule := range config.Docker.TagTracking {
+		if _, err := path.Match(rule.Image, ""); rule.Image == "" || err != nil {
+			return fmt.Errorf("docker tag tracking rule %d: invalid image pattern %q", i+1, rule.Image)
+		}
+		switch rule.Bump {
+		case "none", "patch", "minor", "major":
+		default:
+			return fmt.Errorf("docker tag tracking rule %d: invalid bump %q (expected none, patch, minor or major)", i+1, rule.Bump)
+		}
+	}
+
+	switch config.Tracing.Exporter {
+	case "", "none", "stdout", "otlp":
+	default:
+		return fmt.Errorf("invalid tracing exporter: %s", config.Tracing.Exporter)
+	}
+
+	for _, name := range config.Scanners {
+		if !validScanners[name] {
+			return fmt.Errorf("invalid scanner: %s", name)
+		}
+	}
 
 	return nil
 }
 
+// ApplyManaged applies a configuration profile from the server. Settings
+// configured locally (config file, environment or flags) to something other
+// than the default keep their local value. A profile without an ID removes
+// the managed configuration. The caller saves the config.
+func (c *Config) ApplyManaged(managed *ManagedConfig) error {
+	if managed.ProfileID == "" {
+		c.Managed = nil
+		c.applyManaged()
+		return nil
+	}
+
+	if managed.CheckInInterval != 0 && (managed.CheckInInterval < 30 || managed.CheckInInterval > 3600) {
+		return fmt.Errorf("check_in_interval must be between 30 and 3600 seconds")
+	}
+	switch managed.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("invalid log level: %s", managed.LogLevel)
+	}
+	for _, name := range managed.Scanners {
+		if !validScanners[name] {
+			return fmt.Errorf("invalid scanner: %s", name)
+		}
+	}
+
+	c.Managed = managed
+	c.applyManaged()
+	return nil
+}
+
+// applyManaged recomputes the managed settings from the local values and the
+// server profile
+func (c *Config) applyManaged() {
+	defaults := getDefaultConfig()
+	settings := c.localSettings()
+
+	if m := c.Managed; m != nil {
+		if settings.CheckInInterval == defaults.CheckInInterval && m.CheckInInterval != 0 {
+			settings.CheckInInterval = m.CheckInInterval
+		}
+		if settings.LogLevel == defaults.Logging.Level && m.LogLevel != "" {
+			settings.LogLevel = m.LogLevel
+		}
+		if len(settings.Scanners) == 0 && len(m.Scanners) > 0 {
+			settings.Scanners = m.Scanners
+		}
+	}
+
+	c.CheckInInterval = settings.CheckInInterval
+	c.Logging.Level = settings.LogLevel
+	c.Scanners = settings.Scanners
+}
+
+// localSettings returns the local values of the managed settings, with
+// defaults filled in
+func (c *Config) localSettings() managedSettings {
+	defaults := getDefaultConfig()
+	settings := c.local
+	if settings.CheckInInterval == 0 {
+		settings.CheckInInterval = defaults.CheckInInterval
+	}
+	if settings.LogLevel == "" {
+		settings.LogLevel = defaults.Logging.Level
+	}
+	return settings
+}
+
+// ScannerEnabled reports whether scan_updates should run the named scanner
+func (c *Config) ScannerEnabled(name string) bool {
+	if len(c.Scanners) == 0 {
+		return true
+	}
+	for _, scanner := range c.Scanners {
+		if scanner == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateHealthChecks checks each health check has the fields its type needs
+func validateHealthChecks(checks []HealthCheckConfig) error {
+	names := make(map[string]bool)
+	for i, check := range checks {
+		if check.Name == "" {
+			return fmt.Errorf("health check %d: name is required", i+1)
+		}
+		if names[check.Name] {
+			return fmt.Errorf("health check %q is defined more than once", check.Name)
+		}
+		names[check.Name] = true
+
+		switch check.Type {
+		case "http":
+			if check.URL == "" {
+				return fmt.Errorf("health check %q: url is required for http checks", check.Name)
+			}
+		case "tcp":
+			if check.Address == "" {
+				return fmt.Errorf("health check %q: address is required for tcp checks", check.Name)
+			}
+		case "systemd":
+			if check.Unit == "" {
+				return fmt.Errorf("health check %q: unit is required for systemd checks", check.Name)
+			}
+		case "command":
+			if len(check.Command) == 0 {
+				return fmt.Errorf("health check %q: command is required for command checks", check.Name)
+			}
+		default:
+			return fmt.Errorf("health check %q: invalid type %q (expected http, tcp, systemd or command)", check.Name, check.Type)
+		}
+
+		if check.TimeoutSeconds < 0 || check.Retries < 0 {
+			return fmt.Errorf("health check %q: timeout_seconds and retries cannot be negative", check.Name)
+		}
+	}
+	return nil
+}
+
 // Save writes configuration to file
 func (c *Config) Save(configPath string) error {
-	data, err := json.MarshalIndent(c, "", "  ")
+	// Write the local values of managed settings so the server's don't become local overrides
+	saved := *c
+	if c.Managed != nil {
+		local := c.localSettings()
+		saved.CheckInInterval = local.CheckInInterval
+		saved.Logging.Level = local.LogLevel
+		saved.Scanners = local.Scanners
+	}
+
+	data, err := json.MarshalIndent(&saved, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal config: %w", err)
 	}
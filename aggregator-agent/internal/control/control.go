@@ -0,0 +1,216 @@
+// Package control serves the agent's local control socket, which lets root and
+// members of the redflag group see what the running agent is doing and ask it
+// to check in or scan right away.
+//
+// The socket speaks plain HTTP:
+//
+//	GET  /status        current state as JSON
+//	POST /check-in-now  wake the agent for an immediate check-in
+//	POST /scan-now      run a local update scan, then check in
+package control
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSocket is the control socket path when the agent config doesn't set one
+const DefaultSocket = "/run/redflag-agent/control.sock"
+
+// DefaultGroup may use the control socket besides root
+const DefaultGroup = "redflag"
+
+// Agent states
+const (
+	StateIdle           = "idle"
+	StateCheckingIn     = "checking_in"
+	StateScanning       = "scanning"
+	StateInstalling     = "installing"
+	StateCollectingInfo = "collecting_info"
+	StateRunning        = "running" // Any other command
+)
+
+// maxErrors is how many recent errors the status keeps
+const maxErrors = 10
+
+// Status is the running agent's state as reported on the control socket
+type Status struct {
+	AgentID       string       `json:"agent_id"`
+	Version       string       `json:"version"`
+	PID           int          `json:"pid"`
+	StartedAt     time.Time    `json:"started_at"`
+	State         string       `json:"state"`
+	CommandType   string       `json:"command_type,omitempty"`
+	CommandID     string       `json:"command_id,omitempty"` // Empty for a locally requested scan
+	StateSince    time.Time    `json:"state_since"`
+	LastCheckIn   *time.Time   `json:"last_check_in,omitempty"`
+	NextCheckIn   *time.Time   `json:"next_check_in,omitempty"`
+	OutboxDepth   int          `json:"outbox_depth"`
+	ConfigProfile string       `json:"config_profile,omitempty"`
+	ConfigVersion int          `json:"config_version"`
+	ConfigError   string       `json:"config_error,omitempty"`
+	ScanRequested bool         `json:"scan_requested"`
+	LastErrors    []ErrorEntry `json:"last_errors"`
+}
+
+// ErrorEntry is a recent error from the agent loop
+type ErrorEntry struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// StateForCommand returns the state the agent is in while running a command
+func StateForCommand(commandType string) string {
+	switch commandType {
+	case "scan_updates":
+		return StateScanning
+	case "install_updates", "dry_run_update", "confirm_dependencies", "rollback_update", "update_agent":
+		return StateInstalling
+	case "collect_specs":
+		return StateCollectingInfo
+	default:
+		return StateRunning
+	}
+}
+
+// Tracker holds the agent's current state for the control socket and carries
+// check-in and scan requests back to the agent loop. It is safe for
+// concurrent use.
+type Tracker struct {
+	mu            sync.Mutex
+	status        Status
+	outboxDepth   func() int
+	scanRequested bool
+	wake          chan struct{}
+}
+
+// NewTracker creates a tracker for an idle agent. outboxDepth, if set, is
+// called for each status request.
+func NewTracker(agentID, version string, pid int, outboxDepth func() int) *Tracker {
+	now := time.Now()
+	return &Tracker{
+		status: Status{
+			AgentID:    agentID,
+			Version:    version,
+			PID:        pid,
+			StartedAt:  now,
+			State:      StateIdle,
+			StateSince: now,
+			LastErrors: []ErrorEntry{},
+		},
+		outboxDepth: outboxDepth,
+		wake:        make(chan struct{}, 1),
+	}
+}
+
+// SetState records what the agent is doing; commandType and commandID may be empty
+func (t *Tracker) SetState(state, commandType, commandID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.State = state
+	t.status.CommandType = commandType
+	t.status.CommandID = commandID
+	t.status.StateSince = time.Now()
+}
+
+// SetIdle records that the agent is waiting for its next check-in
+func (t *Tracker) SetIdle() {
+	t.SetState(StateIdle, "", "")
+}
+
+// CheckedIn records a successful check-in
+func (t *Tracker) CheckedIn() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.status.LastCheckIn = &now
+}
+
+// SetConfig records the server configuration profile in effect
+func (t *Tracker) SetConfig(profile string, version int, configError string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.ConfigProfile = profile
+	t.status.ConfigVersion = version
+	t.status.ConfigError = configError
+}
+
+// RecordError keeps err among the recent errors
+func (t *Tracker) RecordError(err error) {
+	if err == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.LastErrors = append(t.status.LastErrors, ErrorEntry{Time: time.Now(), Message: err.Error()})
+	if len(t.status.LastErrors) > maxErrors {
+		t.status.LastErrors = t.status.LastErrors[len(t.status.LastErrors)-maxErrors:]
+	}
+}
+
+// Status returns a copy of the current status
+func (t *Tracker) Status() Status {
+	t.mu.Lock()
+	status := t.status
+	status.LastErrors = append([]ErrorEntry{}, t.status.LastErrors...)
+	status.ScanRequested = t.scanRequested
+	t.mu.Unlock()
+
+	if t.outboxDepth != nil {
+		status.OutboxDepth = t.outboxDepth()
+	}
+	return status
+}
+
+// SetNextCheckIn records when the agent will next check in
+func (t *Tracker) SetNextCheckIn(next time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.status.NextCheckIn = &next
+}
+
+// Woken receives once a check-in or scan is requested, for waits that can't
+// use Sleep
+func (t *Tracker) Woken() <-chan struct{} {
+	return t.wake
+}
+
+// Sleep waits d before the next check-in, returning early (and true) if a
+// check-in or scan is requested meanwhile
+func (t *Tracker) Sleep(d time.Duration) bool {
+	t.SetNextCheckIn(time.Now().Add(d))
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return false
+	case <-t.wake:
+		return true
+	}
+}
+
+// RequestCheckIn wakes the agent for an immediate check-in
+func (t *Tracker) RequestCheckIn() {
+	select {
+	case t.wake <- struct{}{}:
+	default: // Already pending
+	}
+}
+
+// RequestScan asks the agent to run a local update scan, then check in
+func (t *Tracker) RequestScan() {
+	t.mu.Lock()
+	t.scanRequested = true
+	t.mu.Unlock()
+	t.RequestCheckIn()
+}
+
+// TakeScanRequest reports whether a scan was requested, clearing the request
+func (t *Tracker) TakeScanRequest() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	requested := t.scanRequested
+	t.scanRequested = false
+	return requested
+}
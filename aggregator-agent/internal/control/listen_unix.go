@@ -0,0 +1,113 @@
+//go:build !windows
+
+package control
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// Listen opens the control socket at path. The socket file is readable and
+// writable by its owner and group only, and group-owned by group when the
+// agent is allowed to do that; connections from anyone but root, the agent's
+// own user and members of group are refused.
+func Listen(path, group string) (net.Listener, error) {
+	if path == "" {
+		path = DefaultSocket
+	}
+	if group == "" {
+		group = DefaultGroup
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	// A socket left behind by a previous run would make the listen fail
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale control socket: %w", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		l.Close()
+		return nil, fmt.Errorf("failed to set control socket permissions: %w", err)
+	}
+
+	gid := -1
+	if g, err := user.LookupGroup(group); err != nil {
+		slog.Warn("control group not found, control socket limited to root and the agent user", "group", group)
+	} else if gid, err = strconv.Atoi(g.Gid); err == nil {
+		if err := os.Chown(path, -1, gid); err != nil {
+			slog.Warn("failed to give control group the control socket", "group", group, "error", err)
+		}
+	}
+
+	return &peerCheckListener{Listener: l, gid: gid}, nil
+}
+
+// peerCheckListener drops connections from peers that aren't allowed to use
+// the control socket
+type peerCheckListener struct {
+	net.Listener
+	gid int // -1 when the group doesn't exist
+}
+
+func (l *peerCheckListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+
+		uid, gid, err := peerCredentials(conn)
+		if err != nil {
+			slog.Warn("refusing control socket connection", "error", err)
+			conn.Close()
+			continue
+		}
+		if !l.allowed(uid, gid) {
+			slog.Warn("refusing control socket connection, peer is not root or in the control group", "uid", uid)
+			conn.Close()
+			continue
+		}
+		return conn, nil
+	}
+}
+
+// allowed reports whether a peer may use the socket. uid -1 means the
+// platform can't tell, leaving the socket file permissions to decide.
+func (l *peerCheckListener) allowed(uid, gid int) bool {
+	if uid == -1 || uid == 0 || uid == os.Getuid() {
+		return true
+	}
+	if l.gid == -1 {
+		return false
+	}
+	if gid == l.gid {
+		return true
+	}
+
+	u, err := user.LookupId(strconv.Itoa(uid))
+	if err != nil {
+		return false
+	}
+	groups, err := u.GroupIds()
+	if err != nil {
+		return false
+	}
+	for _, g := range groups {
+		if g == strconv.Itoa(l.gid) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package control
+
+import (
+	"fmt"
+	"net"
+)
+
+// Listen is not supported on Windows; the service is managed through the
+// Service Control Manager instead
+func Listen(path, group string) (net.Listener, error) {
+	return nil, fmt.Errorf("the control socket is not supported on Windows")
+}
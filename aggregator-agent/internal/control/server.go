@@ -0,0 +1,118 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Serve answers control requests on l until it is closed
+func Serve(l net.Listener, t *Tracker) error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, t.Status())
+	})
+	mux.HandleFunc("POST /check-in-now", func(w http.ResponseWriter, r *http.Request) {
+		slog.Info("check-in requested over the control socket")
+		t.RequestCheckIn()
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "check-in requested"})
+	})
+	mux.HandleFunc("POST /scan-now", func(w http.ResponseWriter, r *http.Request) {
+		if status := t.Status(); status.State == StateScanning {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": "a scan is already running"})
+			return
+		}
+		slog.Info("update scan requested over the control socket")
+		t.RequestScan()
+		writeJSON(w, http.StatusAccepted, map[string]string{"message": "scan requested"})
+	})
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) && !errors.Is(err, net.ErrClosed) {
+		return err
+	}
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Client talks to a running agent over its control socket
+type Client struct {
+	http *http.Client
+}
+
+// NewClient creates a client for the control socket at path
+func NewClient(path string) *Client {
+	return &Client{
+		http: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", path)
+				},
+			},
+		},
+	}
+}
+
+// Status returns the running agent's status
+func (c *Client) Status() (*Status, error) {
+	var status Status
+	if err := c.do("GET", "/status", &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// CheckInNow asks the running agent to check in right away
+func (c *Client) CheckInNow() error {
+	return c.do("POST", "/check-in-now", nil)
+}
+
+// ScanNow asks the running agent to scan for updates right away
+func (c *Client) ScanNow() error {
+	return c.do("POST", "/scan-now", nil)
+}
+
+func (c *Client) do(method, path string, out interface{}) error {
+	req, err := http.NewRequest(method, "http://redflag-agent"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the agent (is it running, and are you root or in its group?): %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return errors.New(apiErr.Error)
+		}
+		return fmt.Errorf("agent returned %s", resp.Status)
+	}
+
+	if out != nil {
+		return json.Unmarshal(body, out)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/client"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/control"
 )
 
 // Color codes for terminal output
@@ -298,6 +299,71 @@ func PrintAgentStatus(agentID string, serverURL string, lastCheckIn time.Time, l
 	fmt.Println()
 }
 
+// PrintLiveStatus displays the running agent's status from its control socket
+func PrintLiveStatus(status *control.Status) {
+	fmt.Printf("%s🚩 RedFlag Agent Status (live)%s\n", ColorBold+ColorRed, ColorReset)
+	fmt.Println(strings.Repeat("─", 40))
+
+	fmt.Printf("%sAgent ID:%s %s\n", ColorBold, ColorReset, status.AgentID)
+	fmt.Printf("%sVersion:%s %s (pid %d, up %s)\n", ColorBold, ColorReset, status.Version, status.PID,
+		time.Since(status.StartedAt).Round(time.Second))
+
+	state := status.State
+	if status.CommandType != "" {
+		state += " - " + status.CommandType
+		if status.CommandID != "" {
+			state += " (" + status.CommandID + ")"
+		}
+	}
+	stateColor := ColorGreen
+	if status.State != control.StateIdle {
+		stateColor = ColorYellow
+	}
+	fmt.Printf("%sState:%s %s%s%s for %s\n", ColorBold, ColorReset, stateColor, state, ColorReset,
+		time.Since(status.StateSince).Round(time.Second))
+	if status.ScanRequested {
+		fmt.Printf("%sPending:%s scan requested\n", ColorBold, ColorReset)
+	}
+
+	if status.LastCheckIn != nil {
+		fmt.Printf("%sLast Check-in:%s %s\n", ColorBold, ColorReset, formatTimeSince(*status.LastCheckIn))
+	} else {
+		fmt.Printf("%sLast Check-in:%s %sNever%s\n", ColorBold, ColorReset, ColorYellow, ColorReset)
+	}
+	if status.NextCheckIn != nil && status.State == control.StateIdle {
+		if wait := time.Until(*status.NextCheckIn); wait > 0 {
+			fmt.Printf("%sNext Check-in:%s in %s\n", ColorBold, ColorReset, wait.Round(time.Second))
+		} else {
+			fmt.Printf("%sNext Check-in:%s due now\n", ColorBold, ColorReset)
+		}
+	}
+
+	outboxColor := ColorGreen
+	if status.OutboxDepth > 0 {
+		outboxColor = ColorYellow
+	}
+	fmt.Printf("%sOutbox:%s %s%d queued report(s)%s\n", ColorBold, ColorReset, outboxColor, status.OutboxDepth, ColorReset)
+
+	if status.ConfigProfile != "" {
+		fmt.Printf("%sConfig Profile:%s %s (version %d)\n", ColorBold, ColorReset, status.ConfigProfile, status.ConfigVersion)
+	} else {
+		fmt.Printf("%sConfig Profile:%s none (local settings)\n", ColorBold, ColorReset)
+	}
+	if status.ConfigError != "" {
+		fmt.Printf("%sConfig Error:%s %s%s%s\n", ColorBold, ColorReset, ColorRed, status.ConfigError, ColorReset)
+	}
+
+	if len(status.LastErrors) > 0 {
+		fmt.Printf("\n%sRecent Errors:%s\n", ColorBold, ColorReset)
+		for i := len(status.LastErrors) - 1; i >= 0; i-- {
+			entry := status.LastErrors[i]
+			fmt.Printf("  %s%s%s %s\n", ColorRed, entry.Time.Format("2006-01-02 15:04:05"), ColorReset, entry.Message)
+		}
+	}
+
+	fmt.Println()
+}
+
 // Helper functions
 
 func getSeverityColor(severity string) string {
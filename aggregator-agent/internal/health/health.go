@@ -0,0 +1,213 @@
+// Package health runs the declarative health checks from the agent config
+// after installs and reboots, so an update that exits 0 but leaves a service
+// broken is reported as degraded rather than successful.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+)
+
+// Check types
+const (
+	TypeHTTP    = "http"
+	TypeTCP     = "tcp"
+	TypeSystemd = "systemd"
+	TypeCommand = "command"
+)
+
+// DefaultTimeout applies to each attempt of a check without its own timeout
+const DefaultTimeout = 10 * time.Second
+
+// retryDelay is the wait between attempts of a check with retries
+const retryDelay = 5 * time.Second
+
+// maxOutput caps the command output kept per check
+const maxOutput = 4 * 1024
+
+// Unit names as systemd accepts them; also keeps option-like values out of systemctl
+var unitNamePattern = regexp.MustCompile(`^[A-Za-z0-9:_.@\\-]+$`)
+
+// Result is the outcome of one check
+type Result struct {
+	Name            string `json:"name"`
+	Type            string `json:"type"`
+	Passed          bool   `json:"passed"`
+	Attempts        int    `json:"attempts"`
+	DurationSeconds int    `json:"duration_seconds"`
+	Message         string `json:"message"`
+}
+
+// Run executes every check in order and returns their results. The error
+// lists the failed checks, or is nil when all passed.
+func Run(checks []config.HealthCheckConfig) ([]Result, error) {
+	var results []Result
+	var failed []string
+
+	for _, check := range checks {
+		result := runCheck(check)
+		results = append(results, result)
+
+		if result.Passed {
+			slog.Info("health check passed", "check", result.Name, "message", result.Message)
+		} else {
+			slog.Warn("health check failed", "check", result.Name, "attempts", result.Attempts, "message", result.Message)
+			failed = append(failed, result.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("health check(s) failed: %s", strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// runCheck tries a check up to 1+Retries times, stopping at the first pass
+func runCheck(check config.HealthCheckConfig) Result {
+	result := Result{Name: check.Name, Type: check.Type}
+
+	timeout := DefaultTimeout
+	if check.TimeoutSeconds > 0 {
+		timeout = time.Duration(check.TimeoutSeconds) * time.Second
+	}
+
+	start := time.Now()
+	for attempt := 0; attempt <= check.Retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(retryDelay)
+		}
+		result.Attempts++
+
+		message, err := probe(check, timeout)
+		if err == nil {
+			result.Passed = true
+			result.Message = message
+			break
+		}
+		result.Message = err.Error()
+	}
+	result.DurationSeconds = int(time.Since(start).Seconds())
+	return result
+}
+
+// probe performs a single attempt of a check
+func probe(check config.HealthCheckConfig, timeout time.Duration) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch check.Type {
+	case TypeHTTP:
+		return probeHTTP(ctx, check)
+	case TypeTCP:
+		return probeTCP(ctx, check)
+	case TypeSystemd:
+		return probeSystemd(ctx, check)
+	case TypeCommand:
+		return probeCommand(ctx, check)
+	default:
+		return "", fmt.Errorf("unknown check type %q", check.Type)
+	}
+}
+
+func probeHTTP(ctx context.Context, check config.HealthCheckConfig) (string, error) {
+	expected := check.ExpectStatus
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, check.URL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid url: %w", err)
+	}
+	// Checks are against local services; don't follow redirects away from them
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("GET %s failed: %w", check.URL, err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != expected {
+		return "", fmt.Errorf("GET %s returned %d, expected %d", check.URL, resp.StatusCode, expected)
+	}
+	return fmt.Sprintf("GET %s returned %d", check.URL, resp.StatusCode), nil
+}
+
+func probeTCP(ctx context.Context, check config.HealthCheckConfig) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", check.Address)
+	if err != nil {
+		return "", fmt.Errorf("connect to %s failed: %w", check.Address, err)
+	}
+	conn.Close()
+	return fmt.Sprintf("%s is accepting connections", check.Address), nil
+}
+
+func probeSystemd(ctx context.Context, check config.HealthCheckConfig) (string, error) {
+	if !unitNamePattern.MatchString(check.Unit) || strings.HasPrefix(check.Unit, "-") {
+		return "", fmt.Errorf("invalid unit name %q", check.Unit)
+	}
+
+	output, err := exec.CommandContext(ctx, "systemctl", "is-active", check.Unit).Output()
+	state := strings.TrimSpace(string(output))
+	if err != nil {
+		if state == "" {
+			return "", fmt.Errorf("systemctl is-active %s failed: %w", check.Unit, err)
+		}
+		return "", fmt.Errorf("unit %s is %s", check.Unit, state)
+	}
+	return fmt.Sprintf("unit %s is %s", check.Unit, state), nil
+}
+
+func probeCommand(ctx context.Context, check config.HealthCheckConfig) (string, error) {
+	cmd := exec.CommandContext(ctx, check.Command[0], check.Command[1:]...)
+	cmd.WaitDelay = time.Second
+	output, err := cmd.CombinedOutput()
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%s timed out", check.Command[0])
+	}
+
+	exitCode := 0
+	if err != nil {
+		var exitErr *exec.ExitError
+		if !errors.As(err, &exitErr) {
+			return "", fmt.Errorf("failed to run %s: %w", check.Command[0], err)
+		}
+		exitCode = exitErr.ExitCode()
+	}
+
+	summary := strings.TrimSpace(string(output))
+	if len(summary) > maxOutput {
+		summary = summary[len(summary)-maxOutput:]
+	}
+	if exitCode != check.ExpectExitCode {
+		return "", fmt.Errorf("%s exited %d, expected %d: %s", check.Command[0], exitCode, check.ExpectExitCode, summary)
+	}
+	return fmt.Sprintf("%s exited %d", check.Command[0], exitCode), nil
+}
+
+// Summary renders results as one line per check, for log stdout/stderr
+func Summary(results []Result) string {
+	var b strings.Builder
+	for _, r := range results {
+		status := "passed"
+		if !r.Passed {
+			status = "FAILED"
+		}
+		fmt.Fprintf(&b, "[health] %s (%s): %s - %s\n", r.Name, r.Type, status, r.Message)
+	}
+	return b.String()
+}
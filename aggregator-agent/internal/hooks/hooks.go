@@ -0,0 +1,298 @@
+// Package hooks runs administrator-provided scripts around installs and reboots.
+//
+// Hooks live in one directory per phase (e.g. /etc/aggregator/hooks/pre-install.d)
+// and run in lexical order, run-parts style. Each hook gets the package details
+// in REDFLAG_* environment variables and its own timeout, which a script can
+// raise or lower with a "# redflag-timeout: <seconds>" line near its top.
+// A failing pre-* hook stops the remaining hooks and aborts the operation.
+package hooks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook phases; each maps to a <phase>.d directory
+const (
+	PhasePreInstall  = "pre-install"
+	PhasePostInstall = "post-install"
+	PhasePreReboot   = "pre-reboot"
+	PhasePostReboot  = "post-reboot"
+)
+
+// DefaultDir is the hooks root when the agent config doesn't set one
+const DefaultDir = "/etc/aggregator/hooks"
+
+// DefaultTimeout applies to hooks without their own timeout
+const DefaultTimeout = 5 * time.Minute
+
+// DefaultRebootStateFile remembers pending post-reboot hooks across the reboot
+const DefaultRebootStateFile = "/var/lib/redflag-agent/pending-reboot-hooks.json"
+
+// maxOutput caps the output kept per hook
+const maxOutput = 64 * 1024
+
+var (
+	// Like run-parts: skip editor backups, package manager leftovers and dotfiles
+	hookNamePattern    = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+	timeoutLinePattern = regexp.MustCompile(`^#\s*redflag-timeout:\s*([0-9]+)\s*$`)
+)
+
+// Result is the outcome of one hook
+type Result struct {
+	Name            string `json:"name"`
+	Phase           string `json:"phase"`
+	ExitCode        int    `json:"exit_code"`
+	DurationSeconds int    `json:"duration_seconds"`
+	TimedOut        bool   `json:"timed_out,omitempty"`
+	Output          string `json:"output,omitempty"`
+	Error           string `json:"error,omitempty"`
+}
+
+// Failed reports whether the hook did not exit 0
+func (r Result) Failed() bool {
+	return r.Error != "" || r.ExitCode != 0
+}
+
+// Runner runs the hooks found under a root directory
+type Runner struct {
+	dir     string
+	timeout time.Duration
+}
+
+// NewRunner creates a runner; empty/zero values fall back to the defaults
+func NewRunner(dir string, timeout time.Duration) *Runner {
+	if dir == "" {
+		dir = DefaultDir
+	}
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Runner{dir: dir, timeout: timeout}
+}
+
+// Run executes the phase's hooks with env added to the agent's environment.
+// For pre-* phases the first failure stops the run and is returned as an
+// error; post-* phases run every hook and return an error if any failed.
+func (r *Runner) Run(phase string, env map[string]string) ([]Result, error) {
+	paths, err := r.list(phase)
+	if err != nil {
+		return nil, err
+	}
+
+	stopOnFailure := strings.HasPrefix(phase, "pre-")
+	var results []Result
+	var failed []string
+
+	for _, path := range paths {
+		result := r.runOne(phase, path, env)
+		results = append(results, result)
+
+		if result.Failed() {
+			slog.Warn("hook failed", "phase", phase, "hook", result.Name, "exit_code", result.ExitCode, "error", result.Error)
+			failed = append(failed, result.Name)
+			if stopOnFailure {
+				break
+			}
+		} else {
+			slog.Info("hook succeeded", "phase", phase, "hook", result.Name, "duration_seconds", result.DurationSeconds)
+		}
+	}
+
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%s hook(s) failed: %s", phase, strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// list returns the phase's runnable hooks in order. A missing directory means no hooks.
+func (r *Runner) list(phase string) ([]string, error) {
+	dir := filepath.Join(r.dir, phase+".d")
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hook directory %s: %w", dir, err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !hookNamePattern.MatchString(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		// Anyone able to edit a hook could run code as the agent
+		if info.Mode().Perm()&0002 != 0 {
+			slog.Warn("skipping world-writable hook", "path", path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func (r *Runner) runOne(phase, path string, env map[string]string) Result {
+	result := Result{Name: filepath.Base(path), Phase: phase}
+	timeout := hookTimeout(path, r.timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, path)
+	cmd.Dir = filepath.Dir(path)
+	cmd.Env = os.Environ()
+	cmd.Env = append(cmd.Env, "REDFLAG_HOOK_PHASE="+phase)
+	for key, value := range env {
+		cmd.Env = append(cmd.Env, key+"="+value)
+	}
+	killOnTimeout(cmd)
+	// Don't let a detached child holding the output pipe block us past the timeout
+	cmd.WaitDelay = 5 * time.Second
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	start := time.Now()
+	err := cmd.Run()
+	result.DurationSeconds = int(time.Since(start).Seconds())
+	result.Output = truncate(output.String())
+
+	if ctx.Err() == context.DeadlineExceeded {
+		result.TimedOut = true
+		result.ExitCode = -1
+		result.Error = fmt.Sprintf("timed out after %s", timeout)
+		return result
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.ExitCode = exitErr.ExitCode()
+		} else {
+			result.ExitCode = -1
+		}
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// hookTimeout reads a "# redflag-timeout: N" line from the first lines of a script
+func hookTimeout(path string, fallback time.Duration) time.Duration {
+	f, err := os.Open(path)
+	if err != nil {
+		return fallback
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for i := 0; i < 10 && scanner.Scan(); i++ {
+		if m := timeoutLinePattern.FindStringSubmatch(strings.TrimSpace(scanner.Text())); m != nil {
+			if seconds, err := strconv.Atoi(m[1]); err == nil && seconds > 0 {
+				return time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	return fallback
+}
+
+func truncate(output string) string {
+	if len(output) <= maxOutput {
+		return output
+	}
+	return output[len(output)-maxOutput:] + "\n[output truncated to last 64KB]"
+}
+
+// Summary renders results as one line per hook, for log stdout/stderr
+func Summary(results []Result) string {
+	var b strings.Builder
+	for _, r := range results {
+		status := "ok"
+		if r.TimedOut {
+			status = "timed out"
+		} else if r.Failed() {
+			status = fmt.Sprintf("failed (exit %d)", r.ExitCode)
+		}
+		fmt.Fprintf(&b, "[%s] %s: %s in %ds\n", r.Phase, r.Name, status, r.DurationSeconds)
+		if r.Failed() && r.Output != "" {
+			b.WriteString(r.Output)
+			if !strings.HasSuffix(r.Output, "\n") {
+				b.WriteString("\n")
+			}
+		}
+	}
+	return b.String()
+}
+
+// Env builds the standard hook environment for a package operation
+func Env(commandID, packageType string, packageNames []string) map[string]string {
+	return map[string]string{
+		"REDFLAG_COMMAND_ID":    commandID,
+		"REDFLAG_PACKAGE_TYPE":  packageType,
+		"REDFLAG_PACKAGE_NAMES": strings.Join(packageNames, " "),
+	}
+}
+
+// pendingReboot is saved before a reboot so post-reboot hooks run on the next boot
+type pendingReboot struct {
+	CommandID string `json:"command_id"`
+	BootID    string `json:"boot_id"`
+}
+
+// SavePendingReboot records that post-reboot hooks should run once the system has rebooted
+func SavePendingReboot(stateFile, commandID string) error {
+	data, err := json.Marshal(pendingReboot{CommandID: commandID, BootID: bootID()})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(stateFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(stateFile, data, 0600)
+}
+
+// TakePendingReboot returns the reboot command ID if a reboot recorded by
+// SavePendingReboot has since happened, clearing the record. It returns ""
+// while the system is still on the boot the reboot was scheduled from.
+func TakePendingReboot(stateFile string) string {
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		return ""
+	}
+	var pending pendingReboot
+	if err := json.Unmarshal(data, &pending); err != nil {
+		os.Remove(stateFile)
+		return ""
+	}
+	if current := bootID(); current != "" && current == pending.BootID {
+		return ""
+	}
+	os.Remove(stateFile)
+	return pending.CommandID
+}
+
+func bootID() string {
+	data, err := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package hooks
+
+import "os/exec"
+
+// killOnTimeout leaves the default behaviour (kill the hook process) on Windows
+func killOnTimeout(cmd *exec.Cmd) {}
@@ -20,6 +20,11 @@ func NewAPTInstaller() *APTInstaller {
 	}
 }
 
+// SetOutputHandler streams APT output to handler while commands run
+func (i *APTInstaller) SetOutputHandler(handler OutputHandler) {
+	i.executor.SetOutputHandler(handler)
+}
+
 // IsAvailable checks if APT is available on this system
 func (i *APTInstaller) IsAvailable() bool {
 	_, err := exec.LookPath("apt-get")
@@ -176,6 +181,50 @@ func (i *APTInstaller) UpdatePackage(packageName string) (*InstallResult, error)
 	}, nil
 }
 
+// InstallVersion installs a specific version of a package with apt-get install pkg=version,
+// allowing downgrades so an update can be rolled back
+func (i *APTInstaller) InstallVersion(packageName, version string) (*InstallResult, error) {
+	if err := validateVersion(version); err != nil {
+		return &InstallResult{Success: false, ErrorMessage: err.Error(), Action: "rollback"}, err
+	}
+
+	startTime := time.Now()
+
+	// Update package cache first so the requested version can be found
+	updateResult, err := i.executor.ExecuteCommand("apt-get", []string{"update"})
+	if err != nil {
+		updateResult.DurationSeconds = int(time.Since(startTime).Seconds())
+		updateResult.ErrorMessage = fmt.Sprintf("Failed to update APT cache: %v", err)
+		return updateResult, fmt.Errorf("apt-get update failed: %w", err)
+	}
+
+	target := fmt.Sprintf("%s=%s", packageName, version)
+	installResult, err := i.executor.ExecuteCommand("apt-get", []string{"install", "-y", "--allow-downgrades", target})
+	duration := int(time.Since(startTime).Seconds())
+
+	if err != nil {
+		return &InstallResult{
+			Success:        false,
+			ErrorMessage:   fmt.Sprintf("APT install of %s failed: %v", target, err),
+			Stdout:         installResult.Stdout,
+			Stderr:         installResult.Stderr,
+			ExitCode:       installResult.ExitCode,
+			DurationSeconds: duration,
+			Action:         "rollback",
+		}, err
+	}
+
+	return &InstallResult{
+		Success:        true,
+		Stdout:         installResult.Stdout,
+		Stderr:         installResult.Stderr,
+		ExitCode:       installResult.ExitCode,
+		DurationSeconds: duration,
+		PackagesInstalled: []string{target},
+		Action:         "rollback",
+	}, nil
+}
+
 // DryRun performs a dry run installation to check dependencies
 func (i *APTInstaller) DryRun(packageName string) (*InstallResult, error) {
 	startTime := time.Now()
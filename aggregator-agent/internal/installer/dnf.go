@@ -2,7 +2,7 @@ package installer
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"os/exec"
 	"regexp"
 	"strings"
@@ -21,6 +21,11 @@ func NewDNFInstaller() *DNFInstaller {
 	}
 }
 
+// SetOutputHandler streams DNF output to handler while commands run
+func (i *DNFInstaller) SetOutputHandler(handler OutputHandler) {
+	i.executor.SetOutputHandler(handler)
+}
+
 // IsAvailable checks if DNF is available on this system
 func (i *DNFInstaller) IsAvailable() bool {
 	_, err := exec.LookPath("dnf")
@@ -134,6 +139,42 @@ func (i *DNFInstaller) Upgrade() (*InstallResult, error) {
 	}, nil
 }
 
+// InstallVersion downgrades a package to a specific earlier version with dnf downgrade
+func (i *DNFInstaller) InstallVersion(packageName, version string) (*InstallResult, error) {
+	if err := validateVersion(version); err != nil {
+		return &InstallResult{Success: false, ErrorMessage: err.Error(), Action: "rollback"}, err
+	}
+
+	startTime := time.Now()
+
+	// dnf accepts name-[epoch:]version-release
+	target := fmt.Sprintf("%s-%s", packageName, version)
+	downgradeResult, err := i.executor.ExecuteCommand("dnf", []string{"downgrade", "-y", target})
+	duration := int(time.Since(startTime).Seconds())
+
+	if err != nil {
+		return &InstallResult{
+			Success:        false,
+			ErrorMessage:   fmt.Sprintf("DNF downgrade to %s failed: %v", target, err),
+			Stdout:         downgradeResult.Stdout,
+			Stderr:         downgradeResult.Stderr,
+			ExitCode:       downgradeResult.ExitCode,
+			DurationSeconds: duration,
+			Action:         "rollback",
+		}, err
+	}
+
+	return &InstallResult{
+		Success:        true,
+		Stdout:         downgradeResult.Stdout,
+		Stderr:         downgradeResult.Stderr,
+		ExitCode:       downgradeResult.ExitCode,
+		DurationSeconds: duration,
+		PackagesInstalled: []string{target},
+		Action:         "rollback",
+	}, nil
+}
+
 // DryRun performs a dry run installation to check dependencies
 func (i *DNFInstaller) DryRun(packageName string) (*InstallResult, error) {
 	startTime := time.Now()
@@ -143,7 +184,7 @@ func (i *DNFInstaller) DryRun(packageName string) (*InstallResult, error) {
 	refreshResult, refreshErr := i.executor.ExecuteCommand("dnf", []string{"makecache"})
 	if refreshErr != nil {
 		// Log refresh attempt but don't fail the dry run
-		log.Printf("Warning: DNF makecache failed (continuing with dry run): %v", refreshErr)
+		slog.Warn("dnf makecache failed, continuing with dry run", "error", refreshErr)
 	}
 	_ = refreshResult // Discard refresh result intentionally
 
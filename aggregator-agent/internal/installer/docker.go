@@ -1,14 +1,19 @@
 package installer
 
 import (
+	"context"
 	"fmt"
 	"os/exec"
 	"strings"
 	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/scanner"
 )
 
 // DockerInstaller handles Docker image updates
-type DockerInstaller struct{}
+type DockerInstaller struct {
+	outputHandler OutputHandler
+}
 
 // NewDockerInstaller creates a new Docker installer
 func NewDockerInstaller() (*DockerInstaller, error) {
@@ -20,20 +25,39 @@ func NewDockerInstaller() (*DockerInstaller, error) {
 	return &DockerInstaller{}, nil
 }
 
+// SetOutputHandler streams docker pull progress to handler while it runs
+func (i *DockerInstaller) SetOutputHandler(handler OutputHandler) {
+	i.outputHandler = handler
+}
+
 // IsAvailable checks if Docker is available on this system
 func (i *DockerInstaller) IsAvailable() bool {
 	_, err := exec.LookPath("docker")
 	return err == nil
 }
 
-// Update pulls a new image using docker CLI
-func (i *DockerInstaller) Update(imageName, targetVersion string) (*InstallResult, error) {
+// Update pulls a new image using docker CLI, then recreates the containers
+// running the image on the new version. For a tag bump ("app:1.2 [minor]")
+// the newest tag at that level is pulled and the containers on the old tag
+// are moved onto it.
+func (i *DockerInstaller) Update(packageName, targetVersion string) (*InstallResult, error) {
 	startTime := time.Now()
+	fromImage, imageName, err := resolveTagBump(context.Background(), packageName)
+	if err != nil {
+		return &InstallResult{
+			Success:         false,
+			ErrorMessage:    err.Error(),
+			Stderr:          err.Error(),
+			ExitCode:        1,
+			DurationSeconds: int(time.Since(startTime).Seconds()),
+			Action:          "pull",
+		}, err
+	}
 
 	// Pull the new image
 	fmt.Printf("Pulling Docker image: %s...\n", imageName)
 	pullCmd := exec.Command("sudo", "docker", "pull", imageName)
-	output, err := pullCmd.CombinedOutput()
+	output, err := runWithOutput(pullCmd, i.outputHandler)
 	if err != nil {
 		return &InstallResult{
 			Success:      false,
@@ -48,15 +72,31 @@ func (i *DockerInstaller) Update(imageName, targetVersion string) (*InstallResul
 
 	fmt.Printf("Successfully pulled image: %s\n", string(output))
 
+	// Move the image's containers onto it; failed ones are rolled back
+	recreated, err := i.recreateContainers(context.Background(), fromImage, imageName)
+	stdout := string(output) + recreateSummary(recreated)
 	duration := int(time.Since(startTime).Seconds())
+	if err != nil {
+		return &InstallResult{
+			Success:           false,
+			ErrorMessage:      err.Error(),
+			Stdout:            stdout,
+			Stderr:            err.Error(),
+			ExitCode:          1,
+			DurationSeconds:   duration,
+			Action:            "recreate",
+			ContainersUpdated: recreatedIDs(recreated),
+		}, err
+	}
+
 	return &InstallResult{
 		Success:          true,
-		Stdout:           string(output),
+		Stdout:           stdout,
 		Stderr:           "",
 		ExitCode:         0,
 		DurationSeconds:    duration,
 		Action:            "pull",
-		ContainersUpdated: []string{}, // Would find and recreate containers in a real implementation
+		ContainersUpdated: recreatedIDs(recreated),
 	}, nil
 }
 
@@ -84,16 +124,30 @@ func (i *DockerInstaller) InstallMultiple(imageNames []string) (*InstallResult,
 	var allOutput strings.Builder
 	var errors []string
 
-	for _, imageName := range imageNames {
+	var containersUpdated []string
+
+	for _, packageName := range imageNames {
+		fromImage, imageName, err := resolveTagBump(context.Background(), packageName)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", packageName, err))
+			continue
+		}
 		fmt.Printf("Pulling Docker image: %s...\n", imageName)
 		pullCmd := exec.Command("sudo", "docker", "pull", imageName)
-		output, err := pullCmd.CombinedOutput()
+		output, err := runWithOutput(pullCmd, i.outputHandler)
 		allOutput.WriteString(string(output))
 
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("Failed to pull %s: %v", imageName, err))
-		} else {
-			fmt.Printf("Successfully pulled image: %s\n", imageName)
+			continue
+		}
+		fmt.Printf("Successfully pulled image: %s\n", imageName)
+
+		recreated, err := i.recreateContainers(context.Background(), fromImage, imageName)
+		allOutput.WriteString(recreateSummary(recreated))
+		containersUpdated = append(containersUpdated, recreatedIDs(recreated)...)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", imageName, err))
 		}
 	}
 
@@ -108,7 +162,8 @@ func (i *DockerInstaller) InstallMultiple(imageNames []string) (*InstallResult,
 			ExitCode:     1,
 			DurationSeconds: duration,
 			Action:       "pull_multiple",
-		}, fmt.Errorf("docker pull failed for some images")
+			ContainersUpdated: containersUpdated,
+		}, fmt.Errorf("docker update failed for some images")
 	}
 
 	return &InstallResult{
@@ -118,10 +173,39 @@ func (i *DockerInstaller) InstallMultiple(imageNames []string) (*InstallResult,
 		ExitCode:         0,
 		DurationSeconds:    duration,
 		Action:            "pull_multiple",
-		ContainersUpdated: imageNames,
+		ContainersUpdated: containersUpdated,
 	}, nil
 }
 
+// resolveTagBump maps a tag bump package name ("app:1.2 [minor]") to the image
+// its containers run now and the newest tag at that bump level. Plain image
+// names map to themselves.
+func resolveTagBump(ctx context.Context, packageName string) (fromImage, toImage string, err error) {
+	imageName, level, ok := scanner.ParseTagBumpKey(packageName)
+	if !ok {
+		return packageName, packageName, nil
+	}
+	toImage, err = scanner.ResolveTagBump(ctx, imageName, level)
+	return imageName, toImage, err
+}
+
+// recreateSummary lists recreated containers for the install output
+func recreateSummary(recreated []RecreatedContainer) string {
+	var b strings.Builder
+	for _, c := range recreated {
+		fmt.Fprintf(&b, "\nRecreated container %s: %s -> %s", c.Name, c.OldID, c.NewID)
+	}
+	return b.String()
+}
+
+func recreatedIDs(recreated []RecreatedContainer) []string {
+	ids := make([]string, 0, len(recreated))
+	for _, c := range recreated {
+		ids = append(ids, c.NewID)
+	}
+	return ids
+}
+
 // Upgrade is not applicable for Docker in the same way
 func (i *DockerInstaller) Upgrade() (*InstallResult, error) {
 	return &InstallResult{
@@ -134,8 +218,19 @@ func (i *DockerInstaller) Upgrade() (*InstallResult, error) {
 }
 
 // DryRun for Docker images checks if the image can be pulled without actually pulling it
-func (i *DockerInstaller) DryRun(imageName string) (*InstallResult, error) {
+func (i *DockerInstaller) DryRun(packageName string) (*InstallResult, error) {
 	startTime := time.Now()
+	_, imageName, err := resolveTagBump(context.Background(), packageName)
+	if err != nil {
+		return &InstallResult{
+			Success:         false,
+			ErrorMessage:    err.Error(),
+			ExitCode:        1,
+			DurationSeconds: int(time.Since(startTime).Seconds()),
+			IsDryRun:        true,
+			Action:          "dry_run",
+		}, err
+	}
 
 	// Check if image exists locally
 	inspectCmd := exec.Command("sudo", "docker", "image", "inspect", imageName)
@@ -188,6 +283,76 @@ func (i *DockerInstaller) DryRun(imageName string) (*InstallResult, error) {
 	}, nil
 }
 
+// InstallVersion points an image tag back at a previous image, identified by its
+// (short) image ID. The previous image must still be present locally. Tag bumps
+// are rolled back by moving the containers back onto the old tag.
+func (i *DockerInstaller) InstallVersion(imageName, version string) (*InstallResult, error) {
+	startTime := time.Now()
+
+	if bumpedFrom, level, ok := scanner.ParseTagBumpKey(imageName); ok {
+		recreated, err := i.rollbackTagBump(context.Background(), bumpedFrom, level)
+		result := &InstallResult{
+			Success:           err == nil,
+			Stdout:            fmt.Sprintf("Moved containers back to %s", bumpedFrom) + recreateSummary(recreated),
+			DurationSeconds:   int(time.Since(startTime).Seconds()),
+			Action:            "rollback",
+			ContainersUpdated: recreatedIDs(recreated),
+		}
+		if err != nil {
+			result.ErrorMessage = err.Error()
+			result.Stderr = err.Error()
+			result.ExitCode = 1
+		}
+		return result, err
+	}
+
+	imageID := strings.TrimPrefix(version, "sha256:")
+	if len(imageID) < 12 || strings.Trim(imageID, "0123456789abcdef") != "" {
+		err := fmt.Errorf("invalid image ID: %q", version)
+		return &InstallResult{Success: false, ErrorMessage: err.Error(), Action: "rollback"}, err
+	}
+	if strings.HasPrefix(imageName, "-") || strings.Contains(imageName, "..") {
+		err := fmt.Errorf("invalid docker image name: %s", imageName)
+		return &InstallResult{Success: false, ErrorMessage: err.Error(), Action: "rollback"}, err
+	}
+
+	// The old image is only reachable by ID once its tag has moved on
+	inspectCmd := exec.Command("sudo", "docker", "image", "inspect", "--format", "{{.Id}}", imageID)
+	inspectOutput, err := inspectCmd.CombinedOutput()
+	if err != nil {
+		return &InstallResult{
+			Success:        false,
+			ErrorMessage:   fmt.Sprintf("Previous image %s is no longer available locally", imageID),
+			Stdout:         string(inspectOutput),
+			ExitCode:       getExitCode(err),
+			DurationSeconds: int(time.Since(startTime).Seconds()),
+			Action:         "rollback",
+		}, fmt.Errorf("previous image %s not found: %w", imageID, err)
+	}
+
+	tagCmd := exec.Command("sudo", "docker", "tag", imageID, imageName)
+	output, err := runWithOutput(tagCmd, i.outputHandler)
+	duration := int(time.Since(startTime).Seconds())
+	if err != nil {
+		return &InstallResult{
+			Success:        false,
+			ErrorMessage:   fmt.Sprintf("Failed to re-tag %s as %s: %v", imageID, imageName, err),
+			Stdout:         string(output),
+			ExitCode:       getExitCode(err),
+			DurationSeconds: duration,
+			Action:         "rollback",
+		}, fmt.Errorf("docker tag failed: %w", err)
+	}
+
+	return &InstallResult{
+		Success:        true,
+		Stdout:         fmt.Sprintf("Tagged %s as %s", strings.TrimSpace(string(inspectOutput)), imageName),
+		ExitCode:       0,
+		DurationSeconds: duration,
+		Action:         "rollback",
+	}, nil
+}
+
 // GetPackageType returns type of packages this installer handles
 func (i *DockerInstaller) GetPackageType() string {
 	return "docker_image"
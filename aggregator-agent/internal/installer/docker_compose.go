@@ -0,0 +1,262 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/scanner"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	dockerclient "github.com/docker/docker/client"
+)
+
+// ComposeUpdateCommand is the agent subcommand, run as root through sudo, that
+// pulls or re-ups one compose service
+const ComposeUpdateCommand = "update-compose-service"
+
+var (
+	composeProjectPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	composeServicePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+)
+
+// composeService is a compose-managed service whose containers run an image
+// being updated. Recreating its containers by hand would fight compose, so
+// the service is updated with "docker compose pull" and "docker compose up -d"
+// from its project directory instead, through the agent's root helper (see
+// UpdateComposeService).
+type composeService struct {
+	project     string
+	service     string
+	workingDir  string
+	configFiles []string
+	oldImageID  string
+	oldIDs      map[string]string // container name -> ID before the update
+}
+
+// composeServiceFor returns the compose service a container belongs to, or
+// nil if it isn't managed by compose
+func composeServiceFor(info types.ContainerJSON) *composeService {
+	if info.Config == nil {
+		return nil
+	}
+	labels := info.Config.Labels
+	project := labels[scanner.ComposeProjectLabel]
+	service := labels[scanner.ComposeServiceLabel]
+	if project == "" || service == "" {
+		return nil
+	}
+
+	var configFiles []string
+	for _, file := range strings.Split(labels[scanner.ComposeConfigFilesLabel], ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			configFiles = append(configFiles, file)
+		}
+	}
+	return &composeService{
+		project:     project,
+		service:     service,
+		workingDir:  labels[scanner.ComposeWorkingDirLabel],
+		configFiles: configFiles,
+		oldImageID:  info.Image,
+		oldIDs:      map[string]string{},
+	}
+}
+
+// validate rejects label values that can't safely be passed to docker compose
+func (s *composeService) validate() error {
+	if !composeProjectPattern.MatchString(s.project) {
+		return fmt.Errorf("invalid compose project name %q", s.project)
+	}
+	if !composeServicePattern.MatchString(s.service) {
+		return fmt.Errorf("invalid compose service name %q", s.service)
+	}
+	if s.workingDir == "" || !filepath.IsAbs(s.workingDir) {
+		return fmt.Errorf("compose project %s has no absolute working directory label", s.project)
+	}
+	for _, file := range s.configFiles {
+		if !filepath.IsAbs(file) {
+			return fmt.Errorf("compose file %q is not an absolute path", file)
+		}
+	}
+	return nil
+}
+
+// composeArgs builds "docker compose" arguments addressing the service's project
+func (s *composeService) composeArgs(subcommand ...string) []string {
+	args := []string{"compose", "--project-name", s.project, "--project-directory", s.workingDir}
+	for _, file := range s.configFiles {
+		args = append(args, "--file", file)
+	}
+	return append(args, subcommand...)
+}
+
+// updateComposeService pulls and re-ups one compose service, then waits for its
+// containers to come up healthy. On failure the image tag is pointed back at
+// the previous image and the service is brought up on it again.
+func (i *DockerInstaller) updateComposeService(ctx context.Context, cli *dockerclient.Client, svc *composeService, imageName string) ([]RecreatedContainer, error) {
+	if err := svc.validate(); err != nil {
+		return nil, err
+	}
+
+	i.logf("Updating compose service %s/%s in %s\n", svc.project, svc.service, svc.workingDir)
+	if err := i.runCompose(svc, "pull"); err != nil {
+		return nil, fmt.Errorf("compose pull failed: %w", err)
+	}
+
+	upErr := i.runCompose(svc, "up")
+	var started []types.Container
+	if upErr == nil {
+		var err error
+		started, err = composeContainers(ctx, cli, svc)
+		if err != nil {
+			upErr = err
+		}
+		for _, c := range started {
+			if err := waitHealthy(ctx, cli, c.ID); err != nil {
+				upErr = fmt.Errorf("%s: %w", strings.TrimPrefix(firstName(c.Names), "/"), err)
+				break
+			}
+		}
+	}
+	if upErr != nil {
+		return nil, i.rollbackComposeService(svc, imageName, upErr)
+	}
+
+	var recreated []RecreatedContainer
+	for _, c := range started {
+		name := strings.TrimPrefix(firstName(c.Names), "/")
+		if oldID := svc.oldIDs[name]; oldID != c.ID {
+			recreated = append(recreated, RecreatedContainer{Name: name, OldID: shortID(oldID), NewID: shortID(c.ID)})
+		}
+	}
+	return recreated, nil
+}
+
+// rollbackComposeService re-tags the previous image and brings the service back up on it
+func (i *DockerInstaller) rollbackComposeService(svc *composeService, imageName string, cause error) error {
+	i.logf("Rolling back compose service %s/%s: %v\n", svc.project, svc.service, cause)
+
+	tagCmd := exec.Command("sudo", "docker", "tag", svc.oldImageID, imageName)
+	if output, err := runWithOutput(tagCmd, i.outputHandler); err != nil {
+		return fmt.Errorf("%v; rollback failed to re-tag previous image: %v: %s", cause, err, strings.TrimSpace(string(output)))
+	}
+	if err := i.runCompose(svc, "up"); err != nil {
+		return fmt.Errorf("%v; rollback failed to restart service: %w", cause, err)
+	}
+	return fmt.Errorf("%v (rolled back to previous image)", cause)
+}
+
+// runCompose pulls ("pull") or re-ups ("up") a service through the agent
+// binary's update-compose-service helper, run as root with sudo. A sudoers
+// rule for docker compose itself would accept any compose file, and with it
+// any privileged container, so only the helper is allowed.
+func (i *DockerInstaller) runCompose(svc *composeService, action string) error {
+	binary, err := agentBinary()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command("sudo", "-n", binary, ComposeUpdateCommand, action, svc.project, svc.service)
+	output, err := runWithOutput(cmd, i.outputHandler)
+	if err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(lastLine(string(output))))
+	}
+	return nil
+}
+
+// UpdateComposeService runs "docker compose pull <service>" or "docker compose
+// up -d --no-deps <service>", for the "redflag-agent update-compose-service
+// <pull|up> <project> <service>" helper the agent runs as root. The project
+// directory and compose files are read from the labels of the service's
+// existing containers rather than taken from the caller, so the helper only
+// acts on services already deployed from their own compose files.
+func UpdateComposeService(args []string, out io.Writer) error {
+	if len(args) != 3 {
+		return fmt.Errorf("usage: %s <pull|up> <project> <service>", ComposeUpdateCommand)
+	}
+	action, project, service := args[0], args[1], args[2]
+	var subcommand []string
+	switch action {
+	case "pull":
+		subcommand = []string{"pull", service}
+	case "up":
+		subcommand = []string{"up", "-d", "--no-deps", service}
+	default:
+		return fmt.Errorf("unknown compose action %q", action)
+	}
+	if !composeProjectPattern.MatchString(project) {
+		return fmt.Errorf("invalid compose project name %q", project)
+	}
+	if !composeServicePattern.MatchString(service) {
+		return fmt.Errorf("invalid compose service name %q", service)
+	}
+
+	cli, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+	if err != nil {
+		return fmt.Errorf("failed to connect to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	containers, err := cli.ContainerList(ctx, container.ListOptions{
+		All: true,
+		Filters: filters.NewArgs(
+			filters.Arg("label", scanner.ComposeProjectLabel+"="+project),
+			filters.Arg("label", scanner.ComposeServiceLabel+"="+service),
+		),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list containers of %s/%s: %w", project, service, err)
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("%s/%s is not a compose service on this host", project, service)
+	}
+	info, err := cli.ContainerInspect(ctx, containers[0].ID)
+	if err != nil {
+		return fmt.Errorf("failed to inspect %s/%s: %w", project, service, err)
+	}
+	svc := composeServiceFor(info)
+	if svc == nil || svc.project != project || svc.service != service {
+		return fmt.Errorf("%s/%s is not a compose service on this host", project, service)
+	}
+	if err := svc.validate(); err != nil {
+		return err
+	}
+
+	cmd := exec.Command("/usr/bin/docker", svc.composeArgs(subcommand...)...)
+	cmd.Dir = svc.workingDir
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
+// composeContainers lists the running containers of a compose service
+func composeContainers(ctx context.Context, cli *dockerclient.Client, svc *composeService) ([]types.Container, error) {
+	args := filters.NewArgs(
+		filters.Arg("label", scanner.ComposeProjectLabel+"="+svc.project),
+		filters.Arg("label", scanner.ComposeServiceLabel+"="+svc.service),
+	)
+	containers, err := cli.ContainerList(ctx, container.ListOptions{Filters: args})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers of %s/%s: %w", svc.project, svc.service, err)
+	}
+	if len(containers) == 0 {
+		return nil, fmt.Errorf("no running containers for %s/%s after compose up", svc.project, svc.service)
+	}
+	return containers, nil
+}
+
+func firstName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return names[0]
+}
@@ -0,0 +1,416 @@
+package installer
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/scanner"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	dockerclient "github.com/docker/docker/client"
+)
+
+// containerStartTimeout is how long a recreated container has to report
+// healthy (with a HEALTHCHECK) or keep running (without one)
+const containerStartTimeout = 2 * time.Minute
+
+// containerStableWindow is how long a container without a HEALTHCHECK must
+// stay running before it counts as started
+const containerStableWindow = 10 * time.Second
+
+// RecreatedContainer describes a container moved onto a newly pulled image
+type RecreatedContainer struct {
+	Name  string `json:"name"`
+	OldID string `json:"old_id"`
+	NewID string `json:"new_id"`
+}
+
+// recreateContainers moves every container created from fromImage onto
+// imageName, the image that was just pulled; fromImage differs from imageName
+// only for tag bumps. Each container is stopped, renamed aside,
+// re-created with the same configuration on the new image and started; if the
+// new container doesn't come up healthy it is removed and the original is
+// restored. Compose-managed containers are updated through docker compose per
+// service instead. Containers already on the new image are left alone.
+func (i *DockerInstaller) recreateContainers(ctx context.Context, fromImage, imageName string) ([]RecreatedContainer, error) {
+	cli, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Docker: %w", err)
+	}
+	defer cli.Close()
+
+	newImage, _, err := cli.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect pulled image %s: %w", imageName, err)
+	}
+
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var recreated []RecreatedContainer
+	var failures []string
+	var services []*composeService
+	servicesByKey := make(map[string]*composeService)
+	for _, c := range containers {
+		info, err := cli.ContainerInspect(ctx, c.ID)
+		if err != nil || info.Config == nil || normalizeImageRef(info.Config.Image) != normalizeImageRef(fromImage) {
+			continue
+		}
+		name := strings.TrimPrefix(info.Name, "/")
+		if fromImage == imageName && info.Image == newImage.ID {
+			i.logf("Container %s already runs the new image\n", name)
+			continue
+		}
+
+		// Compose-managed containers are updated per service by compose itself
+		if svc := composeServiceFor(info); svc != nil {
+			if fromImage != imageName {
+				// The compose file pins the old tag; "compose up" would put it back
+				failures = append(failures, fmt.Sprintf("%s: compose service %s/%s pins %s; change its image to %s in the compose file", name, svc.project, svc.service, fromImage, imageName))
+				continue
+			}
+			key := svc.project + "/" + svc.service
+			if existing, ok := servicesByKey[key]; ok {
+				svc = existing
+			} else {
+				servicesByKey[key] = svc
+				services = append(services, svc)
+			}
+			svc.oldIDs[name] = info.ID
+			continue
+		}
+		if info.HostConfig != nil && info.HostConfig.AutoRemove {
+			// Stopping would delete it, leaving nothing to roll back to
+			failures = append(failures, fmt.Sprintf("%s: --rm containers can't be recreated safely", name))
+			continue
+		}
+
+		newID, err := i.recreateContainer(ctx, cli, info, imageName)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		recreated = append(recreated, RecreatedContainer{Name: name, OldID: shortID(info.ID), NewID: shortID(newID)})
+	}
+
+	for _, svc := range services {
+		updated, err := i.updateComposeService(ctx, cli, svc, imageName)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s/%s: %v", svc.project, svc.service, err))
+			continue
+		}
+		recreated = append(recreated, updated...)
+	}
+
+	if len(failures) > 0 {
+		return recreated, fmt.Errorf("failed to recreate container(s): %s", strings.Join(failures, "; "))
+	}
+	return recreated, nil
+}
+
+// recreateContainer replaces one container, restoring the original on failure
+func (i *DockerInstaller) recreateContainer(ctx context.Context, cli *dockerclient.Client, old types.ContainerJSON, imageName string) (string, error) {
+	name := strings.TrimPrefix(old.Name, "/")
+	backupName := fmt.Sprintf("%s-redflag-old-%d", name, time.Now().Unix())
+	wasRunning := old.State != nil && old.State.Running
+
+	oldImage, _, err := cli.ImageInspectWithRaw(ctx, old.Image)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect current image: %w", err)
+	}
+
+	i.logf("Recreating container %s on %s\n", name, imageName)
+	if wasRunning {
+		if err := cli.ContainerStop(ctx, old.ID, container.StopOptions{}); err != nil {
+			return "", fmt.Errorf("failed to stop: %w", err)
+		}
+	}
+	if err := cli.ContainerRename(ctx, old.ID, backupName); err != nil {
+		i.restartOld(ctx, cli, old.ID, wasRunning)
+		return "", fmt.Errorf("failed to rename for backup: %w", err)
+	}
+
+	// restore puts the original container back after a failed replacement
+	restore := func(newID string, cause error) (string, error) {
+		i.logf("Rolling back container %s: %v\n", name, cause)
+		if newID != "" {
+			cli.ContainerRemove(ctx, newID, container.RemoveOptions{Force: true})
+		}
+		if err := cli.ContainerRename(ctx, old.ID, name); err != nil {
+			return "", fmt.Errorf("%v; rollback failed, original kept as %s: %w", cause, backupName, err)
+		}
+		i.restartOld(ctx, cli, old.ID, wasRunning)
+		return "", fmt.Errorf("%v (rolled back to original container)", cause)
+	}
+
+	config, hostConfig, networking, extraNetworks := cloneContainerConfig(old, oldImage.Config, imageName)
+	created, err := cli.ContainerCreate(ctx, config, hostConfig, networking, nil, name)
+	if err != nil {
+		return restore("", fmt.Errorf("failed to create: %w", err))
+	}
+	for networkName, endpoint := range extraNetworks {
+		if err := cli.NetworkConnect(ctx, networkName, created.ID, endpoint); err != nil {
+			return restore(created.ID, fmt.Errorf("failed to connect network %s: %w", networkName, err))
+		}
+	}
+
+	if wasRunning {
+		if err := cli.ContainerStart(ctx, created.ID, container.StartOptions{}); err != nil {
+			return restore(created.ID, fmt.Errorf("failed to start: %w", err))
+		}
+		if err := waitHealthy(ctx, cli, created.ID); err != nil {
+			return restore(created.ID, err)
+		}
+	}
+
+	if err := cli.ContainerRemove(ctx, old.ID, container.RemoveOptions{}); err != nil {
+		i.logf("Warning: recreated %s but failed to remove old container %s: %v\n", name, backupName, err)
+	}
+	i.logf("Recreated container %s (%s -> %s)\n", name, shortID(old.ID), shortID(created.ID))
+	return created.ID, nil
+}
+
+func (i *DockerInstaller) restartOld(ctx context.Context, cli *dockerclient.Client, id string, wasRunning bool) {
+	if !wasRunning {
+		return
+	}
+	if err := cli.ContainerStart(ctx, id, container.StartOptions{}); err != nil {
+		i.logf("Warning: failed to restart original container %s: %v\n", shortID(id), err)
+	}
+}
+
+// cloneContainerConfig copies a container's configuration for the new image.
+// Settings the container only inherited from its old image (env, labels,
+// command...) are dropped so the new image's defaults apply. The first network
+// is attached at create time; the rest are returned to connect afterwards.
+func cloneContainerConfig(old types.ContainerJSON, oldImageConfig *container.Config, imageName string) (*container.Config, *container.HostConfig, *network.NetworkingConfig, map[string]*network.EndpointSettings) {
+	config := *old.Config
+	config.Image = imageName
+	// An auto-assigned hostname is the old container's short ID
+	if config.Hostname == shortID(old.ID) {
+		config.Hostname = ""
+	}
+	if oldImageConfig != nil {
+		config.Env = withoutInherited(config.Env, oldImageConfig.Env)
+		config.Labels = withoutInheritedLabels(config.Labels, oldImageConfig.Labels)
+		if reflect.DeepEqual(config.Cmd, oldImageConfig.Cmd) {
+			config.Cmd = nil
+		}
+		if reflect.DeepEqual(config.Entrypoint, oldImageConfig.Entrypoint) {
+			config.Entrypoint = nil
+		}
+		if config.WorkingDir == oldImageConfig.WorkingDir {
+			config.WorkingDir = ""
+		}
+		if config.User == oldImageConfig.User {
+			config.User = ""
+		}
+		if reflect.DeepEqual(config.Healthcheck, oldImageConfig.Healthcheck) {
+			config.Healthcheck = nil
+		}
+		if reflect.DeepEqual(config.ExposedPorts, oldImageConfig.ExposedPorts) {
+			config.ExposedPorts = nil
+		}
+		if reflect.DeepEqual(config.Volumes, oldImageConfig.Volumes) {
+			config.Volumes = nil
+		}
+	}
+
+	var hostConfig *container.HostConfig
+	if old.HostConfig != nil {
+		copied := *old.HostConfig
+		hostConfig = &copied
+	}
+
+	networking := &network.NetworkingConfig{EndpointsConfig: map[string]*network.EndpointSettings{}}
+	extra := map[string]*network.EndpointSettings{}
+	if hostConfig != nil && (hostConfig.NetworkMode.IsHost() || hostConfig.NetworkMode.IsContainer() || hostConfig.NetworkMode.IsNone()) {
+		return &config, hostConfig, networking, extra
+	}
+	if old.NetworkSettings != nil {
+		primary := ""
+		if hostConfig != nil {
+			primary = string(hostConfig.NetworkMode)
+		}
+		for networkName, endpoint := range old.NetworkSettings.Networks {
+			settings := cloneEndpoint(endpoint, old.ID)
+			if networkName == primary || (primary == "default" && networkName == "bridge") {
+				networking.EndpointsConfig[networkName] = settings
+			} else {
+				extra[networkName] = settings
+			}
+		}
+	}
+	return &config, hostConfig, networking, extra
+}
+
+// cloneEndpoint keeps the user-set parts of a network attachment, dropping
+// runtime state and the alias Docker derived from the old container ID
+func cloneEndpoint(endpoint *network.EndpointSettings, oldID string) *network.EndpointSettings {
+	if endpoint == nil {
+		return &network.EndpointSettings{}
+	}
+	var aliases []string
+	for _, alias := range endpoint.Aliases {
+		if alias != shortID(oldID) {
+			aliases = append(aliases, alias)
+		}
+	}
+	return &network.EndpointSettings{
+		IPAMConfig: endpoint.IPAMConfig,
+		Links:      endpoint.Links,
+		Aliases:    aliases,
+		DriverOpts: endpoint.DriverOpts,
+	}
+}
+
+// waitHealthy waits for a started container to report healthy or, without a
+// HEALTHCHECK, to stay running through the stability window
+func waitHealthy(ctx context.Context, cli *dockerclient.Client, id string) error {
+	deadline := time.Now().Add(containerStartTimeout)
+	var runningSince time.Time
+
+	for time.Now().Before(deadline) {
+		info, err := cli.ContainerInspect(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed to inspect new container: %w", err)
+		}
+		if info.State == nil || !info.State.Running {
+			exitCode := 0
+			if info.State != nil {
+				exitCode = info.State.ExitCode
+			}
+			return fmt.Errorf("new container exited (code %d)", exitCode)
+		}
+
+		if info.State.Health != nil {
+			switch info.State.Health.Status {
+			case types.Healthy:
+				return nil
+			case types.Unhealthy:
+				return fmt.Errorf("new container is unhealthy")
+			}
+		} else {
+			if runningSince.IsZero() {
+				runningSince = time.Now()
+			}
+			if time.Since(runningSince) >= containerStableWindow {
+				return nil
+			}
+		}
+
+		time.Sleep(2 * time.Second)
+	}
+	return fmt.Errorf("new container not healthy after %s", containerStartTimeout)
+}
+
+// withoutInherited drops env entries the container got unchanged from its image
+func withoutInherited(values, inherited []string) []string {
+	fromImage := make(map[string]bool, len(inherited))
+	for _, value := range inherited {
+		fromImage[value] = true
+	}
+	var kept []string
+	for _, value := range values {
+		if !fromImage[value] {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// withoutInheritedLabels drops labels the container got unchanged from its image
+func withoutInheritedLabels(labels, inherited map[string]string) map[string]string {
+	kept := make(map[string]string, len(labels))
+	for key, value := range labels {
+		if imageValue, ok := inherited[key]; !ok || imageValue != value {
+			kept[key] = value
+		}
+	}
+	return kept
+}
+
+// rollbackTagBump moves the containers a tag bump of imageName at level moved
+// onto a newer tag back onto imageName
+func (i *DockerInstaller) rollbackTagBump(ctx context.Context, imageName, level string) ([]RecreatedContainer, error) {
+	cli, err := dockerclient.NewClientWithOpts(dockerclient.FromEnv, dockerclient.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Docker: %w", err)
+	}
+	bumped, err := tagBumpedImages(ctx, cli, imageName, level)
+	cli.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(bumped) == 0 {
+		return nil, fmt.Errorf("no containers run a newer %s version of %s", level, imageName)
+	}
+
+	var recreated []RecreatedContainer
+	var failures []string
+	for _, fromImage := range bumped {
+		moved, err := i.recreateContainers(ctx, fromImage, imageName)
+		recreated = append(recreated, moved...)
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return recreated, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return recreated, nil
+}
+
+// tagBumpedImages lists the images containers run that are newer tags of
+// imageName at the given bump level, i.e. the tags a tag bump moved them to
+func tagBumpedImages(ctx context.Context, cli *dockerclient.Client, imageName, level string) ([]string, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var images []string
+	seen := make(map[string]bool)
+	for _, c := range containers {
+		if seen[c.Image] || !scanner.IsTagBumpOf(imageName, level, c.Image) {
+			continue
+		}
+		seen[c.Image] = true
+		images = append(images, c.Image)
+	}
+	return images, nil
+}
+
+// normalizeImageRef adds the implicit :latest tag so "nginx" matches "nginx:latest"
+func normalizeImageRef(ref string) string {
+	if strings.Contains(ref, "@") {
+		return ref
+	}
+	if lastColon := strings.LastIndex(ref, ":"); lastColon > strings.LastIndex(ref, "/") {
+		return ref
+	}
+	return ref + ":latest"
+}
+
+func shortID(id string) string {
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// logf prints progress and streams it to the output handler
+func (i *DockerInstaller) logf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Print(message)
+	if i.outputHandler != nil {
+		i.outputHandler([]byte(message))
+	}
+}
@@ -1,6 +1,37 @@
 package installer
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// versionPattern matches package versions across apt, dnf and image digests
+// (e.g. "1:2.34-1ubuntu1", "5.14.0-3.fc39", "sha256:0123abcd")
+var versionPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+~:_-]*$`)
+
+// validateVersion rejects versions that could be read as options or shell syntax
+func validateVersion(version string) error {
+	if !versionPattern.MatchString(version) {
+		return fmt.Errorf("invalid package version: %q", version)
+	}
+	return nil
+}
+
+// agentBinary returns the path of the running agent binary, for invoking its
+// root helper subcommands through sudo. sudoers names the installed path, so
+// symlinks are resolved.
+func agentBinary() (string, error) {
+	binary, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to locate agent binary: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(binary); err == nil {
+		binary = resolved
+	}
+	return binary, nil
+}
 
 // Installer interface for different package types
 type Installer interface {
@@ -11,6 +42,23 @@ type Installer interface {
 	UpdatePackage(packageName string) (*InstallResult, error)  // New: Update specific package
 	GetPackageType() string
 	DryRun(packageName string) (*InstallResult, error)  // New: Perform dry run to check dependencies
+	InstallVersion(packageName, version string) (*InstallResult, error) // Install a specific earlier version (rollback)
+	SetOutputHandler(handler OutputHandler)             // Stream command output while it runs (nil to disable)
+}
+
+// PackageTypes lists the package types InstallerFactory knows
+var PackageTypes = []string{"apt", "dnf", "docker_image", "podman_image", "windows_update", "winget"}
+
+// AvailablePackageTypes returns the package types that can be installed on
+// this host
+func AvailablePackageTypes() []string {
+	var types []string
+	for _, packageType := range PackageTypes {
+		if inst, err := InstallerFactory(packageType); err == nil && inst.IsAvailable() {
+			types = append(types, packageType)
+		}
+	}
+	return types
 }
 
 // InstallerFactory creates appropriate installer based on package type
@@ -22,6 +70,8 @@ func InstallerFactory(packageType string) (Installer, error) {
 		return NewDNFInstaller(), nil
 	case "docker_image":
 		return NewDockerInstaller()
+	case "podman_image":
+		return NewPodmanInstaller()
 	case "windows_update":
 		return NewWindowsUpdateInstaller(), nil
 	case "winget":
@@ -0,0 +1,46 @@
+package installer
+
+import (
+	"bytes"
+	"os/exec"
+	"sync"
+)
+
+// OutputHandler receives command output incrementally as it is produced.
+// Stdout and stderr are interleaved in the order they are written.
+type OutputHandler func(chunk []byte)
+
+// outputCollector buffers combined output while forwarding each write to a handler
+type outputCollector struct {
+	mu      sync.Mutex
+	buf     bytes.Buffer
+	handler OutputHandler
+}
+
+func (c *outputCollector) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.buf.Write(p)
+	if c.handler != nil {
+		// Copy - the exec package reuses p after Write returns
+		chunk := make([]byte, len(p))
+		copy(chunk, p)
+		c.handler(chunk)
+	}
+	return len(p), nil
+}
+
+// runWithOutput behaves like cmd.CombinedOutput, but also streams output to
+// handler as it arrives. A nil handler makes it equivalent to CombinedOutput.
+func runWithOutput(cmd *exec.Cmd, handler OutputHandler) ([]byte, error) {
+	collector := &outputCollector{handler: handler}
+	cmd.Stdout = collector
+	cmd.Stderr = collector
+
+	err := cmd.Run()
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+	return collector.buf.Bytes(), err
+}
@@ -0,0 +1,548 @@
+package installer
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/scanner"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	dockerclient "github.com/docker/docker/client"
+)
+
+// PodmanRestartCommand is the agent subcommand, run as root through sudo, that
+// restarts a Podman container's systemd unit
+const PodmanRestartCommand = "restart-podman-unit"
+
+var (
+	podmanUnitPattern = regexp.MustCompile(`^[A-Za-z0-9:_.@\\-]+\.service$`)
+	podmanUserPattern = regexp.MustCompile(`^[a-z_][a-z0-9_.-]*$`)
+)
+
+// PodmanInstaller handles Podman image updates. It talks to each rootful and
+// rootless Podman instance through its Docker-compatible API socket: the image
+// is pulled into every instance that runs it, systemd-managed containers are
+// restarted through their unit (like "podman auto-update", rolling back to the
+// previous image if the unit doesn't come up), and other containers are
+// recreated in place.
+type PodmanInstaller struct {
+	outputHandler OutputHandler
+}
+
+// NewPodmanInstaller creates a new Podman installer
+func NewPodmanInstaller() (*PodmanInstaller, error) {
+	if _, err := exec.LookPath("podman"); err != nil {
+		return nil, err
+	}
+	return &PodmanInstaller{}, nil
+}
+
+// SetOutputHandler streams pull and restart progress to handler while it runs
+func (i *PodmanInstaller) SetOutputHandler(handler OutputHandler) {
+	i.outputHandler = handler
+}
+
+// IsAvailable checks if Podman is available on this system
+func (i *PodmanInstaller) IsAvailable() bool {
+	_, err := exec.LookPath("podman")
+	return err == nil
+}
+
+// GetPackageType returns type of packages this installer handles
+func (i *PodmanInstaller) GetPackageType() string {
+	return "podman_image"
+}
+
+// Install installs a Podman image (alias for UpdatePackage)
+func (i *PodmanInstaller) Install(imageName string) (*InstallResult, error) {
+	return i.UpdatePackage(imageName)
+}
+
+// UpdatePackage pulls an image into every Podman instance running it and
+// moves their containers onto it. Tag bumps ("app:1.2 [minor]") pull the
+// newest tag at that level and move the containers on the old one.
+func (i *PodmanInstaller) UpdatePackage(packageName string) (*InstallResult, error) {
+	startTime := time.Now()
+	fromImage, imageName, err := resolveTagBump(context.Background(), packageName)
+	if err != nil {
+		return podmanResult("", nil, err, startTime, "pull")
+	}
+
+	output, recreated, err := i.updateImage(context.Background(), fromImage, imageName, true)
+	return podmanResult(output, recreated, err, startTime, "pull")
+}
+
+// InstallMultiple updates multiple Podman images
+func (i *PodmanInstaller) InstallMultiple(imageNames []string) (*InstallResult, error) {
+	if len(imageNames) == 0 {
+		return &InstallResult{
+			Success:      false,
+			ErrorMessage: "No images specified for installation",
+		}, fmt.Errorf("no images specified")
+	}
+
+	startTime := time.Now()
+	var allOutput strings.Builder
+	var allRecreated []RecreatedContainer
+	var errors []string
+
+	for _, packageName := range imageNames {
+		fromImage, imageName, err := resolveTagBump(context.Background(), packageName)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", packageName, err))
+			continue
+		}
+		output, recreated, err := i.updateImage(context.Background(), fromImage, imageName, true)
+		allOutput.WriteString(output)
+		allRecreated = append(allRecreated, recreated...)
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("%s: %v", packageName, err))
+		}
+	}
+
+	var err error
+	if len(errors) > 0 {
+		err = fmt.Errorf("podman update failed: %s", strings.Join(errors, "; "))
+	}
+	return podmanResult(allOutput.String(), allRecreated, err, startTime, "pull_multiple")
+}
+
+// Upgrade is not applicable for Podman
+func (i *PodmanInstaller) Upgrade() (*InstallResult, error) {
+	return &InstallResult{
+		Success:      false,
+		ErrorMessage: "Podman upgrade not implemented - use specific image updates",
+		ExitCode:     1,
+		Action:       "upgrade",
+	}, fmt.Errorf("podman upgrade not implemented")
+}
+
+// DryRun reports which Podman instances run the image without pulling it
+func (i *PodmanInstaller) DryRun(packageName string) (*InstallResult, error) {
+	startTime := time.Now()
+	ctx := context.Background()
+	fromImage, imageName, err := resolveTagBump(ctx, packageName)
+	if err != nil {
+		return &InstallResult{Success: false, ErrorMessage: err.Error(), ExitCode: 1, IsDryRun: true, Action: "dry_run"}, err
+	}
+
+	var b strings.Builder
+	for _, instance := range scanner.PodmanInstances() {
+		cli, err := instance.Client()
+		if err != nil {
+			continue
+		}
+		containers, err := podmanContainersOf(ctx, cli, fromImage)
+		cli.Close()
+		if err != nil {
+			fmt.Fprintf(&b, "%s: unreachable: %v\n", instance, err)
+			continue
+		}
+		for _, info := range containers {
+			fmt.Fprintf(&b, "%s: would move %s from %s to %s%s\n", instance, strings.TrimPrefix(info.Name, "/"), fromImage, imageName, podmanManagedBy(info))
+		}
+	}
+
+	return &InstallResult{
+		Success:         true,
+		Stdout:          b.String(),
+		DurationSeconds: int(time.Since(startTime).Seconds()),
+		Dependencies:    []string{},
+		IsDryRun:        true,
+		Action:          "dry_run",
+	}, nil
+}
+
+// InstallVersion rolls an image back to a previous image ID in every instance
+// that still has it, re-tagging it and restarting the containers on it. Tag
+// bumps are rolled back by moving the containers back onto the old tag.
+func (i *PodmanInstaller) InstallVersion(imageName, version string) (*InstallResult, error) {
+	startTime := time.Now()
+	ctx := context.Background()
+
+	if bumpedFrom, level, ok := scanner.ParseTagBumpKey(imageName); ok {
+		output, recreated, err := i.rollbackTagBump(ctx, bumpedFrom, level)
+		return podmanResult(output, recreated, err, startTime, "rollback")
+	}
+
+	imageID := strings.TrimPrefix(version, "sha256:")
+	if len(imageID) < 12 || strings.Trim(imageID, "0123456789abcdef") != "" {
+		err := fmt.Errorf("invalid image ID: %q", version)
+		return &InstallResult{Success: false, ErrorMessage: err.Error(), Action: "rollback"}, err
+	}
+
+	tagged := 0
+	for _, instance := range scanner.PodmanInstances() {
+		cli, err := instance.Client()
+		if err != nil {
+			continue
+		}
+		if _, _, err := cli.ImageInspectWithRaw(ctx, imageID); err == nil {
+			if err := cli.ImageTag(ctx, imageID, imageName); err == nil {
+				i.logf("%s: tagged %s as %s\n", instance, imageID, imageName)
+				tagged++
+			}
+		}
+		cli.Close()
+	}
+	if tagged == 0 {
+		err := fmt.Errorf("previous image %s is no longer available in any Podman instance", imageID)
+		return &InstallResult{Success: false, ErrorMessage: err.Error(), ExitCode: 1, Action: "rollback"}, err
+	}
+
+	output, recreated, err := i.updateImage(ctx, imageName, imageName, false)
+	return podmanResult(output, recreated, err, startTime, "rollback")
+}
+
+// rollbackTagBump moves the containers a tag bump of imageName at level moved
+// onto a newer tag back onto imageName, in every Podman instance
+func (i *PodmanInstaller) rollbackTagBump(ctx context.Context, imageName, level string) (string, []RecreatedContainer, error) {
+	var bumped []string
+	seen := make(map[string]bool)
+	for _, instance := range scanner.PodmanInstances() {
+		cli, err := instance.Client()
+		if err != nil {
+			continue
+		}
+		images, err := tagBumpedImages(ctx, cli, imageName, level)
+		cli.Close()
+		if err != nil {
+			continue
+		}
+		for _, image := range images {
+			if !seen[image] {
+				seen[image] = true
+				bumped = append(bumped, image)
+			}
+		}
+	}
+	if len(bumped) == 0 {
+		return "", nil, fmt.Errorf("no containers run a newer %s version of %s", level, imageName)
+	}
+
+	var output strings.Builder
+	var recreated []RecreatedContainer
+	var failures []string
+	for _, fromImage := range bumped {
+		out, moved, err := i.updateImage(ctx, fromImage, imageName, false)
+		output.WriteString(out)
+		recreated = append(recreated, moved...)
+		if err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return output.String(), recreated, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return output.String(), recreated, nil
+}
+
+// updateImage optionally pulls imageName into each Podman instance with
+// containers on fromImage, then moves those containers onto imageName
+func (i *PodmanInstaller) updateImage(ctx context.Context, fromImage, imageName string, pull bool) (string, []RecreatedContainer, error) {
+	var output strings.Builder
+	var recreated []RecreatedContainer
+	var failures []string
+
+	instances := scanner.PodmanInstances()
+	if len(instances) == 0 {
+		return "", nil, fmt.Errorf("no Podman API socket found")
+	}
+
+	for _, instance := range instances {
+		cli, err := instance.Client()
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", instance, err))
+			continue
+		}
+		moved, err := i.updateInstance(ctx, cli, instance, fromImage, imageName, pull, &output)
+		cli.Close()
+		recreated = append(recreated, moved...)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", instance, err))
+		}
+	}
+
+	output.WriteString(recreateSummary(recreated))
+	if len(failures) > 0 {
+		return output.String(), recreated, fmt.Errorf("%s", strings.Join(failures, "; "))
+	}
+	return output.String(), recreated, nil
+}
+
+// updateInstance updates one Podman instance. Instances neither running nor
+// holding the image are left alone.
+func (i *PodmanInstaller) updateInstance(ctx context.Context, cli *dockerclient.Client, instance scanner.PodmanInstance, fromImage, imageName string, pull bool, output *strings.Builder) ([]RecreatedContainer, error) {
+	containers, err := podmanContainersOf(ctx, cli, fromImage)
+	if err != nil {
+		return nil, err
+	}
+	if len(containers) == 0 {
+		if _, _, err := cli.ImageInspectWithRaw(ctx, fromImage); err != nil {
+			return nil, nil
+		}
+	}
+
+	if pull {
+		i.logf("%s: pulling %s\n", instance, imageName)
+		pullOutput, err := i.pull(ctx, cli, imageName)
+		output.WriteString(pullOutput)
+		if err != nil {
+			return nil, fmt.Errorf("pull failed: %w", err)
+		}
+	}
+
+	newImage, _, err := cli.ImageInspectWithRaw(ctx, imageName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect %s: %w", imageName, err)
+	}
+
+	// Containers are recreated with the Docker installer's logic, which
+	// works unchanged against Podman's compatible API
+	recreator := &DockerInstaller{outputHandler: i.outputHandler}
+
+	var recreated []RecreatedContainer
+	var failures []string
+	restartedUnits := make(map[string]bool)
+	for _, info := range containers {
+		name := strings.TrimPrefix(info.Name, "/")
+		if fromImage == imageName && info.Image == newImage.ID {
+			i.logf("%s: container %s already runs the new image\n", instance, name)
+			continue
+		}
+
+		labels := info.Config.Labels
+		switch {
+		case labels[scanner.PodmanSystemdUnitLabel] != "":
+			unit := labels[scanner.PodmanSystemdUnitLabel]
+			if fromImage != imageName {
+				// The unit pins the old tag; restarting it would bring that back
+				failures = append(failures, fmt.Sprintf("%s: systemd unit %s pins %s; change its image to %s", name, unit, fromImage, imageName))
+				continue
+			}
+			if restartedUnits[unit] {
+				continue
+			}
+			restartedUnits[unit] = true
+			newID, err := i.restartUnit(ctx, cli, instance, unit, info, imageName)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			recreated = append(recreated, RecreatedContainer{Name: name, OldID: shortID(info.ID), NewID: shortID(newID)})
+
+		case labels[scanner.ComposeProjectLabel] != "" || labels["io.podman.compose.project"] != "":
+			failures = append(failures, fmt.Sprintf("%s: compose-managed Podman containers must be updated with their compose tool", name))
+
+		default:
+			if info.HostConfig != nil && info.HostConfig.AutoRemove {
+				failures = append(failures, fmt.Sprintf("%s: --rm containers can't be recreated safely", name))
+				continue
+			}
+			newID, err := recreator.recreateContainer(ctx, cli, info, imageName)
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+				continue
+			}
+			recreated = append(recreated, RecreatedContainer{Name: name, OldID: shortID(info.ID), NewID: shortID(newID)})
+		}
+	}
+
+	if len(failures) > 0 {
+		return recreated, fmt.Errorf("failed to update container(s): %s", strings.Join(failures, "; "))
+	}
+	return recreated, nil
+}
+
+// restartUnit restarts the systemd unit running a container so it picks up the
+// new image, then waits for the container to come up. If it doesn't, the tag
+// is pointed back at the previous image and the unit restarted again.
+func (i *PodmanInstaller) restartUnit(ctx context.Context, cli *dockerclient.Client, instance scanner.PodmanInstance, unit string, old types.ContainerJSON, imageName string) (string, error) {
+	if !podmanUnitPattern.MatchString(unit) {
+		return "", fmt.Errorf("invalid systemd unit name %q", unit)
+	}
+	name := strings.TrimPrefix(old.Name, "/")
+
+	i.logf("%s: restarting %s for container %s\n", instance, unit, name)
+	err := i.restartUnitAsRoot(instance, unit)
+	var newID string
+	if err == nil {
+		// The unit re-creates the container under the same name
+		var info types.ContainerJSON
+		info, err = cli.ContainerInspect(ctx, name)
+		if err == nil {
+			newID = info.ID
+			err = waitHealthy(ctx, cli, newID)
+		}
+	}
+	if err == nil {
+		return newID, nil
+	}
+
+	i.logf("%s: rolling back %s: %v\n", instance, unit, err)
+	if tagErr := cli.ImageTag(ctx, old.Image, imageName); tagErr != nil {
+		return "", fmt.Errorf("%v; rollback failed to re-tag previous image: %w", err, tagErr)
+	}
+	if restartErr := i.restartUnitAsRoot(instance, unit); restartErr != nil {
+		return "", fmt.Errorf("%v; rollback failed to restart %s: %w", err, unit, restartErr)
+	}
+	return "", fmt.Errorf("%v (rolled back to previous image)", err)
+}
+
+// restartUnitAsRoot restarts a unit through the agent binary's
+// restart-podman-unit helper, run as root with sudo. sudoers can't restrict
+// systemctl to Podman's units, so the helper checks the unit really runs a
+// Podman container before restarting it (see RestartPodmanUnit).
+func (i *PodmanInstaller) restartUnitAsRoot(instance scanner.PodmanInstance, unit string) error {
+	binary, err := agentBinary()
+	if err != nil {
+		return err
+	}
+
+	output, err := runWithOutput(exec.Command("sudo", "-n", binary, PodmanRestartCommand, instance.String(), unit), i.outputHandler)
+	if err != nil {
+		return fmt.Errorf("restart of %s failed: %w: %s", unit, err, strings.TrimSpace(lastLine(string(output))))
+	}
+	return nil
+}
+
+// RestartPodmanUnit restarts the systemd unit of a Podman container, for the
+// "redflag-agent restart-podman-unit <instance> <unit>" helper the agent runs
+// as root. It only restarts units that a container of that Podman instance
+// names in its PODMAN_SYSTEMD_UNIT label, using the system manager for
+// rootful Podman and the owning user's manager for rootless.
+func RestartPodmanUnit(args []string, out io.Writer) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: %s <rootful|rootless:USER> <unit>", PodmanRestartCommand)
+	}
+	unit := args[1]
+	if !podmanUnitPattern.MatchString(unit) {
+		return fmt.Errorf("invalid systemd unit name %q", unit)
+	}
+	instance, err := scanner.LookupPodmanInstance(args[0])
+	if err != nil {
+		return err
+	}
+	if instance.Rootless && !podmanUserPattern.MatchString(instance.User) {
+		return fmt.Errorf("invalid user name %q", instance.User)
+	}
+
+	cli, err := instance.Client()
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s Podman: %w", instance, err)
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	containers, err := cli.ContainerList(ctx, container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", scanner.PodmanSystemdUnitLabel+"="+unit)),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list %s containers: %w", instance, err)
+	}
+	if len(containers) == 0 {
+		return fmt.Errorf("%s is not the unit of any %s Podman container", unit, instance)
+	}
+
+	cmdArgs := []string{"restart", unit}
+	if instance.Rootless {
+		cmdArgs = []string{"--user", "-M", instance.User + "@", "restart", unit}
+	}
+	cmd := exec.Command("/usr/bin/systemctl", cmdArgs...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
+// pull pulls an image through the API, returning its progress messages
+func (i *PodmanInstaller) pull(ctx context.Context, cli *dockerclient.Client, imageName string) (string, error) {
+	reader, err := cli.ImagePull(ctx, imageName, image.PullOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+
+	var output strings.Builder
+	decoder := json.NewDecoder(reader)
+	for {
+		var message struct {
+			ID     string `json:"id"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		}
+		if err := decoder.Decode(&message); err == io.EOF {
+			break
+		} else if err != nil {
+			return output.String(), fmt.Errorf("failed to read pull progress: %w", err)
+		}
+		if message.Error != "" {
+			return output.String(), fmt.Errorf("%s", message.Error)
+		}
+		if message.Status != "" {
+			line := strings.TrimSpace(message.ID + " " + message.Status)
+			output.WriteString(line + "\n")
+			i.logf("%s\n", line)
+		}
+	}
+	return output.String(), nil
+}
+
+// podmanContainersOf inspects every container created from imageName
+func podmanContainersOf(ctx context.Context, cli *dockerclient.Client, imageName string) ([]types.ContainerJSON, error) {
+	containers, err := cli.ContainerList(ctx, container.ListOptions{All: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+
+	var matched []types.ContainerJSON
+	for _, c := range containers {
+		info, err := cli.ContainerInspect(ctx, c.ID)
+		if err != nil || info.Config == nil || normalizeImageRef(info.Config.Image) != normalizeImageRef(imageName) {
+			continue
+		}
+		matched = append(matched, info)
+	}
+	return matched, nil
+}
+
+// podmanManagedBy describes how a container is managed, for dry runs
+func podmanManagedBy(info types.ContainerJSON) string {
+	if unit := info.Config.Labels[scanner.PodmanSystemdUnitLabel]; unit != "" {
+		return " (restart " + unit + ")"
+	}
+	return " (recreate)"
+}
+
+func podmanResult(output string, recreated []RecreatedContainer, err error, startTime time.Time, action string) (*InstallResult, error) {
+	result := &InstallResult{
+		Success:           err == nil,
+		Stdout:            output,
+		DurationSeconds:   int(time.Since(startTime).Seconds()),
+		Action:            action,
+		ContainersUpdated: recreatedIDs(recreated),
+	}
+	if err != nil {
+		result.ErrorMessage = err.Error()
+		result.Stderr = err.Error()
+		result.ExitCode = 1
+	}
+	return result, err
+}
+
+func (i *PodmanInstaller) logf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	fmt.Print(message)
+	if i.outputHandler != nil {
+		i.outputHandler([]byte(message))
+	}
+}
@@ -7,13 +7,20 @@ import (
 )
 
 // SecureCommandExecutor handles secure execution of privileged commands
-type SecureCommandExecutor struct{}
+type SecureCommandExecutor struct {
+	outputHandler OutputHandler
+}
 
 // NewSecureCommandExecutor creates a new secure command executor
 func NewSecureCommandExecutor() *SecureCommandExecutor {
 	return &SecureCommandExecutor{}
 }
 
+// SetOutputHandler streams output of subsequent commands to handler as they run
+func (e *SecureCommandExecutor) SetOutputHandler(handler OutputHandler) {
+	e.outputHandler = handler
+}
+
 // AllowedCommands defines the commands that can be executed with elevated privileges
 var AllowedCommands = map[string][]string{
 	"apt-get": {
@@ -26,12 +33,24 @@ var AllowedCommands = map[string][]string{
 		"makecache",
 		"install",
 		"upgrade",
+		"downgrade",
 	},
 	"docker": {
 		"pull",
 		"image",
 		"manifest",
 	},
+	// Pre-install filesystem snapshots (see snapshot.go)
+	"mkdir":    {"-p"},
+	"btrfs":    {"subvolume"},
+	"lvs":      {"--noheadings"},
+	"lvcreate": {"--snapshot"},
+	"lvremove": {"-y"},
+	"zfs": {
+		"snapshot",
+		"destroy",
+		"list",
+	},
 }
 
 // validateCommand checks if a command is allowed to be executed
@@ -58,6 +77,8 @@ func (e *SecureCommandExecutor) validateCommand(baseCmd string, args []string) e
 		return e.validateDNFCommand(args)
 	case "docker":
 		return e.validateDockerCommand(args)
+	case "mkdir", "btrfs", "lvs", "lvcreate", "lvremove", "zfs":
+		return e.validateSnapshotCommand(baseCmd, args)
 	}
 
 	return nil
@@ -119,6 +140,10 @@ func (e *SecureCommandExecutor) validateDNFCommand(args []string) error {
 		if !contains(args, "-y") {
 			return fmt.Errorf("dnf upgrade must include -y flag")
 		}
+	case "downgrade":
+		if !contains(args, "-y") {
+			return fmt.Errorf("dnf downgrade must include -y flag")
+		}
 	}
 	return nil
 }
@@ -186,7 +211,7 @@ func (e *SecureCommandExecutor) ExecuteCommand(baseCmd string, args []string) (*
 	fullArgs := append([]string{fullPath}, args...)
 	cmd := exec.Command("sudo", fullArgs...)
 
-	output, err := cmd.CombinedOutput()
+	output, err := runWithOutput(cmd, e.outputHandler)
 
 	if err != nil {
 		return &InstallResult{
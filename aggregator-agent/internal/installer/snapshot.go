@@ -0,0 +1,372 @@
+package installer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Snapshot kinds, by root filesystem
+const (
+	SnapshotBtrfs = "btrfs"
+	SnapshotLVM   = "lvm"
+	SnapshotZFS   = "zfs"
+)
+
+// BtrfsSnapshotDir holds read-only snapshots of a btrfs root
+const BtrfsSnapshotDir = "/.redflag-snapshots"
+
+// DefaultSnapshotRetention is how many RedFlag snapshots are kept when unset
+const DefaultSnapshotRetention = 3
+
+// DefaultLVMSnapshotSize is the copy-on-write space reserved for LVM snapshots when unset
+const DefaultLVMSnapshotSize = "5G"
+
+// maxLVMSnapshotGigabytes is the largest LVM snapshot size sudoers allows
+const maxLVMSnapshotGigabytes = 999
+
+// ErrSnapshotUnsupported is returned when the root filesystem can't be snapshotted
+var ErrSnapshotUnsupported = errors.New("root filesystem is not btrfs, ZFS or an LVM volume")
+
+var (
+	// Only snapshots named like this are ever created or pruned
+	snapshotNamePattern = regexp.MustCompile(`^redflag-[0-9]{8}T[0-9]{6}Z$`)
+	lvmNamePattern      = regexp.MustCompile(`^[A-Za-z0-9+_.][A-Za-z0-9+_.-]*$`)
+	lvmSizePattern      = regexp.MustCompile(`^([0-9]+(\.[0-9]+)?)([KMGTkmgt]?)$`)
+	// Sizes are passed to lvcreate in whole gigabytes, which sudoers matches digit by digit
+	lvmSnapshotSizePattern = regexp.MustCompile(`^[1-9][0-9]{0,2}G$`)
+	lvmDevicePattern       = regexp.MustCompile(`^/dev/(mapper/[A-Za-z0-9+_.-]+|dm-[0-9]+)$`)
+	zfsDatasetPattern      = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.:/-]*$`)
+)
+
+// Snapshot is a point-in-time copy of the root filesystem taken before an install
+type Snapshot struct {
+	Kind      string    `json:"kind"`
+	ID        string    `json:"id"` // btrfs path, LVM vg/lv or ZFS dataset@name
+	CreatedAt time.Time `json:"created_at"`
+	Pruned    []string  `json:"pruned,omitempty"` // Older RedFlag snapshots removed by retention
+}
+
+// Snapshotter takes and prunes root filesystem snapshots through the secure executor
+type Snapshotter struct {
+	executor *SecureCommandExecutor
+	lvmSize  string
+}
+
+// NewSnapshotter creates a snapshotter; lvmSize is the COW size for LVM snapshots
+func NewSnapshotter(lvmSize string) *Snapshotter {
+	if lvmSize == "" {
+		lvmSize = DefaultLVMSnapshotSize
+	}
+	return &Snapshotter{
+		executor: NewSecureCommandExecutor(),
+		lvmSize:  lvmSize,
+	}
+}
+
+// Take snapshots the root filesystem, then removes the oldest RedFlag snapshots
+// so that at most retention remain. Pruning failures are logged, not returned.
+func (s *Snapshotter) Take(retention int) (*Snapshot, error) {
+	if retention <= 0 {
+		retention = DefaultSnapshotRetention
+	}
+
+	kind, source, err := s.detectRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	name := "redflag-" + now.Format("20060102T150405Z")
+	snapshot := &Snapshot{Kind: kind, CreatedAt: now}
+
+	switch kind {
+	case SnapshotBtrfs:
+		if _, err := s.executor.ExecuteCommand("mkdir", []string{"-p", BtrfsSnapshotDir}); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", BtrfsSnapshotDir, err)
+		}
+		snapshot.ID = filepath.Join(BtrfsSnapshotDir, name)
+		if result, err := s.executor.ExecuteCommand("btrfs", []string{"subvolume", "snapshot", "-r", "/", snapshot.ID}); err != nil {
+			return nil, fmt.Errorf("btrfs snapshot failed: %w: %s", err, strings.TrimSpace(result.Stdout))
+		}
+	case SnapshotLVM:
+		size, err := lvmSnapshotSize(s.lvmSize)
+		if err != nil {
+			return nil, err
+		}
+		snapshot.ID = strings.SplitN(source, "/", 2)[0] + "/" + name
+		if result, err := s.executor.ExecuteCommand("lvcreate", []string{"--snapshot", "--name", name, "--size", size, source}); err != nil {
+			return nil, fmt.Errorf("lvcreate snapshot failed: %w: %s", err, strings.TrimSpace(result.Stdout))
+		}
+	case SnapshotZFS:
+		snapshot.ID = source + "@" + name
+		if result, err := s.executor.ExecuteCommand("zfs", []string{"snapshot", snapshot.ID}); err != nil {
+			return nil, fmt.Errorf("zfs snapshot failed: %w: %s", err, strings.TrimSpace(result.Stdout))
+		}
+	}
+
+	slog.Info("created snapshot", "kind", kind, "snapshot", snapshot.ID)
+	snapshot.Pruned = s.prune(kind, source, retention)
+	return snapshot, nil
+}
+
+// detectRoot identifies the root filesystem and what to snapshot: "/" for
+// btrfs, the vg/lv for LVM, or the dataset for ZFS
+func (s *Snapshotter) detectRoot() (kind, source string, err error) {
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read mounts: %w", err)
+	}
+	defer f.Close()
+
+	// The last mount on / is the one in effect
+	var device, fsType string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && fields[1] == "/" {
+			device, fsType = fields[0], fields[2]
+		}
+	}
+	if device == "" {
+		return "", "", fmt.Errorf("root filesystem not found in /proc/mounts")
+	}
+
+	switch fsType {
+	case "btrfs":
+		return SnapshotBtrfs, "/", nil
+	case "zfs":
+		if !zfsDatasetPattern.MatchString(device) {
+			return "", "", fmt.Errorf("unexpected ZFS dataset name: %q", device)
+		}
+		return SnapshotZFS, device, nil
+	}
+
+	// Any other filesystem can still be snapshotted if it sits on a logical volume
+	if lvmDevicePattern.MatchString(device) {
+		result, err := s.executor.ExecuteCommand("lvs", []string{"--noheadings", "--separator", "/", "-o", "vg_name,lv_name", device})
+		if err == nil {
+			if lv := lastLine(result.Stdout); lv != "" && isLVMPath(lv) {
+				return SnapshotLVM, lv, nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("%w (found %s on %s)", ErrSnapshotUnsupported, fsType, device)
+}
+
+// prune removes the oldest RedFlag snapshots beyond retention and returns their IDs
+func (s *Snapshotter) prune(kind, source string, retention int) []string {
+	var ids []string
+
+	switch kind {
+	case SnapshotBtrfs:
+		entries, err := os.ReadDir(BtrfsSnapshotDir)
+		if err != nil {
+			slog.Warn("failed to list snapshots", "kind", kind, "error", err)
+			return nil
+		}
+		for _, entry := range entries {
+			if snapshotNamePattern.MatchString(entry.Name()) {
+				ids = append(ids, filepath.Join(BtrfsSnapshotDir, entry.Name()))
+			}
+		}
+	case SnapshotLVM:
+		vg := strings.SplitN(source, "/", 2)[0]
+		result, err := s.executor.ExecuteCommand("lvs", []string{"--noheadings", "-o", "lv_name", vg})
+		if err != nil {
+			slog.Warn("failed to list snapshots", "kind", kind, "error", err)
+			return nil
+		}
+		for _, line := range strings.Split(result.Stdout, "\n") {
+			if name := strings.TrimSpace(line); snapshotNamePattern.MatchString(name) {
+				ids = append(ids, vg+"/"+name)
+			}
+		}
+	case SnapshotZFS:
+		result, err := s.executor.ExecuteCommand("zfs", []string{"list", "-H", "-t", "snapshot", "-o", "name", source})
+		if err != nil {
+			slog.Warn("failed to list snapshots", "kind", kind, "error", err)
+			return nil
+		}
+		for _, line := range strings.Split(result.Stdout, "\n") {
+			line = strings.TrimSpace(line)
+			if parts := strings.SplitN(line, "@", 2); len(parts) == 2 && parts[0] == source && snapshotNamePattern.MatchString(parts[1]) {
+				ids = append(ids, line)
+			}
+		}
+	}
+
+	// Names embed a UTC timestamp, so lexical order is creation order
+	sort.Strings(ids)
+	if len(ids) <= retention {
+		return nil
+	}
+
+	var pruned []string
+	for _, id := range ids[:len(ids)-retention] {
+		var err error
+		switch kind {
+		case SnapshotBtrfs:
+			_, err = s.executor.ExecuteCommand("btrfs", []string{"subvolume", "delete", id})
+		case SnapshotLVM:
+			// Removed by exact name: sudoers can't safely match a vg/lv path
+			name := strings.SplitN(id, "/", 2)[1]
+			_, err = s.executor.ExecuteCommand("lvremove", []string{"-y", "--select", "lv_name=" + name})
+		case SnapshotZFS:
+			_, err = s.executor.ExecuteCommand("zfs", []string{"destroy", id})
+		}
+		if err != nil {
+			slog.Warn("failed to remove old snapshot", "snapshot", id, "error", err)
+			continue
+		}
+		slog.Info("removed old snapshot", "snapshot", id)
+		pruned = append(pruned, id)
+	}
+	return pruned
+}
+
+// validateSnapshotCommand restricts snapshot tooling to creating, listing and
+// removing RedFlag-named snapshots
+func (e *SecureCommandExecutor) validateSnapshotCommand(baseCmd string, args []string) error {
+	switch baseCmd {
+	case "mkdir":
+		if len(args) != 2 || args[0] != "-p" || args[1] != BtrfsSnapshotDir {
+			return fmt.Errorf("mkdir is only allowed for %s", BtrfsSnapshotDir)
+		}
+	case "btrfs":
+		switch {
+		case len(args) == 5 && args[1] == "snapshot" && args[2] == "-r" && args[3] == "/" && isBtrfsSnapshotPath(args[4]):
+		case len(args) == 3 && args[1] == "delete" && isBtrfsSnapshotPath(args[2]):
+		default:
+			return fmt.Errorf("btrfs command not allowed: %s", strings.Join(args, " "))
+		}
+	case "lvs":
+		for _, arg := range args {
+			if strings.HasPrefix(arg, "--config") {
+				return fmt.Errorf("lvs --config is not allowed")
+			}
+		}
+	case "lvcreate":
+		if len(args) != 6 || args[1] != "--name" || !snapshotNamePattern.MatchString(args[2]) ||
+			args[3] != "--size" || !lvmSnapshotSizePattern.MatchString(args[4]) || !isLVMPath(args[5]) {
+			return fmt.Errorf("lvcreate is only allowed for RedFlag snapshots")
+		}
+	case "lvremove":
+		if len(args) != 3 || args[0] != "-y" || args[1] != "--select" ||
+			!strings.HasPrefix(args[2], "lv_name=") || !snapshotNamePattern.MatchString(strings.TrimPrefix(args[2], "lv_name=")) {
+			return fmt.Errorf("lvremove is only allowed for RedFlag snapshots")
+		}
+	case "zfs":
+		switch args[0] {
+		case "snapshot", "destroy":
+			if len(args) != 2 || !isZFSSnapshot(args[1]) {
+				return fmt.Errorf("zfs %s is only allowed for RedFlag snapshots", args[0])
+			}
+		case "list":
+			if len(args) != 7 || strings.Join(args[:6], " ") != "list -H -t snapshot -o name" || !zfsDatasetPattern.MatchString(args[6]) {
+				return fmt.Errorf("zfs list command not allowed: %s", strings.Join(args, " "))
+			}
+		}
+	}
+	return nil
+}
+
+// lvmSnapshotSize converts an lvcreate size (megabytes when no unit is given)
+// to whole gigabytes, rounding up, so sudoers can pin the --size argument
+func lvmSnapshotSize(size string) (string, error) {
+	match := lvmSizePattern.FindStringSubmatch(size)
+	if match == nil {
+		return "", fmt.Errorf("invalid LVM snapshot size %q", size)
+	}
+	value, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid LVM snapshot size %q: %w", size, err)
+	}
+	switch strings.ToUpper(match[3]) {
+	case "K":
+		value /= 1024 * 1024
+	case "", "M":
+		value /= 1024
+	case "T":
+		value *= 1024
+	}
+	gigabytes := math.Max(1, math.Ceil(value))
+	if gigabytes > maxLVMSnapshotGigabytes {
+		return "", fmt.Errorf("LVM snapshot size %s is over %dG", size, maxLVMSnapshotGigabytes)
+	}
+	return fmt.Sprintf("%dG", int(gigabytes)), nil
+}
+
+func isBtrfsSnapshotPath(path string) bool {
+	return filepath.Dir(path) == BtrfsSnapshotDir && snapshotNamePattern.MatchString(filepath.Base(path))
+}
+
+func isLVMPath(path string) bool {
+	parts := strings.Split(path, "/")
+	return len(parts) == 2 && lvmNamePattern.MatchString(parts[0]) && lvmNamePattern.MatchString(parts[1])
+}
+
+func isZFSSnapshot(id string) bool {
+	parts := strings.SplitN(id, "@", 2)
+	return len(parts) == 2 && zfsDatasetPattern.MatchString(parts[0]) && snapshotNamePattern.MatchString(parts[1])
+}
+
+// rootMount returns the device and filesystem type mounted on /
+func rootMount() (device, fsType string) {
+	data, err := os.ReadFile("/proc/mounts")
+	if err != nil {
+		return "", ""
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 3 && fields[1] == "/" {
+			device, fsType = fields[0], fields[2]
+		}
+	}
+	return device, fsType
+}
+
+// ZFSRootDataset returns the ZFS dataset mounted on /, or "" when the root
+// filesystem isn't ZFS. Sudoers rules for ZFS snapshots name it exactly.
+func ZFSRootDataset() string {
+	device, fsType := rootMount()
+	// ':' is special in sudoers, so such datasets get no rules
+	if fsType != "zfs" || !zfsDatasetPattern.MatchString(device) || strings.Contains(device, ":") {
+		return ""
+	}
+	return device
+}
+
+// LVMRootVolume returns the device mounted on / and its vg/lv, or empty strings
+// when the root filesystem isn't on a logical volume. Sudoers rules for LVM
+// snapshots name both exactly. lvs needs root, as when installing sudoers.
+func LVMRootVolume() (device, lv string) {
+	device, fsType := rootMount()
+	if fsType == "btrfs" || fsType == "zfs" || !lvmDevicePattern.MatchString(device) {
+		return "", ""
+	}
+	output, err := exec.Command("lvs", "--noheadings", "--separator", "/", "-o", "vg_name,lv_name", device).Output()
+	if err != nil {
+		return "", ""
+	}
+	if lv = lastLine(string(output)); !isLVMPath(lv) {
+		return "", ""
+	}
+	return device, lv
+}
+
+func lastLine(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
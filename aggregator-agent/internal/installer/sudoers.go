@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"text/template"
 )
 
@@ -23,12 +24,54 @@ redflag-agent ALL=(root) NOPASSWD: /usr/bin/apt-get install --dry-run --yes *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf refresh -y
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf install -y *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf upgrade -y
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf downgrade -y *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf install --assumeno --downloadonly *
 
 # Docker operations (alternative approach - uncomment if using Docker group instead of sudo)
 # redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker pull *
 # redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker image inspect *
 # redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker manifest inspect *
+# redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker tag *
+
+# Podman systemd-managed containers (restarted onto updated images). systemctl
+# itself would allow any unit, so restarts go through the agent's root helper,
+# which only restarts a unit that runs a Podman container
+redflag-agent ALL=(root) NOPASSWD: /usr/local/bin/redflag-agent restart-podman-unit *
+
+# Compose-managed containers are updated through the agent's root helper, which
+# only runs "pull <service>" or "up -d --no-deps <service>" with the compose
+# files an existing container of that service was deployed from
+redflag-agent ALL=(root) NOPASSWD: /usr/local/bin/redflag-agent update-compose-service *
+
+# Pre-install filesystem snapshots (btrfs, LVM, ZFS). Snapshot names are spelled
+# out as redflag-YYYYMMDDTHHMMSSZ: a sudoers * also matches spaces and "..", so
+# it must never appear in a rule that deletes something. LVM and ZFS rules name
+# the root volume or dataset and are only present when / is on one; LVM sizes
+# are whole gigabytes.
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/mkdir -p /.redflag-snapshots
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/btrfs subvolume snapshot -r / /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/btrfs subvolume snapshot -r / /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/btrfs subvolume delete /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/btrfs subvolume delete /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+{{- if .LVMRoot}}
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvs --noheadings --separator / -o vg_name\,lv_name {{.LVMDevice}}
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvs --noheadings -o lv_name {{.LVMGroup}}
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvcreate --snapshot --name redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z --size [1-9]G {{.LVMRoot}}
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvcreate --snapshot --name redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z --size [1-9][0-9]G {{.LVMRoot}}
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvcreate --snapshot --name redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z --size [1-9][0-9][0-9]G {{.LVMRoot}}
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvremove -y --select lv_name\=redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+{{- end}}
+{{- if .ZFSRoot}}
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/zfs snapshot {{.ZFSRoot}}@redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/zfs destroy {{.ZFSRoot}}@redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/zfs list -H -t snapshot -o name {{.ZFSRoot}}
+{{- end}}
+# Hardware serial numbers for spec collection (root-only in sysfs)
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/dmidecode -s *
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/dmidecode -s *
+
+# Agent self-update (the swap runs in a separate root unit outside the agent's sandbox)
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/systemctl start --no-block redflag-agent-update.service
 `
 
 // SudoersInstaller handles the installation of sudoers configuration
@@ -64,7 +107,10 @@ func (s *SudoersInstaller) InstallSudoersConfig() error {
 	defer file.Close()
 
 	// Write the template to the file
-	if err := tmpl.Execute(file, nil); err != nil {
+	data := struct{ ZFSRoot, LVMDevice, LVMRoot, LVMGroup string }{ZFSRoot: ZFSRootDataset()}
+	data.LVMDevice, data.LVMRoot = LVMRootVolume()
+	data.LVMGroup = strings.SplitN(data.LVMRoot, "/", 2)[0]
+	if err := tmpl.Execute(file, data); err != nil {
 		return fmt.Errorf("failed to write sudoers configuration: %w", err)
 	}
 
@@ -9,13 +9,20 @@ import (
 )
 
 // WindowsUpdateInstaller handles Windows Update installation
-type WindowsUpdateInstaller struct{}
+type WindowsUpdateInstaller struct {
+	outputHandler OutputHandler
+}
 
 // NewWindowsUpdateInstaller creates a new Windows Update installer
 func NewWindowsUpdateInstaller() *WindowsUpdateInstaller {
 	return &WindowsUpdateInstaller{}
 }
 
+// SetOutputHandler streams installer output to handler while it runs
+func (i *WindowsUpdateInstaller) SetOutputHandler(handler OutputHandler) {
+	i.outputHandler = handler
+}
+
 // IsAvailable checks if Windows Update installer is available on this system
 func (i *WindowsUpdateInstaller) IsAvailable() bool {
 	// Only available on Windows
@@ -108,7 +115,7 @@ func (i *WindowsUpdateInstaller) installViaPowerShell(packageNames []string) (st
 		cmd := exec.Command("powershell", "-Command",
 			fmt.Sprintf("Install-WindowsUpdate -Title '%s' -AcceptAll -AutoRestart", packageName))
 
-		output, err := cmd.CombinedOutput()
+		output, err := runWithOutput(cmd, i.outputHandler)
 		if err != nil {
 			return string(output), fmt.Errorf("PowerShell installation failed for %s: %w", packageName, err)
 		}
@@ -117,6 +124,17 @@ func (i *WindowsUpdateInstaller) installViaPowerShell(packageNames []string) (st
 	return "Windows Updates installed via PowerShell", nil
 }
 
+// InstallVersion is not supported: Windows updates are removed by uninstalling the KB, not by
+// installing an older one
+func (i *WindowsUpdateInstaller) InstallVersion(packageName, version string) (*InstallResult, error) {
+	return &InstallResult{
+		Success:      false,
+		ErrorMessage: "Rollback is not supported for Windows updates",
+		ExitCode:     1,
+		Action:       "rollback",
+	}, fmt.Errorf("rollback not supported for windows updates")
+}
+
 // UpdatePackage updates a specific Windows update (alias for Install method)
 func (i *WindowsUpdateInstaller) UpdatePackage(packageName string) (*InstallResult, error) {
 	// Windows uses same logic for updating as installing
@@ -136,7 +154,7 @@ func (i *WindowsUpdateInstaller) installViaWuauclt(packageNames []string) (strin
 
 	// Install updates
 	cmd = exec.Command("cmd", "/c", "wuauclt /updatenow")
-	output, err := cmd.CombinedOutput()
+	output, err := runWithOutput(cmd, i.outputHandler)
 	if err != nil {
 		return string(output), fmt.Errorf("wuauclt updatenow failed: %w", err)
 	}
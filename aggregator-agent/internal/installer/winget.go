@@ -10,13 +10,20 @@ import (
 )
 
 // WingetInstaller handles winget package installation
-type WingetInstaller struct{}
+type WingetInstaller struct {
+	outputHandler OutputHandler
+}
 
 // NewWingetInstaller creates a new Winget installer
 func NewWingetInstaller() *WingetInstaller {
 	return &WingetInstaller{}
 }
 
+// SetOutputHandler streams winget output to handler while it runs
+func (i *WingetInstaller) SetOutputHandler(handler OutputHandler) {
+	i.outputHandler = handler
+}
+
 // IsAvailable checks if winget is available on this system
 func (i *WingetInstaller) IsAvailable() bool {
 	// Only available on Windows
@@ -163,7 +170,7 @@ func (i *WingetInstaller) installPackage(packageName string, isDryRun bool) (*In
 	}
 
 	// Execute command
-	output, err := cmd.CombinedOutput()
+	output, err := runWithOutput(cmd, i.outputHandler)
 	result.Stdout = string(output)
 	result.Stderr = ""
 	result.DurationSeconds = int(time.Since(startTime).Seconds())
@@ -377,4 +384,40 @@ type WingetPackage struct {
 func (i *WingetInstaller) UpdatePackage(packageName string) (*InstallResult, error) {
 	// Winget uses same logic for updating as installing
 	return i.Install(packageName)
-}
\ No newline at end of file
+}
+
+// InstallVersion installs a specific earlier version of a winget package
+func (i *WingetInstaller) InstallVersion(packageName, version string) (*InstallResult, error) {
+	if !i.IsAvailable() {
+		return nil, fmt.Errorf("winget is not available on this system")
+	}
+	if err := validateVersion(version); err != nil {
+		return &InstallResult{Success: false, ErrorMessage: err.Error(), Action: "rollback"}, err
+	}
+
+	startTime := time.Now()
+	cmd := exec.Command("winget", "install", "--id", packageName, "--version", version,
+		"--accept-package-agreements", "--accept-source-agreements", "--force")
+	output, err := runWithOutput(cmd, i.outputHandler)
+	duration := int(time.Since(startTime).Seconds())
+
+	if err != nil {
+		return &InstallResult{
+			Success:        false,
+			ErrorMessage:   fmt.Sprintf("winget install of %s %s failed: %v", packageName, version, err),
+			Stdout:         string(output),
+			ExitCode:       getExitCode(err),
+			DurationSeconds: duration,
+			Action:         "rollback",
+		}, err
+	}
+
+	return &InstallResult{
+		Success:        true,
+		Stdout:         i.parseInstallOutput(string(output), packageName),
+		ExitCode:       0,
+		DurationSeconds: duration,
+		PackagesInstalled: []string{packageName},
+		Action:         "rollback",
+	}, nil
+}
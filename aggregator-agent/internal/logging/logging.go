@@ -0,0 +1,154 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+)
+
+// Redacted replaces secret values in log output
+const Redacted = "[REDACTED]"
+
+// sensitiveKeys are attribute keys whose values are never logged
+var sensitiveKeys = []string{"password", "secret", "token", "authorization", "api_key", "apikey"}
+
+var (
+	level = new(slog.LevelVar)
+
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// Setup installs a JSON slog handler as the process-wide default logger,
+// writing to cfg.File with rotation when set, otherwise to stdout. The
+// standard library log package is routed through it, so existing log.Printf
+// calls come out as structured JSON at info level.
+//
+// The returned closer releases the log file and should be closed on exit.
+func Setup(cfg config.LoggingConfig) (io.Closer, error) {
+	level.Set(ParseLevel(cfg.Level))
+
+	var w io.Writer = os.Stdout
+	var closer io.Closer = nopCloser{}
+	if cfg.File != "" {
+		rw, err := NewRotatingWriter(cfg.File, cfg.MaxSize, cfg.MaxBackups, cfg.MaxAge)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		w = rw
+		closer = rw
+	}
+
+	handler := &redactingHandler{
+		next: slog.NewJSONHandler(w, &slog.HandlerOptions{
+			Level:       level,
+			ReplaceAttr: redactAttr,
+		}),
+	}
+	slog.SetDefault(slog.New(handler))
+	log.SetFlags(0)
+	return closer, nil
+}
+
+// SetLevel changes the minimum level logged ("debug", "info", "warn", "error")
+func SetLevel(name string) {
+	level.Set(ParseLevel(name))
+}
+
+// ParseLevel converts a level name into a slog.Level, defaulting to info
+func ParseLevel(name string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
+// AddSecret registers values that must never appear in log output, such as
+// the agent's access and refresh tokens. Any occurrence in a message or
+// string attribute is replaced with [REDACTED].
+func AddSecret(values ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, v := range values {
+		// Very short values would redact unrelated text
+		if len(v) >= 4 {
+			secrets = append(secrets, v)
+		}
+	}
+}
+
+// Redact scrubs registered secret values from s
+func Redact(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		if strings.Contains(s, secret) {
+			s = strings.ReplaceAll(s, secret, Redacted)
+		}
+	}
+	return s
+}
+
+func isSensitiveKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, k := range sensitiveKeys {
+		if strings.Contains(key, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func redactAttr(groups []string, a slog.Attr) slog.Attr {
+	if isSensitiveKey(a.Key) {
+		return slog.String(a.Key, Redacted)
+	}
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(Redact(a.Value.String()))
+	case slog.KindAny:
+		if err, ok := a.Value.Any().(error); ok {
+			a.Value = slog.StringValue(Redact(err.Error()))
+		}
+	}
+	return a
+}
+
+// redactingHandler scrubs secrets from the message, which ReplaceAttr doesn't see
+type redactingHandler struct {
+	next slog.Handler
+}
+
+func (h *redactingHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return h.next.Enabled(ctx, l)
+}
+
+func (h *redactingHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.Message = Redact(r.Message)
+	return h.next.Handle(ctx, r)
+}
+
+func (h *redactingHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &redactingHandler{next: h.next.WithAttrs(attrs)}
+}
+
+func (h *redactingHandler) WithGroup(name string) slog.Handler {
+	return &redactingHandler{next: h.next.WithGroup(name)}
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,148 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// RotatingWriter is an io.Writer that writes to a file and rotates it once it
+// exceeds maxSize. Rotated files are named <file>.1 (newest) to <file>.N;
+// anything beyond maxBackups or older than maxAge is removed.
+type RotatingWriter struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+	maxAge     time.Duration
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewRotatingWriter opens (or creates) the log file at path. maxSizeMB,
+// maxBackups and maxAgeDays of zero mean no size limit, keep no backups and
+// no age limit respectively.
+func NewRotatingWriter(path string, maxSizeMB, maxBackups, maxAgeDays int) (*RotatingWriter, error) {
+	w := &RotatingWriter{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxBackups: maxBackups,
+		maxAge:     time.Duration(maxAgeDays) * 24 * time.Hour,
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	w.prune()
+	return w, nil
+}
+
+// Write appends p to the log file, rotating first if it would exceed the size limit
+func (w *RotatingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err := w.open(); err != nil {
+			return 0, err
+		}
+	}
+	if w.maxSize > 0 && w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			// Keep logging to the current file rather than losing output
+			fmt.Fprintf(os.Stderr, "log rotation failed: %v\n", err)
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Close closes the current log file
+func (w *RotatingWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *RotatingWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %s: %w", w.path, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to stat log file %s: %w", w.path, err)
+	}
+	w.file = f
+	w.size = info.Size()
+	return nil
+}
+
+// rotate shifts <file>.N-1 -> <file>.N ... <file> -> <file>.1 and reopens <file>
+func (w *RotatingWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	if w.maxBackups > 0 {
+		os.Remove(w.backupName(w.maxBackups))
+		for i := w.maxBackups - 1; i >= 1; i-- {
+			os.Rename(w.backupName(i), w.backupName(i+1))
+		}
+		if err := os.Rename(w.path, w.backupName(1)); err != nil {
+			w.open()
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else if err := os.Truncate(w.path, 0); err != nil {
+		w.open()
+		return fmt.Errorf("failed to truncate log file: %w", err)
+	}
+
+	if err := w.open(); err != nil {
+		return err
+	}
+	w.prune()
+	return nil
+}
+
+// prune removes backups past maxBackups or older than maxAge
+func (w *RotatingWriter) prune() {
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return
+	}
+	for _, name := range matches {
+		var index int
+		if _, err := fmt.Sscanf(name[len(w.path):], ".%d", &index); err != nil {
+			continue
+		}
+		if index > w.maxBackups {
+			os.Remove(name)
+			continue
+		}
+		if w.maxAge > 0 {
+			if info, err := os.Stat(name); err == nil && time.Since(info.ModTime()) > w.maxAge {
+				os.Remove(name)
+			}
+		}
+	}
+}
+
+func (w *RotatingWriter) backupName(index int) string {
+	return fmt.Sprintf("%s.%d", w.path, index)
+}
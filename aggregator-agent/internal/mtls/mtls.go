@@ -0,0 +1,157 @@
+// Package mtls manages the client certificate the RedFlag server issues the
+// agent for mutual TLS.
+//
+// The agent generates its own key and sends the server a CSR when it
+// registers, when its certificate is due for renewal, and when it renews its
+// access token without a usable certificate. The private key never leaves
+// the host.
+package mtls
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+)
+
+// Default file names for the server-issued certificate, next to the config file
+const (
+	DefaultCertFile = "agent.crt"
+	DefaultKeyFile  = "agent.key"
+)
+
+// Request is a pending certificate request: the CSR to send the server and
+// the private key to install with the certificate it returns
+type Request struct {
+	CSR    string
+	keyPEM []byte
+}
+
+// NewRequest generates a fresh key pair and a CSR for it
+func NewRequest(commonName string) (*Request, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate client key: %w", err)
+	}
+
+	// The server replaces the subject with the agent ID; the CSR only proves key possession
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: commonName},
+	}, key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create csr: %w", err)
+	}
+
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode client key: %w", err)
+	}
+
+	return &Request{
+		CSR:    string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})),
+		keyPEM: pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}, nil
+}
+
+// Install writes the issued certificate and the request's key, defaulting the
+// file paths to dir, and marks the certificate as server-issued in cfg. The
+// caller saves cfg and reconfigures the transport.
+func (r *Request) Install(cfg *config.Config, dir, certPEM string) error {
+	if _, err := parseCertificate([]byte(certPEM)); err != nil {
+		return err
+	}
+
+	if cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "" {
+		cfg.TLS.CertFile = filepath.Join(dir, DefaultCertFile)
+		cfg.TLS.KeyFile = filepath.Join(dir, DefaultKeyFile)
+	}
+
+	// Key first: the transport reloads the pair when the certificate file changes
+	if err := writeAtomic(cfg.TLS.KeyFile, r.keyPEM); err != nil {
+		return err
+	}
+	if err := writeAtomic(cfg.TLS.CertFile, []byte(certPEM)); err != nil {
+		return err
+	}
+	cfg.TLS.ServerIssued = true
+	return nil
+}
+
+// NeedsCertificate reports whether the agent should ask the server for a
+// certificate: it has none yet, or its server-issued one has less than a
+// third of its lifetime left. Certificates the operator configured are left alone.
+func NeedsCertificate(cfg *config.Config) bool {
+	if cfg.TLS.CertFile == "" {
+		return true
+	}
+	if !cfg.TLS.ServerIssued {
+		return false
+	}
+
+	data, err := os.ReadFile(cfg.TLS.CertFile)
+	if err != nil {
+		return true
+	}
+	cert, err := parseCertificate(data)
+	if err != nil {
+		return true
+	}
+
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return time.Until(cert.NotAfter) < lifetime/3
+}
+
+// NeedsRenewal reports whether a server-issued certificate is due for renewal
+func NeedsRenewal(cfg *config.Config) bool {
+	return cfg.TLS.ServerIssued && NeedsCertificate(cfg)
+}
+
+func parseCertificate(data []byte) (*x509.Certificate, error) {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, errors.New("client certificate is not a PEM certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse client certificate: %w", err)
+	}
+	return cert, nil
+}
+
+// writeAtomic replaces path via a temp file so readers never see a partial file
+func writeAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-"+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
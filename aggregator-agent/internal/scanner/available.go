@@ -0,0 +1,29 @@
+package scanner
+
+// Available returns the names of the scanners that can run on this host, as
+// used in the agent config and reported to the server as capabilities
+func Available() []string {
+	var names []string
+	if NewAPTScanner().IsAvailable() {
+		names = append(names, "apt")
+	}
+	if NewDNFScanner().IsAvailable() {
+		names = append(names, "dnf")
+	}
+	if docker, err := NewDockerScanner(); err == nil {
+		if docker.IsAvailable() {
+			names = append(names, "docker")
+		}
+		docker.Close()
+	}
+	if NewPodmanScanner().IsAvailable() {
+		names = append(names, "podman")
+	}
+	if NewWindowsUpdateScanner().IsAvailable() {
+		names = append(names, "windows_update")
+	}
+	if NewWingetScanner().IsAvailable() {
+		names = append(names, "winget")
+	}
+	return names
+}
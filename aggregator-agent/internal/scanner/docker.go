@@ -7,6 +7,8 @@ import (
 	"strings"
 
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/client"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	dockerclient "github.com/docker/docker/client"
 )
@@ -15,6 +17,8 @@ import (
 type DockerScanner struct {
 	client         *dockerclient.Client
 	registryClient *RegistryClient
+	tagRules       []config.DockerTagRule
+	packageType    string
 }
 
 // NewDockerScanner creates a new Docker scanner
@@ -27,6 +31,7 @@ func NewDockerScanner() (*DockerScanner, error) {
 	return &DockerScanner{
 		client:         cli,
 		registryClient: NewRegistryClient(),
+		packageType:    "docker_image",
 	}, nil
 }
 
@@ -56,17 +61,20 @@ func (s *DockerScanner) Scan() ([]client.UpdateReportItem, error) {
 		return nil, fmt.Errorf("failed to list containers: %w", err)
 	}
 
-	var updates []client.UpdateReportItem
-	seenImages := make(map[string]bool)
-
+	// Group containers by image so each image is checked once
+	var imageNames []string
+	containersByImage := make(map[string][]types.Container)
 	for _, c := range containers {
-		imageName := c.Image
-
-		// Skip if we've already checked this image
-		if seenImages[imageName] {
-			continue
+		if _, seen := containersByImage[c.Image]; !seen {
+			imageNames = append(imageNames, c.Image)
 		}
-		seenImages[imageName] = true
+		containersByImage[c.Image] = append(containersByImage[c.Image], c)
+	}
+
+	var updates []client.UpdateReportItem
+
+	for _, imageName := range imageNames {
+		c := containersByImage[imageName][0]
 
 		// Get current image details
 		imageInspect, _, err := s.client.ImageInspectWithRaw(ctx, imageName)
@@ -74,83 +82,160 @@ func (s *DockerScanner) Scan() ([]client.UpdateReportItem, error) {
 			continue
 		}
 
-		// Parse image name and tag
-		parts := strings.Split(imageName, ":")
-		baseImage := parts[0]
-		currentTag := "latest"
-		if len(parts) > 1 {
-			currentTag = parts[1]
+		// Images pinned by digest never change; there is no tag to follow
+		if strings.Contains(imageName, "@") {
+			continue
 		}
 
+		// Parse image name and tag
+		baseImage, currentTag := splitImageTag(imageName)
+
 		// Check if update is available by comparing with registry
-		hasUpdate, remoteDigest := s.checkForUpdate(ctx, baseImage, currentTag, imageInspect.ID)
+		hasUpdate, remote := s.checkForUpdate(ctx, baseImage, currentTag, imageInspect)
 
 		if hasUpdate {
-			// Extract short digest for display (first 12 chars of sha256 hash)
+			// Short digests for display: the config digest is what "docker images" shows once pulled
 			localDigest := imageInspect.ID
-			remoteShortDigest := "unknown"
-			if len(remoteDigest) > 7 {
-				// Format: sha256:abcd... -> take first 12 chars of hash
-				parts := strings.SplitN(remoteDigest, ":", 2)
-				if len(parts) == 2 && len(parts[1]) >= 12 {
-					remoteShortDigest = parts[1][:12]
-				}
-			}
+			remoteShortDigest := shortDigest(remote.ConfigDigest)
 
 			update := client.UpdateReportItem{
-				PackageType:        "docker_image",
+				PackageType:        s.packageType,
 				PackageName:        imageName,
-				PackageDescription: fmt.Sprintf("Container: %s", strings.Join(c.Names, ", ")),
+				PackageDescription: fmt.Sprintf("Container: %s", strings.Join(containerNames(containersByImage[imageName]), ", ")),
 				CurrentVersion:     localDigest[:12], // Short hash
 				AvailableVersion:   remoteShortDigest,
 				Severity:           "moderate",
 				RepositorySource:   baseImage,
 				Metadata: map[string]interface{}{
-					"container_id":      c.ID[:12],
-					"container_names":   c.Names,
-					"container_state":   c.State,
-					"image_created":     imageInspect.Created,
-					"local_full_digest": localDigest,
-					"remote_digest":     remoteDigest,
+					"container_id":           c.ID[:12],
+					"container_names":        c.Names,
+					"container_state":        c.State,
+					"image_created":          imageInspect.Created,
+					"local_full_digest":      localDigest,
+					"remote_digest":          remote.Digest,
+					"remote_manifest_digest": remote.ManifestDigest,
+					"remote_config_digest":   remote.ConfigDigest,
 				},
 			}
+			addComposeMetadata(update.Metadata, containersByImage[imageName])
 
 			updates = append(updates, update)
 		}
+
+		// Version-pinned images: report newer tags as well
+		updates = append(updates, s.checkTagUpdates(ctx, imageName, baseImage, currentTag, containersByImage[imageName])...)
 	}
 
 	return updates, nil
 }
 
+// Labels docker compose sets on the containers it manages
+const (
+	ComposeProjectLabel     = "com.docker.compose.project"
+	ComposeServiceLabel     = "com.docker.compose.service"
+	ComposeWorkingDirLabel  = "com.docker.compose.project.working_dir"
+	ComposeConfigFilesLabel = "com.docker.compose.project.config_files"
+)
+
+// ComposeService identifies a compose service running an image
+type ComposeService struct {
+	Project     string `json:"project"`
+	Service     string `json:"service"`
+	WorkingDir  string `json:"working_dir"`
+	ConfigFiles string `json:"config_files,omitempty"`
+}
+
+// addComposeMetadata records which compose projects/services run an image.
+// The first service also goes into flat compose_* keys so the server can
+// group and approve updates per stack.
+func addComposeMetadata(metadata map[string]interface{}, containers []types.Container) {
+	var services []ComposeService
+	seen := make(map[ComposeService]bool)
+	for _, c := range containers {
+		project := c.Labels[ComposeProjectLabel]
+		service := c.Labels[ComposeServiceLabel]
+		if project == "" || service == "" {
+			continue
+		}
+		entry := ComposeService{
+			Project:     project,
+			Service:     service,
+			WorkingDir:  c.Labels[ComposeWorkingDirLabel],
+			ConfigFiles: c.Labels[ComposeConfigFilesLabel],
+		}
+		if !seen[entry] {
+			seen[entry] = true
+			services = append(services, entry)
+		}
+	}
+	if len(services) == 0 {
+		return
+	}
+
+	metadata["compose_project"] = services[0].Project
+	metadata["compose_service"] = services[0].Service
+	metadata["compose_working_dir"] = services[0].WorkingDir
+	metadata["compose_services"] = services
+}
+
+func containerNames(containers []types.Container) []string {
+	var names []string
+	for _, c := range containers {
+		names = append(names, c.Names...)
+	}
+	return names
+}
+
 // checkForUpdate checks if a newer image version is available by comparing digests
-// Returns (hasUpdate bool, remoteDigest string)
+// Returns (hasUpdate bool, remote image digests)
 //
 // This implementation:
 // 1. Queries Docker Registry HTTP API v2 for remote manifest
-// 2. Compares image digests (sha256 hashes) between local and remote
-// 3. Handles authentication for Docker Hub (anonymous pull)
+// 2. Resolves multi-platform manifest lists / OCI indexes to this platform
+// 3. Authenticates via the registry's challenge with docker CLI credentials
 // 4. Caches registry responses (5 min TTL) to respect rate limits
-// 5. Returns both the update status and remote digest for metadata
+// 5. Returns both the update status and remote digests for metadata
 //
-// Note: This compares exact digests. If local digest != remote digest, an update exists.
-// This works for all tags including "latest", version tags, etc.
-func (s *DockerScanner) checkForUpdate(ctx context.Context, imageName, tag, currentID string) (bool, string) {
-	// Get remote digest from registry
-	remoteDigest, err := s.registryClient.GetRemoteDigest(ctx, imageName, tag)
+// The image is up to date if the tag's digest is one of the local image's
+// RepoDigests, or if this platform's config digest equals the local image ID.
+func (s *DockerScanner) checkForUpdate(ctx context.Context, imageName, tag string, local types.ImageInspect) (bool, *RemoteImage) {
+	remote, err := s.registryClient.GetRemoteImage(ctx, imageName, tag)
 	if err != nil {
 		// If we can't check the registry, log the error but don't report an update
 		// This prevents false positives when registry is down or rate-limited
 		fmt.Printf("Warning: Failed to check registry for %s:%s: %v\n", imageName, tag, err)
-		return false, ""
+		return false, nil
+	}
+
+	if remote.ConfigDigest != "" && remote.ConfigDigest == local.ID {
+		return false, remote
+	}
+	for _, repoDigest := range local.RepoDigests {
+		if _, digest, ok := strings.Cut(repoDigest, "@"); ok && (digest == remote.Digest || digest == remote.ManifestDigest) {
+			return false, remote
+		}
 	}
 
-	// Compare digests
-	// Local Docker image ID format: sha256:abc123...
-	// Remote digest format: sha256:def456...
-	// If they differ, an update is available
-	hasUpdate := currentID != remoteDigest
+	return true, remote
+}
 
-	return hasUpdate, remoteDigest
+// splitImageTag splits "registry:5000/app:1.2" into ("registry:5000/app", "1.2").
+// The tag is after the last colon following the last slash, so registry ports
+// aren't mistaken for tags.
+func splitImageTag(imageName string) (string, string) {
+	lastSlash := strings.LastIndex(imageName, "/")
+	if colon := strings.LastIndex(imageName, ":"); colon > lastSlash {
+		return imageName[:colon], imageName[colon+1:]
+	}
+	return imageName, "latest"
+}
+
+// shortDigest returns the first 12 hex characters of a sha256:... digest
+func shortDigest(digest string) string {
+	if _, hash, ok := strings.Cut(digest, ":"); ok && len(hash) >= 12 {
+		return hash[:12]
+	}
+	return "unknown"
 }
 
 // Close closes the Docker client
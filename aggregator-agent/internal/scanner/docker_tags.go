@@ -0,0 +1,241 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/client"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+	"github.com/docker/docker/api/types"
+)
+
+// Tag bump levels, from most to least conservative
+const (
+	BumpNone  = "none"
+	BumpPatch = "patch"
+	BumpMinor = "minor"
+	BumpMajor = "major"
+)
+
+// versionTagPattern matches semver-like tags: 15, 15.4, v1.25.3, 1.25.3-alpine
+var versionTagPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([A-Za-z0-9][A-Za-z0-9._-]*))?$`)
+
+// ImageVersion is a parsed semver-like image tag
+type ImageVersion struct {
+	Tag       string
+	Parts     []int  // major[, minor[, patch]]
+	Variant   string // Suffix after the version, e.g. "alpine" in 1.25.3-alpine
+	HasPrefix bool   // Tag starts with "v"
+}
+
+// ParseImageVersion parses a semver-like tag
+func ParseImageVersion(tag string) (ImageVersion, bool) {
+	match := versionTagPattern.FindStringSubmatch(tag)
+	if match == nil {
+		return ImageVersion{}, false
+	}
+
+	version := ImageVersion{Tag: tag, Variant: match[4], HasPrefix: strings.HasPrefix(tag, "v")}
+	for _, part := range match[1:4] {
+		if part == "" {
+			break
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return ImageVersion{}, false
+		}
+		version.Parts = append(version.Parts, n)
+	}
+	return version, true
+}
+
+// SameTrack reports whether two versions are comparable: same precision,
+// variant and prefix, so "15.4" is never compared with "15" or "15.5-alpine"
+func (v ImageVersion) SameTrack(other ImageVersion) bool {
+	return len(v.Parts) == len(other.Parts) && v.Variant == other.Variant && v.HasPrefix == other.HasPrefix
+}
+
+// Less reports whether v is an older version than other on the same track
+func (v ImageVersion) Less(other ImageVersion) bool {
+	for i := range v.Parts {
+		if v.Parts[i] != other.Parts[i] {
+			return v.Parts[i] < other.Parts[i]
+		}
+	}
+	return false
+}
+
+// bumpLevel classifies the change from v to a newer version on the same track
+func (v ImageVersion) bumpLevel(newer ImageVersion) string {
+	switch {
+	case v.Parts[0] != newer.Parts[0]:
+		return BumpMajor
+	case len(v.Parts) > 1 && v.Parts[1] != newer.Parts[1]:
+		return BumpMinor
+	default:
+		return BumpPatch
+	}
+}
+
+// tagCandidates picks the newest patch, minor and major versions newer than
+// current, within the allowed bump level. Keys are bump levels.
+func tagCandidates(current ImageVersion, tags []string, allowed string) map[string]ImageVersion {
+	rank := map[string]int{BumpNone: 0, BumpPatch: 1, BumpMinor: 2, BumpMajor: 3}
+
+	candidates := make(map[string]ImageVersion)
+	for _, tag := range tags {
+		version, ok := ParseImageVersion(tag)
+		if !ok || !current.SameTrack(version) || !current.Less(version) {
+			continue
+		}
+		level := current.bumpLevel(version)
+		if rank[level] > rank[allowed] {
+			continue
+		}
+		if best, exists := candidates[level]; !exists || best.Less(version) {
+			candidates[level] = version
+		}
+	}
+	return candidates
+}
+
+// TagBumpKey names a tag bump update after the running image and the bump
+// level, e.g. "postgres:15.4 [patch]", so the update keeps its identity when a
+// newer tag is pushed. The candidate tag is carried in AvailableVersion and
+// the candidate_image metadata, and resolved again at install time.
+func TagBumpKey(imageName, level string) string {
+	return imageName + " [" + level + "]"
+}
+
+// ParseTagBumpKey splits a tag bump package name into the running image and
+// the bump level. ok is false for plain image names.
+func ParseTagBumpKey(packageName string) (imageName, level string, ok bool) {
+	imageName, rest, found := strings.Cut(packageName, " [")
+	if !found || !strings.HasSuffix(rest, "]") {
+		return "", "", false
+	}
+	switch level = strings.TrimSuffix(rest, "]"); level {
+	case BumpPatch, BumpMinor, BumpMajor:
+		return imageName, level, true
+	}
+	return "", "", false
+}
+
+// ResolveTagBump returns the newest image of imageName's repository at the
+// given bump level, e.g. "postgres:15.5" for "postgres:15.4" and patch
+func ResolveTagBump(ctx context.Context, imageName, level string) (string, error) {
+	repository, tag := splitImageTag(imageName)
+	current, ok := ParseImageVersion(tag)
+	if !ok {
+		return "", fmt.Errorf("%s is not pinned to a version tag", imageName)
+	}
+
+	tags, err := NewRegistryClient().ListTags(ctx, repository)
+	if err != nil {
+		return "", fmt.Errorf("failed to list tags for %s: %w", repository, err)
+	}
+
+	candidate, ok := tagCandidates(current, tags, level)[level]
+	if !ok {
+		return "", fmt.Errorf("no newer %s version of %s", level, imageName)
+	}
+	return repository + ":" + candidate.Tag, nil
+}
+
+// IsTagBumpOf reports whether candidateImage is a newer tag of imageName's
+// repository at the given bump level, i.e. an image a tag bump may have moved
+// imageName's containers onto
+func IsTagBumpOf(imageName, level, candidateImage string) bool {
+	repository, tag := splitImageTag(imageName)
+	candidateRepository, candidateTag := splitImageTag(candidateImage)
+	if repository != candidateRepository {
+		return false
+	}
+	current, ok := ParseImageVersion(tag)
+	if !ok {
+		return false
+	}
+	candidate, ok := ParseImageVersion(candidateTag)
+	if !ok || !current.SameTrack(candidate) || !current.Less(candidate) {
+		return false
+	}
+	return current.bumpLevel(candidate) == level
+}
+
+// SetTagTracking sets the per-image bump limits for tag tracking
+func (s *DockerScanner) SetTagTracking(rules []config.DockerTagRule) {
+	s.tagRules = rules
+}
+
+// allowedBump returns the bump level allowed for a repository: the first
+// matching rule's, or major when no rule matches. Rules match either the name
+// as written or its short Docker Hub form, so "postgres" also covers Podman's
+// fully qualified "docker.io/library/postgres".
+func (s *DockerScanner) allowedBump(repository string) string {
+	short := strings.TrimPrefix(strings.TrimPrefix(repository, "docker.io/"), "library/")
+	for _, rule := range s.tagRules {
+		if matched, _ := path.Match(rule.Image, repository); matched {
+			return rule.Bump
+		}
+		if matched, _ := path.Match(rule.Image, short); matched {
+			return rule.Bump
+		}
+	}
+	return BumpMajor
+}
+
+// checkTagUpdates lists an image's tags and reports newer patch, minor and
+// major versions of a version-pinned image as separate updates
+func (s *DockerScanner) checkTagUpdates(ctx context.Context, imageName, repository, tag string, containers []types.Container) []client.UpdateReportItem {
+	current, ok := ParseImageVersion(tag)
+	if !ok {
+		return nil
+	}
+	allowed := s.allowedBump(repository)
+	if allowed == BumpNone {
+		return nil
+	}
+
+	tags, err := s.registryClient.ListTags(ctx, repository)
+	if err != nil {
+		fmt.Printf("Warning: Failed to list tags for %s: %v\n", repository, err)
+		return nil
+	}
+
+	candidates := tagCandidates(current, tags, allowed)
+
+	var updates []client.UpdateReportItem
+	for _, level := range []string{BumpPatch, BumpMinor, BumpMajor} {
+		candidate, ok := candidates[level]
+		if !ok {
+			continue
+		}
+		candidateImage := repository + ":" + candidate.Tag
+
+		update := client.UpdateReportItem{
+			PackageType:        s.packageType,
+			PackageName:        TagBumpKey(imageName, level),
+			PackageDescription: fmt.Sprintf("New %s version for container: %s", level, strings.Join(containerNames(containers), ", ")),
+			CurrentVersion:     tag,
+			AvailableVersion:   candidate.Tag,
+			Severity:           "moderate",
+			RepositorySource:   repository,
+			Metadata: map[string]interface{}{
+				"container_id":    containers[0].ID[:12],
+				"container_names": containers[0].Names,
+				"container_state": containers[0].State,
+				"tag_bump":        level,
+				"current_image":   imageName,
+				"candidate_image": candidateImage,
+			},
+		}
+		addComposeMetadata(update.Metadata, containers)
+
+		updates = append(updates, update)
+	}
+	return updates
+}
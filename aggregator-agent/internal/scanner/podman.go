@@ -0,0 +1,187 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/client"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+	dockerclient "github.com/docker/docker/client"
+)
+
+// Podman API sockets: the rootful service and one per rootless user
+// (enabled with "systemctl enable --now podman.socket", --user for rootless).
+// /run/user/<uid> is private to its user, so rootless sockets are only
+// reachable for users the installer granted the agent access to (see
+// REDFLAG_PODMAN_USERS in install.sh); the others are skipped.
+const (
+	podmanRootfulSocket = "/run/podman/podman.sock"
+	podmanRuntimeDir    = "/run/user"
+)
+
+// PodmanSystemdUnitLabel is set by podman generate systemd and Quadlet on
+// containers run by a systemd unit
+const PodmanSystemdUnitLabel = "PODMAN_SYSTEMD_UNIT"
+
+// PodmanInstance is one Podman service the agent can reach: rootful, or a
+// rootless user's
+type PodmanInstance struct {
+	Socket   string
+	User     string // Owning user for rootless instances, empty for rootful
+	Rootless bool
+}
+
+// String names the instance for logs and metadata, e.g. "rootful" or "rootless:alice"
+func (p PodmanInstance) String() string {
+	if p.Rootless {
+		return "rootless:" + p.User
+	}
+	return "rootful"
+}
+
+// Client connects to the instance's Docker-compatible API
+func (p PodmanInstance) Client() (*dockerclient.Client, error) {
+	return dockerclient.NewClientWithOpts(
+		dockerclient.WithHost("unix://"+p.Socket),
+		dockerclient.WithAPIVersionNegotiation(),
+	)
+}
+
+// PodmanInstances finds the Podman API sockets on this host. The agent needs
+// read/write access to a socket to use it, e.g. via an ACL for the agent user.
+func PodmanInstances() []PodmanInstance {
+	var instances []PodmanInstance
+	if _, err := os.Stat(podmanRootfulSocket); err == nil {
+		instances = append(instances, PodmanInstance{Socket: podmanRootfulSocket})
+	}
+
+	// Stat each socket directly: with only search (x) access to a user's
+	// runtime directory the socket can be reached, but not found by a glob
+	entries, _ := os.ReadDir(podmanRuntimeDir)
+	for _, entry := range entries {
+		uid := entry.Name()
+		socket := rootlessSocket(uid)
+		if _, err := os.Stat(socket); err != nil {
+			continue
+		}
+		name := uid
+		if u, err := user.LookupId(uid); err == nil {
+			name = u.Username
+		}
+		instances = append(instances, PodmanInstance{Socket: socket, User: name, Rootless: true})
+	}
+	return instances
+}
+
+// LookupPodmanInstance returns the instance named like PodmanInstance.String(),
+// e.g. "rootful" or "rootless:alice"
+func LookupPodmanInstance(name string) (PodmanInstance, error) {
+	if name == "rootful" {
+		return PodmanInstance{Socket: podmanRootfulSocket}, nil
+	}
+	username, ok := strings.CutPrefix(name, "rootless:")
+	if !ok || username == "" {
+		return PodmanInstance{}, fmt.Errorf("unknown Podman instance %q", name)
+	}
+	u, err := user.Lookup(username)
+	if err != nil {
+		return PodmanInstance{}, fmt.Errorf("unknown user %q: %w", username, err)
+	}
+	return PodmanInstance{Socket: rootlessSocket(u.Uid), User: u.Username, Rootless: true}, nil
+}
+
+func rootlessSocket(uid string) string {
+	return filepath.Join(podmanRuntimeDir, uid, "podman", "podman.sock")
+}
+
+// PodmanScanner scans containers of every reachable Podman instance for image
+// updates, using the same registry checks as the Docker scanner
+type PodmanScanner struct {
+	registryClient *RegistryClient
+	tagRules       []config.DockerTagRule
+}
+
+// NewPodmanScanner creates a new Podman scanner
+func NewPodmanScanner() *PodmanScanner {
+	return &PodmanScanner{
+		registryClient: NewRegistryClient(),
+	}
+}
+
+// SetTagTracking sets the per-image bump limits for tag tracking
+func (s *PodmanScanner) SetTagTracking(rules []config.DockerTagRule) {
+	s.tagRules = rules
+}
+
+// IsAvailable checks if Podman is installed and has an API socket
+func (s *PodmanScanner) IsAvailable() bool {
+	if _, err := exec.LookPath("podman"); err != nil {
+		return false
+	}
+	return len(PodmanInstances()) > 0
+}
+
+// Scan scans all Podman instances for available image updates. An image used
+// by several instances is reported once, listing every instance.
+func (s *PodmanScanner) Scan() ([]client.UpdateReportItem, error) {
+	var updates []client.UpdateReportItem
+	var failures []string
+	indexByName := make(map[string]int)
+
+	instances := PodmanInstances()
+	for _, instance := range instances {
+		items, err := s.scanInstance(instance)
+		if err != nil {
+			slog.Warn("skipping podman instance", "instance", instance.String(), "socket", instance.Socket, "error", err)
+			failures = append(failures, fmt.Sprintf("%s: %v", instance, err))
+			continue
+		}
+
+		for _, item := range items {
+			if i, seen := indexByName[item.PackageName]; seen {
+				existing := updates[i].Metadata["podman_instances"].([]string)
+				updates[i].Metadata["podman_instances"] = append(existing, instance.String())
+				continue
+			}
+			item.Metadata["podman_instances"] = []string{instance.String()}
+			indexByName[item.PackageName] = len(updates)
+			updates = append(updates, item)
+		}
+	}
+
+	if len(failures) == len(instances) && len(failures) > 0 {
+		return nil, fmt.Errorf("no Podman instance could be scanned: %s", strings.Join(failures, "; "))
+	}
+	return updates, nil
+}
+
+// scanInstance runs the Docker scanner against one instance's compatible API
+func (s *PodmanScanner) scanInstance(instance PodmanInstance) ([]client.UpdateReportItem, error) {
+	cli, err := instance.Client()
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	_, err = cli.Ping(ctx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := &DockerScanner{
+		client:         cli,
+		registryClient: s.registryClient,
+		tagRules:       s.tagRules,
+		packageType:    "podman_image",
+	}
+	return scanner.Scan()
+}
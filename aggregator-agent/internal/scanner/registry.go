@@ -2,19 +2,59 @@ package scanner
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"net/http"
+	neturl "net/url"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/client"
+)
+
+// Manifest media types accepted from registries
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
 )
 
-// RegistryClient handles communication with Docker registries (Docker Hub and custom registries)
+// manifestAccept asks for any single-platform manifest or multi-platform index
+var manifestAccept = strings.Join([]string{
+	MediaTypeDockerManifestList, MediaTypeOCIIndex, MediaTypeDockerManifest, MediaTypeOCIManifest,
+}, ", ")
+
+// RegistryClient handles communication with Docker registries: Docker Hub,
+// GHCR, Quay, Harbor and self-hosted registry:2 instances. Requests start
+// anonymous and follow the registry's WWW-Authenticate challenge (bearer token
+// or basic auth) using credentials from the docker CLI config.
 type RegistryClient struct {
 	httpClient *http.Client
 	cache      *manifestCache
+	tokens     *tokenCache
+	platform   Platform
+}
+
+// Platform selects an image from a multi-platform manifest list or OCI index
+type Platform struct {
+	OS           string `json:"os"`
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// RemoteImage is what a tag currently resolves to in the registry
+type RemoteImage struct {
+	Digest         string // Digest the tag points at: a manifest list/index or a manifest
+	ManifestDigest string // This platform's manifest
+	ConfigDigest   string // This platform's config digest, which is the local image ID once pulled
 }
 
 // manifestCache stores registry responses to avoid hitting rate limits
@@ -24,21 +64,37 @@ type manifestCache struct {
 }
 
 type cacheEntry struct {
-	digest    string
+	image     RemoteImage
 	expiresAt time.Time
 }
 
-// ManifestResponse represents the response from a Docker Registry API v2 manifest request
+// tokenCache keeps Authorization values per registry and scope until they expire
+type tokenCache struct {
+	mu      sync.Mutex
+	entries map[string]tokenEntry
+}
+
+type tokenEntry struct {
+	authorization string
+	expiresAt     time.Time
+}
+
+// ManifestResponse represents a Docker Registry API v2 manifest, manifest list or OCI index
 type ManifestResponse struct {
 	SchemaVersion int    `json:"schemaVersion"`
 	MediaType     string `json:"mediaType"`
 	Config        struct {
 		Digest string `json:"digest"`
 	} `json:"config"`
+	Manifests []struct {
+		MediaType string   `json:"mediaType"`
+		Digest    string   `json:"digest"`
+		Platform  Platform `json:"platform"`
+	} `json:"manifests"`
 }
 
-// DockerHubTokenResponse represents the authentication token response from Docker Hub
-type DockerHubTokenResponse struct {
+// TokenResponse represents a registry token service response
+type TokenResponse struct {
 	Token       string    `json:"token"`
 	AccessToken string    `json:"access_token"`
 	ExpiresIn   int       `json:"expires_in"`
@@ -47,44 +103,124 @@ type DockerHubTokenResponse struct {
 
 // NewRegistryClient creates a new registry client with caching
 func NewRegistryClient() *RegistryClient {
+	platform := Platform{OS: runtime.GOOS, Architecture: runtime.GOARCH}
+	if runtime.GOARCH == "arm" {
+		platform.Variant = "v7"
+	}
+
 	return &RegistryClient{
-		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
-		},
+		// Shares the agent's proxy, TLS and retry settings
+		httpClient: client.HTTPClient(),
 		cache: &manifestCache{
 			entries: make(map[string]*cacheEntry),
 		},
+		tokens: &tokenCache{
+			entries: make(map[string]tokenEntry),
+		},
+		platform: platform,
 	}
 }
 
 // GetRemoteDigest fetches the digest of a remote image from the registry
 // Returns the digest string (e.g., "sha256:abc123...") or an error
 func (c *RegistryClient) GetRemoteDigest(ctx context.Context, imageName, tag string) (string, error) {
+	image, err := c.GetRemoteImage(ctx, imageName, tag)
+	if err != nil {
+		return "", err
+	}
+	return image.Digest, nil
+}
+
+// GetRemoteImage resolves a tag to its digest and, for multi-platform images,
+// to the manifest and config digest for this agent's platform
+func (c *RegistryClient) GetRemoteImage(ctx context.Context, imageName, tag string) (*RemoteImage, error) {
 	// Parse image name to determine registry and repository
 	registry, repository := parseImageName(imageName)
 
 	// Check cache first
 	cacheKey := fmt.Sprintf("%s/%s:%s", registry, repository, tag)
-	if digest := c.cache.get(cacheKey); digest != "" {
-		return digest, nil
+	if image, ok := c.cache.get(cacheKey); ok {
+		return &image, nil
 	}
 
-	// Get authentication token (if needed)
-	token, err := c.getAuthToken(ctx, registry, repository)
+	manifest, digest, err := c.fetchManifest(ctx, registry, repository, tag)
 	if err != nil {
-		return "", fmt.Errorf("failed to get auth token: %w", err)
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
 	}
+	image := RemoteImage{Digest: digest, ManifestDigest: digest, ConfigDigest: manifest.Config.Digest}
 
-	// Fetch manifest from registry
-	digest, err := c.fetchManifestDigest(ctx, registry, repository, tag, token)
-	if err != nil {
-		return "", fmt.Errorf("failed to fetch manifest: %w", err)
+	if isIndex(manifest) {
+		entry, err := c.selectPlatform(manifest)
+		if err != nil {
+			return nil, fmt.Errorf("%s/%s:%s: %w", registry, repository, tag, err)
+		}
+		platformManifest, _, err := c.fetchManifest(ctx, registry, repository, entry)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s manifest: %w", c.platformString(), err)
+		}
+		image.ManifestDigest = entry
+		image.ConfigDigest = platformManifest.Config.Digest
 	}
 
 	// Cache the result (5 minute TTL to avoid hammering registries)
-	c.cache.set(cacheKey, digest, 5*time.Minute)
+	c.cache.set(cacheKey, image, 5*time.Minute)
 
-	return digest, nil
+	return &image, nil
+}
+
+// maxTagPages bounds tag list pagination for repositories with huge tag counts
+const maxTagPages = 50
+
+// ListTags returns every tag of an image repository, following the
+// registry's Link header pagination
+func (c *RegistryClient) ListTags(ctx context.Context, imageName string) ([]string, error) {
+	registry, repository := parseImageName(imageName)
+
+	var tags []string
+	path := "tags/list?n=1000"
+	for page := 0; page < maxTagPages && path != ""; page++ {
+		resp, err := c.get(ctx, registry, repository, path, "application/json")
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags: %w", err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			resp.Body.Close()
+			return nil, fmt.Errorf("tag list request failed with status %d: %s", resp.StatusCode, string(body))
+		}
+
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tag list: %w", err)
+		}
+		tags = append(tags, list.Tags...)
+
+		path = nextTagsPage(resp.Header.Get("Link"))
+	}
+
+	return tags, nil
+}
+
+// nextTagsPage turns a `</v2/<repo>/tags/list?last=x&n=1000>; rel="next"`
+// Link header into the path for the next page, or "" on the last page
+func nextTagsPage(link string) string {
+	if !strings.Contains(link, `rel="next"`) {
+		return ""
+	}
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end <= start {
+		return ""
+	}
+	next, err := neturl.Parse(link[start+1 : end])
+	if err != nil {
+		return ""
+	}
+	return "tags/list?" + next.RawQuery
 }
 
 // parseImageName splits an image name into registry and repository
@@ -92,11 +228,12 @@ func (c *RegistryClient) GetRemoteDigest(ctx context.Context, imageName, tag str
 //   - "nginx" -> ("registry-1.docker.io", "library/nginx")
 //   - "myuser/myimage" -> ("registry-1.docker.io", "myuser/myimage")
 //   - "gcr.io/myproject/myimage" -> ("gcr.io", "myproject/myimage")
+//   - "localhost:5000/myimage" -> ("localhost:5000", "myimage")
 func parseImageName(imageName string) (registry, repository string) {
 	parts := strings.Split(imageName, "/")
 
-	// Check if first part looks like a domain (contains . or :)
-	if len(parts) >= 2 && (strings.Contains(parts[0], ".") || strings.Contains(parts[0], ":")) {
+	// Check if first part looks like a domain (contains . or :) or is localhost
+	if len(parts) >= 2 && (strings.Contains(parts[0], ".") || strings.Contains(parts[0], ":") || parts[0] == "localhost") {
 		// Custom registry: gcr.io/myproject/myimage
 		registry = parts[0]
 		repository = strings.Join(parts[1:], "/")
@@ -110,137 +247,322 @@ func parseImageName(imageName string) (registry, repository string) {
 		repository = imageName
 	}
 
+	// Fully qualified Docker Hub names: docker.io/nginx, docker.io/library/nginx
+	if registry == "docker.io" || registry == "index.docker.io" {
+		registry = "registry-1.docker.io"
+		if !strings.Contains(repository, "/") {
+			repository = "library/" + repository
+		}
+	}
+
 	return registry, repository
 }
 
-// getAuthToken obtains an authentication token for the registry
-// For Docker Hub, uses the token authentication flow
-// For other registries, may need different auth mechanisms (TODO: implement)
-func (c *RegistryClient) getAuthToken(ctx context.Context, registry, repository string) (string, error) {
-	// Docker Hub token authentication
-	if registry == "registry-1.docker.io" {
-		return c.getDockerHubToken(ctx, repository)
+// registryURL returns the base URL for a registry. Like the Docker daemon,
+// registries on the local host are spoken to over plain HTTP.
+func registryURL(registry string) string {
+	host := registry
+	if h, _, err := net.SplitHostPort(registry); err == nil {
+		host = h
 	}
-
-	// For other registries, we'll try unauthenticated first
-	// TODO: Support authentication for private registries (basic auth, bearer tokens, etc.)
-	return "", nil
+	if host == "localhost" {
+		return "http://" + registry
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return "http://" + registry
+	}
+	return "https://" + registry
 }
 
-// getDockerHubToken obtains a token from Docker Hub's authentication service
-func (c *RegistryClient) getDockerHubToken(ctx context.Context, repository string) (string, error) {
-	authURL := fmt.Sprintf(
-		"https://auth.docker.io/token?service=registry.docker.io&scope=repository:%s:pull",
-		repository,
-	)
-
-	req, err := http.NewRequestWithContext(ctx, "GET", authURL, nil)
+// fetchManifest fetches a manifest, manifest list or index by tag or digest
+// and returns it with its digest
+func (c *RegistryClient) fetchManifest(ctx context.Context, registry, repository, reference string) (*ManifestResponse, string, error) {
+	resp, err := c.get(ctx, registry, repository, "manifests/"+reference, manifestAccept)
 	if err != nil {
-		return "", err
+		return nil, "", err
 	}
+	defer resp.Body.Close()
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return "", err
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, "", fmt.Errorf("rate limited by registry (429 Too Many Requests)")
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, "", fmt.Errorf("unauthorized: authentication failed for %s/%s:%s", registry, repository, reference)
 	}
-	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("auth request failed with status %d: %s", resp.StatusCode, string(body))
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, "", fmt.Errorf("manifest request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	var tokenResp DockerHubTokenResponse
-	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
-		return "", fmt.Errorf("failed to decode token response: %w", err)
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read manifest: %w", err)
 	}
 
-	// Docker Hub can return either 'token' or 'access_token'
-	if tokenResp.Token != "" {
-		return tokenResp.Token, nil
+	var manifest ManifestResponse
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, "", fmt.Errorf("failed to decode manifest: %w", err)
+	}
+	if manifest.MediaType == "" {
+		manifest.MediaType = strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
 	}
-	return tokenResp.AccessToken, nil
+
+	// Prefer the registry's Docker-Content-Digest header; otherwise hash the body
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		digest = fmt.Sprintf("sha256:%x", sha256.Sum256(body))
+	}
+
+	if !isIndex(&manifest) && manifest.Config.Digest == "" {
+		return nil, "", fmt.Errorf("manifest does not contain a config digest")
+	}
+
+	return &manifest, digest, nil
 }
 
-// fetchManifestDigest fetches the manifest from the registry and extracts the digest
-func (c *RegistryClient) fetchManifestDigest(ctx context.Context, registry, repository, tag, token string) (string, error) {
-	// Build manifest URL
-	manifestURL := fmt.Sprintf("https://%s/v2/%s/manifests/%s", registry, repository, tag)
+// get performs an authenticated GET against /v2/<repository>/<path>. A cached
+// Authorization is used when available; otherwise the request is retried once
+// after answering the registry's WWW-Authenticate challenge.
+func (c *RegistryClient) get(ctx context.Context, registry, repository, path, accept string) (*http.Response, error) {
+	url := fmt.Sprintf("%s/v2/%s/%s", registryURL(registry), repository, path)
+	scope := fmt.Sprintf("repository:%s:pull", repository)
+	cacheKey := registry + "|" + scope
+
+	resp, err := c.send(ctx, url, accept, c.tokens.get(cacheKey))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", manifestURL, nil)
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+	if challenge == "" {
+		return nil, fmt.Errorf("registry %s returned 401 without an authentication challenge", registry)
+	}
+
+	authorization, ttl, err := c.authorize(ctx, registry, scope, challenge)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	c.tokens.set(cacheKey, authorization, ttl)
+
+	return c.send(ctx, url, accept, authorization)
+}
+
+func (c *RegistryClient) send(ctx context.Context, url, accept, authorization string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
 	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+	return c.httpClient.Do(req)
+}
 
-	// Set required headers
-	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
-	if token != "" {
-		req.Header.Set("Authorization", "Bearer "+token)
+// authorize answers a WWW-Authenticate challenge, returning the Authorization
+// header value to use and how long it stays valid
+func (c *RegistryClient) authorize(ctx context.Context, registry, scope, challenge string) (string, time.Duration, error) {
+	creds, err := lookupCredentials(ctx, registry)
+	if err != nil {
+		// Fall back to anonymous access; public images still work
+		slog.Warn("failed to load registry credentials", "registry", registry, "error", err)
+	}
+
+	scheme, params := parseChallenge(challenge)
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if creds == nil || creds.Username == "" {
+			return "", 0, fmt.Errorf("registry %s requires credentials (docker login %s)", registry, registry)
+		}
+		encoded := base64.StdEncoding.EncodeToString([]byte(creds.Username + ":" + creds.Password))
+		return "Basic " + encoded, time.Hour, nil
+
+	case "bearer":
+		if params["realm"] == "" {
+			return "", 0, fmt.Errorf("registry %s sent a bearer challenge without a realm", registry)
+		}
+		if params["scope"] != "" {
+			scope = params["scope"]
+		}
+		token, ttl, err := c.fetchToken(ctx, params["realm"], params["service"], scope, creds)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to get auth token from %s: %w", params["realm"], err)
+		}
+		return "Bearer " + token, ttl, nil
+
+	default:
+		return "", 0, fmt.Errorf("registry %s uses unsupported authentication scheme %q", registry, scheme)
+	}
+}
+
+// fetchToken obtains a bearer token from a registry's token service. Identity
+// tokens use the OAuth2 refresh flow; otherwise the token is requested with
+// basic auth, or anonymously when there are no credentials.
+func (c *RegistryClient) fetchToken(ctx context.Context, realm, service, scope string, creds *registryCredentials) (string, time.Duration, error) {
+	var req *http.Request
+	var err error
+
+	if creds != nil && creds.IdentityToken != "" {
+		form := neturl.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {creds.IdentityToken},
+			"service":       {service},
+			"scope":         {scope},
+			"client_id":     {"redflag-agent"},
+		}
+		req, err = http.NewRequestWithContext(ctx, "POST", realm, strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", 0, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		tokenURL, err := neturl.Parse(realm)
+		if err != nil {
+			return "", 0, fmt.Errorf("invalid realm: %w", err)
+		}
+		query := tokenURL.Query()
+		if service != "" {
+			query.Set("service", service)
+		}
+		query.Set("scope", scope)
+		tokenURL.RawQuery = query.Encode()
+
+		req, err = http.NewRequestWithContext(ctx, "GET", tokenURL.String(), nil)
+		if err != nil {
+			return "", 0, err
+		}
+		if creds != nil && creds.Username != "" {
+			req.SetBasicAuth(creds.Username, creds.Password)
+		}
 	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode == http.StatusTooManyRequests {
-		return "", fmt.Errorf("rate limited by registry (429 Too Many Requests)")
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", 0, fmt.Errorf("auth request failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	if resp.StatusCode == http.StatusUnauthorized {
-		return "", fmt.Errorf("unauthorized: authentication failed for %s/%s:%s", registry, repository, tag)
+	var tokenResp TokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tokenResp); err != nil {
+		return "", 0, fmt.Errorf("failed to decode token response: %w", err)
 	}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("manifest request failed with status %d: %s", resp.StatusCode, string(body))
+	// Token services return either 'token' or 'access_token'
+	token := tokenResp.Token
+	if token == "" {
+		token = tokenResp.AccessToken
+	}
+	if token == "" {
+		return "", 0, fmt.Errorf("token response contained no token")
 	}
 
-	// Try to get digest from Docker-Content-Digest header first (faster)
-	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
-		return digest, nil
+	// Tokens without an expiry are valid for 60 seconds per the token spec
+	ttl := 60 * time.Second
+	if tokenResp.ExpiresIn > 0 {
+		ttl = time.Duration(tokenResp.ExpiresIn) * time.Second
 	}
+	return token, ttl, nil
+}
 
-	// Fallback: parse manifest and extract config digest
-	var manifest ManifestResponse
-	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
-		return "", fmt.Errorf("failed to decode manifest: %w", err)
+// isIndex reports whether a manifest is a multi-platform manifest list or OCI index
+func isIndex(manifest *ManifestResponse) bool {
+	switch manifest.MediaType {
+	case MediaTypeDockerManifestList, MediaTypeOCIIndex:
+		return true
+	case "":
+		return len(manifest.Manifests) > 0
 	}
+	return false
+}
 
-	if manifest.Config.Digest == "" {
-		return "", fmt.Errorf("manifest does not contain a config digest")
+// selectPlatform picks this agent's platform from a manifest list or index,
+// preferring an exact variant match, and returns its manifest digest
+func (c *RegistryClient) selectPlatform(manifest *ManifestResponse) (string, error) {
+	match := ""
+	for _, entry := range manifest.Manifests {
+		if entry.Platform.OS != c.platform.OS || entry.Platform.Architecture != c.platform.Architecture {
+			continue
+		}
+		if entry.Platform.Variant == c.platform.Variant {
+			return entry.Digest, nil
+		}
+		if match == "" {
+			match = entry.Digest
+		}
+	}
+	if match == "" {
+		return "", fmt.Errorf("no image for platform %s", c.platformString())
+	}
+	return match, nil
+}
+
+func (c *RegistryClient) platformString() string {
+	if c.platform.Variant != "" {
+		return c.platform.OS + "/" + c.platform.Architecture + "/" + c.platform.Variant
+	}
+	return c.platform.OS + "/" + c.platform.Architecture
+}
+
+// tokenCache methods
+
+func (tc *tokenCache) get(key string) string {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	entry, exists := tc.entries[key]
+	if !exists || time.Now().After(entry.expiresAt) {
+		delete(tc.entries, key)
+		return ""
 	}
+	return entry.authorization
+}
 
-	return manifest.Config.Digest, nil
+func (tc *tokenCache) set(key, authorization string, ttl time.Duration) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	// Renew a little early so a token doesn't expire mid-request
+	if ttl > 20*time.Second {
+		ttl -= 10 * time.Second
+	}
+	tc.entries[key] = tokenEntry{authorization: authorization, expiresAt: time.Now().Add(ttl)}
 }
 
 // manifestCache methods
 
-func (mc *manifestCache) get(key string) string {
+func (mc *manifestCache) get(key string) (RemoteImage, bool) {
 	mc.mu.RLock()
 	defer mc.mu.RUnlock()
 
 	entry, exists := mc.entries[key]
 	if !exists {
-		return ""
+		return RemoteImage{}, false
 	}
 
 	if time.Now().After(entry.expiresAt) {
-		// Entry expired
-		delete(mc.entries, key)
-		return ""
+		// Entry expired (removed by cleanupExpired)
+		return RemoteImage{}, false
 	}
 
-	return entry.digest
+	return entry.image, true
 }
 
-func (mc *manifestCache) set(key, digest string, ttl time.Duration) {
+func (mc *manifestCache) set(key string, image RemoteImage, ttl time.Duration) {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 
 	mc.entries[key] = &cacheEntry{
-		digest:    digest,
+		image:     image,
 		expiresAt: time.Now().Add(ttl),
 	}
 }
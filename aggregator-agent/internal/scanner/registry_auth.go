@@ -0,0 +1,197 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// dockerHubConfigKey is the key Docker Hub credentials are stored under in config.json
+const dockerHubConfigKey = "https://index.docker.io/v1/"
+
+// credentialHelperTimeout bounds a docker-credential-* helper invocation
+const credentialHelperTimeout = 10 * time.Second
+
+var credentialHelperPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// registryCredentials are the credentials for one registry
+type registryCredentials struct {
+	Username      string
+	Password      string
+	IdentityToken string // OAuth2 refresh token, exchanged at the token endpoint
+}
+
+// dockerConfigFile is the part of ~/.docker/config.json that holds credentials
+type dockerConfigFile struct {
+	Auths map[string]struct {
+		Auth          string `json:"auth"`
+		Username      string `json:"username"`
+		Password      string `json:"password"`
+		IdentityToken string `json:"identitytoken"`
+	} `json:"auths"`
+	CredsStore  string            `json:"credsStore"`
+	CredHelpers map[string]string `json:"credHelpers"`
+}
+
+// dockerConfigPath returns the docker CLI config the agent's user would use
+func dockerConfigPath() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// lookupCredentials finds credentials for a registry in the docker config,
+// asking the configured credential helper when there is one. It returns nil
+// when the registry has no credentials, so requests stay anonymous.
+func lookupCredentials(ctx context.Context, registry string) (*registryCredentials, error) {
+	path := dockerConfigPath()
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	var config dockerConfigFile
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	keys := credentialKeys(registry)
+
+	// Per-registry helpers win over the default store, which wins over inline auths
+	for _, key := range keys {
+		if helper := config.CredHelpers[key]; helper != "" {
+			return runCredentialHelper(ctx, helper, key)
+		}
+	}
+	if config.CredsStore != "" {
+		creds, err := runCredentialHelper(ctx, config.CredsStore, keys[0])
+		if creds != nil || err != nil {
+			return creds, err
+		}
+	}
+
+	for _, key := range keys {
+		entry, ok := config.Auths[key]
+		if !ok {
+			continue
+		}
+		creds := &registryCredentials{
+			Username:      entry.Username,
+			Password:      entry.Password,
+			IdentityToken: entry.IdentityToken,
+		}
+		if entry.Auth != "" {
+			decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+			if err != nil {
+				return nil, fmt.Errorf("invalid auth entry for %s in %s", key, path)
+			}
+			if user, pass, ok := strings.Cut(string(decoded), ":"); ok {
+				creds.Username, creds.Password = user, pass
+			}
+		}
+		if creds.Username == "" && creds.IdentityToken == "" {
+			continue
+		}
+		return creds, nil
+	}
+	return nil, nil
+}
+
+// credentialKeys lists the config.json keys a registry's credentials may be
+// stored under, most likely first
+func credentialKeys(registry string) []string {
+	switch registry {
+	case "registry-1.docker.io", "docker.io", "index.docker.io":
+		return []string{dockerHubConfigKey, "index.docker.io", "docker.io", "registry-1.docker.io"}
+	}
+	return []string{registry, "https://" + registry, "http://" + registry}
+}
+
+// runCredentialHelper asks docker-credential-<helper> for a registry's credentials
+func runCredentialHelper(ctx context.Context, helper, serverURL string) (*registryCredentials, error) {
+	if !credentialHelperPattern.MatchString(helper) {
+		return nil, fmt.Errorf("invalid credential helper name %q", helper)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, credentialHelperTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		// Helpers report a missing entry on stdout/stderr and exit non-zero
+		if strings.Contains(string(output)+stderr.String(), "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("credential helper %s failed: %w", helper, err)
+	}
+
+	var response struct {
+		Username string `json:"Username"`
+		Secret   string `json:"Secret"`
+	}
+	if err := json.Unmarshal(output, &response); err != nil {
+		return nil, fmt.Errorf("credential helper %s returned invalid output: %w", helper, err)
+	}
+	if response.Username == "<token>" {
+		return &registryCredentials{IdentityToken: response.Secret}, nil
+	}
+	return &registryCredentials{Username: response.Username, Password: response.Secret}, nil
+}
+
+// parseChallenge parses a WWW-Authenticate header such as
+// `Bearer realm="https://auth.example.com/token",service="registry",scope="repository:app:pull"`
+func parseChallenge(header string) (scheme string, params map[string]string) {
+	params = make(map[string]string)
+	scheme, rest, _ := strings.Cut(strings.TrimSpace(header), " ")
+
+	for rest = strings.TrimSpace(rest); rest != ""; rest = strings.TrimSpace(rest) {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			break
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, `"`) {
+			// Quoted value; may contain commas
+			end := 1
+			for end < len(value) && value[end] != '"' {
+				if value[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			params[key] = strings.ReplaceAll(value[1:min(end, len(value))], `\"`, `"`)
+			rest = strings.TrimPrefix(value[min(end+1, len(value)):], ",")
+		} else {
+			raw, remainder, _ := strings.Cut(value, ",")
+			params[key] = strings.TrimSpace(raw)
+			rest = remainder
+		}
+	}
+	return scheme, params
+}
@@ -0,0 +1,355 @@
+package scanner
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// fakeRegistry is a registry:2 lookalike serving one repository, team/app,
+// behind no auth, a Basic challenge or a Bearer token service
+type fakeRegistry struct {
+	*httptest.Server
+	scheme        string // "", "basic" or "bearer"
+	user          string // Required credentials; empty allows anonymous tokens
+	password      string
+	manifests     map[string]fakeManifest
+	tags          []string
+	tokenRequests atomic.Int32
+	tokenAuth     atomic.Value // Authorization sent to the token service
+}
+
+type fakeManifest struct {
+	mediaType string
+	body      string
+}
+
+const fakeToken = "fake-token"
+
+func newFakeRegistry(t *testing.T, scheme, user, password string) *fakeRegistry {
+	t.Helper()
+	r := &fakeRegistry{scheme: scheme, user: user, password: password, manifests: map[string]fakeManifest{}}
+	r.tokenAuth.Store("")
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// image returns the repository's name as the agent sees it
+func (r *fakeRegistry) image() string {
+	return strings.TrimPrefix(r.URL, "http://") + "/team/app"
+}
+
+func (r *fakeRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+func (r *fakeRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	if !r.authorized(req) {
+		switch r.scheme {
+		case "basic":
+			w.Header().Set("WWW-Authenticate", `Basic realm="fake"`)
+		case "bearer":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="fake-registry",scope="repository:team/app:pull"`, r.URL))
+		}
+		http.Error(w, `{"errors":[{"code":"UNAUTHORIZED"}]}`, http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case req.URL.Path == "/v2/team/app/tags/list":
+		json.NewEncoder(w).Encode(map[string]interface{}{"name": "team/app", "tags": r.tags})
+	case strings.HasPrefix(req.URL.Path, "/v2/team/app/manifests/"):
+		manifest, ok := r.manifests[strings.TrimPrefix(req.URL.Path, "/v2/team/app/manifests/")]
+		if !ok {
+			http.NotFound(w, req)
+			return
+		}
+		w.Header().Set("Content-Type", manifest.mediaType)
+		w.Write([]byte(manifest.body))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+func (r *fakeRegistry) authorized(req *http.Request) bool {
+	got := req.Header.Get("Authorization")
+	switch r.scheme {
+	case "basic":
+		return got == "Basic "+base64.StdEncoding.EncodeToString([]byte(r.user+":"+r.password))
+	case "bearer":
+		return got == "Bearer "+fakeToken
+	}
+	return true
+}
+
+func (r *fakeRegistry) serveToken(w http.ResponseWriter, req *http.Request) {
+	r.tokenRequests.Add(1)
+	r.tokenAuth.Store(req.Header.Get("Authorization"))
+	if req.URL.Query().Get("service") != "fake-registry" || req.URL.Query().Get("scope") != "repository:team/app:pull" {
+		http.Error(w, "bad service or scope", http.StatusBadRequest)
+		return
+	}
+	if r.user != "" {
+		user, password, ok := req.BasicAuth()
+		if !ok || user != r.user || password != r.password {
+			http.Error(w, "bad credentials", http.StatusUnauthorized)
+			return
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"token": fakeToken, "expires_in": 300})
+}
+
+// writeDockerConfig points the docker CLI config at a temporary config.json
+func writeDockerConfig(t *testing.T, config string) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Setenv("DOCKER_CONFIG", dir)
+	if config == "" {
+		return
+	}
+	if err := os.WriteFile(filepath.Join(dir, "config.json"), []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// installCredentialHelper puts a docker-credential-<name> on PATH that
+// answers for registry with the given credentials
+func installCredentialHelper(t *testing.T, name, registry, user, secret string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("credential helper stubs are shell scripts")
+	}
+	dir := t.TempDir()
+	script := fmt.Sprintf(`#!/bin/sh
+read server
+if [ "$server" = %q ]; then
+	printf '{"ServerURL":"%%s","Username":%q,"Secret":%q}' "$server"
+else
+	echo "credentials not found in native keychain"
+	exit 1
+fi
+`, registry, user, secret)
+	if err := os.WriteFile(filepath.Join(dir, "docker-credential-"+name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func inlineAuth(registry, user, password string) string {
+	auth := base64.StdEncoding.EncodeToString([]byte(user + ":" + password))
+	return fmt.Sprintf(`{"auths":{%q:{"auth":%q}}}`, registry, auth)
+}
+
+func TestRegistryBearerChallenge(t *testing.T) {
+	registry := newFakeRegistry(t, "bearer", "alice", "s3cret")
+	registry.tags = []string{"1.0", "1.1"}
+	writeDockerConfig(t, inlineAuth(registry.host(), "alice", "s3cret"))
+
+	c := NewRegistryClient()
+	tags, err := c.ListTags(context.Background(), registry.image())
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	if strings.Join(tags, ",") != "1.0,1.1" {
+		t.Fatalf("tags = %v", tags)
+	}
+	if got := registry.tokenAuth.Load().(string); !strings.HasPrefix(got, "Basic ") {
+		t.Errorf("token service got Authorization %q, want the docker config credentials", got)
+	}
+
+	// The token is cached for the repository
+	if _, err := c.ListTags(context.Background(), registry.image()); err != nil {
+		t.Fatalf("second ListTags: %v", err)
+	}
+	if n := registry.tokenRequests.Load(); n != 1 {
+		t.Errorf("token service called %d times, want 1", n)
+	}
+}
+
+func TestRegistryBasicChallenge(t *testing.T) {
+	registry := newFakeRegistry(t, "basic", "bob", "hunter2")
+	registry.tags = []string{"2.0"}
+	writeDockerConfig(t, inlineAuth("http://"+registry.host(), "bob", "hunter2"))
+
+	tags, err := NewRegistryClient().ListTags(context.Background(), registry.image())
+	if err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+	if len(tags) != 1 || tags[0] != "2.0" {
+		t.Fatalf("tags = %v", tags)
+	}
+}
+
+func TestRegistryBasicChallengeWithoutCredentials(t *testing.T) {
+	registry := newFakeRegistry(t, "basic", "bob", "hunter2")
+	writeDockerConfig(t, "")
+
+	_, err := NewRegistryClient().ListTags(context.Background(), registry.image())
+	if err == nil || !strings.Contains(err.Error(), "requires credentials") {
+		t.Fatalf("ListTags error = %v, want a missing credentials error", err)
+	}
+}
+
+func TestRegistryAnonymousFallback(t *testing.T) {
+	for name, config := range map[string]string{
+		"no config":      "",
+		"other registry": inlineAuth("registry.example.com", "carol", "pw"),
+		"broken config":  "{not json",
+	} {
+		t.Run(name, func(t *testing.T) {
+			registry := newFakeRegistry(t, "bearer", "", "")
+			registry.tags = []string{"latest"}
+			writeDockerConfig(t, config)
+
+			if _, err := NewRegistryClient().ListTags(context.Background(), registry.image()); err != nil {
+				t.Fatalf("ListTags: %v", err)
+			}
+			if got := registry.tokenAuth.Load().(string); got != "" {
+				t.Errorf("anonymous token request sent Authorization %q", got)
+			}
+		})
+	}
+}
+
+func TestRegistryCredsStore(t *testing.T) {
+	registry := newFakeRegistry(t, "basic", "dave", "from-store")
+	registry.tags = []string{"3.0"}
+	installCredentialHelper(t, "teststore", registry.host(), "dave", "from-store")
+	writeDockerConfig(t, `{"credsStore":"teststore"}`)
+
+	if _, err := NewRegistryClient().ListTags(context.Background(), registry.image()); err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+}
+
+func TestRegistryCredHelpersWinOverCredsStore(t *testing.T) {
+	registry := newFakeRegistry(t, "bearer", "erin", "from-helper")
+	registry.tags = []string{"4.0"}
+	installCredentialHelper(t, "testhelper", registry.host(), "erin", "from-helper")
+	installCredentialHelper(t, "teststore", registry.host(), "erin", "wrong")
+	writeDockerConfig(t, fmt.Sprintf(`{"credsStore":"teststore","credHelpers":{%q:"testhelper"}}`, registry.host()))
+
+	if _, err := NewRegistryClient().ListTags(context.Background(), registry.image()); err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+}
+
+func TestRegistryCredsStoreMissingEntryFallsBackToAuths(t *testing.T) {
+	registry := newFakeRegistry(t, "basic", "frank", "inline")
+	registry.tags = []string{"5.0"}
+	installCredentialHelper(t, "teststore", "registry.example.com", "nobody", "nothing")
+	auth := base64.StdEncoding.EncodeToString([]byte("frank:inline"))
+	writeDockerConfig(t, fmt.Sprintf(`{"credsStore":"teststore","auths":{%q:{"auth":%q}}}`, registry.host(), auth))
+
+	if _, err := NewRegistryClient().ListTags(context.Background(), registry.image()); err != nil {
+		t.Fatalf("ListTags: %v", err)
+	}
+}
+
+// indexBody builds a manifest list or OCI index with one entry per platform
+func indexBody(mediaType string, entries map[string]Platform) string {
+	var manifests []map[string]interface{}
+	for digest, platform := range entries {
+		manifests = append(manifests, map[string]interface{}{
+			"mediaType": MediaTypeOCIManifest,
+			"digest":    digest,
+			"platform":  platform,
+		})
+	}
+	data, _ := json.Marshal(map[string]interface{}{"schemaVersion": 2, "mediaType": mediaType, "manifests": manifests})
+	return string(data)
+}
+
+func manifestBody(configDigest string) string {
+	return fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"config":{"digest":%q}}`, MediaTypeOCIManifest, configDigest)
+}
+
+func TestRegistryPlatformSelection(t *testing.T) {
+	entries := map[string]Platform{
+		"sha256:amd64":   {OS: "linux", Architecture: "amd64"},
+		"sha256:arm64":   {OS: "linux", Architecture: "arm64"},
+		"sha256:armv6":   {OS: "linux", Architecture: "arm", Variant: "v6"},
+		"sha256:armv7":   {OS: "linux", Architecture: "arm", Variant: "v7"},
+		"sha256:windows": {OS: "windows", Architecture: "amd64"},
+	}
+
+	for _, mediaType := range []string{MediaTypeDockerManifestList, MediaTypeOCIIndex} {
+		for _, tc := range []struct {
+			platform Platform
+			manifest string
+		}{
+			{Platform{OS: "linux", Architecture: "amd64"}, "sha256:amd64"},
+			{Platform{OS: "linux", Architecture: "arm64"}, "sha256:arm64"},
+			{Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, "sha256:armv7"},
+			{Platform{OS: "windows", Architecture: "amd64"}, "sha256:windows"},
+		} {
+			t.Run(mediaType+"/"+tc.manifest, func(t *testing.T) {
+				registry := newFakeRegistry(t, "", "", "")
+				writeDockerConfig(t, "")
+				registry.manifests["1.0"] = fakeManifest{mediaType, indexBody(mediaType, entries)}
+				for digest := range entries {
+					registry.manifests[digest] = fakeManifest{MediaTypeOCIManifest, manifestBody("sha256:config-" + strings.TrimPrefix(digest, "sha256:"))}
+				}
+
+				c := NewRegistryClient()
+				c.platform = tc.platform
+				image, err := c.GetRemoteImage(context.Background(), registry.image(), "1.0")
+				if err != nil {
+					t.Fatalf("GetRemoteImage: %v", err)
+				}
+				if image.ManifestDigest != tc.manifest {
+					t.Errorf("manifest digest = %s, want %s", image.ManifestDigest, tc.manifest)
+				}
+				if want := "sha256:config-" + strings.TrimPrefix(tc.manifest, "sha256:"); image.ConfigDigest != want {
+					t.Errorf("config digest = %s, want %s", image.ConfigDigest, want)
+				}
+				if image.Digest == image.ManifestDigest {
+					t.Errorf("tag digest should be the index's, not the platform manifest's")
+				}
+			})
+		}
+	}
+}
+
+func TestRegistryPlatformSelectionNoMatch(t *testing.T) {
+	registry := newFakeRegistry(t, "", "", "")
+	writeDockerConfig(t, "")
+	registry.manifests["1.0"] = fakeManifest{MediaTypeOCIIndex, indexBody(MediaTypeOCIIndex, map[string]Platform{
+		"sha256:amd64": {OS: "linux", Architecture: "amd64"},
+	})}
+
+	c := NewRegistryClient()
+	c.platform = Platform{OS: "linux", Architecture: "s390x"}
+	_, err := c.GetRemoteImage(context.Background(), registry.image(), "1.0")
+	if err == nil || !strings.Contains(err.Error(), "no image for platform linux/s390x") {
+		t.Fatalf("GetRemoteImage error = %v, want no image for platform", err)
+	}
+}
+
+func TestRegistrySinglePlatformManifest(t *testing.T) {
+	registry := newFakeRegistry(t, "", "", "")
+	writeDockerConfig(t, "")
+	registry.manifests["1.0"] = fakeManifest{MediaTypeDockerManifest, manifestBody("sha256:config")}
+
+	image, err := NewRegistryClient().GetRemoteImage(context.Background(), registry.image(), "1.0")
+	if err != nil {
+		t.Fatalf("GetRemoteImage: %v", err)
+	}
+	if image.ConfigDigest != "sha256:config" || image.ManifestDigest != image.Digest {
+		t.Fatalf("image = %+v", image)
+	}
+}
@@ -0,0 +1,29 @@
+//go:build !windows
+
+package selfupdate
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// checkRootOwned fails unless path is owned by root and not writable by group
+// or others
+func checkRootOwned(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("cannot determine owner of %s", path)
+	}
+	if stat.Uid != 0 {
+		return fmt.Errorf("%s is owned by uid %d, not root", path, stat.Uid)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		return fmt.Errorf("%s is writable by group or others (mode %s)", path, info.Mode().Perm())
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package selfupdate
+
+// checkRootOwned is never reached on Windows, where self-update is unsupported
+func checkRootOwned(path string) error {
+	return ErrUnsupported
+}
@@ -0,0 +1,609 @@
+// Package selfupdate replaces the agent binary with a signed release and
+// rolls back automatically if the new binary never checks in.
+//
+// The flow spans three processes:
+//
+//  1. The running agent (unprivileged) downloads and verifies the release into
+//     the staging directory, records a state file and asks systemd to start
+//     redflag-agent-update.service.
+//  2. That unit runs "redflag-agent -apply-update" as root: it re-verifies the
+//     staged binary, swaps it in atomically, restarts the agent and waits for
+//     a confirmation. Without one it restores the previous binary.
+//  3. The restarted agent confirms once it has checked in successfully and
+//     reports the outcome for the original command.
+//
+// A release is trusted only if it is signed by the key in KeyFile (or the key
+// compiled in as BuiltinPublicKey). Signatures cover the binary and a release
+// statement binding its checksum to a version and platform, and only versions
+// newer than the running agent are accepted, so an old signed binary can't be
+// replayed as a downgrade.
+package selfupdate
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Update states recorded in the state file
+const (
+	StatusStaged     = "staged"      // Verified and waiting for the apply unit
+	StatusApplied    = "applied"     // New binary installed, waiting for confirmation
+	StatusRolledBack = "rolled_back" // New binary never confirmed, previous binary restored
+	StatusFailed     = "failed"      // Apply failed before the binary was replaced
+)
+
+const (
+	// UpdateUnit is the root systemd unit that runs -apply-update
+	UpdateUnit = "redflag-agent-update.service"
+	// AgentUnit is the agent's own systemd unit
+	AgentUnit = "redflag-agent.service"
+
+	// MaxBinarySize caps how large a downloaded agent binary may be
+	MaxBinarySize = 200 * 1024 * 1024
+
+	// MinRollbackWindow is the shortest time a new binary gets to check in
+	MinRollbackWindow = 5 * time.Minute
+
+	stateFile   = "state.json"
+	confirmFile = "confirmed"
+	stagedFile  = "redflag-agent.staged"
+
+	// A staged update the apply unit never picked up is abandoned after this
+	staleStageAge = time.Hour
+
+	// KeyFile holds the base64 Ed25519 key releases must be signed with. It
+	// lives outside the agent's writable config and must be owned by root.
+	KeyFile = "/etc/redflag-agent/update.pub"
+)
+
+// BuiltinPublicKey, when set at build time with
+//
+//	-ldflags "-X github.com/Fimeg/RedFlag/aggregator-agent/internal/selfupdate.BuiltinPublicKey=<base64 key>"
+//
+// is trusted instead of KeyFile
+var BuiltinPublicKey string
+
+// ErrUnsupported is returned on platforms without self-update support
+var ErrUnsupported = errors.New("agent self-update is only supported on Linux with systemd")
+
+// State tracks an in-flight update across the agent restart
+type State struct {
+	CommandID        string     `json:"command_id"`
+	FromVersion      string     `json:"from_version"`
+	ToVersion        string     `json:"to_version"`
+	SHA256           string     `json:"sha256"`
+	Signature        string     `json:"signature"`
+	ReleaseSignature string     `json:"release_signature"`
+	Status           string     `json:"status"`
+	Error            string     `json:"error,omitempty"`
+	RollbackWindow   int        `json:"rollback_window_seconds"`
+	BinaryPath       string     `json:"binary_path,omitempty"`
+	BackupPath       string     `json:"backup_path,omitempty"`
+	StagedAt         time.Time  `json:"staged_at"`
+	AppliedAt        *time.Time `json:"applied_at,omitempty"`
+}
+
+// Result is the outcome of an update, reported by the restarted agent
+type Result struct {
+	CommandID   string
+	FromVersion string
+	ToVersion   string
+	Success     bool
+	Message     string
+}
+
+// DefaultDir returns the staging directory, inside the agent's writable home
+func DefaultDir() string {
+	return "/var/lib/redflag-agent/update"
+}
+
+// RollbackWindow returns how long a new binary has to check in before it is
+// rolled back: twice the check-in interval, but never less than five minutes
+func RollbackWindow(checkInInterval time.Duration) time.Duration {
+	window := 2 * checkInInterval
+	if window < MinRollbackWindow {
+		window = MinRollbackWindow
+	}
+	return window
+}
+
+// Platform returns the release platform this binary was built for, e.g. linux-amd64
+func Platform() string {
+	return runtime.GOOS + "-" + runtime.GOARCH
+}
+
+// TrustedKey returns the key releases must be signed with: the compiled-in
+// key if there is one, otherwise the contents of KeyFile. The file is only
+// trusted when root owns it and nobody else can write to it.
+func TrustedKey() (string, error) {
+	if BuiltinPublicKey != "" {
+		return BuiltinPublicKey, nil
+	}
+
+	f, err := os.Open(KeyFile)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("no update public key installed at %s", KeyFile)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to open update public key: %w", err)
+	}
+	defer f.Close()
+
+	for _, path := range []string{filepath.Dir(KeyFile), KeyFile} {
+		if err := checkRootOwned(path); err != nil {
+			return "", fmt.Errorf("untrusted update public key: %w", err)
+		}
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, 1024))
+	if err != nil {
+		return "", fmt.Errorf("failed to read update public key: %w", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// InstallTrustedKey writes publicKey to KeyFile. Only root can do this - the
+// agent itself must never be able to change which key it trusts.
+func InstallTrustedKey(publicKey string) error {
+	if _, err := decodeKey(publicKey); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(KeyFile), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(KeyFile), err)
+	}
+	if err := writeFileAtomic(KeyFile, []byte(strings.TrimSpace(publicKey)+"\n")); err != nil {
+		return fmt.Errorf("failed to write update public key: %w", err)
+	}
+	return os.Chmod(KeyFile, 0644)
+}
+
+// ReleaseStatement is the message a release signature covers. It must match
+// the server's signing.ReleaseStatement byte for byte.
+func ReleaseStatement(version, platform, sha256 string) []byte {
+	return []byte(fmt.Sprintf("redflag-agent-release\nversion=%s\nplatform=%s\nsha256=%s\n",
+		strings.TrimSpace(version), strings.TrimSpace(platform), strings.ToLower(strings.TrimSpace(sha256))))
+}
+
+// verifyRelease checks a binary against the state's checksum, its signature
+// over the binary and its release signature over (version, platform, sha256),
+// and that the version is newer than currentVersion
+func verifyRelease(data []byte, st *State, currentVersion, publicKey string) error {
+	if !IsNewerVersion(st.ToVersion, currentVersion) {
+		return fmt.Errorf("version %s is not newer than the running agent (%s)", st.ToVersion, currentVersion)
+	}
+
+	key, err := decodeKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(data)
+	if actual := hex.EncodeToString(sum[:]); !strings.EqualFold(actual, st.SHA256) {
+		return fmt.Errorf("checksum mismatch: expected %s, got %s", st.SHA256, actual)
+	}
+	if !verifySignature(key, data, st.Signature) {
+		return errors.New("binary signature verification failed")
+	}
+	// The platform comes from this binary, not the command, so a release
+	// signed for another platform never verifies
+	if !verifySignature(key, ReleaseStatement(st.ToVersion, Platform(), st.SHA256), st.ReleaseSignature) {
+		return fmt.Errorf("release signature does not cover version %s for %s", st.ToVersion, Platform())
+	}
+	return nil
+}
+
+func decodeKey(publicKey string) (ed25519.PublicKey, error) {
+	if strings.TrimSpace(publicKey) == "" {
+		return nil, errors.New("no update public key configured")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid update public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+func verifySignature(key ed25519.PublicKey, data []byte, signature string) bool {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(key, data, sig)
+}
+
+// IsNewerVersion reports whether version is newer than current, comparing
+// dotted major.minor.patch numbers like the server does
+func IsNewerVersion(version, current string) bool {
+	v, c := parseVersion(version), parseVersion(current)
+	for i := range v {
+		if v[i] != c[i] {
+			return v[i] > c[i]
+		}
+	}
+	return false
+}
+
+func parseVersion(version string) [3]int {
+	var result [3]int
+	parts := strings.Split(strings.TrimPrefix(strings.TrimSpace(version), "v"), ".")
+	for i := 0; i < len(parts) && i < 3; i++ {
+		if num, err := strconv.Atoi(parts[i]); err == nil {
+			result[i] = num
+		}
+	}
+	return result
+}
+
+// Stage downloads a release into dir, verifies it and records it for the apply
+// unit. st.FromVersion must be the running agent's version. download writes
+// the binary to the given writer.
+func Stage(dir string, st *State, download func(w io.Writer) error) error {
+	if runtime.GOOS != "linux" {
+		return ErrUnsupported
+	}
+	publicKey, err := TrustedKey()
+	if err != nil {
+		return fmt.Errorf("refusing to update: %w", err)
+	}
+	if !IsNewerVersion(st.ToVersion, st.FromVersion) {
+		return fmt.Errorf("refusing to update: version %s is not newer than the running agent (%s)", st.ToVersion, st.FromVersion)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create staging directory: %w", err)
+	}
+	// Start clean - a leftover confirmation must not vouch for this update
+	os.Remove(filepath.Join(dir, confirmFile))
+
+	staged := filepath.Join(dir, stagedFile)
+	f, err := os.OpenFile(staged, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create staged binary: %w", err)
+	}
+	if err := download(f); err != nil {
+		f.Close()
+		os.Remove(staged)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(staged)
+		return fmt.Errorf("failed to write staged binary: %w", err)
+	}
+
+	data, err := os.ReadFile(staged)
+	if err == nil {
+		err = verifyRelease(data, st, st.FromVersion, publicKey)
+	}
+	if err != nil {
+		os.Remove(staged)
+		return fmt.Errorf("refusing to update: %w", err)
+	}
+
+	st.Status = StatusStaged
+	st.StagedAt = time.Now().UTC()
+	if err := saveState(dir, st); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	return nil
+}
+
+// TriggerApply asks systemd to run the root apply unit. --no-block returns
+// immediately: the unit restarts this agent, so nothing here waits for it.
+func TriggerApply() error {
+	args := []string{"/usr/bin/systemctl", "start", "--no-block", UpdateUnit}
+	if os.Geteuid() != 0 {
+		args = append([]string{"sudo", "-n"}, args...)
+	}
+	output, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w - %s", UpdateUnit, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// Discard removes a staged update and its state
+func Discard(dir string) {
+	os.Remove(filepath.Join(dir, stagedFile))
+	os.Remove(filepath.Join(dir, stateFile))
+	os.Remove(filepath.Join(dir, confirmFile))
+}
+
+// Apply installs the staged binary over the running executable, restarts the
+// agent and waits for it to confirm. Runs as root from the update unit;
+// currentVersion is the version of the installed binary doing the apply.
+func Apply(dir, currentVersion string) error {
+	st, err := loadState(dir)
+	if err != nil {
+		return err
+	}
+	if st.Status != StatusStaged {
+		return fmt.Errorf("no staged update (status %q)", st.Status)
+	}
+
+	fail := func(err error) error {
+		st.Status = StatusFailed
+		st.Error = err.Error()
+		saveState(dir, st)
+		os.Remove(filepath.Join(dir, stagedFile))
+		return err
+	}
+
+	// The staging directory and the agent's config are writable by the agent
+	// user, so never trust what the agent verified or the key it was given -
+	// read the binary once and verify those exact bytes as root, against the
+	// root-owned key and this binary's own version and platform
+	publicKey, err := TrustedKey()
+	if err != nil {
+		return fail(fmt.Errorf("staged binary rejected: %w", err))
+	}
+	staged := filepath.Join(dir, stagedFile)
+	data, err := os.ReadFile(staged)
+	if err != nil {
+		return fail(fmt.Errorf("failed to read staged binary: %w", err))
+	}
+	if err := verifyRelease(data, st, currentVersion, publicKey); err != nil {
+		return fail(fmt.Errorf("staged binary rejected: %w", err))
+	}
+
+	binary, err := os.Executable()
+	if err != nil {
+		return fail(fmt.Errorf("failed to locate agent binary: %w", err))
+	}
+	if resolved, err := filepath.EvalSymlinks(binary); err == nil {
+		binary = resolved
+	}
+	backup := binary + ".bak"
+
+	if err := copyFile(binary, backup); err != nil {
+		return fail(fmt.Errorf("failed to back up current binary: %w", err))
+	}
+	if err := writeFileAtomic(binary, data); err != nil {
+		return fail(fmt.Errorf("failed to install new binary: %w", err))
+	}
+
+	now := time.Now().UTC()
+	st.Status = StatusApplied
+	st.BinaryPath = binary
+	st.BackupPath = backup
+	st.AppliedAt = &now
+	if err := saveState(dir, st); err != nil {
+		return rollback(dir, st, err)
+	}
+
+	if err := restartAgent(); err != nil {
+		return rollback(dir, st, err)
+	}
+
+	window := time.Duration(st.RollbackWindow) * time.Second
+	if window < MinRollbackWindow {
+		window = MinRollbackWindow
+	}
+	deadline := time.Now().Add(window)
+	for time.Now().Before(deadline) {
+		if confirmed(dir, st.SHA256) {
+			os.Remove(staged)
+			os.Remove(filepath.Join(dir, stateFile))
+			os.Remove(filepath.Join(dir, confirmFile))
+			return nil
+		}
+		time.Sleep(5 * time.Second)
+	}
+
+	return rollback(dir, st, fmt.Errorf("agent %s did not check in within %s", st.ToVersion, window))
+}
+
+// CheckIn is called by the agent after each successful check-in. It confirms
+// an applied update when this process is the new binary, and returns the
+// result to report for the update command (nil when there is nothing to report).
+func CheckIn(dir string) *Result {
+	st, err := loadState(dir)
+	if err != nil {
+		return nil
+	}
+
+	result := &Result{CommandID: st.CommandID, FromVersion: st.FromVersion, ToVersion: st.ToVersion}
+
+	switch st.Status {
+	case StatusApplied:
+		if _, err := os.Stat(filepath.Join(dir, confirmFile)); err == nil {
+			return nil // Already confirmed, waiting for the apply unit to clean up
+		}
+		if sum, err := executableSHA256(); err != nil || !strings.EqualFold(sum, st.SHA256) {
+			return nil // Still the old binary, the restart hasn't happened yet
+		}
+		if err := os.WriteFile(filepath.Join(dir, confirmFile), []byte(st.SHA256), 0644); err != nil {
+			return nil
+		}
+		result.Success = true
+		result.Message = fmt.Sprintf("Agent updated from %s to %s", st.FromVersion, st.ToVersion)
+		return result
+
+	case StatusRolledBack, StatusFailed:
+		Discard(dir)
+		result.Message = fmt.Sprintf("Agent update to %s failed: %s", st.ToVersion, st.Error)
+		if st.Status == StatusRolledBack {
+			result.Message = fmt.Sprintf("Agent update to %s rolled back to %s: %s", st.ToVersion, st.FromVersion, st.Error)
+		}
+		return result
+
+	case StatusStaged:
+		if time.Since(st.StagedAt) < staleStageAge {
+			return nil
+		}
+		Discard(dir)
+		result.Message = fmt.Sprintf("Agent update to %s was staged but never applied - is %s installed?", st.ToVersion, UpdateUnit)
+		return result
+	}
+
+	return nil
+}
+
+func rollback(dir string, st *State, cause error) error {
+	st.Error = cause.Error()
+	if err := replaceFile(st.BackupPath, st.BinaryPath); err != nil {
+		st.Status = StatusFailed
+		st.Error = fmt.Sprintf("%v; restoring previous binary also failed: %v", cause, err)
+		saveState(dir, st)
+		return errors.New(st.Error)
+	}
+	st.Status = StatusRolledBack
+	saveState(dir, st)
+	os.Remove(filepath.Join(dir, stagedFile))
+	os.Remove(filepath.Join(dir, confirmFile))
+
+	if err := restartAgent(); err != nil {
+		return fmt.Errorf("%v; restart after rollback failed: %w", cause, err)
+	}
+	return fmt.Errorf("rolled back: %w", cause)
+}
+
+func restartAgent() error {
+	output, err := exec.Command("/usr/bin/systemctl", "restart", AgentUnit).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to restart %s: %w - %s", AgentUnit, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+func confirmed(dir, sha string) bool {
+	data, err := os.ReadFile(filepath.Join(dir, confirmFile))
+	return err == nil && strings.EqualFold(strings.TrimSpace(string(data)), sha)
+}
+
+func executableSHA256() (string, error) {
+	path, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// replaceFile copies src next to dst and renames it into place, so dst is
+// never left partially written
+func replaceFile(src, dst string) error {
+	tmp := dst + ".new"
+	if err := copyFile(src, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic writes data next to dst and renames it into place
+func writeFileAtomic(dst string, data []byte) error {
+	tmp := dst + ".new"
+	os.Remove(tmp)
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0755)
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0755)
+	}
+	if err == nil {
+		err = os.Rename(tmp, dst)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, 0755)
+}
+
+func loadState(dir string) (*State, error) {
+	data, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if err != nil {
+		return nil, err
+	}
+	var st State
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("failed to parse update state: %w", err)
+	}
+	return &st, nil
+}
+
+func saveState(dir string, st *State) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	// The directory belongs to the agent user: create the temp file exclusively
+	// so root never writes through a planted symlink
+	tmp := filepath.Join(dir, stateFile+".tmp")
+	os.Remove(tmp)
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write update state: %w", err)
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write update state: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, stateFile)); err != nil {
+		return fmt.Errorf("failed to write update state: %w", err)
+	}
+	return nil
+}
@@ -4,19 +4,25 @@ package service
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"math/rand"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/client"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/health"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/installer"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/logging"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/mtls"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/scanner"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/selfupdate"
 	"github.com/Fimeg/RedFlag/aggregator-agent/internal/system"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/tracing"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/debug"
 	"golang.org/x/sys/windows/svc/eventlog"
@@ -37,6 +43,21 @@ type redflagService struct {
 	stop  chan struct{}
 }
 
+// serviceCommands are the command types the service's check-in loop handles
+var serviceCommands = []string{
+	"scan_updates", "collect_specs", "dry_run_update", "install_updates", "confirm_dependencies",
+	"rollback_update", "enable_heartbeat", "disable_heartbeat", "update_agent",
+}
+
+// serviceCapabilities reports what the service can run on this host
+func serviceCapabilities() *client.Capabilities {
+	return &client.Capabilities{
+		Commands:   serviceCommands,
+		Scanners:   scanner.Available(),
+		Installers: installer.AvailablePackageTypes(),
+	}
+}
+
 func (s *redflagService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
 	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
 	changes <- svc.Status{State: svc.StartPending}
@@ -45,7 +66,7 @@ func (s *redflagService) Execute(args []string, r <-chan svc.ChangeRequest, chan
 	var err error
 	elog, err = eventlog.Open(serviceName)
 	if err != nil {
-		log.Printf("Failed to open event log: %v", err)
+		slog.Warn("failed to open event log", "error", err)
 		elog = debug.New("RedFlagAgent")
 	}
 	defer elog.Close()
@@ -96,12 +117,14 @@ loop:
 }
 
 func (s *redflagService) runAgent() {
-	log.Printf("🚩 RedFlag Agent starting in service mode...")
-	log.Printf("==================================================================")
-	log.Printf("📋 AGENT ID: %s", s.agent.AgentID)
-	log.Printf("🌐 SERVER: %s", s.agent.ServerURL)
-	log.Printf("⏱️  CHECK-IN INTERVAL: %ds", s.agent.CheckInInterval)
-	log.Printf("==================================================================")
+	slog.Info("RedFlag Agent starting in service mode", "agent_id", s.agent.AgentID,
+		"server", s.agent.ServerURL, "check_in_interval_seconds", s.agent.CheckInInterval)
+
+	// Reports that fail to upload wait in the outbox for the next check-in
+	if err := client.EnableOutbox(client.DefaultOutboxDir()); err != nil {
+		slog.Warn("offline outbox disabled", "error", err)
+		elog.Warning(1, fmt.Sprintf("Offline outbox disabled: %v", err))
+	}
 
 	// Initialize API client
 	apiClient := client.NewClient(s.agent.ServerURL, s.agent.Token)
@@ -110,6 +133,9 @@ func (s *redflagService) runAgent() {
 	aptScanner := scanner.NewAPTScanner()
 	dnfScanner := scanner.NewDNFScanner()
 	dockerScanner, _ := scanner.NewDockerScanner()
+	if dockerScanner != nil {
+		dockerScanner.SetTagTracking(s.agent.Docker.TagTracking)
+	}
 	windowsUpdateScanner := scanner.NewWindowsUpdateScanner()
 	wingetScanner := scanner.NewWingetScanner()
 
@@ -117,11 +143,20 @@ func (s *redflagService) runAgent() {
 	var lastSystemInfoUpdate time.Time
 	const systemInfoUpdateInterval = 1 * time.Hour // Update detailed system info every hour
 
+	// Reported with check-ins; refreshed with the system info in case tools were installed
+	capabilities := serviceCapabilities()
+
+	// mTLS certificate renewal is checked on the same cadence
+	var lastCertificateCheck time.Time
+
+	// Why the last server configuration profile was rejected, reported with check-ins
+	var configError string
+
 	// Main check-in loop with service stop handling
 	for {
 		select {
 		case <-s.stop:
-			log.Printf("Received stop signal, shutting down gracefully...")
+			slog.Info("received stop signal, shutting down")
 			elog.Info(1, "Agent shutting down gracefully")
 			return
 		default:
@@ -131,18 +166,24 @@ func (s *redflagService) runAgent() {
 
 			// Check if we need to send detailed system info update
 			if time.Since(lastSystemInfoUpdate) >= systemInfoUpdateInterval {
-				log.Printf("Updating detailed system information...")
+				slog.Debug("updating detailed system information")
 				if err := s.reportSystemInfo(apiClient); err != nil {
-					log.Printf("Failed to report system info: %v\n", err)
+					slog.Error("failed to report system info", "error", err)
 					elog.Error(1, fmt.Sprintf("Failed to report system info: %v", err))
 				} else {
 					lastSystemInfoUpdate = time.Now()
-					log.Printf("✓ System information updated\n")
+					slog.Info("system information updated")
 					elog.Info(1, "System information updated successfully")
 				}
+				capabilities = serviceCapabilities()
+			}
+
+			if time.Since(lastCertificateCheck) >= systemInfoUpdateInterval {
+				s.renewCertificateIfNeeded(apiClient)
+				lastCertificateCheck = time.Now()
 			}
 
-			log.Printf("Checking in with server...")
+			slog.Debug("checking in with server")
 
 			// Collect lightweight system metrics
 			sysMetrics, err := system.GetLightweightMetrics()
@@ -158,6 +199,14 @@ func (s *redflagService) runAgent() {
 					DiskPercent:   sysMetrics.DiskPercent,
 					Uptime:        sysMetrics.Uptime,
 					Version:       AgentVersion,
+					ConfigError:   configError,
+
+					ProtocolVersion: client.ProtocolVersion,
+					Capabilities:    capabilities,
+				}
+				if s.agent.Managed != nil {
+					metrics.ConfigProfileID = s.agent.Managed.ProfileID
+					metrics.ConfigVersion = s.agent.Managed.Version
 				}
 			}
 
@@ -184,87 +233,132 @@ func (s *redflagService) runAgent() {
 				// Try to renew token if we got a 401 error
 				newClient, renewErr := s.renewTokenIfNeeded(apiClient, err)
 				if renewErr != nil {
-					log.Printf("Check-in unsuccessful and token renewal failed: %v\n", renewErr)
+					slog.Error("check-in unsuccessful and token renewal failed", "error", renewErr)
 					elog.Error(1, fmt.Sprintf("Check-in failed and token renewal failed: %v", renewErr))
 					time.Sleep(time.Duration(s.getCurrentPollingInterval()) * time.Second)
 					continue
 				}
 				// If token was renewed, update client and retry
 				if newClient != apiClient {
-					log.Printf("🔄 Retrying check-in with renewed token...")
+					slog.Info("retrying check-in with renewed token")
 					elog.Info(1, "Retrying check-in with renewed token")
 					apiClient = newClient
 					commands, err = apiClient.GetCommands(s.agent.AgentID, metrics)
 					if err != nil {
-						log.Printf("Check-in unsuccessful even after token renewal: %v\n", err)
+						slog.Error("check-in unsuccessful even after token renewal", "error", err)
 						elog.Error(1, fmt.Sprintf("Check-in failed after token renewal: %v", err))
 						time.Sleep(time.Duration(s.getCurrentPollingInterval()) * time.Second)
 						continue
 					}
 				} else {
-					log.Printf("Check-in unsuccessful: %v\n", err)
+					slog.Warn("check-in unsuccessful", "error", err)
 					elog.Error(1, fmt.Sprintf("Check-in unsuccessful: %v", err))
 					time.Sleep(time.Duration(s.getCurrentPollingInterval()) * time.Second)
 					continue
 				}
 			}
 
+			// Deliver results queued while the server was unreachable
+			if sent, err := apiClient.FlushOutbox(s.agent.AgentID); err != nil {
+				slog.Warn("outbox replay stopped", "sent", sent, "error", err)
+				elog.Warning(1, fmt.Sprintf("Outbox replay stopped after %d report(s): %v", sent, err))
+			} else if sent > 0 {
+				slog.Info("delivered queued reports from the outbox", "sent", sent)
+				elog.Info(1, fmt.Sprintf("Delivered %d queued report(s) from the outbox", sent))
+			}
+
+			if err := s.applyServerConfig(apiClient); err != nil {
+				slog.Warn("rejected server configuration", "error", err)
+				elog.Warning(1, fmt.Sprintf("Rejected server configuration %v", err))
+				configError = err.Error()
+			} else {
+				configError = ""
+			}
+
 			if len(commands) == 0 {
-				log.Printf("Check-in successful - no new commands")
+				slog.Debug("check-in successful, no new commands")
 				elog.Info(1, "Check-in successful - no new commands")
 			} else {
-				log.Printf("Check-in successful - received %d command(s)", len(commands))
+				slog.Info("check-in successful", "commands", len(commands))
 				elog.Info(1, fmt.Sprintf("Check-in successful - received %d command(s)", len(commands)))
 			}
 
 			// Process each command with full implementation
 			for _, cmd := range commands {
-				log.Printf("Processing command: %s (%s)\n", cmd.Type, cmd.ID)
+				slog.Info("processing command", "command_type", cmd.Type, "command_id", cmd.ID)
 				elog.Info(1, fmt.Sprintf("Processing command: %s (%s)", cmd.Type, cmd.ID))
 
+				// Continue the server's trace for this command
+				ctx, span := tracing.StartCommand(cmd.ID, cmd.Type, cmd.Params)
+				cmdClient := apiClient.WithContext(ctx)
+				var cmdErr error
+
 				switch cmd.Type {
 				case "scan_updates":
-					if err := s.handleScanUpdates(apiClient, aptScanner, dnfScanner, dockerScanner, windowsUpdateScanner, wingetScanner, cmd.ID); err != nil {
-						log.Printf("Error scanning updates: %v\n", err)
-						elog.Error(1, fmt.Sprintf("Error scanning updates: %v", err))
+					if cmdErr = s.handleScanUpdates(cmdClient, aptScanner, dnfScanner, dockerScanner, windowsUpdateScanner, wingetScanner, cmd.ID); cmdErr != nil {
+						slog.Error("failed to scan for updates", "command_id", cmd.ID, "error", cmdErr)
+						elog.Error(1, fmt.Sprintf("Error scanning updates: %v", cmdErr))
 					}
 				case "collect_specs":
-					log.Println("Spec collection not yet implemented")
+					if cmdErr = s.handleCollectSpecs(cmdClient, cmd.ID); cmdErr != nil {
+						slog.Error("failed to collect system specs", "command_id", cmd.ID, "error", cmdErr)
+						elog.Error(1, fmt.Sprintf("Error collecting specs: %v", cmdErr))
+					}
 				case "dry_run_update":
-					if err := s.handleDryRunUpdate(apiClient, cmd.ID, cmd.Params); err != nil {
-						log.Printf("Error dry running update: %v\n", err)
-						elog.Error(1, fmt.Sprintf("Error dry running update: %v", err))
+					if cmdErr = s.handleDryRunUpdate(cmdClient, cmd.ID, cmd.Params); cmdErr != nil {
+						slog.Error("failed to dry run update", "command_id", cmd.ID, "error", cmdErr)
+						elog.Error(1, fmt.Sprintf("Error dry running update: %v", cmdErr))
 					}
 				case "install_updates":
-					if err := s.handleInstallUpdates(apiClient, cmd.ID, cmd.Params); err != nil {
-						log.Printf("Error installing updates: %v\n", err)
-						elog.Error(1, fmt.Sprintf("Error installing updates: %v", err))
+					if cmdErr = s.handleInstallUpdates(cmdClient, cmd.ID, cmd.Params); cmdErr != nil {
+						slog.Error("failed to install updates", "command_id", cmd.ID, "error", cmdErr)
+						elog.Error(1, fmt.Sprintf("Error installing updates: %v", cmdErr))
 					}
 				case "confirm_dependencies":
-					if err := s.handleConfirmDependencies(apiClient, cmd.ID, cmd.Params); err != nil {
-						log.Printf("Error confirming dependencies: %v\n", err)
-						elog.Error(1, fmt.Sprintf("Error confirming dependencies: %v", err))
+					if cmdErr = s.handleConfirmDependencies(cmdClient, cmd.ID, cmd.Params); cmdErr != nil {
+						slog.Error("failed to confirm dependencies", "command_id", cmd.ID, "error", cmdErr)
+						elog.Error(1, fmt.Sprintf("Error confirming dependencies: %v", cmdErr))
+					}
+				case "rollback_update":
+					if cmdErr = s.handleRollbackUpdate(cmdClient, cmd.ID, cmd.Params); cmdErr != nil {
+						slog.Error("failed to roll back update", "command_id", cmd.ID, "error", cmdErr)
+						elog.Error(1, fmt.Sprintf("Error rolling back update: %v", cmdErr))
 					}
 				case "enable_heartbeat":
-					if err := s.handleEnableHeartbeat(apiClient, cmd.ID, cmd.Params); err != nil {
-						log.Printf("[Heartbeat] Error enabling heartbeat: %v\n", err)
-						elog.Error(1, fmt.Sprintf("Error enabling heartbeat: %v", err))
+					if cmdErr = s.handleEnableHeartbeat(cmdClient, cmd.ID, cmd.Params); cmdErr != nil {
+						slog.Error("failed to enable heartbeat", "command_id", cmd.ID, "error", cmdErr)
+						elog.Error(1, fmt.Sprintf("Error enabling heartbeat: %v", cmdErr))
 					}
 				case "disable_heartbeat":
-					if err := s.handleDisableHeartbeat(apiClient, cmd.ID); err != nil {
-						log.Printf("[Heartbeat] Error disabling heartbeat: %v\n", err)
-						elog.Error(1, fmt.Sprintf("Error disabling heartbeat: %v", err))
+					if cmdErr = s.handleDisableHeartbeat(cmdClient, cmd.ID); cmdErr != nil {
+						slog.Error("failed to disable heartbeat", "command_id", cmd.ID, "error", cmdErr)
+						elog.Error(1, fmt.Sprintf("Error disabling heartbeat: %v", cmdErr))
+					}
+				case "update_agent":
+					// Self-update relies on the Linux systemd update unit
+					cmdErr = selfupdate.ErrUnsupported
+					slog.Error("agent update failed", "command_id", cmd.ID, "error", cmdErr)
+					logReport := client.LogReport{
+						CommandID: cmd.ID,
+						Action:    "update_agent",
+						Result:    "failed",
+						Stderr:    cmdErr.Error(),
+						ExitCode:  1,
+					}
+					if reportErr := cmdClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
+						slog.Error("failed to report agent update failure", "command_id", cmd.ID, "error", reportErr)
 					}
 				default:
-					log.Printf("Unknown command type: %s\n", cmd.Type)
+					slog.Warn("unknown command type", "command_type", cmd.Type, "command_id", cmd.ID)
 					elog.Error(1, fmt.Sprintf("Unknown command type: %s", cmd.Type))
 				}
+				tracing.End(span, cmdErr)
 			}
 
 			// Wait for next check-in with stop signal checking
 			select {
 			case <-s.stop:
-				log.Printf("Received stop signal during wait, shutting down gracefully...")
+				slog.Info("received stop signal, shutting down")
 				elog.Info(1, "Agent shutting down gracefully during wait period")
 				return
 			case <-time.After(time.Duration(s.getCurrentPollingInterval()) * time.Second):
@@ -344,7 +438,7 @@ func InstallService() error {
 		return fmt.Errorf("failed to set recovery actions: %w", err)
 	}
 
-	log.Printf("Service %s installed successfully", serviceName)
+	slog.Info("service installed", "service", serviceName)
 	return nil
 }
 
@@ -372,7 +466,7 @@ func RemoveService() error {
 		if _, err := s.Control(svc.Stop); err != nil {
 			return fmt.Errorf("failed to stop service: %w", err)
 		}
-		log.Printf("Stopping service...")
+		slog.Info("stopping service", "service", serviceName)
 		time.Sleep(5 * time.Second) // Wait for service to stop
 	}
 
@@ -381,7 +475,7 @@ func RemoveService() error {
 		return fmt.Errorf("failed to delete service: %w", err)
 	}
 
-	log.Printf("Service %s removed successfully", serviceName)
+	slog.Info("service removed", "service", serviceName)
 	return nil
 }
 
@@ -403,7 +497,7 @@ func StartService() error {
 		return fmt.Errorf("failed to start service: %w", err)
 	}
 
-	log.Printf("Service %s started successfully", serviceName)
+	slog.Info("service started", "service", serviceName)
 	return nil
 }
 
@@ -425,7 +519,7 @@ func StopService() error {
 		return fmt.Errorf("failed to stop service: %w", err)
 	}
 
-	log.Printf("Service %s stopped successfully", serviceName)
+	slog.Info("service stopped", "service", serviceName)
 	return nil
 }
 
@@ -466,7 +560,7 @@ func ServiceStatus() error {
 		state = "RESUMING"
 	}
 
-	log.Printf("Service %s status: %s", serviceName, state)
+	slog.Info("service status", "service", serviceName, "state", state)
 	return nil
 }
 
@@ -486,7 +580,7 @@ func (s *redflagService) getCurrentPollingInterval() int {
 		// Save the updated config to clean up expired rapid mode
 		configPath := s.getConfigPath()
 		if err := s.agent.Save(configPath); err != nil {
-			log.Printf("Warning: Failed to cleanup expired rapid polling mode: %v", err)
+			slog.Warn("failed to clean up expired rapid polling mode", "error", err)
 		}
 	}
 
@@ -501,12 +595,12 @@ func (s *redflagService) getConfigPath() string {
 // renewTokenIfNeeded handles 401 errors by renewing the agent token using refresh token
 func (s *redflagService) renewTokenIfNeeded(apiClient *client.Client, err error) (*client.Client, error) {
 	if err != nil && strings.Contains(err.Error(), "401 Unauthorized") {
-		log.Printf("🔄 Access token expired - attempting renewal with refresh token...")
+		slog.Info("access token expired, renewing with refresh token")
 		elog.Info(1, "Access token expired - attempting renewal with refresh token")
 
 		// Check if we have a refresh token
 		if s.agent.RefreshToken == "" {
-			log.Printf("❌ No refresh token available - re-registration required")
+			slog.Error("no refresh token available, re-registration required")
 			elog.Error(1, "No refresh token available - re-registration required")
 			return nil, fmt.Errorf("refresh token missing - please re-register agent")
 		}
@@ -514,25 +608,45 @@ func (s *redflagService) renewTokenIfNeeded(apiClient *client.Client, err error)
 		// Create temporary client without token for renewal
 		tempClient := client.NewClient(s.agent.ServerURL, "")
 
+		// Without a usable client certificate the 401 may be the server
+		// requiring mTLS, so ask for one along with the token
+		var certReq *mtls.Request
+		var csr string
+		if mtls.NeedsCertificate(s.agent) {
+			if r, err := mtls.NewRequest(s.agent.AgentID.String()); err == nil {
+				certReq, csr = r, r.CSR
+			}
+		}
+
 		// Attempt to renew access token using refresh token
-		if err := tempClient.RenewToken(s.agent.AgentID, s.agent.RefreshToken); err != nil {
-			log.Printf("❌ Refresh token renewal failed: %v", err)
+		cert, err := tempClient.RenewTokenWithCSR(s.agent.AgentID, s.agent.RefreshToken, csr)
+		if err != nil {
+			slog.Error("refresh token renewal failed, the refresh token may have expired and re-registration may be required", "error", err)
 			elog.Error(1, fmt.Sprintf("Refresh token renewal failed: %v", err))
-			log.Printf("💡 Refresh token may be expired (>90 days) - re-registration required")
 			return nil, fmt.Errorf("refresh token renewal failed: %w - please re-register agent", err)
 		}
 
 		// Update config with new access token (agent ID and refresh token stay the same!)
 		s.agent.Token = tempClient.GetToken()
+		logging.AddSecret(s.agent.Token)
+
+		if cert != nil && certReq != nil {
+			if err := s.installCertificate(certReq, cert); err != nil {
+				slog.Warn("failed to install client certificate", "error", err)
+				elog.Error(1, fmt.Sprintf("Failed to install client certificate: %v", err))
+			}
+			// Pick up the transport presenting the new certificate
+			tempClient = client.NewClient(s.agent.ServerURL, s.agent.Token)
+		}
 
 		// Save updated config
 		configPath := s.getConfigPath()
 		if err := s.agent.Save(configPath); err != nil {
-			log.Printf("⚠️  Warning: Failed to save renewed access token: %v", err)
+			slog.Warn("failed to save renewed access token", "error", err)
 			elog.Error(1, fmt.Sprintf("Failed to save renewed access token: %v", err))
 		}
 
-		log.Printf("✅ Access token renewed successfully - agent ID maintained: %s", s.agent.AgentID)
+		slog.Info("access token renewed", "agent_id", s.agent.AgentID)
 		elog.Info(1, fmt.Sprintf("Access token renewed successfully - agent ID maintained: %s", s.agent.AgentID))
 		return tempClient, nil
 	}
@@ -541,6 +655,147 @@ func (s *redflagService) renewTokenIfNeeded(apiClient *client.Client, err error)
 	return apiClient, nil
 }
 
+// installCertificate stores a server-issued mTLS client certificate with the
+// config and rebuilds the transport so new clients present it
+func (s *redflagService) installCertificate(certReq *mtls.Request, cert *client.AgentCertificate) error {
+	configPath := s.getConfigPath()
+	if err := certReq.Install(s.agent, filepath.Dir(configPath), cert.Certificate); err != nil {
+		return fmt.Errorf("failed to install client certificate: %w", err)
+	}
+	if err := s.agent.Save(configPath); err != nil {
+		return err
+	}
+	slog.Info("client certificate installed", "expires", cert.NotAfter.Format(time.RFC3339))
+	elog.Info(1, fmt.Sprintf("Client certificate installed (expires %s)", cert.NotAfter.Format(time.RFC3339)))
+	return client.ConfigureTransport(s.agent)
+}
+
+// applyServerConfig applies the configuration profile delivered with the last
+// check-in, if it differs from the one already applied
+func (s *redflagService) applyServerConfig(apiClient *client.Client) error {
+	managed := apiClient.ManagedConfig
+	if managed == nil {
+		return nil
+	}
+	if s.agent.Managed == nil && managed.ProfileID == "" {
+		return nil
+	}
+	if s.agent.Managed != nil && s.agent.Managed.ProfileID == managed.ProfileID && s.agent.Managed.Version == managed.Version {
+		return nil
+	}
+
+	if err := s.agent.ApplyManaged(managed); err != nil {
+		return fmt.Errorf("profile %s version %d: %w", managed.ProfileID, managed.Version, err)
+	}
+	logging.SetLevel(s.agent.Logging.Level)
+	if err := s.agent.Save(s.getConfigPath()); err != nil {
+		slog.Warn("failed to save server configuration", "error", err)
+	}
+
+	if managed.ProfileID == "" {
+		elog.Info(1, "Server configuration profile removed - using local settings")
+	} else {
+		elog.Info(1, fmt.Sprintf("Applied server configuration profile %q (version %d)", managed.ProfileName, managed.Version))
+	}
+	return nil
+}
+
+// renewCertificateIfNeeded renews the server-issued mTLS client certificate
+// once less than a third of its lifetime is left
+func (s *redflagService) renewCertificateIfNeeded(apiClient *client.Client) {
+	if !mtls.NeedsRenewal(s.agent) {
+		return
+	}
+
+	certReq, err := mtls.NewRequest(s.agent.AgentID.String())
+	if err != nil {
+		slog.Error("failed to create certificate request", "error", err)
+		return
+	}
+	cert, err := apiClient.RenewCertificate(s.agent.AgentID, certReq.CSR)
+	if err != nil {
+		slog.Error("failed to renew client certificate", "error", err)
+		elog.Error(1, fmt.Sprintf("Failed to renew client certificate: %v", err))
+		return
+	}
+	if err := s.installCertificate(certReq, cert); err != nil {
+		slog.Error("failed to save renewed client certificate", "error", err)
+		elog.Error(1, fmt.Sprintf("Failed to save renewed client certificate: %v", err))
+	}
+}
+
+// handleCollectSpecs collects the host's hardware and software inventory and
+// reports it to the server, which keeps a snapshot whenever it changes
+func (s *redflagService) handleCollectSpecs(apiClient *client.Client, commandID string) error {
+	slog.Info("collecting system specs")
+	startTime := time.Now()
+
+	reportFailure := func(err error) error {
+		logReport := client.LogReport{
+			CommandID:       commandID,
+			Action:          "collect_specs",
+			Result:          "failed",
+			Stderr:          err.Error(),
+			ExitCode:        1,
+			DurationSeconds: int(time.Since(startTime).Seconds()),
+		}
+		if reportErr := apiClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
+			slog.Error("failed to report spec collection failure", "command_id", commandID, "error", reportErr)
+		}
+		return err
+	}
+
+	specs, err := system.CollectSpecs()
+	if err != nil {
+		return reportFailure(fmt.Errorf("failed to collect specs: %w", err))
+	}
+
+	resp, err := apiClient.ReportSpecs(s.agent.AgentID, specs)
+	if err != nil {
+		return reportFailure(fmt.Errorf("failed to report specs: %w", err))
+	}
+
+	var stdout strings.Builder
+	fmt.Fprintf(&stdout, "%s, %d cores/%d threads, %d MB memory, %d disks, %d network interfaces",
+		specs.CPUModel, specs.CPUCores, specs.CPUThreads, specs.MemoryTotalMB, len(specs.Disks), len(specs.NetworkInterfaces))
+	if specs.Virtualization != "" && specs.Virtualization != "none" {
+		fmt.Fprintf(&stdout, ", virtualization: %s", specs.Virtualization)
+	}
+	switch {
+	case !resp.Changed:
+		stdout.WriteString("\nNo changes since the last snapshot")
+	case len(resp.Changes) == 0:
+		stdout.WriteString("\nRecorded first snapshot")
+	default:
+		fmt.Fprintf(&stdout, "\n%d changes since the last snapshot:", len(resp.Changes))
+		for _, change := range resp.Changes {
+			switch {
+			case change.From == nil:
+				fmt.Fprintf(&stdout, "\n  %s: added (%v)", change.Field, change.To)
+			case change.To == nil:
+				fmt.Fprintf(&stdout, "\n  %s: removed (%v)", change.Field, change.From)
+			default:
+				fmt.Fprintf(&stdout, "\n  %s: %v -> %v", change.Field, change.From, change.To)
+			}
+		}
+	}
+
+	logReport := client.LogReport{
+		CommandID:       commandID,
+		Action:          "collect_specs",
+		Result:          "success",
+		Stdout:          stdout.String(),
+		ExitCode:        0,
+		DurationSeconds: int(time.Since(startTime).Seconds()),
+	}
+	if reportErr := apiClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
+		slog.Error("failed to report spec collection", "command_id", commandID, "error", reportErr)
+	}
+
+	slog.Info("reported system specs", "changed", resp.Changed)
+	return nil
+}
+
 // reportSystemInfo collects and reports detailed system information to the server
 func (s *redflagService) reportSystemInfo(apiClient *client.Client) error {
 	// Collect detailed system information
@@ -596,7 +851,7 @@ func (s *redflagService) reportSystemInfo(apiClient *client.Client) error {
 // Command handling functions - these need to be fully implemented
 
 func (s *redflagService) handleScanUpdates(apiClient *client.Client, aptScanner *scanner.APTScanner, dnfScanner *scanner.DNFScanner, dockerScanner *scanner.DockerScanner, windowsUpdateScanner *scanner.WindowsUpdateScanner, wingetScanner *scanner.WingetScanner, commandID string) error {
-	log.Println("Scanning for updates...")
+	slog.Info("scanning for updates")
 	elog.Info(1, "Starting update scan")
 
 	var allUpdates []client.UpdateReportItem
@@ -604,17 +859,19 @@ func (s *redflagService) handleScanUpdates(apiClient *client.Client, aptScanner
 	var scanResults []string
 
 	// Scan APT updates
-	if aptScanner.IsAvailable() {
-		log.Println("  - Scanning APT packages...")
+	if !s.agent.ScannerEnabled("apt") {
+		scanResults = append(scanResults, "APT scanner disabled by configuration")
+	} else if aptScanner.IsAvailable() {
+		slog.Info("scanning apt packages")
 		updates, err := aptScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("APT scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("apt scan failed", "error", err)
 			elog.Error(1, errorMsg)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d APT updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("apt scan complete", "updates", len(updates))
 			elog.Info(1, resultMsg)
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
@@ -624,17 +881,19 @@ func (s *redflagService) handleScanUpdates(apiClient *client.Client, aptScanner
 	}
 
 	// Scan DNF updates
-	if dnfScanner.IsAvailable() {
-		log.Println("  - Scanning DNF packages...")
+	if !s.agent.ScannerEnabled("dnf") {
+		scanResults = append(scanResults, "DNF scanner disabled by configuration")
+	} else if dnfScanner.IsAvailable() {
+		slog.Info("scanning dnf packages")
 		updates, err := dnfScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("DNF scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("dnf scan failed", "error", err)
 			elog.Error(1, errorMsg)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d DNF updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("dnf scan complete", "updates", len(updates))
 			elog.Info(1, resultMsg)
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
@@ -644,17 +903,19 @@ func (s *redflagService) handleScanUpdates(apiClient *client.Client, aptScanner
 	}
 
 	// Scan Docker updates
-	if dockerScanner != nil && dockerScanner.IsAvailable() {
-		log.Println("  - Scanning Docker images...")
+	if !s.agent.ScannerEnabled("docker") {
+		scanResults = append(scanResults, "Docker scanner disabled by configuration")
+	} else if dockerScanner != nil && dockerScanner.IsAvailable() {
+		slog.Info("scanning docker images")
 		updates, err := dockerScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("Docker scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("docker scan failed", "error", err)
 			elog.Error(1, errorMsg)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d Docker image updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("docker scan complete", "updates", len(updates))
 			elog.Info(1, resultMsg)
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
@@ -664,17 +925,19 @@ func (s *redflagService) handleScanUpdates(apiClient *client.Client, aptScanner
 	}
 
 	// Scan Windows updates
-	if windowsUpdateScanner.IsAvailable() {
-		log.Println("  - Scanning Windows updates...")
+	if !s.agent.ScannerEnabled("windows_update") {
+		scanResults = append(scanResults, "Windows Update scanner disabled by configuration")
+	} else if windowsUpdateScanner.IsAvailable() {
+		slog.Info("scanning windows updates")
 		updates, err := windowsUpdateScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("Windows Update scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("windows update scan failed", "error", err)
 			elog.Error(1, errorMsg)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d Windows updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("windows update scan complete", "updates", len(updates))
 			elog.Info(1, resultMsg)
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
@@ -684,17 +947,19 @@ func (s *redflagService) handleScanUpdates(apiClient *client.Client, aptScanner
 	}
 
 	// Scan Winget packages
-	if wingetScanner.IsAvailable() {
-		log.Println("  - Scanning Winget packages...")
+	if !s.agent.ScannerEnabled("winget") {
+		scanResults = append(scanResults, "Winget scanner disabled by configuration")
+	} else if wingetScanner.IsAvailable() {
+		slog.Info("scanning winget packages")
 		updates, err := wingetScanner.Scan()
 		if err != nil {
 			errorMsg := fmt.Sprintf("Winget scan failed: %v", err)
-			log.Printf("    %s\n", errorMsg)
+			slog.Error("winget scan failed", "error", err)
 			elog.Error(1, errorMsg)
 			scanErrors = append(scanErrors, errorMsg)
 		} else {
 			resultMsg := fmt.Sprintf("Found %d Winget package updates", len(updates))
-			log.Printf("    %s\n", resultMsg)
+			slog.Info("winget scan complete", "updates", len(updates))
 			elog.Info(1, resultMsg)
 			scanResults = append(scanResults, resultMsg)
 			allUpdates = append(allUpdates, updates...)
@@ -737,7 +1002,7 @@ func (s *redflagService) handleScanUpdates(apiClient *client.Client, aptScanner
 
 	// Report the scan log
 	if err := apiClient.ReportLog(s.agent.AgentID, logReport); err != nil {
-		log.Printf("Failed to report scan log: %v\n", err)
+		slog.Error("failed to report scan log", "error", err)
 		elog.Error(1, fmt.Sprintf("Failed to report scan log: %v", err))
 		// Continue anyway - updates are more important
 	}
@@ -754,10 +1019,10 @@ func (s *redflagService) handleScanUpdates(apiClient *client.Client, aptScanner
 			return fmt.Errorf("failed to report updates: %w", err)
 		}
 
-		log.Printf("✓ Reported %d updates to server\n", len(allUpdates))
+		slog.Info("reported updates to server", "updates", len(allUpdates))
 		elog.Info(1, fmt.Sprintf("Reported %d updates to server", len(allUpdates)))
 	} else {
-		log.Println("✓ No updates found")
+		slog.Info("no updates found")
 		elog.Info(1, "No updates found")
 	}
 
@@ -770,7 +1035,7 @@ func (s *redflagService) handleScanUpdates(apiClient *client.Client, aptScanner
 }
 
 func (s *redflagService) handleDryRunUpdate(apiClient *client.Client, commandID string, params map[string]interface{}) error {
-	log.Println("Performing dry run update...")
+	slog.Info("performing dry run update", "command_id", commandID)
 	elog.Info(1, "Starting dry run update")
 
 	// Parse parameters
@@ -807,7 +1072,7 @@ func (s *redflagService) handleDryRunUpdate(apiClient *client.Client, commandID
 	}
 
 	// Perform dry run
-	log.Printf("Dry running package: %s (type: %s)", packageName, packageType)
+	slog.Info("dry running package", "package", packageName, "package_type", packageType)
 	elog.Info(1, fmt.Sprintf("Dry running package: %s (type: %s)", packageName, packageType))
 
 	result, err := inst.DryRun(packageName)
@@ -824,7 +1089,7 @@ func (s *redflagService) handleDryRunUpdate(apiClient *client.Client, commandID
 		}
 
 		if reportErr := apiClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
-			log.Printf("Failed to report dry run failure: %v\n", reportErr)
+			slog.Error("failed to report dry run failure", "command_id", commandID, "error", reportErr)
 			elog.Error(1, fmt.Sprintf("Failed to report dry run failure: %v", reportErr))
 		}
 
@@ -856,7 +1121,7 @@ func (s *redflagService) handleDryRunUpdate(apiClient *client.Client, commandID
 	}
 
 	if reportErr := apiClient.ReportDependencies(s.agent.AgentID, depReport); reportErr != nil {
-		log.Printf("Failed to report dependencies: %v\n", reportErr)
+		slog.Error("failed to report dependencies", "command_id", commandID, "error", reportErr)
 		elog.Error(1, fmt.Sprintf("Failed to report dependencies: %v", reportErr))
 		return fmt.Errorf("failed to report dependencies: %w", reportErr)
 	}
@@ -877,22 +1142,22 @@ func (s *redflagService) handleDryRunUpdate(apiClient *client.Client, commandID
 	}
 
 	if reportErr := apiClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
-		log.Printf("Failed to report dry run success: %v\n", reportErr)
+		slog.Error("failed to report dry run success", "command_id", commandID, "error", reportErr)
 		elog.Error(1, fmt.Sprintf("Failed to report dry run success: %v", reportErr))
 	}
 
 	if result.Success {
-		log.Printf("✓ Dry run completed successfully in %d seconds\n", result.DurationSeconds)
+		slog.Info("dry run completed", "duration_seconds", result.DurationSeconds)
 		elog.Info(1, fmt.Sprintf("Dry run completed successfully in %d seconds", result.DurationSeconds))
 		if len(result.Dependencies) > 0 {
-			log.Printf("  Dependencies found: %v\n", result.Dependencies)
+			slog.Info("dependencies found", "dependencies", result.Dependencies)
 			elog.Info(1, fmt.Sprintf("Dependencies found: %v", result.Dependencies))
 		} else {
-			log.Printf("  No additional dependencies found\n")
+			slog.Info("no additional dependencies found")
 			elog.Info(1, "No additional dependencies found")
 		}
 	} else {
-		log.Printf("✗ Dry run failed after %d seconds\n", result.DurationSeconds)
+		slog.Error("dry run failed", "duration_seconds", result.DurationSeconds, "error", result.ErrorMessage)
 		elog.Error(1, fmt.Sprintf("Dry run failed after %d seconds: %s", result.DurationSeconds, result.ErrorMessage))
 	}
 
@@ -900,7 +1165,7 @@ func (s *redflagService) handleDryRunUpdate(apiClient *client.Client, commandID
 }
 
 func (s *redflagService) handleInstallUpdates(apiClient *client.Client, commandID string, params map[string]interface{}) error {
-	log.Println("Installing updates...")
+	slog.Info("installing updates", "command_id", commandID)
 	elog.Info(1, "Starting update installation")
 
 	// Parse parameters
@@ -942,7 +1207,7 @@ func (s *redflagService) handleInstallUpdates(apiClient *client.Client, commandI
 	// Perform installation based on what's specified
 	if packageName != "" {
 		action = "update"
-		log.Printf("Updating package: %s (type: %s)", packageName, packageType)
+		slog.Info("updating package", "package", packageName, "package_type", packageType)
 		elog.Info(1, fmt.Sprintf("Updating package: %s (type: %s)", packageName, packageType))
 		result, err = inst.UpdatePackage(packageName)
 	} else if len(params) > 1 {
@@ -957,20 +1222,20 @@ func (s *redflagService) handleInstallUpdates(apiClient *client.Client, commandI
 		}
 		if len(packageNames) > 0 {
 			action = "install_multiple"
-			log.Printf("Installing multiple packages: %v (type: %s)", packageNames, packageType)
+			slog.Info("installing packages", "packages", packageNames, "package_type", packageType)
 			elog.Info(1, fmt.Sprintf("Installing multiple packages: %v (type: %s)", packageNames, packageType))
 			result, err = inst.InstallMultiple(packageNames)
 		} else {
 			// Upgrade all packages if no specific packages named
 			action = "upgrade"
-			log.Printf("Upgrading all packages (type: %s)", packageType)
+			slog.Info("upgrading all packages", "package_type", packageType)
 			elog.Info(1, fmt.Sprintf("Upgrading all packages (type: %s)", packageType))
 			result, err = inst.Upgrade()
 		}
 	} else {
 		// Upgrade all packages if no specific packages named
 		action = "upgrade"
-		log.Printf("Upgrading all packages (type: %s)", packageType)
+		slog.Info("upgrading all packages", "package_type", packageType)
 		elog.Info(1, fmt.Sprintf("Upgrading all packages (type: %s)", packageType))
 		result, err = inst.Upgrade()
 	}
@@ -988,7 +1253,7 @@ func (s *redflagService) handleInstallUpdates(apiClient *client.Client, commandI
 		}
 
 		if reportErr := apiClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
-			log.Printf("Failed to report installation failure: %v\n", reportErr)
+			slog.Error("failed to report installation failure", "command_id", commandID, "error", reportErr)
 			elog.Error(1, fmt.Sprintf("Failed to report installation failure: %v", reportErr))
 		}
 
@@ -1011,20 +1276,31 @@ func (s *redflagService) handleInstallUpdates(apiClient *client.Client, commandI
 		logReport.Stdout += fmt.Sprintf("\nPackages installed: %v", result.PackagesInstalled)
 	}
 
+	// The install worked; make sure what it touched still does
+	if len(s.agent.HealthChecks) > 0 {
+		results, healthErr := health.Run(s.agent.HealthChecks)
+		logReport.Details = map[string]interface{}{"health_checks": results, "health": "passed"}
+		if healthErr != nil {
+			logReport.Details["health"] = "failed"
+			logReport.Stderr += "\n" + healthErr.Error() + "\n" + health.Summary(results)
+			elog.Warning(1, fmt.Sprintf("Post-install %v", healthErr))
+		}
+	}
+
 	if reportErr := apiClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
-		log.Printf("Failed to report installation success: %v\n", reportErr)
+		slog.Error("failed to report installation success", "command_id", commandID, "error", reportErr)
 		elog.Error(1, fmt.Sprintf("Failed to report installation success: %v", reportErr))
 	}
 
 	if result.Success {
-		log.Printf("✓ Installation completed successfully in %d seconds\n", result.DurationSeconds)
+		slog.Info("installation completed", "duration_seconds", result.DurationSeconds)
 		elog.Info(1, fmt.Sprintf("Installation completed successfully in %d seconds", result.DurationSeconds))
 		if len(result.PackagesInstalled) > 0 {
-			log.Printf("  Packages installed: %v\n", result.PackagesInstalled)
+			slog.Info("packages installed", "packages", result.PackagesInstalled)
 			elog.Info(1, fmt.Sprintf("Packages installed: %v", result.PackagesInstalled))
 		}
 	} else {
-		log.Printf("✗ Installation failed after %d seconds\n", result.DurationSeconds)
+		slog.Error("installation failed", "duration_seconds", result.DurationSeconds, "error", result.ErrorMessage)
 		elog.Error(1, fmt.Sprintf("Installation failed after %d seconds: %s", result.DurationSeconds, result.ErrorMessage))
 	}
 
@@ -1032,7 +1308,7 @@ func (s *redflagService) handleInstallUpdates(apiClient *client.Client, commandI
 }
 
 func (s *redflagService) handleConfirmDependencies(apiClient *client.Client, commandID string, params map[string]interface{}) error {
-	log.Println("Installing update with confirmed dependencies...")
+	slog.Info("installing update with confirmed dependencies", "command_id", commandID)
 	elog.Info(1, "Starting dependency confirmation installation")
 
 	// Parse parameters
@@ -1082,14 +1358,14 @@ func (s *redflagService) handleConfirmDependencies(apiClient *client.Client, com
 	// Perform installation with dependencies
 	if len(dependencies) > 0 {
 		action = "install_with_dependencies"
-		log.Printf("Installing package with dependencies: %s (dependencies: %v)", packageName, dependencies)
+		slog.Info("installing package with dependencies", "package", packageName, "dependencies", dependencies)
 		elog.Info(1, fmt.Sprintf("Installing package with dependencies: %s (dependencies: %v)", packageName, dependencies))
 		// Install main package + dependencies
 		allPackages := append([]string{packageName}, dependencies...)
 		result, err = inst.InstallMultiple(allPackages)
 	} else {
 		action = "upgrade"
-		log.Printf("Installing package: %s (no dependencies)", packageName)
+		slog.Info("installing package without dependencies", "package", packageName)
 		elog.Info(1, fmt.Sprintf("Installing package: %s (no dependencies)", packageName))
 		// Use UpdatePackage instead of Install to handle existing packages
 		result, err = inst.UpdatePackage(packageName)
@@ -1108,7 +1384,7 @@ func (s *redflagService) handleConfirmDependencies(apiClient *client.Client, com
 		}
 
 		if reportErr := apiClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
-			log.Printf("Failed to report installation failure: %v\n", reportErr)
+			slog.Error("failed to report installation failure", "command_id", commandID, "error", reportErr)
 			elog.Error(1, fmt.Sprintf("Failed to report installation failure: %v", reportErr))
 		}
 
@@ -1134,28 +1410,97 @@ func (s *redflagService) handleConfirmDependencies(apiClient *client.Client, com
 		logReport.Stdout += fmt.Sprintf("\nDependencies included: %v", dependencies)
 	}
 
+	// The install worked; make sure what it touched still does
+	if len(s.agent.HealthChecks) > 0 {
+		results, healthErr := health.Run(s.agent.HealthChecks)
+		logReport.Details = map[string]interface{}{"health_checks": results, "health": "passed"}
+		if healthErr != nil {
+			logReport.Details["health"] = "failed"
+			logReport.Stderr += "\n" + healthErr.Error() + "\n" + health.Summary(results)
+			elog.Warning(1, fmt.Sprintf("Post-install %v", healthErr))
+		}
+	}
+
 	if reportErr := apiClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
-		log.Printf("Failed to report installation success: %v\n", reportErr)
+		slog.Error("failed to report installation success", "command_id", commandID, "error", reportErr)
 		elog.Error(1, fmt.Sprintf("Failed to report installation success: %v", reportErr))
 	}
 
 	if result.Success {
-		log.Printf("✓ Installation with dependencies completed successfully in %d seconds\n", result.DurationSeconds)
+		slog.Info("installation with dependencies completed", "duration_seconds", result.DurationSeconds)
 		elog.Info(1, fmt.Sprintf("Installation with dependencies completed successfully in %d seconds", result.DurationSeconds))
 		if len(result.PackagesInstalled) > 0 {
-			log.Printf("  Packages installed: %v\n", result.PackagesInstalled)
+			slog.Info("packages installed", "packages", result.PackagesInstalled)
 			elog.Info(1, fmt.Sprintf("Packages installed: %v", result.PackagesInstalled))
 		}
 	} else {
-		log.Printf("✗ Installation with dependencies failed after %d seconds\n", result.DurationSeconds)
+		slog.Error("installation with dependencies failed", "duration_seconds", result.DurationSeconds, "error", result.ErrorMessage)
 		elog.Error(1, fmt.Sprintf("Installation with dependencies failed after %d seconds: %s", result.DurationSeconds, result.ErrorMessage))
 	}
 
 	return nil
 }
 
+// handleRollbackUpdate reinstalls the previous version of a package
+func (s *redflagService) handleRollbackUpdate(apiClient *client.Client, commandID string, params map[string]interface{}) error {
+	packageType, _ := params["package_type"].(string)
+	packageName, _ := params["package_name"].(string)
+	version, _ := params["version"].(string)
+
+	if packageType == "" || packageName == "" || version == "" {
+		err := fmt.Errorf("package_type, package_name and version parameters are required")
+		elog.Error(1, err.Error())
+		return err
+	}
+
+	slog.Info("rolling back package", "package", packageName, "package_type", packageType, "version", version)
+	elog.Info(1, fmt.Sprintf("Rolling back %s to version %s", packageName, version))
+
+	inst, err := installer.InstallerFactory(packageType)
+	if err != nil {
+		err := fmt.Errorf("failed to create installer for package type %s: %w", packageType, err)
+		elog.Error(1, err.Error())
+		return err
+	}
+
+	result, err := inst.InstallVersion(packageName, version)
+	if result == nil {
+		result = &installer.InstallResult{}
+	}
+
+	logReport := client.LogReport{
+		CommandID:       commandID,
+		Action:          "rollback",
+		Result:          "success",
+		Stdout:          result.Stdout,
+		Stderr:          result.Stderr,
+		ExitCode:        result.ExitCode,
+		DurationSeconds: result.DurationSeconds,
+	}
+	if err != nil {
+		logReport.Result = "failed"
+		if logReport.Stderr == "" {
+			logReport.Stderr = result.ErrorMessage
+		}
+		if logReport.Stderr == "" {
+			logReport.Stderr = err.Error()
+		}
+	}
+
+	if reportErr := apiClient.ReportLog(s.agent.AgentID, logReport); reportErr != nil {
+		slog.Error("failed to report rollback result", "command_id", commandID, "error", reportErr)
+		elog.Error(1, fmt.Sprintf("Failed to report rollback result: %v", reportErr))
+	}
+
+	if err != nil {
+		return fmt.Errorf("rollback failed: %w", err)
+	}
+	elog.Info(1, fmt.Sprintf("Rolled back %s to %s", packageName, version))
+	return nil
+}
+
 func (s *redflagService) handleEnableHeartbeat(apiClient *client.Client, commandID string, params map[string]interface{}) error {
-	log.Printf("[Heartbeat] Enabling rapid polling with params: %v", params)
+	slog.Info("enabling rapid polling", "params", params)
 
 	// Parse duration parameter (default 60 minutes)
 	durationMinutes := 60
@@ -1170,7 +1515,7 @@ func (s *redflagService) handleEnableHeartbeat(apiClient *client.Client, command
 	// Save config
 	configPath := s.getConfigPath()
 	if err := s.agent.Save(configPath); err != nil {
-		log.Printf("[Heartbeat] Warning: Failed to save config: %v", err)
+		slog.Warn("failed to save heartbeat config", "error", err)
 	}
 
 	// Create log report
@@ -1185,11 +1530,11 @@ func (s *redflagService) handleEnableHeartbeat(apiClient *client.Client, command
 	}
 
 	if err := apiClient.ReportLog(s.agent.AgentID, logReport); err != nil {
-		log.Printf("[Heartbeat] Failed to report heartbeat enable: %v", err)
+		slog.Error("failed to report heartbeat enable", "command_id", commandID, "error", err)
 	}
 
 	// Send immediate check-in to update heartbeat status in UI
-	log.Printf("[Heartbeat] Sending immediate check-in to update status")
+	slog.Debug("sending immediate check-in to update heartbeat status")
 	sysMetrics, err := system.GetLightweightMetrics()
 	if err == nil {
 		metrics := &client.SystemMetrics{
@@ -1213,18 +1558,18 @@ func (s *redflagService) handleEnableHeartbeat(apiClient *client.Client, command
 		// Send immediate check-in with updated heartbeat status
 		_, checkinErr := apiClient.GetCommands(s.agent.AgentID, metrics)
 		if checkinErr != nil {
-			log.Printf("[Heartbeat] Failed to send immediate check-in: %v", checkinErr)
+			slog.Warn("failed to send immediate check-in", "error", checkinErr)
 		} else {
-			log.Printf("[Heartbeat] Immediate check-in sent successfully")
+			slog.Debug("immediate check-in sent")
 		}
 	}
 
-	log.Printf("[Heartbeat] Rapid polling enabled successfully")
+	slog.Info("rapid polling enabled")
 	return nil
 }
 
 func (s *redflagService) handleDisableHeartbeat(apiClient *client.Client, commandID string) error {
-	log.Printf("[Heartbeat] Disabling rapid polling")
+	slog.Info("disabling rapid polling")
 
 	// Update agent config to disable rapid polling
 	s.agent.RapidPollingEnabled = false
@@ -1233,7 +1578,7 @@ func (s *redflagService) handleDisableHeartbeat(apiClient *client.Client, comman
 	// Save config
 	configPath := s.getConfigPath()
 	if err := s.agent.Save(configPath); err != nil {
-		log.Printf("[Heartbeat] Warning: Failed to save config: %v", err)
+		slog.Warn("failed to save heartbeat config", "error", err)
 	}
 
 	// Create log report
@@ -1248,11 +1593,11 @@ func (s *redflagService) handleDisableHeartbeat(apiClient *client.Client, comman
 	}
 
 	if err := apiClient.ReportLog(s.agent.AgentID, logReport); err != nil {
-		log.Printf("[Heartbeat] Failed to report heartbeat disable: %v", err)
+		slog.Error("failed to report heartbeat disable", "command_id", commandID, "error", err)
 	}
 
 	// Send immediate check-in to update heartbeat status in UI
-	log.Printf("[Heartbeat] Sending immediate check-in to update status")
+	slog.Debug("sending immediate check-in to update heartbeat status")
 	sysMetrics, err := system.GetLightweightMetrics()
 	if err == nil {
 		metrics := &client.SystemMetrics{
@@ -1276,20 +1621,19 @@ func (s *redflagService) handleDisableHeartbeat(apiClient *client.Client, comman
 		// Send immediate check-in with updated heartbeat status
 		_, checkinErr := apiClient.GetCommands(s.agent.AgentID, metrics)
 		if checkinErr != nil {
-			log.Printf("[Heartbeat] Failed to send immediate check-in: %v", checkinErr)
+			slog.Warn("failed to send immediate check-in", "error", checkinErr)
 		} else {
-			log.Printf("[Heartbeat] Immediate check-in sent successfully")
+			slog.Debug("immediate check-in sent")
 		}
 	}
 
-	log.Printf("[Heartbeat] Rapid polling disabled successfully")
+	slog.Info("rapid polling disabled")
 	return nil
 }
 
 // RunConsole runs the agent in console mode with signal handling
 func RunConsole(cfg *config.Config) error {
-	log.Printf("🚩 RedFlag Agent starting in console mode...")
-	log.Printf("Press Ctrl+C to stop")
+	slog.Info("agent starting in console mode, press Ctrl+C to stop")
 
 	// Handle console signals
 	sigChan := make(chan os.Signal, 1)
@@ -1301,16 +1645,16 @@ func RunConsole(cfg *config.Config) error {
 	// Start agent in goroutine
 	go func() {
 		defer close(stopChan)
-		log.Printf("Agent console mode running...")
+		slog.Info("agent console mode running")
 		ticker := time.NewTicker(time.Duration(cfg.CheckInInterval) * time.Second)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
-				log.Printf("Checking in with server...")
+				slog.Debug("checking in with server")
 			case <-stopChan:
-				log.Printf("Shutting down console agent...")
+				slog.Info("shutting down console agent")
 				return
 			}
 		}
@@ -1318,12 +1662,12 @@ func RunConsole(cfg *config.Config) error {
 
 	// Wait for signal
 	<-sigChan
-	log.Printf("Received shutdown signal, stopping agent...")
+	slog.Info("received shutdown signal, stopping agent")
 
 	// Graceful shutdown
 	close(stopChan)
 	time.Sleep(2 * time.Second) // Allow cleanup
 
-	log.Printf("Agent stopped")
+	slog.Info("agent stopped")
 	return nil
 }
\ No newline at end of file
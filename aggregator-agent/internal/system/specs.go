@@ -0,0 +1,266 @@
+package system
+
+import (
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// Specs is the hardware and software inventory reported by collect_specs.
+// Unlike SystemInfo it only holds facts that rarely change, so the server can
+// keep a history of changes.
+type Specs struct {
+	OSType            string             `json:"os_type"`
+	OSVersion         string             `json:"os_version"`
+	OSArchitecture    string             `json:"os_architecture"`
+	KernelVersion     string             `json:"kernel_version,omitempty"`
+	CPUModel          string             `json:"cpu_model"`
+	CPUCores          int                `json:"cpu_cores"`
+	CPUThreads        int                `json:"cpu_threads"`
+	MemoryTotalMB     int                `json:"memory_total_mb"`
+	Disks             []SpecDisk         `json:"disks"`
+	NetworkInterfaces []NetworkInterface `json:"network_interfaces"`
+	DockerVersion     string             `json:"docker_version,omitempty"`
+	PackageManagers   []string           `json:"package_managers"`
+	DMI               DMIInfo            `json:"dmi"`
+	Virtualization    string             `json:"virtualization"` // none, or the hypervisor/container type
+}
+
+// SpecDisk is a mounted filesystem
+type SpecDisk struct {
+	Mountpoint string `json:"mountpoint"`
+	Device     string `json:"device"`
+	DiskType   string `json:"disk_type"`
+	TotalGB    int    `json:"total_gb"`
+	FreeGB     int    `json:"free_gb"`
+}
+
+// NetworkInterface is a network interface with its hardware address
+type NetworkInterface struct {
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+	MTU  int    `json:"mtu"`
+}
+
+// DMIInfo holds the SMBIOS system, board and BIOS identification
+type DMIInfo struct {
+	SystemVendor  string `json:"system_vendor,omitempty"`
+	SystemProduct string `json:"system_product,omitempty"`
+	SystemSerial  string `json:"system_serial,omitempty"`
+	BoardVendor   string `json:"board_vendor,omitempty"`
+	BoardProduct  string `json:"board_product,omitempty"`
+	BoardSerial   string `json:"board_serial,omitempty"`
+	BIOSVendor    string `json:"bios_vendor,omitempty"`
+	BIOSVersion   string `json:"bios_version,omitempty"`
+	BIOSDate      string `json:"bios_date,omitempty"`
+}
+
+// Package managers looked for on the PATH
+var specPackageManagers = []string{"apt", "dnf", "yum", "zypper", "pacman", "apk", "snap", "flatpak", "docker", "podman", "winget", "choco"}
+
+// CollectSpecs gathers the host's hardware and software inventory. Missing
+// pieces are left empty rather than failing the collection.
+func CollectSpecs() (*Specs, error) {
+	specs := &Specs{
+		OSType:            runtime.GOOS,
+		OSArchitecture:    runtime.GOARCH,
+		Disks:             []SpecDisk{},
+		NetworkInterfaces: []NetworkInterface{},
+		PackageManagers:   []string{},
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		specs.OSVersion = getLinuxDistroInfo()
+		if data, err := exec.Command("uname", "-r").Output(); err == nil {
+			specs.KernelVersion = strings.TrimSpace(string(data))
+		}
+		specs.DMI = getLinuxDMIInfo()
+	case "windows":
+		specs.OSVersion = getWindowsInfo()
+		specs.DMI = getWindowsDMIInfo()
+	case "darwin":
+		specs.OSVersion = getMacOSInfo()
+	}
+
+	if cpu, err := getCPUInfo(); err == nil {
+		specs.CPUModel = strings.TrimSpace(cpu.ModelName)
+		specs.CPUCores = cpu.Cores
+		specs.CPUThreads = cpu.Threads
+	}
+
+	if mem, err := getMemoryInfo(); err == nil {
+		specs.MemoryTotalMB = int(mem.Total / (1024 * 1024))
+	}
+
+	if disks, err := getDiskInfo(); err == nil {
+		for _, disk := range disks {
+			specs.Disks = append(specs.Disks, SpecDisk{
+				Mountpoint: disk.Mountpoint,
+				Device:     disk.Device,
+				DiskType:   disk.DiskType,
+				TotalGB:    int(disk.Total / (1024 * 1024 * 1024)),
+				FreeGB:     int(disk.Available / (1024 * 1024 * 1024)),
+			})
+		}
+		sort.Slice(specs.Disks, func(i, j int) bool { return specs.Disks[i].Mountpoint < specs.Disks[j].Mountpoint })
+	}
+
+	specs.NetworkInterfaces = getNetworkInterfaces()
+
+	for _, name := range specPackageManagers {
+		if _, err := exec.LookPath(name); err == nil {
+			specs.PackageManagers = append(specs.PackageManagers, name)
+		}
+	}
+
+	specs.DockerVersion = getDockerVersion()
+	specs.Virtualization = detectVirtualization(specs.DMI)
+
+	return specs, nil
+}
+
+// getNetworkInterfaces lists non-loopback interfaces that have a hardware
+// address. Addresses are left out: DHCP and IPv6 privacy addresses change too
+// often to be part of the inventory.
+func getNetworkInterfaces() []NetworkInterface {
+	nics := []NetworkInterface{}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return nics
+	}
+
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 || len(iface.HardwareAddr) == 0 {
+			continue
+		}
+		// Container and bridge plumbing comes and goes with every container
+		if strings.HasPrefix(iface.Name, "veth") || strings.HasPrefix(iface.Name, "br-") {
+			continue
+		}
+
+		nics = append(nics, NetworkInterface{
+			Name: iface.Name,
+			MAC:  iface.HardwareAddr.String(),
+			MTU:  iface.MTU,
+		})
+	}
+
+	sort.Slice(nics, func(i, j int) bool { return nics[i].Name < nics[j].Name })
+	return nics
+}
+
+// getDockerVersion returns the installed Docker version, if any
+func getDockerVersion() string {
+	data, err := exec.Command("docker", "version", "--format", "{{.Server.Version}}").Output()
+	if err == nil && strings.TrimSpace(string(data)) != "" {
+		return strings.TrimSpace(string(data))
+	}
+
+	// Without access to the daemon, fall back to the client version:
+	// "Docker version 24.0.7, build afdd53b"
+	data, err = exec.Command("docker", "--version").Output()
+	if err != nil {
+		return ""
+	}
+	version := strings.TrimPrefix(strings.TrimSpace(string(data)), "Docker version ")
+	if i := strings.Index(version, ","); i >= 0 {
+		version = version[:i]
+	}
+	return version
+}
+
+// getLinuxDMIInfo reads SMBIOS identification from sysfs. Serial numbers are
+// only readable by root, so those come from dmidecode via sudo.
+func getLinuxDMIInfo() DMIInfo {
+	read := func(name string) string {
+		data, err := os.ReadFile(filepath.Join("/sys/class/dmi/id", name))
+		if err != nil {
+			return ""
+		}
+		return cleanDMIValue(string(data))
+	}
+	serial := func(name, keyword string) string {
+		if value := read(name); value != "" {
+			return value
+		}
+		if os.Geteuid() == 0 {
+			return dmidecode(keyword)
+		}
+		if data, err := exec.Command("sudo", "-n", "dmidecode", "-s", keyword).Output(); err == nil {
+			return cleanDMIValue(string(data))
+		}
+		return ""
+	}
+
+	return DMIInfo{
+		SystemVendor:  read("sys_vendor"),
+		SystemProduct: read("product_name"),
+		SystemSerial:  serial("product_serial", "system-serial-number"),
+		BoardVendor:   read("board_vendor"),
+		BoardProduct:  read("board_name"),
+		BoardSerial:   serial("board_serial", "baseboard-serial-number"),
+		BIOSVendor:    read("bios_vendor"),
+		BIOSVersion:   read("bios_version"),
+		BIOSDate:      read("bios_date"),
+	}
+}
+
+func dmidecode(keyword string) string {
+	data, err := exec.Command("dmidecode", "-s", keyword).Output()
+	if err != nil {
+		return ""
+	}
+	return cleanDMIValue(string(data))
+}
+
+// cleanDMIValue trims a DMI string and drops the placeholders vendors leave in unset fields
+func cleanDMIValue(value string) string {
+	value = strings.TrimSpace(value)
+	switch strings.ToLower(value) {
+	case "", "none", "not specified", "not applicable", "default string", "to be filled by o.e.m.", "system serial number", "0":
+		return ""
+	}
+	return value
+}
+
+// detectVirtualization returns the hypervisor or container the host runs in, or "none"
+func detectVirtualization(dmi DMIInfo) string {
+	if runtime.GOOS == "linux" {
+		// Exits non-zero, printing "none", on bare metal
+		if data, err := exec.Command("systemd-detect-virt").Output(); err == nil {
+			if virt := strings.TrimSpace(string(data)); virt != "" {
+				return virt
+			}
+		}
+		if _, err := os.Stat("/.dockerenv"); err == nil {
+			return "docker"
+		}
+		if _, err := os.Stat("/run/.containerenv"); err == nil {
+			return "podman"
+		}
+	}
+
+	product := strings.ToLower(dmi.SystemVendor + " " + dmi.SystemProduct)
+	switch {
+	case strings.Contains(product, "vmware"):
+		return "vmware"
+	case strings.Contains(product, "virtualbox"):
+		return "oracle"
+	case strings.Contains(product, "kvm"), strings.Contains(product, "qemu"):
+		return "kvm"
+	case strings.Contains(product, "microsoft") && strings.Contains(product, "virtual"):
+		return "microsoft"
+	case strings.Contains(product, "xen"):
+		return "xen"
+	case strings.Contains(product, "amazon ec2"):
+		return "amazon"
+	case strings.Contains(product, "google compute engine"):
+		return "google"
+	}
+	return "none"
+}
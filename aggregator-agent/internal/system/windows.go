@@ -425,4 +425,48 @@ func getWindowsHardwareInfo() map[string]string {
 	}
 
 	return hardware
-}
\ No newline at end of file
+}
+
+// getWindowsDMIInfo reads SMBIOS identification through WMI
+func getWindowsDMIInfo() DMIInfo {
+	query := func(class string, fields ...string) map[string]string {
+		values := make(map[string]string)
+		cmd, err := exec.LookPath("wmic")
+		if err != nil {
+			return values
+		}
+		data, err := exec.Command(cmd, append([]string{class, "get", strings.Join(fields, ",")}, "/value")...).Output()
+		if err != nil {
+			return values
+		}
+		// /value prints one Key=Value pair per line
+		for _, line := range strings.Split(string(data), "\n") {
+			if key, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok {
+				values[key] = cleanDMIValue(value)
+			}
+		}
+		return values
+	}
+
+	system := query("computersystem", "Manufacturer", "Model")
+	bios := query("bios", "Manufacturer", "SMBIOSBIOSVersion", "ReleaseDate", "SerialNumber")
+	board := query("baseboard", "Manufacturer", "Product", "SerialNumber")
+
+	releaseDate := bios["ReleaseDate"]
+	if len(releaseDate) >= 8 {
+		// WMI datetime: yyyymmddHHMMSS.ffffff+UUU
+		releaseDate = releaseDate[4:6] + "/" + releaseDate[6:8] + "/" + releaseDate[:4]
+	}
+
+	return DMIInfo{
+		SystemVendor:  system["Manufacturer"],
+		SystemProduct: system["Model"],
+		SystemSerial:  bios["SerialNumber"],
+		BoardVendor:   board["Manufacturer"],
+		BoardProduct:  board["Product"],
+		BoardSerial:   board["SerialNumber"],
+		BIOSVendor:    bios["Manufacturer"],
+		BIOSVersion:   bios["SMBIOSBIOSVersion"],
+		BIOSDate:      releaseDate,
+	}
+}
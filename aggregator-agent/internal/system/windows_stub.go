@@ -36,4 +36,8 @@ func getWindowsHardwareInfo() map[string]string {
 
 func getWindowsInfo() string {
 	return "Windows"
-}
\ No newline at end of file
+}
+
+func getWindowsDMIInfo() DMIInfo {
+	return DMIInfo{}
+}
@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/Fimeg/RedFlag/aggregator-agent"
+
+// ParamKey is the command params key the server uses to send W3C trace context
+const ParamKey = "trace_context"
+
+// Setup installs the global tracer provider and W3C propagator. Trace context
+// received with commands is always forwarded to the server in request headers,
+// even when no exporter is configured and the agent records no spans itself.
+// The returned function flushes pending spans.
+func Setup(cfg config.TracingConfig, agentID, version string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{},
+	))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case "otlp":
+		var opts []otlptracehttp.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter: %s", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", cfg.Exporter, err)
+	}
+
+	res, err := resource.New(context.Background(),
+		resource.WithFromEnv(),
+		resource.WithHost(),
+		resource.WithAttributes(
+			attribute.String("service.name", "redflag-agent"),
+			attribute.String("service.version", version),
+			attribute.String("service.instance.id", agentID),
+		),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		// The server decides whether a command's trace is sampled
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// StartCommand starts a span for executing a command, continuing the trace
+// the server attached to the command params
+func StartCommand(commandID, commandType string, params map[string]interface{}) (context.Context, trace.Span) {
+	ctx := context.Background()
+	if tc, ok := params[ParamKey].(map[string]interface{}); ok {
+		carrier := propagation.MapCarrier{}
+		for k, v := range tc {
+			if s, ok := v.(string); ok {
+				carrier[k] = s
+			}
+		}
+		ctx = otel.GetTextMapPropagator().Extract(ctx, carrier)
+	}
+
+	return otel.Tracer(instrumentationName).Start(ctx, "agent.command "+commandType,
+		trace.WithAttributes(
+			attribute.String("redflag.command_id", commandID),
+			attribute.String("redflag.command_type", commandType),
+		),
+	)
+}
+
+// End records err (if any) on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/client"
+	"github.com/Fimeg/RedFlag/aggregator-agent/internal/config"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTest(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	if _, err := Setup(config.TracingConfig{}, "agent-1", "test"); err != nil {
+		t.Fatal(err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+	return exporter
+}
+
+// serverParams returns command params carrying trace context the way the
+// server sends it, and the span context they carry
+func serverParams(t *testing.T) (map[string]interface{}, trace.SpanContext) {
+	t.Helper()
+	serverProvider := sdktrace.NewTracerProvider()
+	defer serverProvider.Shutdown(context.Background())
+	ctx, span := serverProvider.Tracer("server").Start(context.Background(), "command.dispatch")
+	span.End()
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	tc := make(map[string]interface{}, len(carrier))
+	for k, v := range carrier {
+		tc[k] = v
+	}
+	return map[string]interface{}{ParamKey: tc}, span.SpanContext()
+}
+
+func TestStartCommandContinuesServerTrace(t *testing.T) {
+	exporter := setupTest(t)
+	params, server := serverParams(t)
+
+	_, span := StartCommand("cmd-1", "scan_updates", params)
+	End(span, nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	if spans[0].SpanContext.TraceID() != server.TraceID() || spans[0].Parent.SpanID() != server.SpanID() {
+		t.Fatalf("command span doesn't continue the server's trace")
+	}
+	if !spans[0].Parent.IsRemote() {
+		t.Errorf("command span's parent isn't marked remote")
+	}
+}
+
+func TestStartCommandWithoutTraceContext(t *testing.T) {
+	exporter := setupTest(t)
+
+	_, span := StartCommand("cmd-1", "scan_updates", map[string]interface{}{ParamKey: "garbage"})
+	End(span, nil)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 || spans[0].Parent.IsValid() {
+		t.Fatalf("command without trace context should start a new trace")
+	}
+}
+
+func TestCommandRequestsCarryTraceparent(t *testing.T) {
+	exporter := setupTest(t)
+	params, server := serverParams(t)
+
+	received := make(chan trace.SpanContext, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		received <- trace.SpanContextFromContext(ctx)
+		w.Write([]byte(`{}`))
+	}))
+	defer srv.Close()
+
+	ctx, span := StartCommand("cmd-1", "scan_updates", params)
+	err := client.NewClient(srv.URL, "token").WithContext(ctx).ReportLog(uuid.New(), client.LogReport{
+		CommandID: "cmd-1",
+		Action:    "scan",
+		Result:    "success",
+	})
+	End(span, err)
+	if err != nil {
+		t.Fatalf("ReportLog: %v", err)
+	}
+
+	got := <-received
+	if got.TraceID() != server.TraceID() {
+		t.Fatalf("request traceparent trace %s, want the server's %s", got.TraceID(), server.TraceID())
+	}
+
+	// The header names the agent's HTTP client span, a child of the command span
+	var command, request tracetest.SpanStub
+	for _, s := range exporter.GetSpans() {
+		switch s.SpanKind {
+		case trace.SpanKindClient:
+			request = s
+		case trace.SpanKindInternal:
+			command = s
+		}
+	}
+	if got.SpanID() != request.SpanContext.SpanID() {
+		t.Errorf("traceparent names span %s, want the HTTP client span %s", got.SpanID(), request.SpanContext.SpanID())
+	}
+	if request.Parent.SpanID() != command.SpanContext.SpanID() {
+		t.Errorf("HTTP client span isn't a child of the command span")
+	}
+}
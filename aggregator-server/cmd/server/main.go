@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
+	"net/http"
+	"os"
 	"path/filepath"
 	"time"
 
@@ -12,13 +17,19 @@ import (
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/config"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/database"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/events"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/logging"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/pki"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/services"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/signing"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/tracing"
 	"github.com/gin-gonic/gin"
 )
 
 func startWelcomeModeServer() {
 	setupHandler := handlers.NewSetupHandler("/app/config")
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery(), middleware.RequestIDMiddleware(), middleware.RequestLogger())
 
 	// Add CORS middleware
 	router.Use(middleware.CORSMiddleware())
@@ -43,8 +54,7 @@ func startWelcomeModeServer() {
 	// Setup endpoint for web configuration
 	router.GET("/setup", setupHandler.ShowSetupPage)
 
-	log.Printf("Welcome mode server started on :8080")
-	log.Printf("Waiting for configuration...")
+	slog.Info("welcome mode server started, waiting for configuration", "addr", ":8080")
 
 	if err := router.Run(":8080"); err != nil {
 		log.Fatal("Failed to start welcome mode server:", err)
@@ -59,6 +69,16 @@ func main() {
 	flag.BoolVar(&setup, "setup", false, "Run setup wizard")
 	flag.BoolVar(&migrate, "migrate", false, "Run database migrations only")
 	flag.BoolVar(&version, "version", false, "Show version information")
+	var genSigningKey string
+	var signBinaries bool
+	var signingKey string
+	var agentDir string
+	var releaseVersion string
+	flag.StringVar(&genSigningKey, "gen-signing-key", "", "Generate an agent release signing key at this path")
+	flag.BoolVar(&signBinaries, "sign-binaries", false, "Sign the agent binaries under -agent-dir with -signing-key")
+	flag.StringVar(&signingKey, "signing-key", os.Getenv("REDFLAG_SIGNING_KEY_FILE"), "Agent release signing key (PEM)")
+	flag.StringVar(&agentDir, "agent-dir", "/app", "Directory containing binaries/{platform}/")
+	flag.StringVar(&releaseVersion, "release-version", os.Getenv("LATEST_AGENT_VERSION"), "Agent version the binaries are signed as (must match LATEST_AGENT_VERSION)")
 	flag.Parse()
 
 	// Handle special commands
@@ -68,6 +88,41 @@ func main() {
 		return
 	}
 
+	if genSigningKey != "" {
+		publicKey, err := signing.GenerateKey(genSigningKey)
+		if err != nil {
+			log.Fatal("Failed to generate signing key:", err)
+		}
+		fmt.Printf("Signing key written to %s - keep it out of the server image\n", genSigningKey)
+		fmt.Printf("Set on the server: REDFLAG_UPDATE_PUBLIC_KEY=%s\n", publicKey)
+		return
+	}
+
+	if signBinaries {
+		if signingKey == "" {
+			log.Fatal("-sign-binaries requires -signing-key or REDFLAG_SIGNING_KEY_FILE")
+		}
+		if releaseVersion == "" {
+			log.Fatal("-sign-binaries requires -release-version or LATEST_AGENT_VERSION")
+		}
+		priv, err := signing.LoadPrivateKey(signingKey)
+		if err != nil {
+			log.Fatal("Failed to load signing key:", err)
+		}
+		releases, err := services.NewReleaseService(agentDir, releaseVersion, "").SignBinaries(priv)
+		if err != nil {
+			log.Fatal("Failed to sign agent binaries:", err)
+		}
+		if len(releases) == 0 {
+			log.Fatalf("No agent binaries found under %s", filepath.Join(agentDir, "binaries"))
+		}
+		for _, release := range releases {
+			fmt.Printf("Signed %-14s %s sha256=%s\n", release.Platform, release.Version, release.SHA256)
+		}
+		fmt.Printf("Public key: %s\n", signing.PublicKeyString(priv))
+		return
+	}
+
 	if setup {
 		if err := config.RunSetupWizard(); err != nil {
 			log.Fatal("Setup failed:", err)
@@ -75,18 +130,41 @@ func main() {
 		return
 	}
 
+	// Structured JSON logging; the level is applied once configuration is loaded
+	logging.Setup(os.Stdout)
+	logging.SetLevel(os.Getenv("REDFLAG_LOG_LEVEL"))
+
 	// Load configuration
 	cfg, err := config.Load()
 	if err != nil {
-		log.Printf("Server waiting for configuration: %v", err)
-		log.Printf("Run: docker-compose exec server ./redflag-server --setup")
-		log.Printf("Or configure via web interface at: http://localhost:8080/setup")
+		slog.Warn("server waiting for configuration", "error", err,
+			"setup_command", "docker-compose exec server ./redflag-server --setup",
+			"setup_url", "http://localhost:8080/setup")
 
 		// Start welcome mode server
 		startWelcomeModeServer()
 		return
 	}
 
+	logging.SetLevel(cfg.Logging.Level)
+	logging.AddSecret(cfg.Admin.JWTSecret, cfg.Admin.Password, cfg.Database.Password)
+
+	// Tracing (spans are only recorded when an exporter is configured)
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Config{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		SampleRatio: cfg.Tracing.SampleRatio,
+		Version:     "0.1.0-alpha",
+	})
+	if err != nil {
+		log.Fatal("Failed to set up tracing:", err)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdownTracing(ctx)
+	}()
+
 	// Set JWT secret
 	middleware.JWTSecret = cfg.Admin.JWTSecret
 
@@ -107,7 +185,7 @@ func main() {
 		if err := db.Migrate(migrationsPath); err != nil {
 			log.Fatal("Migration failed:", err)
 		}
-		fmt.Printf("✅ Database migrations completed\n")
+		slog.Info("database migrations completed")
 		return
 	}
 
@@ -116,7 +194,7 @@ func main() {
 	if err := db.Migrate(migrationsPath); err != nil {
 		// For development, continue even if migrations fail
 		// In production, you might want to handle this more gracefully
-		fmt.Printf("Warning: Migration failed (tables may already exist): %v\n", err)
+		slog.Warn("migration failed (tables may already exist)", "error", err)
 	}
 
 	// Initialize queries
@@ -126,34 +204,71 @@ func main() {
 	refreshTokenQueries := queries.NewRefreshTokenQueries(db.DB)
 	registrationTokenQueries := queries.NewRegistrationTokenQueries(db.DB)
 	userQueries := queries.NewUserQueries(db.DB)
+	alertQueries := queries.NewAlertQueries(db.DB)
+	rolloutQueries := queries.NewRolloutQueries(db.DB)
+	agentCertificateQueries := queries.NewAgentCertificateQueries(db.DB)
+	configProfileQueries := queries.NewConfigProfileQueries(db.DB)
+	agentSpecsQueries := queries.NewAgentSpecsQueries(db.DB)
+
+	// Event bus for live operations (keeps recent history so SSE clients can resume)
+	eventBus := events.NewBus(1000)
+	agentQueries.SetEventBus(eventBus)
+	commandQueries.SetEventBus(eventBus)
 
 	// Ensure admin user exists
 	if err := userQueries.EnsureAdminUser(cfg.Admin.Username, cfg.Admin.Username+"@redflag.local", cfg.Admin.Password); err != nil {
-		fmt.Printf("Warning: Failed to create admin user: %v\n", err)
+		slog.Warn("failed to create admin user", "error", err)
 	} else {
-		fmt.Println("✅ Admin user ensured")
+		slog.Info("admin user ensured", "username", cfg.Admin.Username)
 	}
 
 	// Initialize services
 	timezoneService := services.NewTimezoneService(cfg)
 	timeoutService := services.NewTimeoutService(commandQueries, updateQueries)
 
+	// Alert notification channels - the server log always, plus a webhook if configured
+	notifiers := []services.Notifier{services.NewLogNotifier()}
+	if cfg.Alerting.WebhookURL != "" {
+		notifiers = append(notifiers, services.NewWebhookNotifier(cfg.Alerting.WebhookURL))
+	}
+	alertService := services.NewAlertService(alertQueries, agentQueries, commandQueries, cfg.CheckInInterval, notifiers...)
+	rolloutService := services.NewRolloutService(rolloutQueries, updateQueries, agentQueries, commandQueries, eventBus)
+
 	// Initialize rate limiter
 	rateLimiter := middleware.NewRateLimiter()
 
 	// Initialize handlers
-	agentHandler := handlers.NewAgentHandler(agentQueries, commandQueries, refreshTokenQueries, registrationTokenQueries, cfg.CheckInInterval, cfg.LatestAgentVersion)
-	updateHandler := handlers.NewUpdateHandler(updateQueries, agentQueries, commandQueries, agentHandler)
+	releaseService := services.NewReleaseService("/app", cfg.LatestAgentVersion, cfg.Updates.PublicKey)
+	agentHandler := handlers.NewAgentHandler(agentQueries, commandQueries, refreshTokenQueries, registrationTokenQueries, configProfileQueries, alertService, releaseService, cfg.CheckInInterval, cfg.LatestAgentVersion)
+	agentHandler.SetEventBus(eventBus)
+	updateHandler := handlers.NewUpdateHandler(updateQueries, agentQueries, commandQueries, agentHandler, eventBus, rolloutService)
 	authHandler := handlers.NewAuthHandler(cfg.Admin.JWTSecret, userQueries)
 	statsHandler := handlers.NewStatsHandler(agentQueries, updateQueries)
 	settingsHandler := handlers.NewSettingsHandler(timezoneService)
 	dockerHandler := handlers.NewDockerHandler(updateQueries, agentQueries, commandQueries)
 	registrationTokenHandler := handlers.NewRegistrationTokenHandler(registrationTokenQueries, agentQueries, cfg)
 	rateLimitHandler := handlers.NewRateLimitHandler(rateLimiter)
-	downloadHandler := handlers.NewDownloadHandler(filepath.Join("/app"), cfg)
+	downloadHandler := handlers.NewDownloadHandler(filepath.Join("/app"), cfg, releaseService)
+	alertHandler := handlers.NewAlertHandler(alertQueries)
+	rolloutHandler := handlers.NewRolloutHandler(rolloutQueries, rolloutService)
+	configProfileHandler := handlers.NewConfigProfileHandler(configProfileQueries, agentQueries)
+	specsHandler := handlers.NewSpecsHandler(agentSpecsQueries, agentQueries, commandQueries)
+	eventHandler := handlers.NewEventHandler(eventBus)
+
+	// mTLS: the internal CA issues agent client certificates at registration
+	var agentCA *pki.CA
+	if cfg.Server.MTLS.Mode != "off" {
+		agentCA, err = pki.LoadOrCreateCA(cfg.Server.MTLS.CADir)
+		if err != nil {
+			log.Fatalf("Failed to load agent CA: %v", err)
+		}
+		agentHandler.SetCertificateAuthority(agentCA, agentCertificateQueries, time.Duration(cfg.Server.MTLS.CertDays)*24*time.Hour, cfg.Server.MTLS.Mode == "required")
+		slog.Info("agent mTLS enabled", "mode", cfg.Server.MTLS.Mode, "ca_dir", cfg.Server.MTLS.CADir)
+	}
 
 	// Setup router
-	router := gin.Default()
+	router := gin.New()
+	router.Use(gin.Recovery(), middleware.RequestIDMiddleware(), middleware.RequestLogger(), middleware.TracingMiddleware())
 
 	// Add CORS middleware
 	router.Use(middleware.CORSMiddleware())
@@ -179,19 +294,26 @@ func main() {
 		api.POST("/agents/renew", rateLimiter.RateLimit("public_access", middleware.KeyByIP), agentHandler.RenewToken)
 
 		// Public download routes (no authentication - agents need these!)
+		api.GET("/downloads/manifest", rateLimiter.RateLimit("public_access", middleware.KeyByIP), downloadHandler.GetManifest)
 		api.GET("/downloads/:platform", rateLimiter.RateLimit("public_access", middleware.KeyByIP), downloadHandler.DownloadAgent)
 		api.GET("/install/:platform", rateLimiter.RateLimit("public_access", middleware.KeyByIP), downloadHandler.InstallScript)
 
 		// Protected agent routes
 		agents := api.Group("/agents")
 		agents.Use(middleware.AuthMiddleware())
+		if agentCA != nil {
+			agents.Use(middleware.AgentCertificateMiddleware(agentCertificateQueries, cfg.Server.MTLS.Mode == "required"))
+		}
 		{
 			agents.GET("/:id/commands", agentHandler.GetCommands)
 			agents.POST("/:id/updates", rateLimiter.RateLimit("agent_reports", middleware.KeyByAgentID), updateHandler.ReportUpdates)
 			agents.POST("/:id/logs", rateLimiter.RateLimit("agent_reports", middleware.KeyByAgentID), updateHandler.ReportLog)
+			agents.POST("/:id/commands/:command_id/output", rateLimiter.RateLimit("agent_reports", middleware.KeyByAgentID), updateHandler.ReportCommandOutput)
 			agents.POST("/:id/dependencies", rateLimiter.RateLimit("agent_reports", middleware.KeyByAgentID), updateHandler.ReportDependencies)
 			agents.POST("/:id/system-info", rateLimiter.RateLimit("agent_reports", middleware.KeyByAgentID), agentHandler.ReportSystemInfo)
+			agents.POST("/:id/specs", rateLimiter.RateLimit("agent_reports", middleware.KeyByAgentID), specsHandler.ReportSpecs)
 			agents.POST("/:id/rapid-mode", rateLimiter.RateLimit("agent_reports", middleware.KeyByAgentID), agentHandler.SetRapidPollingMode)
+			agents.POST("/:id/certificate", rateLimiter.RateLimit("agent_reports", middleware.KeyByAgentID), agentHandler.RenewCertificate)
 			agents.DELETE("/:id", agentHandler.UnregisterAgent)
 		}
 
@@ -207,6 +329,10 @@ func main() {
 			dashboard.POST("/agents/:id/heartbeat", agentHandler.TriggerHeartbeat)
 			dashboard.GET("/agents/:id/heartbeat", agentHandler.GetHeartbeatStatus)
 			dashboard.POST("/agents/:id/reboot", agentHandler.TriggerReboot)
+			dashboard.POST("/agents/:id/update-agent", agentHandler.TriggerAgentUpdate)
+			dashboard.GET("/agents/:id/specs", specsHandler.ListAgentSpecs)
+			dashboard.GET("/agents/:id/specs/diff", specsHandler.DiffAgentSpecs)
+			dashboard.POST("/agents/:id/specs/collect", specsHandler.CollectSpecs)
 			dashboard.GET("/updates", updateHandler.ListUpdates)
 			dashboard.GET("/updates/:id", updateHandler.GetUpdate)
 			dashboard.GET("/updates/:id/logs", updateHandler.GetUpdateLogs)
@@ -214,17 +340,22 @@ func main() {
 			dashboard.POST("/updates/approve", updateHandler.ApproveUpdates)
 			dashboard.POST("/updates/:id/reject", updateHandler.RejectUpdate)
 			dashboard.POST("/updates/:id/install", updateHandler.InstallUpdate)
+			dashboard.POST("/updates/:id/rollback", updateHandler.RollbackUpdate)
 			dashboard.POST("/updates/:id/confirm-dependencies", updateHandler.ConfirmDependencies)
 
 			// Log routes
 			dashboard.GET("/logs", updateHandler.GetAllLogs)
 			dashboard.GET("/logs/active", updateHandler.GetActiveOperations)
 
+			// Live operations event stream (Server-Sent Events)
+			dashboard.GET("/events/stream", eventHandler.StreamEvents)
+
 			// Command routes
 			dashboard.GET("/commands/active", updateHandler.GetActiveCommands)
 			dashboard.GET("/commands/recent", updateHandler.GetRecentCommands)
 			dashboard.POST("/commands/:id/retry", updateHandler.RetryCommand)
 			dashboard.POST("/commands/:id/cancel", updateHandler.CancelCommand)
+			dashboard.GET("/commands/:id/output", updateHandler.GetCommandOutput)
 			dashboard.DELETE("/commands/failed", updateHandler.ClearFailedCommands)
 
 			// Settings routes
@@ -232,9 +363,39 @@ func main() {
 			dashboard.GET("/settings/timezones", settingsHandler.GetTimezones)
 			dashboard.PUT("/settings/timezone", settingsHandler.UpdateTimezone)
 
+			// Alerting routes
+			dashboard.GET("/alerts", alertHandler.ListAlerts)
+			dashboard.POST("/alerts/:id/acknowledge", alertHandler.AcknowledgeAlert)
+			dashboard.GET("/alerts/rules", alertHandler.ListAlertRules)
+			dashboard.POST("/alerts/rules", alertHandler.CreateAlertRule)
+			dashboard.PUT("/alerts/rules/:id", alertHandler.UpdateAlertRule)
+			dashboard.DELETE("/alerts/rules/:id", alertHandler.DeleteAlertRule)
+			dashboard.GET("/alerts/silences", alertHandler.ListSilences)
+			dashboard.POST("/alerts/silences", alertHandler.CreateSilence)
+			dashboard.DELETE("/alerts/silences/:id", alertHandler.DeleteSilence)
+
+			// Staged rollout routes
+			dashboard.GET("/rollouts", rolloutHandler.ListRollouts)
+			dashboard.POST("/rollouts", rolloutHandler.CreateRollout)
+			dashboard.GET("/rollouts/:id", rolloutHandler.GetRollout)
+			dashboard.POST("/rollouts/:id/pause", rolloutHandler.PauseRollout)
+			dashboard.POST("/rollouts/:id/resume", rolloutHandler.ResumeRollout)
+			dashboard.POST("/rollouts/:id/abort", rolloutHandler.AbortRollout)
+
+			// Server-managed agent configuration
+			dashboard.GET("/config-profiles", configProfileHandler.ListConfigProfiles)
+			dashboard.POST("/config-profiles", configProfileHandler.CreateConfigProfile)
+			dashboard.GET("/config-profiles/status", configProfileHandler.ListAgentConfigStatus)
+			dashboard.GET("/config-profiles/:id", configProfileHandler.GetConfigProfile)
+			dashboard.PUT("/config-profiles/:id", configProfileHandler.UpdateConfigProfile)
+			dashboard.DELETE("/config-profiles/:id", configProfileHandler.DeleteConfigProfile)
+			dashboard.PUT("/agents/:id/config-profile", configProfileHandler.SetAgentConfigProfile)
+
 			// Docker routes
 			dashboard.GET("/docker/containers", dockerHandler.GetContainers)
 			dashboard.GET("/docker/stats", dockerHandler.GetStats)
+			dashboard.GET("/docker/stacks", dockerHandler.GetComposeStacks)
+			dashboard.POST("/docker/stacks/:agent_id/:project/approve", dockerHandler.ApproveComposeStack)
 			dashboard.POST("/docker/containers/:container_id/images/:image_id/approve", dockerHandler.ApproveUpdate)
 			dashboard.POST("/docker/containers/:container_id/images/:image_id/reject", dockerHandler.RejectUpdate)
 			dashboard.POST("/docker/containers/:container_id/images/:image_id/install", dockerHandler.InstallUpdate)
@@ -275,7 +436,7 @@ func main() {
 			case <-ticker.C:
 				// Mark agents as offline if they haven't checked in within 10 minutes
 				if err := agentQueries.MarkOfflineAgents(10 * time.Minute); err != nil {
-					log.Printf("Failed to mark offline agents: %v", err)
+					slog.Error("failed to mark offline agents", "error", err)
 				}
 			}
 		}
@@ -283,21 +444,50 @@ func main() {
 
 	// Start timeout service
 	timeoutService.Start()
-	log.Println("Timeout service started")
 
 	// Add graceful shutdown for timeout service
 	defer func() {
 		timeoutService.Stop()
-		log.Println("Timeout service stopped")
 	}()
 
+	// Start alert service
+	alertService.Start()
+	defer alertService.Stop()
+
+	// Start rollout service
+	rolloutService.Start()
+	defer rolloutService.Stop()
+
 	// Start server
 	addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
-	fmt.Printf("\nRedFlag Aggregator Server starting on %s\n", addr)
-	fmt.Printf("Admin interface: http://%s:%d/admin\n", cfg.Server.Host, cfg.Server.Port)
-	fmt.Printf("Dashboard: http://%s:%d\n\n", cfg.Server.Host, cfg.Server.Port)
+	scheme := "http"
+	if cfg.Server.TLS.Enabled {
+		scheme = "https"
+	}
+	slog.Info("RedFlag Aggregator Server starting", "addr", addr, "tls", cfg.Server.TLS.Enabled,
+		"admin_url", fmt.Sprintf("%s://%s:%d/admin", scheme, cfg.Server.Host, cfg.Server.Port),
+		"dashboard_url", fmt.Sprintf("%s://%s:%d", scheme, cfg.Server.Host, cfg.Server.Port))
 
-	if err := router.Run(addr); err != nil {
+	if !cfg.Server.TLS.Enabled {
+		if err := router.Run(addr); err != nil {
+			log.Fatal("Failed to start server:", err)
+		}
+		return
+	}
+
+	server := &http.Server{
+		Addr:    addr,
+		Handler: router.Handler(),
+		TLSConfig: &tls.Config{
+			MinVersion: tls.VersionTLS12,
+		},
+	}
+	if agentCA != nil {
+		// Browsers connect without a certificate; agent routes enforce it per mode
+		server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		server.TLSConfig.ClientCAs = agentCA.Pool()
+	}
+	if err := server.ListenAndServeTLS(cfg.Server.TLS.CertFile, cfg.Server.TLS.KeyFile); err != nil {
 		log.Fatal("Failed to start server:", err)
 	}
 }
@@ -1,17 +1,25 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/api/middleware"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/events"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/pki"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/services"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/tracing"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type AgentHandler struct {
@@ -19,21 +27,122 @@ type AgentHandler struct {
 	commandQueries           *queries.CommandQueries
 	refreshTokenQueries      *queries.RefreshTokenQueries
 	registrationTokenQueries *queries.RegistrationTokenQueries
+	configProfileQueries     *queries.ConfigProfileQueries
+	alertService             *services.AlertService
+	releaseService           *services.ReleaseService
 	checkInInterval          int
 	latestAgentVersion       string
+
+	// Long polling of GET /commands; disabled while eventBus is nil
+	eventBus *events.Bus
+
+	// mTLS; ca is nil when the server doesn't issue client certificates
+	ca                 *pki.CA
+	certificateQueries *queries.AgentCertificateQueries
+	certValidity       time.Duration
+	certRequired       bool
 }
 
-func NewAgentHandler(aq *queries.AgentQueries, cq *queries.CommandQueries, rtq *queries.RefreshTokenQueries, regTokenQueries *queries.RegistrationTokenQueries, checkInInterval int, latestAgentVersion string) *AgentHandler {
+func NewAgentHandler(aq *queries.AgentQueries, cq *queries.CommandQueries, rtq *queries.RefreshTokenQueries, regTokenQueries *queries.RegistrationTokenQueries, configProfileQueries *queries.ConfigProfileQueries, alertService *services.AlertService, releaseService *services.ReleaseService, checkInInterval int, latestAgentVersion string) *AgentHandler {
 	return &AgentHandler{
 		agentQueries:             aq,
 		commandQueries:           cq,
 		refreshTokenQueries:      rtq,
 		registrationTokenQueries: regTokenQueries,
+		configProfileQueries:     configProfileQueries,
+		alertService:             alertService,
+		releaseService:           releaseService,
 		checkInInterval:          checkInInterval,
 		latestAgentVersion:       latestAgentVersion,
 	}
 }
 
+// SetCertificateAuthority enables issuing agent client certificates. With
+// required set, agents must send a CSR when registering.
+func (h *AgentHandler) SetCertificateAuthority(ca *pki.CA, certQueries *queries.AgentCertificateQueries, validity time.Duration, required bool) {
+	h.ca = ca
+	h.certificateQueries = certQueries
+	h.certValidity = validity
+	h.certRequired = required
+}
+
+// SetEventBus enables long polling: agents may ask GetCommands to hold the
+// request until a command is queued for them
+func (h *AgentHandler) SetEventBus(bus *events.Bus) {
+	h.eventBus = bus
+}
+
+// maxLongPollWait caps how long GetCommands holds a request open
+const maxLongPollWait = 60 * time.Second
+
+// longPollWait returns the wait an agent asked for with ?wait=<seconds>, or 0
+// for a normal check-in
+func (h *AgentHandler) longPollWait(c *gin.Context) time.Duration {
+	if h.eventBus == nil {
+		return 0
+	}
+	seconds, err := strconv.Atoi(c.Query("wait"))
+	if err != nil || seconds <= 0 {
+		return 0
+	}
+	wait := time.Duration(seconds) * time.Second
+	if wait > maxLongPollWait {
+		wait = maxLongPollWait
+	}
+	return wait
+}
+
+// waitForCommand blocks until a pending command is queued for the agent, the
+// wait runs out or the agent goes away. Returns true if a command was queued.
+func waitForCommand(c *gin.Context, sub *events.Subscription, wait time.Duration) bool {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// Dropped as a slow subscriber; let the caller look again
+				return true
+			}
+			if status, _ := e.Data["status"].(string); status == models.CommandStatusPending {
+				return true
+			}
+		case <-timer.C:
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+	}
+}
+
+// issueCertificate signs an agent's CSR and records the certificate so its
+// serial can be checked and revoked
+func (h *AgentHandler) issueCertificate(agentID uuid.UUID, csr string) (*models.AgentCertificate, error) {
+	issued, err := h.ca.IssueAgentCertificate(csr, agentID, h.certValidity)
+	if err != nil {
+		return nil, err
+	}
+	return h.recordCertificate(agentID, issued)
+}
+
+// recordCertificate stores a signed certificate. Agents are only accepted with
+// recorded certificates, so one that is signed but never recorded can't be used.
+func (h *AgentHandler) recordCertificate(agentID uuid.UUID, issued *pki.IssuedCertificate) (*models.AgentCertificate, error) {
+	if err := h.certificateQueries.CreateAgentCertificate(&queries.AgentCertificate{
+		SerialNumber: issued.SerialNumber,
+		AgentID:      agentID,
+		Fingerprint:  issued.Fingerprint,
+		NotBefore:    issued.NotBefore,
+		NotAfter:     issued.NotAfter,
+	}); err != nil {
+		return nil, err
+	}
+
+	slog.Info("issued client certificate", "serial", issued.SerialNumber, "agent_id", agentID, "expires", issued.NotAfter.Format(time.RFC3339))
+	return &models.AgentCertificate{Certificate: issued.PEM, NotAfter: issued.NotAfter}, nil
+}
+
 // RegisterAgent handles agent registration
 func (h *AgentHandler) RegisterAgent(c *gin.Context) {
 	var req models.AgentRegistrationRequest
@@ -71,18 +180,25 @@ func (h *AgentHandler) RegisterAgent(c *gin.Context) {
 		return
 	}
 
+	if h.ca != nil && h.certRequired && req.CSR == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "server requires mTLS: registration must include a csr"})
+		return
+	}
+
 	// Create new agent
 	agent := &models.Agent{
-		ID:             uuid.New(),
-		Hostname:       req.Hostname,
-		OSType:         req.OSType,
-		OSVersion:      req.OSVersion,
-		OSArchitecture: req.OSArchitecture,
-		AgentVersion:   req.AgentVersion,
-		CurrentVersion: req.AgentVersion,
-		LastSeen:       time.Now(),
-		Status:         "online",
-		Metadata:       models.JSONB{},
+		ID:              uuid.New(),
+		Hostname:        req.Hostname,
+		OSType:          req.OSType,
+		OSVersion:       req.OSVersion,
+		OSArchitecture:  req.OSArchitecture,
+		AgentVersion:    req.AgentVersion,
+		CurrentVersion:  req.AgentVersion,
+		LastSeen:        time.Now(),
+		Status:          "online",
+		Metadata:        models.JSONB{},
+		ProtocolVersion: req.ProtocolVersion,
+		Capabilities:    req.Capabilities,
 	}
 
 	// Add metadata if provided
@@ -92,6 +208,18 @@ func (h *AgentHandler) RegisterAgent(c *gin.Context) {
 		}
 	}
 
+	// Sign the agent's client certificate first so a bad CSR doesn't consume the
+	// token; it is only recorded, and so usable, once the token is consumed
+	var issued *pki.IssuedCertificate
+	if h.ca != nil && req.CSR != "" {
+		issued, err = h.ca.IssueAgentCertificate(req.CSR, agent.ID, h.certValidity)
+		if err != nil {
+			middleware.Logger(c).Warn("failed to issue client certificate", "hostname", req.Hostname, "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to issue client certificate"})
+			return
+		}
+	}
+
 	// Save to database
 	if err := h.agentQueries.CreateAgent(agent); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to register agent"})
@@ -101,14 +229,24 @@ func (h *AgentHandler) RegisterAgent(c *gin.Context) {
 	// Mark registration token as used (CRITICAL: must succeed or delete agent)
 	if err := h.registrationTokenQueries.MarkTokenUsed(registrationToken, agent.ID); err != nil {
 		// Token marking failed - rollback agent creation to prevent token reuse
-		log.Printf("ERROR: Failed to mark registration token as used: %v - rolling back agent creation", err)
+		middleware.Logger(c).Error("failed to mark registration token as used, rolling back agent creation", "agent_id", agent.ID, "error", err)
 		if deleteErr := h.agentQueries.DeleteAgent(agent.ID); deleteErr != nil {
-			log.Printf("ERROR: Failed to delete agent during rollback: %v", deleteErr)
+			middleware.Logger(c).Error("failed to delete agent during rollback", "agent_id", agent.ID, "error", deleteErr)
 		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "registration token could not be consumed - token may be expired, revoked, or all seats may be used"})
 		return
 	}
 
+	var certificate *models.AgentCertificate
+	if issued != nil {
+		certificate, err = h.recordCertificate(agent.ID, issued)
+		if err != nil {
+			middleware.Logger(c).Error("failed to record client certificate", "agent_id", agent.ID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store client certificate"})
+			return
+		}
+	}
+
 	// Generate JWT access token (short-lived: 24 hours)
 	token, err := middleware.GenerateAgentToken(agent.ID)
 	if err != nil {
@@ -139,6 +277,11 @@ func (h *AgentHandler) RegisterAgent(c *gin.Context) {
 			"check_in_interval": h.checkInInterval,
 			"server_url":        c.Request.Host,
 		},
+		Certificate: certificate,
+	}
+	if publicKey := h.releaseService.PublicKey(); publicKey != "" {
+		// Agents only accept self-updates signed with this key
+		response.Config["update_public_key"] = publicKey
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -148,43 +291,53 @@ func (h *AgentHandler) RegisterAgent(c *gin.Context) {
 // Agents can optionally send lightweight system metrics in request body
 func (h *AgentHandler) GetCommands(c *gin.Context) {
 	agentID := c.MustGet("agent_id").(uuid.UUID)
+	wait := h.longPollWait(c)
 
 	// Try to parse optional system metrics from request body
 	var metrics struct {
-		CPUPercent    float64                   `json:"cpu_percent,omitempty"`
-		MemoryPercent float64                   `json:"memory_percent,omitempty"`
-		MemoryUsedGB  float64                   `json:"memory_used_gb,omitempty"`
-		MemoryTotalGB float64                   `json:"memory_total_gb,omitempty"`
-		DiskUsedGB    float64                   `json:"disk_used_gb,omitempty"`
-		DiskTotalGB   float64                   `json:"disk_total_gb,omitempty"`
-		DiskPercent   float64                   `json:"disk_percent,omitempty"`
-		Uptime        string                    `json:"uptime,omitempty"`
-		Version       string                    `json:"version,omitempty"`
-		Metadata      map[string]interface{}     `json:"metadata,omitempty"`
+		CPUPercent    float64                `json:"cpu_percent,omitempty"`
+		MemoryPercent float64                `json:"memory_percent,omitempty"`
+		MemoryUsedGB  float64                `json:"memory_used_gb,omitempty"`
+		MemoryTotalGB float64                `json:"memory_total_gb,omitempty"`
+		DiskUsedGB    float64                `json:"disk_used_gb,omitempty"`
+		DiskTotalGB   float64                `json:"disk_total_gb,omitempty"`
+		DiskPercent   float64                `json:"disk_percent,omitempty"`
+		Uptime        string                 `json:"uptime,omitempty"`
+		Version       string                 `json:"version,omitempty"`
+		Metadata      map[string]interface{} `json:"metadata,omitempty"`
+
+		// Config profile the agent has applied
+		ConfigProfileID string `json:"config_profile_id,omitempty"`
+		ConfigVersion   int    `json:"config_version,omitempty"`
+		ConfigError     string `json:"config_error,omitempty"`
+
+		// What the agent can run
+		ProtocolVersion int                       `json:"protocol_version,omitempty"`
+		Capabilities    *models.AgentCapabilities `json:"capabilities,omitempty"`
 	}
 
 	// Parse metrics if provided (optional, won't fail if empty)
 	err := c.ShouldBindJSON(&metrics)
 	if err != nil {
-		log.Printf("DEBUG: Failed to parse metrics JSON: %v", err)
+		middleware.Logger(c).Debug("failed to parse metrics JSON", "agent_id", agentID, "error", err)
 	}
+	metricsReported := err == nil
 
-	// Debug logging to see what we received
-	log.Printf("DEBUG: Received metrics - Version: '%s', CPU: %.2f, Memory: %.2f",
-		metrics.Version, metrics.CPUPercent, metrics.MemoryPercent)
+	middleware.Logger(c).Debug("received check-in metrics", "agent_id", agentID,
+		"version", metrics.Version, "cpu_percent", metrics.CPUPercent, "memory_percent", metrics.MemoryPercent)
 
 	// Always handle version information if provided
 	if metrics.Version != "" {
 		// Update agent's current version in database (primary source of truth)
 		if err := h.agentQueries.UpdateAgentVersion(agentID, metrics.Version); err != nil {
-			log.Printf("Warning: Failed to update agent version: %v", err)
+			middleware.Logger(c).Warn("failed to update agent version", "agent_id", agentID, "error", err)
 		} else {
 			// Check if update is available
 			updateAvailable := utils.IsNewerVersion(h.latestAgentVersion, metrics.Version)
 
 			// Update agent's update availability status
 			if err := h.agentQueries.UpdateAgentUpdateAvailable(agentID, updateAvailable); err != nil {
-				log.Printf("Warning: Failed to update agent update availability: %v", err)
+				middleware.Logger(c).Warn("failed to update agent update availability", "agent_id", agentID, "error", err)
 			}
 
 			// Get current agent for logging and metadata update
@@ -192,11 +345,11 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 			if err == nil {
 				// Log version check
 				if updateAvailable {
-					log.Printf("🔄 Agent %s (%s) version %s has update available: %s",
-						agent.Hostname, agentID, metrics.Version, h.latestAgentVersion)
+					middleware.Logger(c).Info("agent update available", "agent_id", agentID, "hostname", agent.Hostname,
+						"version", metrics.Version, "latest_version", h.latestAgentVersion)
 				} else {
-					log.Printf("✅ Agent %s (%s) version %s is up to date",
-						agent.Hostname, agentID, metrics.Version)
+					middleware.Logger(c).Debug("agent is up to date", "agent_id", agentID, "hostname", agent.Hostname,
+						"version", metrics.Version)
 				}
 
 				// Store version in metadata as well (for backwards compatibility)
@@ -211,17 +364,22 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 
 				// Update agent metadata
 				if err := h.agentQueries.UpdateAgent(agent); err != nil {
-					log.Printf("Warning: Failed to update agent metadata: %v", err)
+					middleware.Logger(c).Warn("failed to update agent metadata", "agent_id", agentID, "error", err)
 				}
 			}
 		}
 	}
 
 	// Update agent metadata with current metrics if provided
-	if metrics.CPUPercent > 0 || metrics.MemoryPercent > 0 || metrics.DiskUsedGB > 0 || metrics.Uptime != "" {
+	hasMetrics := metrics.CPUPercent > 0 || metrics.MemoryPercent > 0 || metrics.DiskUsedGB > 0 || metrics.Uptime != ""
+	var previousUptime string
+	if hasMetrics {
 		// Get current agent to preserve existing metadata
 		agent, err := h.agentQueries.GetAgentByID(agentID)
 		if err == nil && agent.Metadata != nil {
+			// Remember the last reported uptime so alerting can detect reboots
+			previousUptime, _ = agent.Metadata["uptime"].(string)
+
 			// Update metrics in metadata
 			agent.Metadata["cpu_percent"] = metrics.CPUPercent
 			agent.Metadata["memory_percent"] = metrics.MemoryPercent
@@ -247,10 +405,10 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 							agent.Metadata["rapid_polling_until"] = rapidPollingUntil
 							agent.Metadata["rapid_polling_active"] = isActive
 
-							log.Printf("[Heartbeat] Agent %s heartbeat status: enabled=%v, until=%v, active=%v",
-								agentID, rapidPollingEnabled, rapidPollingUntil, isActive)
+							middleware.Logger(c).Debug("agent heartbeat status", "agent_id", agentID,
+								"enabled", rapidPollingEnabled, "until", rapidPollingUntil, "active", isActive)
 						} else {
-							log.Printf("[Heartbeat] Failed to parse rapid_polling_until timestamp for agent %s: %v", agentID, err)
+							middleware.Logger(c).Warn("failed to parse rapid_polling_until timestamp", "agent_id", agentID, "error", err)
 						}
 					}
 				}
@@ -258,7 +416,7 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 
 			// Update agent with new metadata
 			if err := h.agentQueries.UpdateAgent(agent); err != nil {
-				log.Printf("Warning: Failed to update agent metrics: %v", err)
+				middleware.Logger(c).Warn("failed to update agent metrics", "agent_id", agentID, "error", err)
 			}
 		}
 	}
@@ -269,6 +427,31 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 		return
 	}
 
+	// Keep the agent's capabilities current, e.g. after it was upgraded or Docker was installed
+	if metrics.Capabilities != nil {
+		if metrics.ProtocolVersion > models.AgentProtocolVersion {
+			middleware.Logger(c).Warn("agent speaks a newer protocol than the server", "agent_id", agentID,
+				"agent_protocol", metrics.ProtocolVersion, "server_protocol", models.AgentProtocolVersion)
+		}
+		if err := h.agentQueries.UpdateAgentCapabilities(agentID, metrics.ProtocolVersion, metrics.Capabilities); err != nil {
+			middleware.Logger(c).Warn("failed to update agent capabilities", "agent_id", agentID, "error", err)
+		}
+	}
+
+	// Evaluate alert rules against this check-in (off the request path so notifications can't stall agents)
+	if h.alertService != nil {
+		sample := services.AgentMetricsSample{
+			HasMetrics:    hasMetrics,
+			DiskPercent:   metrics.DiskPercent,
+			MemoryPercent: metrics.MemoryPercent,
+		}
+		if hasMetrics {
+			sample.Uptime, _ = utils.ParseUptime(metrics.Uptime)
+			sample.PreviousUptime, _ = utils.ParseUptime(previousUptime)
+		}
+		go h.alertService.EvaluateCheckIn(agentID, sample)
+	}
+
 	// Process heartbeat metadata from agent check-ins
 	if metrics.Metadata != nil {
 		agent, err := h.agentQueries.GetAgentByID(agentID)
@@ -285,15 +468,15 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 						agent.Metadata["rapid_polling_until"] = rapidPollingUntil
 						agent.Metadata["rapid_polling_active"] = isActive
 
-						log.Printf("[Heartbeat] Agent %s heartbeat status: enabled=%v, until=%v, active=%v",
-							agentID, rapidPollingEnabled, rapidPollingUntil, isActive)
+						middleware.Logger(c).Debug("agent heartbeat status", "agent_id", agentID,
+							"enabled", rapidPollingEnabled, "until", rapidPollingUntil, "active", isActive)
 
 						// Update agent with new metadata
 						if err := h.agentQueries.UpdateAgent(agent); err != nil {
-							log.Printf("[Heartbeat] Warning: Failed to update agent heartbeat metadata: %v", err)
+							middleware.Logger(c).Warn("failed to update agent heartbeat metadata", "agent_id", agentID, "error", err)
 						}
 					} else {
-						log.Printf("[Heartbeat] Failed to parse rapid_polling_until timestamp for agent %s: %v", agentID, err)
+						middleware.Logger(c).Warn("failed to parse rapid_polling_until timestamp", "agent_id", agentID, "error", err)
 					}
 				}
 			}
@@ -312,31 +495,65 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 			// Update agent's update availability status if it changed
 			if agent.UpdateAvailable != updateAvailable {
 				if err := h.agentQueries.UpdateAgentUpdateAvailable(agentID, updateAvailable); err != nil {
-					log.Printf("Warning: Failed to update agent update availability: %v", err)
+					middleware.Logger(c).Warn("failed to update agent update availability", "agent_id", agentID, "error", err)
 				} else {
 					// Log version check for agent without version reporting
 					if updateAvailable {
-						log.Printf("🔄 Agent %s (%s) stored version %s has update available: %s",
-							agent.Hostname, agentID, agent.CurrentVersion, h.latestAgentVersion)
+						middleware.Logger(c).Info("agent update available", "agent_id", agentID, "hostname", agent.Hostname,
+							"version", agent.CurrentVersion, "latest_version", h.latestAgentVersion)
 					} else {
-						log.Printf("✅ Agent %s (%s) stored version %s is up to date",
-							agent.Hostname, agentID, agent.CurrentVersion)
+						middleware.Logger(c).Debug("agent is up to date", "agent_id", agentID, "hostname", agent.Hostname,
+							"version", agent.CurrentVersion)
 					}
 				}
 			}
 		}
 	}
 
+	// Long poll: subscribe before looking so a command queued in between isn't missed
+	var sub *events.Subscription
+	if wait > 0 {
+		sub, _, _ = h.eventBus.Subscribe(events.Filter{
+			AgentID: &agentID,
+			Types: map[string]bool{
+				events.TypeCommandCreated: true,
+				events.TypeCommandStatus:  true, // Retried commands go back to pending
+			},
+		}, "")
+		defer sub.Close()
+	}
+
 	// Get pending commands
-	commands, err := h.commandQueries.GetPendingCommands(agentID)
+	commands, err := h.commandQueries.WithContext(c.Request.Context()).GetPendingCommands(agentID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve commands"})
 		return
 	}
 
+	// Nothing pending: hold the request until a command is queued or the wait runs out
+	if len(commands) == 0 && wait > 0 && waitForCommand(c, sub, wait) {
+		commands, err = h.commandQueries.WithContext(c.Request.Context()).GetPendingCommands(agentID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to retrieve commands"})
+			return
+		}
+	}
+
 	// Convert to response format
 	commandItems := make([]models.CommandItem, 0, len(commands))
 	for _, cmd := range commands {
+		// Dispatch continues the trace that created the command (or this check-in's
+		// trace for commands created without one); the agent picks it up from params
+		dispatchCtx, span := tracing.Start(tracing.ExtractParams(c.Request.Context(), cmd.Params), "command.dispatch",
+			trace.WithLinks(trace.LinkFromContext(c.Request.Context())),
+			trace.WithAttributes(
+				attribute.String("redflag.command_id", cmd.ID.String()),
+				attribute.String("redflag.command_type", cmd.CommandType),
+				attribute.String("redflag.agent_id", agentID.String()),
+			),
+		)
+		tracing.InjectParams(dispatchCtx, cmd.Params)
+
 		commandItems = append(commandItems, models.CommandItem{
 			ID:     cmd.ID.String(),
 			Type:   cmd.CommandType,
@@ -344,14 +561,16 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 		})
 
 		// Mark as sent
-		h.commandQueries.MarkCommandSent(cmd.ID)
+		err := h.commandQueries.WithContext(dispatchCtx).MarkCommandSent(cmd.ID)
+		tracing.End(span, err)
 	}
 
 	// Check if rapid polling should be enabled
 	var rapidPolling *models.RapidPollingConfig
 
-	// Enable rapid polling if there are commands to process
-	if len(commandItems) > 0 {
+	// Enable rapid polling if there are commands to process; long-polling
+	// agents get follow-up commands right away without it
+	if len(commandItems) > 0 && wait == 0 {
 		rapidPolling = &models.RapidPollingConfig{
 			Enabled: true,
 			Until:   time.Now().Add(10 * time.Minute).Format(time.RFC3339), // 10 minutes default
@@ -374,9 +593,10 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 	}
 
 	// Detect stale heartbeat state: Server thinks it's active, but agent didn't report it
-	// This happens when agent restarts without heartbeat mode
+	// This happens when agent restarts without heartbeat mode. Long polls carry
+	// no metadata, so only full check-ins are checked.
 	agent, err := h.agentQueries.GetAgentByID(agentID)
-	if err == nil && agent.Metadata != nil {
+	if err == nil && agent.Metadata != nil && wait == 0 {
 		// Check if server metadata shows heartbeat active
 		if serverEnabled, ok := agent.Metadata["rapid_polling_enabled"].(bool); ok && serverEnabled {
 			if untilStr, ok := agent.Metadata["rapid_polling_until"].(string); ok {
@@ -392,17 +612,17 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 
 					// If agent is NOT reporting heartbeat but server expects it → stale state
 					if !agentReportingHeartbeat {
-						log.Printf("[Heartbeat] Stale heartbeat detected for agent %s - server expected active until %s, but agent not reporting heartbeat (likely restarted)",
-							agentID, until.Format(time.RFC3339))
+						middleware.Logger(c).Info("stale heartbeat detected, agent isn't reporting heartbeat and likely restarted",
+							"agent_id", agentID, "expected_until", until.Format(time.RFC3339))
 
 						// Clear stale heartbeat state
 						agent.Metadata["rapid_polling_enabled"] = false
 						delete(agent.Metadata, "rapid_polling_until")
 
 						if err := h.agentQueries.UpdateAgent(agent); err != nil {
-							log.Printf("[Heartbeat] Warning: Failed to clear stale heartbeat state: %v", err)
+							middleware.Logger(c).Warn("failed to clear stale heartbeat state", "agent_id", agentID, "error", err)
 						} else {
-							log.Printf("[Heartbeat] Cleared stale heartbeat state for agent %s", agentID)
+							middleware.Logger(c).Info("cleared stale heartbeat state", "agent_id", agentID)
 
 							// Create audit command to show in history
 							now := time.Now()
@@ -419,12 +639,13 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 								CreatedAt:   now,
 								SentAt:      &now,
 								CompletedAt: &now,
+								RequestID:   requestIDRef(c),
 							}
 
-							if err := h.commandQueries.CreateCommand(auditCmd); err != nil {
-								log.Printf("[Heartbeat] Warning: Failed to create audit command for stale heartbeat: %v", err)
+							if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(auditCmd); err != nil {
+								middleware.Logger(c).Warn("failed to create audit command for stale heartbeat", "agent_id", agentID, "error", err)
 							} else {
-								log.Printf("[Heartbeat] Created audit trail for stale heartbeat cleanup (agent %s)", agentID)
+								middleware.Logger(c).Debug("created audit trail for stale heartbeat cleanup", "agent_id", agentID)
 							}
 						}
 
@@ -439,11 +660,76 @@ func (h *AgentHandler) GetCommands(c *gin.Context) {
 	response := models.CommandsResponse{
 		Commands:     commandItems,
 		RapidPolling: rapidPolling,
+		Config:       h.agentConfigForCheckIn(c, agentID, metricsReported, metrics.ConfigProfileID, metrics.ConfigVersion, metrics.ConfigError),
+	}
+	if wait > 0 {
+		response.LongPoll = int(wait / time.Second)
 	}
 
 	c.JSON(http.StatusOK, response)
 }
 
+// agentConfigForCheckIn records the config profile version an agent reports
+// having applied and returns the profile to deliver, or nil if the agent is
+// already running the current version
+func (h *AgentHandler) agentConfigForCheckIn(c *gin.Context, agentID uuid.UUID, reported bool, profileID string, version int, applyError string) *models.AgentConfig {
+	state, err := h.configProfileQueries.GetAgentConfigState(agentID)
+	if err != nil {
+		middleware.Logger(c).Warn("failed to get agent config state", "agent_id", agentID, "error", err)
+		return nil
+	}
+
+	if reported {
+		var applied *uuid.UUID
+		if id, err := uuid.Parse(profileID); err == nil {
+			applied = &id
+		}
+		if !sameUUID(applied, state.AppliedProfileID) || version != state.AppliedVersion || applyError != state.ApplyError {
+			if err := h.configProfileQueries.RecordAppliedConfig(agentID, applied, version, applyError); err != nil {
+				middleware.Logger(c).Warn("failed to record applied config", "agent_id", agentID, "error", err)
+			}
+			if applyError != "" {
+				middleware.Logger(c).Warn("agent rejected config profile", "agent_id", agentID, "error", applyError)
+			}
+			state.AppliedProfileID, state.AppliedVersion = applied, version
+		}
+	}
+
+	profile, err := h.configProfileQueries.GetEffectiveConfigProfile(state.ProfileID)
+	if err != nil {
+		middleware.Logger(c).Warn("failed to get config profile", "agent_id", agentID, "error", err)
+		return nil
+	}
+
+	if profile == nil {
+		if state.AppliedProfileID == nil {
+			return nil
+		}
+		// The agent still runs a profile that was deleted or unassigned
+		return &models.AgentConfig{}
+	}
+	if sameUUID(state.AppliedProfileID, &profile.ID) && state.AppliedVersion == profile.Version {
+		return nil
+	}
+
+	return &models.AgentConfig{
+		ProfileID:       profile.ID.String(),
+		ProfileName:     profile.Name,
+		Version:         profile.Version,
+		CheckInInterval: profile.CheckInInterval,
+		LogLevel:        profile.LogLevel,
+		Scanners:        profile.Scanners,
+	}
+}
+
+// sameUUID reports whether two optional IDs are equal
+func sameUUID(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
 // ListAgents returns all agents with last scan information
 func (h *AgentHandler) ListAgents(c *gin.Context) {
 	status := c.Query("status")
@@ -455,10 +741,7 @@ func (h *AgentHandler) ListAgents(c *gin.Context) {
 		return
 	}
 
-	// Debug: Log what we're returning
-	for _, agent := range agents {
-		log.Printf("DEBUG: Returning agent %s: last_seen=%s, last_scan=%s", agent.Hostname, agent.LastSeen, agent.LastScan)
-	}
+	middleware.Logger(c).Debug("listing agents", "count", len(agents))
 
 	c.JSON(http.StatusOK, gin.H{
 		"agents": agents,
@@ -495,9 +778,9 @@ func (h *AgentHandler) TriggerScan(c *gin.Context) {
 
 	// Trigger system heartbeat before scan (5 minutes should be enough for most scans)
 	if created, err := h.triggerSystemHeartbeat(agentID, 5); err != nil {
-		log.Printf("Warning: Failed to trigger system heartbeat for scan: %v", err)
+		middleware.Logger(c).Warn("failed to trigger system heartbeat for scan", "agent_id", agentID, "error", err)
 	} else if created {
-		log.Printf("[Scan] Heartbeat initiated for 'Scan Updates' operation on agent %s", agentID)
+		middleware.Logger(c).Info("heartbeat initiated for scan", "agent_id", agentID)
 	}
 
 	// Create scan command
@@ -508,10 +791,11 @@ func (h *AgentHandler) TriggerScan(c *gin.Context) {
 		Params:      models.JSONB{},
 		Status:      models.CommandStatusPending,
 		Source:      models.CommandSourceManual,
+		RequestID:   requestIDRef(c),
 	}
 
-	if err := h.commandQueries.CreateCommand(cmd); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create command"})
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(cmd); err != nil {
+		commandCreateFailed(c, err, "failed to create command")
 		return
 	}
 
@@ -553,10 +837,11 @@ func (h *AgentHandler) TriggerHeartbeat(c *gin.Context) {
 		},
 		Status: models.CommandStatusPending,
 		Source: models.CommandSourceManual,
+		RequestID: requestIDRef(c),
 	}
 
-	if err := h.commandQueries.CreateCommand(cmd); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create heartbeat command"})
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(cmd); err != nil {
+		commandCreateFailed(c, err, "failed to create heartbeat command")
 		return
 	}
 
@@ -569,7 +854,7 @@ func (h *AgentHandler) TriggerHeartbeat(c *gin.Context) {
 			}
 			agent.Metadata["heartbeat_source"] = models.CommandSourceManual
 			if err := h.agentQueries.UpdateAgent(agent); err != nil {
-				log.Printf("Warning: Failed to update agent metadata with heartbeat source: %v", err)
+				middleware.Logger(c).Warn("failed to update agent metadata with heartbeat source", "agent_id", agentID, "error", err)
 			}
 		}
 	}
@@ -579,8 +864,8 @@ func (h *AgentHandler) TriggerHeartbeat(c *gin.Context) {
 		action = "enabled"
 	}
 
-	log.Printf("[Heartbeat] Manual heartbeat %s command created for agent %s (duration: %d minutes)",
-		action, agentID, request.DurationMinutes)
+	middleware.Logger(c).Info("created manual heartbeat command", "agent_id", agentID, "action", action,
+		"duration_minutes", request.DurationMinutes)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": fmt.Sprintf("heartbeat %s command sent", action),
@@ -595,7 +880,7 @@ func (h *AgentHandler) triggerSystemHeartbeat(agentID uuid.UUID, durationMinutes
 	// Check if heartbeat should be enabled (not already active)
 	agent, err := h.agentQueries.GetAgentByID(agentID)
 	if err != nil {
-		log.Printf("Warning: Failed to get agent %s for heartbeat check: %v", agentID, err)
+		slog.Warn("failed to get agent for heartbeat check", "agent_id", agentID, "error", err)
 		// Enable heartbeat by default if we can't check
 	} else {
 		// Check if rapid polling is already enabled and not expired
@@ -604,7 +889,7 @@ func (h *AgentHandler) triggerSystemHeartbeat(agentID uuid.UUID, durationMinutes
 				until, err := time.Parse(time.RFC3339, untilStr)
 				if err == nil && until.After(time.Now().Add(time.Duration(durationMinutes)*time.Minute)) {
 					// Heartbeat is already active for sufficient time
-					log.Printf("[Heartbeat] Agent %s already has active heartbeat until %s (skipping system heartbeat)", agentID, untilStr)
+					slog.Debug("agent already has an active heartbeat, skipping system heartbeat", "agent_id", agentID, "until", untilStr)
 					return false, nil
 				}
 			}
@@ -635,11 +920,11 @@ func (h *AgentHandler) triggerSystemHeartbeat(agentID uuid.UUID, durationMinutes
 		}
 		agent.Metadata["heartbeat_source"] = models.CommandSourceSystem
 		if err := h.agentQueries.UpdateAgent(agent); err != nil {
-			log.Printf("Warning: Failed to update agent metadata with heartbeat source: %v", err)
+			slog.Warn("failed to update agent metadata with heartbeat source", "agent_id", agentID, "error", err)
 		}
 	}
 
-	log.Printf("[Heartbeat] System heartbeat initiated for agent %s - Scan operation (duration: %d minutes)", agentID, durationMinutes)
+	slog.Info("system heartbeat initiated", "agent_id", agentID, "duration_minutes", durationMinutes)
 	return true, nil
 }
 
@@ -748,10 +1033,11 @@ func (h *AgentHandler) TriggerUpdate(c *gin.Context) {
 		Params:      params,
 		Status:      models.CommandStatusPending,
 		Source:      models.CommandSourceManual,
+		RequestID:   requestIDRef(c),
 	}
 
-	if err := h.commandQueries.CreateCommand(cmd); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create update command"})
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(cmd); err != nil {
+		commandCreateFailed(c, err, "failed to create update command")
 		return
 	}
 
@@ -774,7 +1060,7 @@ func (h *AgentHandler) RenewToken(c *gin.Context) {
 	// Validate refresh token
 	refreshToken, err := h.refreshTokenQueries.ValidateRefreshToken(req.AgentID, req.RefreshToken)
 	if err != nil {
-		log.Printf("Token renewal failed for agent %s: %v", req.AgentID, err)
+		middleware.Logger(c).Warn("token renewal failed", "agent_id", req.AgentID, "error", err)
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid or expired refresh token"})
 		return
 	}
@@ -788,14 +1074,14 @@ func (h *AgentHandler) RenewToken(c *gin.Context) {
 
 	// Update agent last_seen timestamp
 	if err := h.agentQueries.UpdateAgentLastSeen(req.AgentID); err != nil {
-		log.Printf("Warning: Failed to update last_seen for agent %s: %v", req.AgentID, err)
+		middleware.Logger(c).Warn("failed to update last_seen", "agent_id", req.AgentID, "error", err)
 	}
 
 	// Update refresh token expiration (sliding window - reset to 90 days from now)
 	// This ensures active agents never need to re-register
 	newExpiry := time.Now().Add(90 * 24 * time.Hour)
 	if err := h.refreshTokenQueries.UpdateExpiration(refreshToken.ID, newExpiry); err != nil {
-		log.Printf("Warning: Failed to update refresh token expiration: %v", err)
+		middleware.Logger(c).Warn("failed to update refresh token expiration", "agent_id", req.AgentID, "error", err)
 	}
 
 	// Generate new access token (24 hours)
@@ -805,16 +1091,54 @@ func (h *AgentHandler) RenewToken(c *gin.Context) {
 		return
 	}
 
-	log.Printf("✅ Token renewed successfully for agent %s (%s)", agent.Hostname, req.AgentID)
+	middleware.Logger(c).Info("renewed agent token", "agent_id", req.AgentID, "hostname", agent.Hostname)
 
 	// Return new access token
 	response := models.TokenRenewalResponse{
 		Token: token,
 	}
 
+	// An agent whose certificate expired can't reach the mTLS-protected
+	// routes, so it gets a new one here on the strength of its refresh token
+	if h.ca != nil && req.CSR != "" {
+		certificate, err := h.issueCertificate(req.AgentID, req.CSR)
+		if err != nil {
+			middleware.Logger(c).Warn("failed to issue client certificate", "agent_id", req.AgentID, "error", err)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "failed to issue client certificate"})
+			return
+		}
+		response.Certificate = certificate
+	}
+
 	c.JSON(http.StatusOK, response)
 }
 
+// RenewCertificate issues an agent a new client certificate before its
+// current one expires
+func (h *AgentHandler) RenewCertificate(c *gin.Context) {
+	agentID := c.MustGet("agent_id").(uuid.UUID)
+
+	if h.ca == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "mTLS is not enabled on this server"})
+		return
+	}
+
+	var req models.CertificateRenewalRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	certificate, err := h.issueCertificate(agentID, req.CSR)
+	if err != nil {
+		middleware.Logger(c).Warn("failed to renew client certificate", "agent_id", agentID, "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to issue client certificate"})
+		return
+	}
+
+	c.JSON(http.StatusOK, certificate)
+}
+
 // UnregisterAgent removes an agent from the system
 func (h *AgentHandler) UnregisterAgent(c *gin.Context) {
 	idStr := c.Param("id")
@@ -831,6 +1155,18 @@ func (h *AgentHandler) UnregisterAgent(c *gin.Context) {
 		return
 	}
 
+	// Revoke its client certificates first; the revocations outlive the agent row
+	if h.certificateQueries != nil {
+		revoked, err := h.certificateQueries.RevokeAgentCertificates(agentID, "agent unregistered")
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to revoke agent certificates"})
+			return
+		}
+		if revoked > 0 {
+			middleware.Logger(c).Info("revoked client certificates", "agent_id", agentID, "count", revoked)
+		}
+	}
+
 	// Delete the agent and all associated data
 	if err := h.agentQueries.DeleteAgent(agentID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to delete agent"})
@@ -920,13 +1256,13 @@ func (h *AgentHandler) ReportSystemInfo(c *gin.Context) {
 
 	// Update agent with new metadata
 	if err := h.agentQueries.UpdateAgent(agent); err != nil {
-		log.Printf("Warning: Failed to update agent system info: %v", err)
+		middleware.Logger(c).Error("failed to update agent system info", "agent_id", agentID, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update system info"})
 		return
 	}
 
-	log.Printf("✅ System info updated for agent %s (%s): CPU=%s, Cores=%d, Memory=%dMB",
-		agent.Hostname, agentID, req.CPUModel, req.CPUCores, req.MemoryTotal/1024/1024)
+	middleware.Logger(c).Info("updated agent system info", "agent_id", agentID, "hostname", agent.Hostname,
+		"cpu", req.CPUModel, "cores", req.CPUCores, "memory_mb", req.MemoryTotal/1024/1024)
 
 	c.JSON(http.StatusOK, gin.H{"message": "system info updated successfully"})
 }
@@ -953,20 +1289,18 @@ func (h *AgentHandler) EnableRapidPollingMode(agentID uuid.UUID, durationMinutes
 			if currentUntil, err := time.Parse(time.RFC3339, untilStr); err == nil {
 				// If current heartbeat expires later than the new duration, keep the longer duration
 				if currentUntil.After(newRapidPollingUntil) {
-					log.Printf("💓 Heartbeat already active for agent %s (%s), keeping longer duration (expires: %s)",
-						agent.Hostname, agentID, currentUntil.Format(time.RFC3339))
+					slog.Debug("heartbeat already active, keeping longer duration", "agent_id", agentID,
+						"hostname", agent.Hostname, "expires", currentUntil.Format(time.RFC3339))
 					return nil
 				}
 				// Otherwise extend the heartbeat
-				log.Printf("💓 Extending heartbeat for agent %s (%s) from %s to %s",
-					agent.Hostname, agentID,
-					currentUntil.Format(time.RFC3339),
-					newRapidPollingUntil.Format(time.RFC3339))
+				slog.Info("extending heartbeat", "agent_id", agentID, "hostname", agent.Hostname,
+					"from", currentUntil.Format(time.RFC3339), "to", newRapidPollingUntil.Format(time.RFC3339))
 			}
 		}
 	} else {
-		log.Printf("💓 Enabling heartbeat mode for agent %s (%s) for %d minutes",
-			agent.Hostname, agentID, durationMinutes)
+		slog.Info("enabling heartbeat mode", "agent_id", agentID, "hostname", agent.Hostname,
+			"duration_minutes", durationMinutes)
 	}
 
 	// Set/update rapid polling settings
@@ -1031,8 +1365,8 @@ func (h *AgentHandler) SetRapidPollingMode(c *gin.Context) {
 		duration = req.DurationMinutes
 	}
 
-	log.Printf("🚀 Rapid polling mode %s for agent %s (%s) for %d minutes",
-		status, agent.Hostname, agentID, duration)
+	middleware.Logger(c).Info("set rapid polling mode", "agent_id", agentID, "hostname", agent.Hostname,
+		"status", status, "duration_minutes", duration)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": fmt.Sprintf("Rapid polling mode %s", status),
@@ -1084,16 +1418,17 @@ func (h *AgentHandler) TriggerReboot(c *gin.Context) {
 		Status:    models.CommandStatusPending,
 		Source:    models.CommandSourceManual,
 		CreatedAt: time.Now(),
+		RequestID: requestIDRef(c),
 	}
 
 	// Save command to database
-	if err := h.commandQueries.CreateCommand(cmd); err != nil {
-		log.Printf("Failed to create reboot command: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create reboot command"})
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(cmd); err != nil {
+		middleware.Logger(c).Error("failed to create reboot command", "agent_id", agentID, "error", err)
+		commandCreateFailed(c, err, "failed to create reboot command")
 		return
 	}
 
-	log.Printf("Reboot command created for agent %s (%s)", agent.Hostname, agentID)
+	middleware.Logger(c).Info("created reboot command", "agent_id", agentID, "hostname", agent.Hostname)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":    "reboot command sent",
@@ -1102,3 +1437,104 @@ func (h *AgentHandler) TriggerReboot(c *gin.Context) {
 		"hostname":   agent.Hostname,
 	})
 }
+
+// TriggerAgentUpdate sends an update_agent command telling the agent to upgrade
+// itself to the latest published binary for its platform
+func (h *AgentHandler) TriggerAgentUpdate(c *gin.Context) {
+	agentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent ID"})
+		return
+	}
+
+	agent, err := h.agentQueries.GetAgentByID(agentID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+
+	// Agents refuse anything that isn't newer than what they run, so there is
+	// no point queueing it
+	if !utils.IsNewerVersion(h.releaseService.LatestVersion(), agent.CurrentVersion) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("agent is already running version %s", agent.CurrentVersion)})
+		return
+	}
+
+	active, err := h.commandQueries.HasActiveCommand(agentID, models.CommandTypeUpdateAgent)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check existing commands"})
+		return
+	}
+	if active {
+		c.JSON(http.StatusConflict, gin.H{"error": "an agent update is already in progress"})
+		return
+	}
+
+	platform := services.PlatformFor(agent.OSType, agent.OSArchitecture)
+	release, err := h.releaseService.GetRelease(platform)
+	if err != nil {
+		switch err {
+		case services.ErrReleaseNotFound:
+			c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("no agent binary published for %s", platform)})
+		case services.ErrReleaseUnsigned:
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("agent binary for %s has no signature", platform)})
+		case services.ErrReleaseBadSignature:
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("agent binary signature for %s does not match the update public key", platform)})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to load agent release: %v", err)})
+		}
+		return
+	}
+
+	cmd := &models.AgentCommand{
+		ID:          uuid.New(),
+		AgentID:     agentID,
+		CommandType: models.CommandTypeUpdateAgent,
+		Params: models.JSONB{
+			"version":      release.Version,
+			"platform":     release.Platform,
+			"download_url": release.DownloadURL,
+			"sha256":       release.SHA256,
+			"signature":         release.Signature,
+			"release_signature": release.ReleaseSignature,
+			"size":              release.Size,
+		},
+		Status:    models.CommandStatusPending,
+		Source:    models.CommandSourceManual,
+		CreatedAt: time.Now(),
+		RequestID: requestIDRef(c),
+	}
+
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(cmd); err != nil {
+		commandCreateFailed(c, err, "failed to create agent update command")
+		return
+	}
+
+	middleware.Logger(c).Info("created agent update command", "agent_id", agentID, "hostname", agent.Hostname, "from_version", agent.CurrentVersion, "to_version", release.Version)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "agent update command sent",
+		"command_id":   cmd.ID,
+		"from_version": agent.CurrentVersion,
+		"to_version":   release.Version,
+		"platform":     release.Platform,
+	})
+}
+
+// commandCreateFailed responds to a failed CreateCommand: 422 with the reason
+// when the agent can't run the command, otherwise 500 with message
+func commandCreateFailed(c *gin.Context, err error, message string) {
+	if errors.Is(err, queries.ErrCommandUnsupported) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+}
+
+// requestIDRef returns the current request ID for recording on commands
+func requestIDRef(c *gin.Context) *string {
+	if requestID := middleware.GetRequestID(c); requestID != "" {
+		return &requestID
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type AlertHandler struct {
+	alertQueries *queries.AlertQueries
+}
+
+func NewAlertHandler(alq *queries.AlertQueries) *AlertHandler {
+	return &AlertHandler{
+		alertQueries: alq,
+	}
+}
+
+// ListAlerts returns alerts, optionally filtered by status and agent
+func (h *AlertHandler) ListAlerts(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != models.AlertStatusPending && status != models.AlertStatusFiring && status != models.AlertStatusResolved {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+
+	var agentID *uuid.UUID
+	if agentIDStr := c.Query("agent_id"); agentIDStr != "" {
+		id, err := uuid.Parse(agentIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent ID"})
+			return
+		}
+		agentID = &id
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if limit < 1 || limit > 1000 {
+		limit = 100
+	}
+
+	alerts, err := h.alertQueries.ListAlerts(status, agentID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list alerts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"alerts": alerts,
+		"total":  len(alerts),
+	})
+}
+
+// AcknowledgeAlert marks an alert as acknowledged by the current user
+func (h *AlertHandler) AcknowledgeAlert(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid alert ID"})
+		return
+	}
+
+	if err := h.alertQueries.AcknowledgeAlert(id, currentUserID(c)); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "alert acknowledged"})
+}
+
+// ListAlertRules returns all alert rules
+func (h *AlertHandler) ListAlertRules(c *gin.Context) {
+	rules, err := h.alertQueries.ListAlertRules()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list alert rules"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"rules": rules})
+}
+
+// CreateAlertRule creates a new alert rule
+func (h *AlertHandler) CreateAlertRule(c *gin.Context) {
+	var req models.AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule := &models.AlertRule{ID: uuid.New(), Enabled: true}
+	if msg := applyAlertRuleRequest(rule, &req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.alertQueries.CreateAlertRule(rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create alert rule"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rule)
+}
+
+// UpdateAlertRule replaces an existing alert rule
+func (h *AlertHandler) UpdateAlertRule(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule ID"})
+		return
+	}
+
+	var req models.AlertRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rule, err := h.alertQueries.GetAlertRule(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "alert rule not found"})
+		return
+	}
+
+	if msg := applyAlertRuleRequest(rule, &req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.alertQueries.UpdateAlertRule(rule); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update alert rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// DeleteAlertRule deletes an alert rule and its alerts
+func (h *AlertHandler) DeleteAlertRule(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rule ID"})
+		return
+	}
+
+	if err := h.alertQueries.DeleteAlertRule(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "alert rule deleted"})
+}
+
+// ListSilences returns silences that are active or scheduled
+func (h *AlertHandler) ListSilences(c *gin.Context) {
+	silences, err := h.alertQueries.ListActiveSilences()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list silences"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"silences": silences})
+}
+
+// CreateSilence suppresses notifications for a rule and/or agent for a period of time
+func (h *AlertHandler) CreateSilence(c *gin.Context) {
+	var req models.AlertSilenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	silence := &models.AlertSilence{
+		ID:        uuid.New(),
+		RuleID:    req.RuleID,
+		AgentID:   req.AgentID,
+		Reason:    req.Reason,
+		CreatedBy: currentUserID(c),
+		StartsAt:  now,
+		EndsAt:    now.Add(time.Duration(req.DurationMinutes) * time.Minute),
+	}
+
+	if err := h.alertQueries.CreateSilence(silence); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create silence"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, silence)
+}
+
+// DeleteSilence ends a silence immediately
+func (h *AlertHandler) DeleteSilence(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid silence ID"})
+		return
+	}
+
+	if err := h.alertQueries.ExpireSilence(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "silence expired"})
+}
+
+// applyAlertRuleRequest validates a rule request and copies it onto the rule.
+// Returns a non-empty message if the request is invalid.
+func applyAlertRuleRequest(rule *models.AlertRule, req *models.AlertRuleRequest) string {
+	switch req.RuleType {
+	case models.AlertRuleDiskUsage, models.AlertRuleMemoryUsage:
+		if req.Threshold <= 0 || req.Threshold > 100 {
+			return "threshold must be between 0 and 100 for usage rules"
+		}
+	case models.AlertRuleMissedCheckIn:
+		if req.MissedIntervals < 1 {
+			return "missed_intervals must be at least 1"
+		}
+	case models.AlertRuleUnexpectedReboot:
+	default:
+		return "invalid rule_type"
+	}
+
+	if req.DurationSeconds < 0 {
+		return "duration_seconds cannot be negative"
+	}
+
+	severity := req.Severity
+	if severity == "" {
+		severity = models.AlertSeverityWarning
+	}
+	if severity != models.AlertSeverityInfo && severity != models.AlertSeverityWarning && severity != models.AlertSeverityCritical {
+		return "invalid severity"
+	}
+
+	rule.Name = req.Name
+	rule.RuleType = req.RuleType
+	rule.Threshold = req.Threshold
+	rule.DurationSeconds = req.DurationSeconds
+	rule.MissedIntervals = req.MissedIntervals
+	rule.Severity = severity
+	rule.AgentID = req.AgentID
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	return ""
+}
+
+// currentUserID returns the authenticated dashboard user, if any
+func currentUserID(c *gin.Context) *uuid.UUID {
+	if v, exists := c.Get("user_id"); exists {
+		if id, ok := v.(uuid.UUID); ok {
+			return &id
+		}
+	}
+	return nil
+}
@@ -1,10 +1,10 @@
 package handlers
 
 import (
-	"fmt"
 	"net/http"
 	"time"
 
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/api/middleware"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
 	"github.com/gin-gonic/gin"
@@ -109,6 +109,10 @@ func (h *AuthHandler) Logout(c *gin.Context) {
 func (h *AuthHandler) WebAuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
+		// Browser EventSource can't set headers, so event streams may pass the token as a query param
+		if authHeader == "" && c.GetHeader("Accept") == "text/event-stream" {
+			authHeader = c.Query("token")
+		}
 		if authHeader == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authorization header"})
 			c.Abort()
@@ -126,8 +130,7 @@ func (h *AuthHandler) WebAuthMiddleware() gin.HandlerFunc {
 		})
 
 		if err != nil || !token.Valid {
-			// Debug: Log the JWT validation error (remove in production)
-			fmt.Printf("🔓 JWT validation failed: %v (secret: %s)\n", err, h.jwtSecret)
+			middleware.Logger(c).Debug("dashboard JWT validation failed", "error", err)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
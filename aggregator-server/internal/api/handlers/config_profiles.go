@@ -0,0 +1,237 @@
+package handlers
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type ConfigProfileHandler struct {
+	configProfileQueries *queries.ConfigProfileQueries
+	agentQueries         *queries.AgentQueries
+}
+
+func NewConfigProfileHandler(cpq *queries.ConfigProfileQueries, aq *queries.AgentQueries) *ConfigProfileHandler {
+	return &ConfigProfileHandler{
+		configProfileQueries: cpq,
+		agentQueries:         aq,
+	}
+}
+
+// ListConfigProfiles returns all config profiles with their agent counts
+func (h *ConfigProfileHandler) ListConfigProfiles(c *gin.Context) {
+	profiles, err := h.configProfileQueries.ListConfigProfiles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list config profiles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"profiles": profiles,
+		"total":    len(profiles),
+	})
+}
+
+// CreateConfigProfile creates a new config profile at version 1
+func (h *ConfigProfileHandler) CreateConfigProfile(c *gin.Context) {
+	var req models.ConfigProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile := &models.ConfigProfile{ID: uuid.New(), Version: 1}
+	if msg := applyConfigProfileRequest(profile, &req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	if err := h.configProfileQueries.CreateConfigProfile(profile); err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			c.JSON(http.StatusConflict, gin.H{"error": "a config profile with that name already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create config profile"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, profile)
+}
+
+// GetConfigProfile returns a config profile
+func (h *ConfigProfileHandler) GetConfigProfile(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile ID"})
+		return
+	}
+
+	profile, err := h.configProfileQueries.GetConfigProfile(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "config profile not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get config profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// UpdateConfigProfile replaces a config profile. Changing its settings bumps
+// the version, which agents then pick up on their next check-in.
+func (h *ConfigProfileHandler) UpdateConfigProfile(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile ID"})
+		return
+	}
+
+	var req models.ConfigProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile, err := h.configProfileQueries.GetConfigProfile(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "config profile not found"})
+		return
+	}
+
+	previous := *profile
+	if msg := applyConfigProfileRequest(profile, &req); msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+	if profile.CheckInInterval != previous.CheckInInterval || profile.LogLevel != previous.LogLevel ||
+		strings.Join(profile.Scanners, ",") != strings.Join(previous.Scanners, ",") {
+		profile.Version++
+	}
+
+	if err := h.configProfileQueries.UpdateConfigProfile(profile); err != nil {
+		if strings.Contains(err.Error(), "duplicate key") {
+			c.JSON(http.StatusConflict, gin.H{"error": "a config profile with that name already exists"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update config profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profile)
+}
+
+// DeleteConfigProfile deletes a config profile; its agents fall back to the default profile
+func (h *ConfigProfileHandler) DeleteConfigProfile(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid profile ID"})
+		return
+	}
+
+	if err := h.configProfileQueries.DeleteConfigProfile(id); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "config profile deleted"})
+}
+
+// ListAgentConfigStatus shows which profile version each agent should run and which it has applied
+func (h *ConfigProfileHandler) ListAgentConfigStatus(c *gin.Context) {
+	statuses, err := h.configProfileQueries.ListAgentConfigStatus()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list agent config status"})
+		return
+	}
+
+	inSync := 0
+	for _, status := range statuses {
+		if status.InSync {
+			inSync++
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"agents":  statuses,
+		"total":   len(statuses),
+		"in_sync": inSync,
+	})
+}
+
+// SetAgentConfigProfile assigns a config profile to an agent
+func (h *ConfigProfileHandler) SetAgentConfigProfile(c *gin.Context) {
+	agentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent ID"})
+		return
+	}
+
+	var req models.AgentConfigProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if _, err := h.agentQueries.GetAgentByID(agentID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+	if req.ProfileID != nil {
+		if _, err := h.configProfileQueries.GetConfigProfile(*req.ProfileID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "config profile not found"})
+			return
+		}
+	}
+
+	if err := h.configProfileQueries.SetAgentConfigProfile(agentID, req.ProfileID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to assign config profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "config profile assigned", "profile_id": req.ProfileID})
+}
+
+// applyConfigProfileRequest validates a profile request and copies it onto the
+// profile. Returns a non-empty message if the request is invalid.
+func applyConfigProfileRequest(profile *models.ConfigProfile, req *models.ConfigProfileRequest) string {
+	if req.CheckInInterval != 0 && (req.CheckInInterval < 30 || req.CheckInInterval > 3600) {
+		return "check_in_interval must be between 30 and 3600 seconds"
+	}
+
+	switch req.LogLevel {
+	case "", "debug", "info", "warn", "error":
+	default:
+		return "invalid log_level"
+	}
+
+	for _, name := range req.Scanners {
+		valid := false
+		for _, scanner := range models.ConfigProfileScanners {
+			if name == scanner {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return "invalid scanner: " + name
+		}
+	}
+
+	profile.Name = req.Name
+	profile.Description = req.Description
+	profile.IsDefault = req.IsDefault
+	profile.CheckInInterval = req.CheckInInterval
+	profile.LogLevel = req.LogLevel
+	profile.Scanners = nil
+	if len(req.Scanners) > 0 {
+		profile.Scanners = models.StringArray(req.Scanners)
+	}
+	return ""
+}
@@ -33,10 +33,11 @@ func (h *DockerHandler) GetContainers(c *gin.Context) {
 	status := c.Query("status")
 
 	filters := &models.UpdateFilters{
-		PackageType: "docker_image",
-		Page:        page,
-		PageSize:    pageSize,
-		Status:      status,
+		PackageType:    "docker_image",
+		Page:           page,
+		PageSize:       pageSize,
+		Status:         status,
+		ComposeProject: c.Query("compose_project"),
 	}
 
 	// Parse agent_id if provided
@@ -128,6 +129,8 @@ func (h *DockerHandler) GetContainers(c *gin.Context) {
 			CurrentVersion:   update.CurrentVersion,
 			AvailableVersion: update.AvailableVersion,
 		}
+		container.ComposeProject, _ = update.Metadata["compose_project"].(string)
+		container.ComposeService, _ = update.Metadata["compose_service"].(string)
 
 		// Add image to unique set
 		imageKey := update.PackageName + ":" + update.AvailableVersion
@@ -164,11 +167,12 @@ func (h *DockerHandler) GetAgentContainers(c *gin.Context) {
 	status := c.Query("status")
 
 	filters := &models.UpdateFilters{
-		AgentID:     agentID,
-		PackageType: "docker_image",
-		Page:        page,
-		PageSize:    pageSize,
-		Status:      status,
+		AgentID:        agentID,
+		PackageType:    "docker_image",
+		Page:           page,
+		PageSize:       pageSize,
+		Status:         status,
+		ComposeProject: c.Query("compose_project"),
 	}
 
 	// Get Docker updates for specific agent
@@ -246,6 +250,8 @@ func (h *DockerHandler) GetAgentContainers(c *gin.Context) {
 			CurrentVersion:   update.CurrentVersion,
 			AvailableVersion: update.AvailableVersion,
 		}
+		container.ComposeProject, _ = update.Metadata["compose_project"].(string)
+		container.ComposeService, _ = update.Metadata["compose_service"].(string)
 
 		imageKey := update.PackageName + ":" + update.AvailableVersion
 		uniqueImages[imageKey] = true
@@ -323,6 +329,55 @@ func (h *DockerHandler) GetStats(c *gin.Context) {
 	c.JSON(http.StatusOK, stats)
 }
 
+// GetComposeStacks returns outstanding Docker updates grouped by compose project
+func (h *DockerHandler) GetComposeStacks(c *gin.Context) {
+	var agentID uuid.UUID
+	if agent := c.Query("agent"); agent != "" {
+		parsedID, err := uuid.Parse(agent)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent ID"})
+			return
+		}
+		agentID = parsedID
+	}
+
+	stacks, err := h.updateQueries.ListComposeStacks(agentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to fetch compose stacks"})
+		return
+	}
+	if stacks == nil {
+		stacks = []models.DockerComposeStack{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"stacks": stacks,
+		"total":  len(stacks),
+	})
+}
+
+// ApproveComposeStack approves every pending image update in an agent's compose project
+func (h *DockerHandler) ApproveComposeStack(c *gin.Context) {
+	agentID, err := uuid.Parse(c.Param("agent_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent ID"})
+		return
+	}
+	project := c.Param("project")
+
+	approved, err := h.updateQueries.ApproveComposeStack(agentID, project, "admin")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to approve compose stack"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "compose stack updates approved",
+		"project": project,
+		"count":   approved,
+	})
+}
+
 // ApproveUpdate approves a Docker image update
 func (h *DockerHandler) ApproveUpdate(c *gin.Context) {
 	containerID := c.Param("container_id")
@@ -414,24 +469,26 @@ func (h *DockerHandler) InstallUpdate(c *gin.Context) {
 		return
 	}
 
-	// Create a command for the agent to install the update
-	// This would trigger the agent to pull the new image
+	// Create a command for the agent to install the update: it pulls the new
+	// image and recreates the containers running it, rolling back on failure
 	command := &models.AgentCommand{
 		ID:          uuid.New(),
 		AgentID:     update.AgentID,
-		CommandType: models.CommandTypeScanUpdates, // Reuse scan for Docker updates
+		CommandType: models.CommandTypeInstallUpdate,
 		Params: models.JSONB{
-			"package_type": "docker",
+			"update_id":    update.ID.String(),
+			"package_type": update.PackageType,
 			"package_name": update.PackageName,
 			"target_version": update.AvailableVersion,
 			"container_id": containerID,
 		},
 		Status: models.CommandStatusPending,
 		Source: models.CommandSourceManual, // User-initiated Docker update
+		RequestID: requestIDRef(c),
 	}
 
-	if err := h.commandQueries.CreateCommand(command); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create Docker update command"})
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(command); err != nil {
+		commandCreateFailed(c, err, "failed to create Docker update command")
 		return
 	}
 
@@ -8,19 +8,23 @@ import (
 	"strings"
 
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/config"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/services"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/signing"
 	"github.com/gin-gonic/gin"
 )
 
 // DownloadHandler handles agent binary downloads
 type DownloadHandler struct {
-	agentDir string
-	config   *config.Config
+	agentDir       string
+	config         *config.Config
+	releaseService *services.ReleaseService
 }
 
-func NewDownloadHandler(agentDir string, cfg *config.Config) *DownloadHandler {
+func NewDownloadHandler(agentDir string, cfg *config.Config, releaseService *services.ReleaseService) *DownloadHandler {
 	return &DownloadHandler{
-		agentDir: agentDir,
-		config:   cfg,
+		agentDir:       agentDir,
+		config:         cfg,
+		releaseService: releaseService,
 	}
 }
 
@@ -90,6 +94,15 @@ func (h *DownloadHandler) DownloadAgent(c *gin.Context) {
 		return
 	}
 
+	// Release metadata so clients can verify what they downloaded
+	if release, err := h.releaseService.Describe(platform); err == nil {
+		c.Header("X-Agent-Version", release.Version)
+		c.Header("X-Checksum-SHA256", release.SHA256)
+		if release.Signature != "" {
+			c.Header("X-Signature-Ed25519", release.Signature)
+		}
+	}
+
 	// Handle both GET and HEAD requests
 	if c.Request.Method == "HEAD" {
 		c.Status(http.StatusOK)
@@ -99,6 +112,51 @@ func (h *DownloadHandler) DownloadAgent(c *gin.Context) {
 	c.File(agentPath)
 }
 
+// GetManifest lists the published agent binaries with their version,
+// SHA-256 and Ed25519 signature
+func (h *DownloadHandler) GetManifest(c *gin.Context) {
+	manifest, err := h.releaseService.Manifest()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to build release manifest"})
+		return
+	}
+
+	c.JSON(http.StatusOK, manifest)
+}
+
+// releaseScriptVars renders the expected checksum and signature of each
+// platform's binary as script variable assignments. prefix/quote adapt the
+// output to bash ("", `"`) or cmd ("set ", "").
+func (h *DownloadHandler) releaseScriptVars(osName, prefix, quote string) string {
+	var b strings.Builder
+	for _, platform := range services.SupportedPlatforms {
+		if !strings.HasPrefix(platform, osName+"-") {
+			continue
+		}
+		var sum, sig string
+		if release, err := h.releaseService.Describe(platform); err == nil {
+			sum, sig = release.SHA256, release.Signature
+		}
+		name := strings.ToUpper(strings.ReplaceAll(platform, "-", "_"))
+		fmt.Fprintf(&b, "%sSHA256_%s=%s%s%s\n", prefix, name, quote, sum, quote)
+		fmt.Fprintf(&b, "%sSIGNATURE_%s=%s%s%s\n", prefix, name, quote, sig, quote)
+	}
+	return b.String()
+}
+
+// releasePublicKeyPEM returns the release signing key in PEM form, or "" when
+// release signing isn't configured
+func (h *DownloadHandler) releasePublicKeyPEM() string {
+	if h.releaseService.PublicKey() == "" {
+		return ""
+	}
+	keyPEM, err := signing.PublicKeyPEM(h.releaseService.PublicKey())
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(keyPEM)
+}
+
 // InstallScript serves the installation script
 func (h *DownloadHandler) InstallScript(c *gin.Context) {
 	platform := c.Param("platform")
@@ -135,8 +193,16 @@ AGENT_HOME="/var/lib/redflag-agent"
 AGENT_BINARY="/usr/local/bin/redflag-agent"
 SUDOERS_FILE="/etc/sudoers.d/redflag-agent"
 SERVICE_FILE="/etc/systemd/system/redflag-agent.service"
+CONTROL_GROUP="redflag"
+UPDATE_SERVICE_FILE="/etc/systemd/system/redflag-agent-update.service"
 CONFIG_DIR="/etc/aggregator"
+STATE_DIR="/var/lib/aggregator"
 
+# Release verification data (see /api/v1/downloads/manifest)
+UPDATE_PUBLIC_KEY="` + h.releaseService.PublicKey() + `"
+UPDATE_PUBLIC_KEY_PEM="` + h.releasePublicKeyPEM() + `"
+UPDATE_KEY_FILE="/etc/redflag-agent/update.pub"
+` + h.releaseScriptVars("linux", "", `"`) + `
 echo "=== RedFlag Agent Installation ==="
 echo ""
 
@@ -174,6 +240,13 @@ else
     echo "✓ User $AGENT_USER created"
 fi
 
+# Members of the redflag group may use the agent's local control socket
+if ! getent group "$CONTROL_GROUP" &>/dev/null; then
+    groupadd -r "$CONTROL_GROUP"
+    echo "✓ Group $CONTROL_GROUP created"
+fi
+usermod -aG "$CONTROL_GROUP" "$AGENT_USER"
+
 # Create home directory if it doesn't exist
 if [ ! -d "$AGENT_HOME" ]; then
     mkdir -p "$AGENT_HOME"
@@ -195,28 +268,21 @@ echo ""
 echo "Step 2: Downloading agent binary..."
 echo "Downloading from ${REDFLAG_SERVER}/api/v1/downloads/linux-${DOWNLOAD_ARCH}..."
 
-# Download to temporary file first (to avoid root permission issues)
+# Download to temporary file first - it is only installed once verified
 TEMP_FILE="/tmp/redflag-agent-${DOWNLOAD_ARCH}"
+rm -f "$TEMP_FILE"
 echo "Downloading to temporary file: $TEMP_FILE"
 
 # Try curl first (most reliable)
-if curl -sL "${REDFLAG_SERVER}/api/v1/downloads/linux-${DOWNLOAD_ARCH}" -o "$TEMP_FILE"; then
-    echo "✓ Download successful, moving to final location"
-    mv "$TEMP_FILE" "${AGENT_BINARY}"
-    chmod 755 "${AGENT_BINARY}"
-    chown root:root "${AGENT_BINARY}"
-    echo "✓ Agent binary downloaded and installed"
+if curl -sfL "${REDFLAG_SERVER}/api/v1/downloads/linux-${DOWNLOAD_ARCH}" -o "$TEMP_FILE"; then
+    echo "✓ Download successful"
 else
     echo "✗ Download with curl failed"
     # Fallback to wget if available
     if command -v wget >/dev/null 2>&1; then
         echo "Trying wget fallback..."
         if wget -q "${REDFLAG_SERVER}/api/v1/downloads/linux-${DOWNLOAD_ARCH}" -O "$TEMP_FILE"; then
-            echo "✓ Download successful with wget, moving to final location"
-            mv "$TEMP_FILE" "${AGENT_BINARY}"
-            chmod 755 "${AGENT_BINARY}"
-            chown root:root "${AGENT_BINARY}"
-            echo "✓ Agent binary downloaded and installed (using wget fallback)"
+            echo "✓ Download successful with wget"
         else
             echo "ERROR: Failed to download agent binary"
             echo "Both curl and wget failed"
@@ -235,8 +301,66 @@ else
     fi
 fi
 
-# Clean up temp file if it still exists
-rm -f "$TEMP_FILE"
+# Verify the binary before it is installed and run as a service
+echo "Verifying agent binary..."
+case "$DOWNLOAD_ARCH" in
+    amd64)
+        EXPECTED_SHA256="$SHA256_LINUX_AMD64"
+        EXPECTED_SIGNATURE="$SIGNATURE_LINUX_AMD64"
+        ;;
+    arm64)
+        EXPECTED_SHA256="$SHA256_LINUX_ARM64"
+        EXPECTED_SIGNATURE="$SIGNATURE_LINUX_ARM64"
+        ;;
+esac
+
+if [ -z "$EXPECTED_SHA256" ]; then
+    echo "ERROR: Server did not publish a checksum for linux-${DOWNLOAD_ARCH}"
+    rm -f "$TEMP_FILE"
+    exit 1
+fi
+
+ACTUAL_SHA256=$(sha256sum "$TEMP_FILE" | awk '{print $1}')
+if [ "$ACTUAL_SHA256" != "$EXPECTED_SHA256" ]; then
+    echo "ERROR: Checksum mismatch - refusing to install"
+    echo "  Expected: $EXPECTED_SHA256"
+    echo "  Actual:   $ACTUAL_SHA256"
+    rm -f "$TEMP_FILE"
+    exit 1
+fi
+echo "✓ SHA-256 checksum verified"
+
+if [ -n "$UPDATE_PUBLIC_KEY_PEM" ]; then
+    if [ -z "$EXPECTED_SIGNATURE" ]; then
+        echo "ERROR: Agent binary for linux-${DOWNLOAD_ARCH} is not signed - refusing to install"
+        rm -f "$TEMP_FILE"
+        exit 1
+    fi
+    if ! command -v openssl >/dev/null 2>&1; then
+        echo "ERROR: openssl is required to verify the agent binary signature"
+        rm -f "$TEMP_FILE"
+        exit 1
+    fi
+
+    echo "$UPDATE_PUBLIC_KEY_PEM" > "$TEMP_FILE.pem"
+    echo "$EXPECTED_SIGNATURE" | base64 -d > "$TEMP_FILE.sig"
+    if openssl pkeyutl -verify -pubin -inkey "$TEMP_FILE.pem" -rawin -in "$TEMP_FILE" -sigfile "$TEMP_FILE.sig" >/dev/null 2>&1; then
+        rm -f "$TEMP_FILE.pem" "$TEMP_FILE.sig"
+        echo "✓ Ed25519 signature verified"
+    else
+        echo "ERROR: Signature verification failed - refusing to install"
+        echo "(Ed25519 verification requires OpenSSL 3.0 or newer)"
+        rm -f "$TEMP_FILE" "$TEMP_FILE.pem" "$TEMP_FILE.sig"
+        exit 1
+    fi
+else
+    echo "WARNING: Server has no release signing key configured - signature not verified"
+fi
+
+mv "$TEMP_FILE" "${AGENT_BINARY}"
+chmod 755 "${AGENT_BINARY}"
+chown root:root "${AGENT_BINARY}"
+echo "✓ Agent binary downloaded and installed"
 
 # Set SELinux context for binary if SELinux is enabled
 if command -v getenforce >/dev/null 2>&1 && [ "$(getenforce)" != "Disabled" ]; then
@@ -263,13 +387,70 @@ redflag-agent ALL=(root) NOPASSWD: /usr/bin/apt-get install --dry-run --yes *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf makecache
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf install -y *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf upgrade -y *
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf downgrade -y *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/dnf install --assumeno --downloadonly *
 
 # Docker operations
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker pull *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker image inspect *
 redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker manifest inspect *
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/docker tag *
+# Compose-managed containers are updated through the agent's root helper, which
+# only runs "pull <service>" or "up -d --no-deps <service>" with the compose
+# files an existing container of that service was deployed from. A rule for
+# docker compose itself would accept any compose file.
+redflag-agent ALL=(root) NOPASSWD: /usr/local/bin/redflag-agent update-compose-service *
+
+# Podman systemd-managed containers (restarted onto updated images). systemctl
+# itself would allow any unit, so restarts go through the agent's root helper,
+# which only restarts a unit that runs a Podman container
+redflag-agent ALL=(root) NOPASSWD: /usr/local/bin/redflag-agent restart-podman-unit *
+
+# Pre-install filesystem snapshots (btrfs, LVM, ZFS). Snapshot names are spelled
+# out as redflag-YYYYMMDDTHHMMSSZ: a sudoers * also matches spaces and "..", so
+# it must never appear in a rule that deletes something. LVM and ZFS rules name
+# the root volume or dataset and are added below when / is on one.
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/mkdir -p /.redflag-snapshots
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/btrfs subvolume snapshot -r / /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/btrfs subvolume snapshot -r / /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/btrfs subvolume delete /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/btrfs subvolume delete /.redflag-snapshots/redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+# Hardware serial numbers for spec collection (root-only in sysfs)
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/dmidecode -s *
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/dmidecode -s *
+
+# Agent self-update (the swap runs in a separate root unit outside the agent's sandbox)
+redflag-agent ALL=(root) NOPASSWD: /usr/bin/systemctl start --no-block redflag-agent-update.service
+SUDOERS_EOF
+
+# ZFS snapshot rules name the root dataset exactly - with a wildcard a crafted
+# argument could reach other datasets and snapshots
+ZFS_ROOT=$(awk '$2 == "/" { dev = $1; fs = $3 } END { if (fs == "zfs") print dev }' /proc/mounts)
+if [ -n "$ZFS_ROOT" ] && echo "$ZFS_ROOT" | grep -Eq '^[A-Za-z0-9][A-Za-z0-9_./-]*$'; then
+    cat >> "$SUDOERS_FILE" <<SUDOERS_EOF
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/zfs snapshot $ZFS_ROOT@redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/zfs destroy $ZFS_ROOT@redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/zfs list -H -t snapshot -o name $ZFS_ROOT
+SUDOERS_EOF
+fi
+
+# LVM snapshot rules name the root device, volume group and vg/lv exactly, and
+# the agent passes --size in whole gigabytes so it can be matched digit by digit
+ROOT_DEVICE=$(awk '$2 == "/" { dev = $1; fs = $3 } END { if (fs != "btrfs" && fs != "zfs") print dev }' /proc/mounts)
+if echo "$ROOT_DEVICE" | grep -Eq '^/dev/(mapper/[A-Za-z0-9+_.-]+|dm-[0-9]+)$'; then
+    LVM_ROOT=$(lvs --noheadings --separator / -o vg_name,lv_name "$ROOT_DEVICE" 2>/dev/null | tail -n 1 | tr -d ' ')
+    if echo "$LVM_ROOT" | grep -Eq '^[A-Za-z0-9+_.][A-Za-z0-9+_.-]*/[A-Za-z0-9+_.][A-Za-z0-9+_.-]*$'; then
+        LVM_VG="${LVM_ROOT%%/*}"
+        cat >> "$SUDOERS_FILE" <<SUDOERS_EOF
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvs --noheadings --separator / -o vg_name\,lv_name $ROOT_DEVICE
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvs --noheadings -o lv_name $LVM_VG
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvcreate --snapshot --name redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z --size [1-9]G $LVM_ROOT
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvcreate --snapshot --name redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z --size [1-9][0-9]G $LVM_ROOT
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvcreate --snapshot --name redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z --size [1-9][0-9][0-9]G $LVM_ROOT
+redflag-agent ALL=(root) NOPASSWD: /usr/sbin/lvremove -y --select lv_name\=redflag-[0-9][0-9][0-9][0-9][0-9][0-9][0-9][0-9]T[0-9][0-9][0-9][0-9][0-9][0-9]Z
 SUDOERS_EOF
+    fi
+fi
 
 chmod 440 "$SUDOERS_FILE"
 
@@ -288,8 +469,34 @@ echo "Step 4: Creating configuration directory..."
 mkdir -p "$CONFIG_DIR"
 chown "$AGENT_USER:$AGENT_USER" "$CONFIG_DIR"
 chmod 755 "$CONFIG_DIR"
+# Hook scripts stay root-owned: the agent runs them but must not be able to change them
+for phase in pre-install post-install pre-reboot post-reboot; do
+    mkdir -p "$CONFIG_DIR/hooks/$phase.d"
+    chown root:root "$CONFIG_DIR/hooks/$phase.d"
+    chmod 755 "$CONFIG_DIR/hooks/$phase.d"
+done
+chown root:root "$CONFIG_DIR/hooks"
+chmod 755 "$CONFIG_DIR/hooks"
 echo "✓ Configuration directory created"
 
+# Self-update trusts only this root-owned key, never the agent's own config
+if [ -n "$UPDATE_PUBLIC_KEY" ]; then
+    mkdir -p "$(dirname "$UPDATE_KEY_FILE")"
+    chown root:root "$(dirname "$UPDATE_KEY_FILE")"
+    chmod 755 "$(dirname "$UPDATE_KEY_FILE")"
+    echo "$UPDATE_PUBLIC_KEY" > "$UPDATE_KEY_FILE"
+    chown root:root "$UPDATE_KEY_FILE"
+    chmod 644 "$UPDATE_KEY_FILE"
+    echo "✓ Update public key installed in $UPDATE_KEY_FILE"
+else
+    echo "⚠ Server has no release signing key - agent self-update stays disabled"
+fi
+
+# State directory: scan cache and the outbox of reports awaiting upload
+mkdir -p "$STATE_DIR"
+chown "$AGENT_USER:$AGENT_USER" "$STATE_DIR"
+chmod 700 "$STATE_DIR"
+
 # Set SELinux context for config directory if SELinux is enabled
 if command -v getenforce >/dev/null 2>&1 && [ "$(getenforce)" != "Disabled" ]; then
     echo "Setting SELinux context for config directory..."
@@ -315,11 +522,19 @@ ExecStart=$AGENT_BINARY
 Restart=always
 RestartSec=30
 
+# Control socket lives in /run/redflag-agent
+RuntimeDirectory=redflag-agent
+RuntimeDirectoryMode=0755
+
 # Security hardening
 # NoNewPrivileges=true - DISABLED: Prevents sudo from working, which agent needs for package management
 ProtectSystem=strict
-ProtectHome=true
-ReadWritePaths=$AGENT_HOME /var/log $CONFIG_DIR
+# /run/user stays visible (read-only) so rootless Podman sockets can be reached
+ProtectHome=tmpfs
+BindReadOnlyPaths=-/run/user
+# Root helpers run through sudo inherit this sandbox: snapshot and LVM paths
+# must be writable for pre-install snapshots (the agent user still can't write them)
+ReadWritePaths=$AGENT_HOME /var/log $CONFIG_DIR $STATE_DIR -/.redflag-snapshots -/etc/lvm -/run/lock/lvm
 PrivateTmp=true
 
 # Logging
@@ -334,6 +549,55 @@ SERVICE_EOF
 chmod 644 "$SERVICE_FILE"
 echo "✓ Systemd service installed"
 
+# Snapshots are written under /.redflag-snapshots, which the unit's
+# ReadWritePaths opens up; create it now since / is read-only inside the sandbox
+if [ "$(awk '$2 == "/" { fs = $3 } END { print fs }' /proc/mounts)" = "btrfs" ]; then
+    mkdir -p /.redflag-snapshots
+    chmod 700 /.redflag-snapshots
+fi
+
+# Rootless Podman: /run/user/<uid> is private to each user, so the agent can
+# only reach the Podman API of users listed in $PODMAN_USERS_FILE (seeded from
+# REDFLAG_PODMAN_USERS). Their podman.socket grants the agent an ACL on start.
+PODMAN_USERS_FILE="/etc/redflag-agent/podman-users"
+mkdir -p /etc/redflag-agent /etc/systemd/user/podman.socket.d
+touch "$PODMAN_USERS_FILE"
+chmod 644 "$PODMAN_USERS_FILE"
+for podman_user in $REDFLAG_PODMAN_USERS; do
+    if id "$podman_user" >/dev/null 2>&1 && ! grep -qxF "$podman_user" "$PODMAN_USERS_FILE"; then
+        echo "$podman_user" >> "$PODMAN_USERS_FILE"
+    fi
+done
+cat > /etc/systemd/user/podman.socket.d/redflag-agent.conf <<'PODMAN_EOF'
+# Installed by RedFlag: lets the redflag-agent user reach the rootless Podman
+# API of users listed in /etc/redflag-agent/podman-users
+[Socket]
+ExecStartPost=-/bin/sh -c 'grep -qxF "%u" /etc/redflag-agent/podman-users || exit 0; setfacl -m u:redflag-agent:x "%t" && setfacl -m u:redflag-agent:rw "%t/podman/podman.sock"'
+PODMAN_EOF
+chmod 644 /etc/systemd/user/podman.socket.d/redflag-agent.conf
+while read -r podman_user; do
+    [ -n "$podman_user" ] || continue
+    systemctl --user -M "$podman_user@" daemon-reload 2>/dev/null || continue
+    systemctl --user -M "$podman_user@" try-restart podman.socket 2>/dev/null || true
+    echo "✓ Rootless Podman access granted for $podman_user"
+done < "$PODMAN_USERS_FILE"
+
+# Self-update unit: verifies the binary the agent staged, swaps it in, restarts
+# the agent and rolls back if the new version doesn't check in
+cat > "$UPDATE_SERVICE_FILE" <<SERVICE_EOF
+[Unit]
+Description=RedFlag Agent Self-Update
+Documentation=https://github.com/Fimeg/RedFlag
+
+[Service]
+Type=simple
+ExecStart=$AGENT_BINARY -apply-update
+SyslogIdentifier=redflag-agent-update
+SERVICE_EOF
+
+chmod 644 "$UPDATE_SERVICE_FILE"
+echo "✓ Self-update service installed"
+
 # Step 6: Register agent with server
 echo ""
 echo "Step 6: Agent registration"
@@ -477,6 +741,7 @@ fi
 echo "Useful commands:"
 echo "  Check status:  sudo systemctl status redflag-agent"
 echo "  View logs:     sudo journalctl -u redflag-agent -f"
+echo "  Live status:   sudo redflag-agent status --live"
 echo "  Restart:       sudo systemctl restart redflag-agent"
 echo "  Stop:          sudo systemctl stop redflag-agent"
 echo ""
@@ -485,6 +750,8 @@ echo "  Config file:   $CONFIG_DIR/config.json"
 echo "  Binary:        $AGENT_BINARY"
 echo "  Service:       $SERVICE_FILE"
 echo "  Sudoers:       $SUDOERS_FILE"
+echo "  Snapshots:     /.redflag-snapshots (btrfs), writable through the unit's ReadWritePaths"
+echo "  Podman users:  $PODMAN_USERS_FILE (rootless users whose Podman the agent may manage)"
 echo ""
 `
 
@@ -502,6 +769,8 @@ set AGENT_DIR=%ProgramFiles%\RedFlag
 set AGENT_BINARY=%AGENT_DIR%\redflag-agent.exe
 set CONFIG_DIR=%ProgramData%\RedFlag
 
+REM Release verification data (see /api/v1/downloads/manifest)
+` + h.releaseScriptVars("windows", "set ", "") + `
 echo === RedFlag Agent Installation ===
 echo.
 
@@ -565,6 +834,29 @@ if %errorLevel% neq 0 (
     exit /b 1
 )
 echo [OK] Agent binary downloaded
+
+REM Verify the checksum (cmd has no Ed25519 support, so the signature is not checked here)
+set EXPECTED_SHA256=
+if "%DOWNLOAD_ARCH%"=="amd64" set EXPECTED_SHA256=%SHA256_WINDOWS_AMD64%
+if "%DOWNLOAD_ARCH%"=="arm64" set EXPECTED_SHA256=%SHA256_WINDOWS_ARM64%
+if "%EXPECTED_SHA256%"=="" (
+    echo ERROR: Server did not publish a checksum for windows-%DOWNLOAD_ARCH%
+    del "%AGENT_BINARY%" >nul 2>&1
+    pause
+    exit /b 1
+)
+set ACTUAL_SHA256=
+for /f "delims=" %%H in ('certutil -hashfile "%AGENT_BINARY%" SHA256 ^| findstr /v ":"') do set ACTUAL_SHA256=%%H
+set ACTUAL_SHA256=%ACTUAL_SHA256: =%
+if /i not "%ACTUAL_SHA256%"=="%EXPECTED_SHA256%" (
+    echo ERROR: Checksum mismatch - refusing to install
+    echo   Expected: %EXPECTED_SHA256%
+    echo   Actual:   %ACTUAL_SHA256%
+    del "%AGENT_BINARY%" >nul 2>&1
+    pause
+    exit /b 1
+)
+echo [OK] SHA-256 checksum verified
 echo.
 
 REM Agent registration
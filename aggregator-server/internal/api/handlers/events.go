@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/events"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type EventHandler struct {
+	bus *events.Bus
+}
+
+func NewEventHandler(bus *events.Bus) *EventHandler {
+	return &EventHandler{
+		bus: bus,
+	}
+}
+
+// StreamEvents streams operational events to the dashboard as Server-Sent Events.
+// Query params: agent_id, command_id, types (comma-separated event types).
+// Reconnecting clients send Last-Event-ID (header, or last_event_id query param)
+// to replay anything they missed; if that isn't possible a "reset" event tells
+// them to reload state from the REST endpoints.
+func (h *EventHandler) StreamEvents(c *gin.Context) {
+	var filter events.Filter
+
+	if agentIDStr := c.Query("agent_id"); agentIDStr != "" {
+		id, err := uuid.Parse(agentIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent ID"})
+			return
+		}
+		filter.AgentID = &id
+	}
+	if commandIDStr := c.Query("command_id"); commandIDStr != "" {
+		id, err := uuid.Parse(commandIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid command ID"})
+			return
+		}
+		filter.CommandID = &id
+	}
+	if types := c.Query("types"); types != "" {
+		filter.Types = make(map[string]bool)
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				filter.Types[t] = true
+			}
+		}
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	sub, replay, resumed := h.bus.Subscribe(filter, lastEventID)
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no") // Disable proxy buffering (nginx)
+	c.Status(http.StatusOK)
+
+	w := c.Writer
+	// Ask browsers to wait a few seconds before reconnecting
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	if !resumed {
+		fmt.Fprintf(w, "event: reset\ndata: {\"reason\":\"event history unavailable, reload state\"}\n\n")
+	}
+	for i := range replay {
+		if err := writeSSEEvent(w, &replay[i]); err != nil {
+			return
+		}
+	}
+	w.Flush()
+
+	keepalive := time.NewTicker(30 * time.Second)
+	defer keepalive.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				// Dropped for falling behind - client reconnects with Last-Event-ID
+				return
+			}
+			if err := writeSSEEvent(w, &event); err != nil {
+				return
+			}
+			w.Flush()
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			w.Flush()
+		}
+	}
+}
+
+func writeSSEEvent(w gin.ResponseWriter, event *events.Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: %s\ndata: %s\n\n", event.ID, event.Type, data)
+	return err
+}
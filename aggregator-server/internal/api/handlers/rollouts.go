@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type RolloutHandler struct {
+	rolloutQueries *queries.RolloutQueries
+	rolloutService *services.RolloutService
+}
+
+func NewRolloutHandler(rq *queries.RolloutQueries, rs *services.RolloutService) *RolloutHandler {
+	return &RolloutHandler{
+		rolloutQueries: rq,
+		rolloutService: rs,
+	}
+}
+
+// ListRollouts returns rollouts, optionally filtered by status
+func (h *RolloutHandler) ListRollouts(c *gin.Context) {
+	status := c.Query("status")
+	switch status {
+	case "", models.RolloutStatusRunning, models.RolloutStatusPaused, models.RolloutStatusHalted,
+		models.RolloutStatusCompleted, models.RolloutStatusAborted:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid status"})
+		return
+	}
+
+	rollouts, err := h.rolloutQueries.ListRollouts(status)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list rollouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"rollouts": rollouts,
+		"total":    len(rollouts),
+	})
+}
+
+// CreateRollout plans a staged rollout of approved updates and starts its first ring
+func (h *RolloutHandler) CreateRollout(c *gin.Context) {
+	var req models.RolloutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rollout, err := h.rolloutService.CreateRollout(&req, currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, rollout)
+}
+
+// GetRollout returns a rollout with its rings and per-agent targets
+func (h *RolloutHandler) GetRollout(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rollout ID"})
+		return
+	}
+
+	rollout, err := h.rolloutQueries.GetRolloutDetail(id)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusNotFound, gin.H{"error": "rollout not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get rollout"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rollout)
+}
+
+// PauseRollout stops a running rollout from dispatching further installs
+func (h *RolloutHandler) PauseRollout(c *gin.Context) {
+	h.rolloutAction(c, h.rolloutService.PauseRollout, "rollout paused")
+}
+
+// ResumeRollout continues a paused rollout, or overrides a halt and moves to the next ring
+func (h *RolloutHandler) ResumeRollout(c *gin.Context) {
+	h.rolloutAction(c, h.rolloutService.ResumeRollout, "rollout resumed")
+}
+
+// AbortRollout cancels a rollout's remaining installs
+func (h *RolloutHandler) AbortRollout(c *gin.Context) {
+	h.rolloutAction(c, h.rolloutService.AbortRollout, "rollout aborted")
+}
+
+func (h *RolloutHandler) rolloutAction(c *gin.Context, action func(uuid.UUID) error, message string) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid rollout ID"})
+		return
+	}
+
+	if err := action(id); err != nil {
+		switch {
+		case errors.Is(err, services.ErrRolloutNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrRolloutState):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": message})
+}
@@ -3,6 +3,7 @@ package handlers
 import (
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strconv"
 
@@ -45,7 +46,7 @@ func updatePostgresPassword(dbHost, dbPort, dbUser, currentPassword, newPassword
 		return fmt.Errorf("failed to update PostgreSQL password: %v", err)
 	}
 
-	fmt.Println("PostgreSQL password updated successfully")
+	slog.Info("PostgreSQL password updated")
 	return nil
 }
 
@@ -96,7 +97,7 @@ REDFLAG_MAX_SEATS=%s`,
 // ShowSetupPage displays the web setup interface
 func (h *SetupHandler) ShowSetupPage(c *gin.Context) {
 	// Display setup page - configuration will be generated via web interface
-	fmt.Println("Showing setup page - configuration will be generated via web interface")
+	slog.Info("showing setup page - configuration will be generated via web interface")
 
 	html := `
 <!DOCTYPE html>
@@ -381,20 +382,19 @@ func (h *SetupHandler) ConfigureServer(c *gin.Context) {
 	}
 
 	// Step 1: Update PostgreSQL password from bootstrap to user password
-	fmt.Println("Updating PostgreSQL password from bootstrap to user-provided password...")
+	slog.Info("updating PostgreSQL password from bootstrap to user-provided password")
 	bootstrapPassword := "redflag_bootstrap"  // This matches our bootstrap .env
 	if err := updatePostgresPassword(req.DBHost, req.DBPort, req.DBUser, bootstrapPassword, req.DBPassword); err != nil {
-		fmt.Printf("Warning: Failed to update PostgreSQL password: %v\n", err)
-		fmt.Println("Will proceed with configuration anyway...")
+		slog.Warn("failed to update PostgreSQL password, proceeding with configuration anyway", "error", err)
 	}
 
 	// Step 2: Generate configuration content for manual update
-	fmt.Println("Generating configuration content for manual .env file update...")
+	slog.Info("generating configuration content for manual .env file update")
 
 	// Generate the complete .env file content for the user to copy
 	newEnvContent, err := createSharedEnvContentForDisplay(req, jwtSecret)
 	if err != nil {
-		fmt.Printf("Failed to generate .env content: %v\n", err)
+		slog.Error("failed to generate .env content", "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate configuration content"})
 		return
 	}
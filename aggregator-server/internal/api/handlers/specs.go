@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/api/middleware"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+type SpecsHandler struct {
+	agentSpecsQueries *queries.AgentSpecsQueries
+	agentQueries      *queries.AgentQueries
+	commandQueries    *queries.CommandQueries
+}
+
+func NewSpecsHandler(asq *queries.AgentSpecsQueries, aq *queries.AgentQueries, cq *queries.CommandQueries) *SpecsHandler {
+	return &SpecsHandler{
+		agentSpecsQueries: asq,
+		agentQueries:      aq,
+		commandQueries:    cq,
+	}
+}
+
+// ReportSpecs handles the inventory an agent collected for collect_specs. A new
+// snapshot is stored only when something changed since the last one.
+func (h *SpecsHandler) ReportSpecs(c *gin.Context) {
+	agentID := c.MustGet("agent_id").(uuid.UUID)
+
+	var req models.AgentSpecsReport
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	specs := specsFromReport(agentID, &req)
+	specs.CollectedAt = now
+	specs.LastSeenAt = now
+	fields := specFields(specs)
+	specs.Fingerprint = specFingerprint(fields)
+
+	latest, err := h.agentSpecsQueries.GetLatestAgentSpecs(agentID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get agent specs"})
+		return
+	}
+
+	if latest != nil && latest.Fingerprint == specs.Fingerprint {
+		latest.DiskFreeGB = specs.DiskFreeGB
+		latest.Disks = specs.Disks
+		latest.LastSeenAt = now
+		if err := h.agentSpecsQueries.TouchAgentSpecs(latest); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update agent specs"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "specs unchanged", "snapshot_id": latest.ID, "changed": false})
+		return
+	}
+
+	changes := []models.SpecChange{}
+	if latest != nil {
+		changes = diffSpecFields(specFields(latest), fields)
+	}
+
+	if err := h.agentSpecsQueries.CreateAgentSpecs(specs); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store agent specs"})
+		return
+	}
+
+	if latest == nil {
+		middleware.Logger(c).Info("recorded first spec snapshot", "agent_id", agentID)
+	} else {
+		middleware.Logger(c).Info("agent specs changed", "agent_id", agentID, "fields", len(changes))
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "specs recorded",
+		"snapshot_id": specs.ID,
+		"changed":     true,
+		"changes":     changes,
+	})
+}
+
+// ListAgentSpecs returns an agent's current specs and its snapshot history
+func (h *SpecsHandler) ListAgentSpecs(c *gin.Context) {
+	agentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent ID"})
+		return
+	}
+
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	history, err := h.agentSpecsQueries.ListAgentSpecs(agentID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to list agent specs"})
+		return
+	}
+
+	var current *models.AgentSpecs
+	if len(history) > 0 {
+		current = &history[0]
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"current": current,
+		"history": history,
+		"total":   len(history),
+	})
+}
+
+// DiffAgentSpecs compares two of an agent's spec snapshots. "to" defaults to
+// the latest snapshot and "from" to the one collected before "to".
+func (h *SpecsHandler) DiffAgentSpecs(c *gin.Context) {
+	agentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent ID"})
+		return
+	}
+
+	to, msg, status := h.lookupSpecs(agentID, c.Query("to"))
+	if msg != "" {
+		c.JSON(status, gin.H{"error": msg})
+		return
+	}
+	if to == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent has not reported specs"})
+		return
+	}
+
+	var from *models.AgentSpecs
+	if c.Query("from") != "" {
+		from, msg, status = h.lookupSpecs(agentID, c.Query("from"))
+		if msg != "" {
+			c.JSON(status, gin.H{"error": msg})
+			return
+		}
+	} else {
+		from, err = h.agentSpecsQueries.GetPreviousAgentSpecs(to)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get agent specs"})
+			return
+		}
+		if from == nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no earlier spec snapshot to compare with"})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from":    from,
+		"to":      to,
+		"changes": diffSpecFields(specFields(from), specFields(to)),
+	})
+}
+
+// CollectSpecs creates a collect_specs command for an agent
+func (h *SpecsHandler) CollectSpecs(c *gin.Context) {
+	agentID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid agent ID"})
+		return
+	}
+
+	if _, err := h.agentQueries.GetAgentByID(agentID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "agent not found"})
+		return
+	}
+
+	cmd := &models.AgentCommand{
+		ID:          uuid.New(),
+		AgentID:     agentID,
+		CommandType: models.CommandTypeCollectSpecs,
+		Params:      models.JSONB{},
+		Status:      models.CommandStatusPending,
+		Source:      models.CommandSourceManual,
+		RequestID:   requestIDRef(c),
+	}
+
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(cmd); err != nil {
+		commandCreateFailed(c, err, "failed to create command")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "spec collection triggered", "command_id": cmd.ID})
+}
+
+// lookupSpecs resolves a snapshot ID, or the latest snapshot when it is empty.
+// Returns a non-empty message and status if the snapshot can't be found.
+func (h *SpecsHandler) lookupSpecs(agentID uuid.UUID, idStr string) (*models.AgentSpecs, string, int) {
+	if idStr == "" {
+		specs, err := h.agentSpecsQueries.GetLatestAgentSpecs(agentID)
+		if err != nil {
+			return nil, "failed to get agent specs", http.StatusInternalServerError
+		}
+		return specs, "", 0
+	}
+
+	id, err := uuid.Parse(idStr)
+	if err != nil {
+		return nil, "invalid snapshot ID: " + idStr, http.StatusBadRequest
+	}
+	specs, err := h.agentSpecsQueries.GetAgentSpecs(agentID, id)
+	if err == sql.ErrNoRows {
+		return nil, "spec snapshot not found: " + idStr, http.StatusNotFound
+	}
+	if err != nil {
+		return nil, "failed to get agent specs", http.StatusInternalServerError
+	}
+	return specs, "", 0
+}
+
+// specsFromReport builds a snapshot from an agent's report
+func specsFromReport(agentID uuid.UUID, req *models.AgentSpecsReport) *models.AgentSpecs {
+	specs := &models.AgentSpecs{
+		ID:                uuid.New(),
+		AgentID:           agentID,
+		OSType:            req.OSType,
+		OSVersion:         req.OSVersion,
+		OSArchitecture:    req.OSArchitecture,
+		KernelVersion:     req.KernelVersion,
+		CPUModel:          req.CPUModel,
+		CPUCores:          req.CPUCores,
+		CPUThreads:        req.CPUThreads,
+		MemoryTotalMB:     req.MemoryTotalMB,
+		Disks:             models.SpecDisks(req.Disks),
+		NetworkInterfaces: models.SpecNetworkInterfaces(req.NetworkInterfaces),
+		DockerInstalled:   req.DockerVersion != "",
+		DockerVersion:     req.DockerVersion,
+		PackageManagers:   models.StringArray{},
+		DMI:               req.DMI,
+		Virtualization:    req.Virtualization,
+	}
+
+	for _, disk := range req.Disks {
+		specs.DiskTotalGB += disk.TotalGB
+		specs.DiskFreeGB += disk.FreeGB
+	}
+
+	specs.PackageManagers = append(specs.PackageManagers, req.PackageManagers...)
+	sort.Strings(specs.PackageManagers)
+
+	return specs
+}
+
+// specFields flattens a snapshot into the fields compared for change
+// detection, keyed the way changes are reported (e.g. "disks[/].total_gb").
+// Free disk space is left out: it changes constantly and belongs to metrics.
+func specFields(specs *models.AgentSpecs) map[string]interface{} {
+	fields := map[string]interface{}{
+		"os_type":            specs.OSType,
+		"os_version":         specs.OSVersion,
+		"os_architecture":    specs.OSArchitecture,
+		"kernel_version":     specs.KernelVersion,
+		"cpu_model":          specs.CPUModel,
+		"cpu_cores":          specs.CPUCores,
+		"cpu_threads":        specs.CPUThreads,
+		"memory_total_mb":    specs.MemoryTotalMB,
+		"disk_total_gb":      specs.DiskTotalGB,
+		"docker_version":     specs.DockerVersion,
+		"virtualization":     specs.Virtualization,
+		"dmi.system_vendor":  specs.DMI.SystemVendor,
+		"dmi.system_product": specs.DMI.SystemProduct,
+		"dmi.system_serial":  specs.DMI.SystemSerial,
+		"dmi.board_vendor":   specs.DMI.BoardVendor,
+		"dmi.board_product":  specs.DMI.BoardProduct,
+		"dmi.board_serial":   specs.DMI.BoardSerial,
+		"dmi.bios_vendor":    specs.DMI.BIOSVendor,
+		"dmi.bios_version":   specs.DMI.BIOSVersion,
+		"dmi.bios_date":      specs.DMI.BIOSDate,
+	}
+
+	for _, disk := range specs.Disks {
+		key := fmt.Sprintf("disks[%s]", disk.Mountpoint)
+		fields[key+".device"] = disk.Device
+		fields[key+".disk_type"] = disk.DiskType
+		fields[key+".total_gb"] = disk.TotalGB
+	}
+	for _, nic := range specs.NetworkInterfaces {
+		key := fmt.Sprintf("network_interfaces[%s]", nic.Name)
+		fields[key+".mac"] = nic.MAC
+		fields[key+".mtu"] = nic.MTU
+	}
+	for _, manager := range specs.PackageManagers {
+		fields[fmt.Sprintf("package_managers[%s]", manager)] = true
+	}
+
+	return fields
+}
+
+// specFingerprint hashes the compared fields; map keys marshal sorted, so the
+// hash is stable
+func specFingerprint(fields map[string]interface{}) string {
+	data, _ := json.Marshal(fields)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// diffSpecFields lists the fields that differ between two flattened snapshots
+func diffSpecFields(from, to map[string]interface{}) []models.SpecChange {
+	keys := make([]string, 0, len(from)+len(to))
+	for key := range from {
+		keys = append(keys, key)
+	}
+	for key := range to {
+		if _, ok := from[key]; !ok {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	changes := []models.SpecChange{}
+	for _, key := range keys {
+		before, inFrom := from[key]
+		after, inTo := to[key]
+		if inFrom && inTo && before == after {
+			continue
+		}
+		changes = append(changes, models.SpecChange{Field: key, From: before, To: after})
+	}
+	return changes
+}
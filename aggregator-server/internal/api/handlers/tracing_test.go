@@ -0,0 +1,249 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/api/middleware"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/services"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/tracing"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// fakeResult answers queries containing match with rows. Queries nothing
+// matches return no rows; statements succeed with one row affected.
+type fakeResult struct {
+	match   string
+	columns []string
+	rows    [][]driver.Value
+}
+
+// fakeDB is a database/sql driver answering from a fixed set of results, so
+// handlers can run without PostgreSQL
+type fakeDB struct {
+	results []fakeResult
+}
+
+func newFakeDB(results ...fakeResult) *sqlx.DB {
+	return sqlx.NewDb(sql.OpenDB(&fakeDB{results: results}), "postgres")
+}
+
+func (d *fakeDB) Connect(context.Context) (driver.Conn, error) { return &fakeConn{db: d}, nil }
+func (d *fakeDB) Driver() driver.Driver                        { return d }
+func (d *fakeDB) Open(string) (driver.Conn, error)             { return &fakeConn{db: d}, nil }
+
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return &fakeStmt{db: c.db, query: query}, nil
+}
+func (c *fakeConn) Close() error              { return nil }
+func (c *fakeConn) Begin() (driver.Tx, error) { return c, nil }
+func (c *fakeConn) Commit() error             { return nil }
+func (c *fakeConn) Rollback() error           { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s *fakeStmt) Close() error  { return nil }
+func (s *fakeStmt) NumInput() int { return -1 }
+
+func (s *fakeStmt) Exec([]driver.Value) (driver.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+
+func (s *fakeStmt) Query([]driver.Value) (driver.Rows, error) {
+	for _, result := range s.db.results {
+		if strings.Contains(s.query, result.match) {
+			return &fakeRows{columns: result.columns, rows: result.rows}, nil
+		}
+	}
+	return &fakeRows{}, nil
+}
+
+type fakeRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.columns }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// recordSpans routes spans to an in-memory exporter for the rest of the test
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	if _, err := tracing.Setup(context.Background(), tracing.Config{Exporter: tracing.ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func findSpan(t *testing.T, spans tracetest.SpanStubs, name string) tracetest.SpanStub {
+	t.Helper()
+	for _, span := range spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	names := make([]string, 0, len(spans))
+	for _, span := range spans {
+		names = append(names, span.Name)
+	}
+	t.Fatalf("no %q span; got %v", name, names)
+	return tracetest.SpanStub{}
+}
+
+func TestCommandTraceSpansCreateDispatchAndReport(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	exporter := recordSpans(t)
+
+	agentID := uuid.New()
+	cmd := &models.AgentCommand{
+		ID:          uuid.New(),
+		AgentID:     agentID,
+		CommandType: models.CommandTypeScanUpdates,
+		Params:      models.JSONB{},
+		Status:      models.CommandStatusPending,
+		Source:      models.CommandSourceManual,
+		CreatedAt:   time.Now(),
+	}
+
+	db := newFakeDB(
+		fakeResult{match: "SELECT hostname, capabilities FROM agents", columns: []string{"hostname", "capabilities"},
+			rows: [][]driver.Value{{"host-1", nil}}},
+		fakeResult{match: "UPDATE agents a SET last_seen", columns: []string{"status", "hostname"},
+			rows: [][]driver.Value{{"online", "host-1"}}},
+	)
+	commandQueries := queries.NewCommandQueries(db)
+	agentQueries := queries.NewAgentQueries(db)
+	updateQueries := queries.NewUpdateQueries(db)
+
+	// The dashboard request that queues the command
+	requestCtx, requestSpan := tracing.Start(context.Background(), "POST /api/v1/agents/:id/scan")
+	if err := commandQueries.WithContext(requestCtx).CreateCommand(cmd); err != nil {
+		t.Fatalf("CreateCommand: %v", err)
+	}
+	requestSpan.End()
+	if _, ok := cmd.Params[tracing.ParamKey]; !ok {
+		t.Fatalf("CreateCommand didn't store trace context in params: %v", cmd.Params)
+	}
+
+	// The command as the agent's check-in reads it back from the database
+	params, err := json.Marshal(cmd.Params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db = newFakeDB(
+		fakeResult{match: "UPDATE agents a SET last_seen", columns: []string{"status", "hostname"},
+			rows: [][]driver.Value{{"online", "host-1"}}},
+		fakeResult{match: "WHERE agent_id = $1 AND status = 'pending'",
+			columns: []string{"id", "agent_id", "command_type", "params", "status", "source", "created_at"},
+			rows:    [][]driver.Value{{cmd.ID.String(), agentID.String(), cmd.CommandType, params, cmd.Status, cmd.Source, cmd.CreatedAt}}},
+	)
+	commandQueries = queries.NewCommandQueries(db)
+	agentQueries = queries.NewAgentQueries(db)
+	updateQueries = queries.NewUpdateQueries(db)
+
+	agentHandler := NewAgentHandler(agentQueries, commandQueries, nil, nil, queries.NewConfigProfileQueries(db), nil, nil, 300, "0.1.0")
+	rolloutService := services.NewRolloutService(queries.NewRolloutQueries(db), updateQueries, agentQueries, commandQueries, nil)
+	updateHandler := NewUpdateHandler(updateQueries, agentQueries, commandQueries, agentHandler, nil, rolloutService)
+
+	router := gin.New()
+	router.Use(middleware.TracingMiddleware(), func(c *gin.Context) { c.Set("agent_id", agentID) })
+	router.GET("/commands", agentHandler.GetCommands)
+	router.POST("/logs", updateHandler.ReportLog)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/commands", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /commands: %d %s", w.Code, w.Body)
+	}
+	var response models.CommandsResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Commands) != 1 {
+		t.Fatalf("got %d commands, want 1", len(response.Commands))
+	}
+
+	// The agent sends the command's trace context back with its result
+	traceContext, _ := response.Commands[0].Params[tracing.ParamKey].(map[string]interface{})
+	traceparent, _ := traceContext["traceparent"].(string)
+	if traceparent == "" {
+		t.Fatalf("dispatched command has no traceparent: %v", response.Commands[0].Params)
+	}
+	body := `{"command_id":"` + cmd.ID.String() + `","action":"scan","result":"success"}`
+	req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", traceparent)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /logs: %d %s", w.Code, w.Body)
+	}
+
+	spans := exporter.GetSpans()
+	request := findSpan(t, spans, "POST /api/v1/agents/:id/scan")
+	create := findSpan(t, spans, "CommandQueries.CreateCommand")
+	checkIn := findSpan(t, spans, "GET /commands")
+	dispatch := findSpan(t, spans, "command.dispatch")
+	sent := findSpan(t, spans, "CommandQueries.MarkCommandSent")
+	report := findSpan(t, spans, "POST /logs")
+
+	traceID := request.SpanContext.TraceID()
+	if create.Parent.SpanID() != request.SpanContext.SpanID() {
+		t.Errorf("CreateCommand span isn't a child of the creating request")
+	}
+	if dispatch.SpanContext.TraceID() != traceID || dispatch.Parent.SpanID() != request.SpanContext.SpanID() {
+		t.Errorf("dispatch span doesn't continue the creating request's trace")
+	}
+	if len(dispatch.Links) != 1 || dispatch.Links[0].SpanContext.SpanID() != checkIn.SpanContext.SpanID() {
+		t.Errorf("dispatch span isn't linked to the check-in request: %v", dispatch.Links)
+	}
+	if sent.Parent.SpanID() != dispatch.SpanContext.SpanID() {
+		t.Errorf("MarkCommandSent span isn't a child of the dispatch span")
+	}
+	if report.SpanContext.TraceID() != traceID || report.Parent.SpanID() != dispatch.SpanContext.SpanID() {
+		t.Errorf("ReportLog span doesn't join the command's trace under the dispatch span")
+	}
+	if report.SpanKind != trace.SpanKindServer {
+		t.Errorf("ReportLog span kind = %v, want server", report.SpanKind)
+	}
+}
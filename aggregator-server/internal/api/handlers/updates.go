@@ -1,31 +1,45 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/api/middleware"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/events"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// outboxReplayHeader marks a report the agent queued while the server was
+// unreachable and is now delivering late
+const outboxReplayHeader = "X-RedFlag-Replay"
+
 type UpdateHandler struct {
 	updateQueries  *queries.UpdateQueries
 	agentQueries   *queries.AgentQueries
 	commandQueries *queries.CommandQueries
 	agentHandler   *AgentHandler
+	eventBus       *events.Bus
+	rolloutService *services.RolloutService
 }
 
-func NewUpdateHandler(uq *queries.UpdateQueries, aq *queries.AgentQueries, cq *queries.CommandQueries, ah *AgentHandler) *UpdateHandler {
+func NewUpdateHandler(uq *queries.UpdateQueries, aq *queries.AgentQueries, cq *queries.CommandQueries, ah *AgentHandler, bus *events.Bus, rs *services.RolloutService) *UpdateHandler {
 	return &UpdateHandler{
 		updateQueries:  uq,
 		agentQueries:   aq,
 		commandQueries: cq,
 		agentHandler:   ah,
+		eventBus:       bus,
+		rolloutService: rs,
 	}
 }
 
@@ -34,7 +48,7 @@ func NewUpdateHandler(uq *queries.UpdateQueries, aq *queries.AgentQueries, cq *q
 func (h *UpdateHandler) shouldEnableHeartbeat(agentID uuid.UUID, durationMinutes int) (bool, error) {
 	agent, err := h.agentQueries.GetAgentByID(agentID)
 	if err != nil {
-		log.Printf("Warning: Failed to get agent %s for heartbeat check: %v", agentID, err)
+		slog.Warn("failed to get agent for heartbeat check", "agent_id", agentID, "error", err)
 		return true, nil // Enable heartbeat by default if we can't check
 	}
 
@@ -44,7 +58,7 @@ func (h *UpdateHandler) shouldEnableHeartbeat(agentID uuid.UUID, durationMinutes
 			until, err := time.Parse(time.RFC3339, untilStr)
 			if err == nil && until.After(time.Now().Add(5*time.Minute)) {
 				// Heartbeat is already active for sufficient time
-				log.Printf("[Heartbeat] Agent %s already has active heartbeat until %s (skipping)", agentID, untilStr)
+				slog.Debug("agent already has an active heartbeat", "agent_id", agentID, "until", untilStr)
 				return false, nil
 			}
 		}
@@ -175,7 +189,7 @@ func (h *UpdateHandler) ApproveUpdate(c *gin.Context) {
 
 	// For now, use "admin" as approver. Will integrate with proper auth later
 	if err := h.updateQueries.ApproveUpdate(id, "admin"); err != nil {
-		fmt.Printf("DEBUG: ApproveUpdate failed for ID %s: %v\n", id, err)
+		middleware.Logger(c).Debug("approve update failed", "update_id", id, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to approve update: %v", err)})
 		return
 	}
@@ -199,6 +213,26 @@ func (h *UpdateHandler) ReportLog(c *gin.Context) {
 		return
 	}
 
+	// A replayed result may have been recorded already, with only the
+	// response lost; recording it twice would double-count rollout results
+	var lateResult bool
+	if commandID, err := uuid.Parse(req.CommandID); err == nil {
+		if command, err := h.commandQueries.WithContext(c.Request.Context()).GetCommandByID(commandID); err == nil {
+			switch command.Status {
+			case models.CommandStatusCompleted, models.CommandStatusFailed:
+				if c.GetHeader(outboxReplayHeader) != "" {
+					c.JSON(http.StatusOK, gin.H{"message": "log already recorded", "duplicate": true})
+					return
+				}
+			case models.CommandStatusTimedOut:
+				// The agent finished after the timeout service gave up; its result wins
+				lateResult = true
+				middleware.Logger(c).Info("accepting late result for timed out command",
+					"command_id", commandID, "result", req.Result)
+			}
+		}
+	}
+
 	logEntry := &models.UpdateLog{
 		ID:              uuid.New(),
 		AgentID:         agentID,
@@ -217,12 +251,36 @@ func (h *UpdateHandler) ReportLog(c *gin.Context) {
 		return
 	}
 
+	// Publish the log for live operation views (output is fetched separately to keep events small)
+	logEvent := events.Event{
+		Type:    events.TypeLogReported,
+		AgentID: &agentID,
+		Data: map[string]interface{}{
+			"log_id":           logEntry.ID,
+			"action":           req.Action,
+			"result":           req.Result,
+			"exit_code":        req.ExitCode,
+			"duration_seconds": req.DurationSeconds,
+		},
+	}
+	if commandID, err := uuid.Parse(req.CommandID); err == nil {
+		logEvent.CommandID = &commandID
+	}
+	h.eventBus.Publish(logEvent)
+
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(
+		attribute.String("redflag.command_id", req.CommandID),
+		attribute.String("redflag.action", req.Action),
+		attribute.String("redflag.result", req.Result),
+		attribute.Int("redflag.exit_code", req.ExitCode),
+	)
+
 	// NEW: Update command status if command_id is provided
 	if req.CommandID != "" {
 		commandID, err := uuid.Parse(req.CommandID)
 		if err != nil {
 			// Log warning but don't fail the request
-			fmt.Printf("Warning: Invalid command ID format in log request: %s\n", req.CommandID)
+			middleware.Logger(c).Warn("invalid command ID format in log request", "command_id", req.CommandID)
 		} else {
 			// Prepare result data for command update
 			result := models.JSONB{
@@ -232,15 +290,29 @@ func (h *UpdateHandler) ReportLog(c *gin.Context) {
 				"duration_seconds": req.DurationSeconds,
 				"logged_at":        time.Now(),
 			}
+			if lateResult {
+				result["late_result"] = true
+			}
+			for key, value := range req.Details {
+				if _, reserved := result[key]; !reserved {
+					result[key] = value
+				}
+			}
 
 			// Update command status based on log result
 			if req.Result == "success" || req.Result == "completed" {
-				if err := h.commandQueries.MarkCommandCompleted(commandID, result); err != nil {
-					fmt.Printf("Warning: Failed to mark command %s as completed: %v\n", commandID, err)
+				if err := h.commandQueries.WithContext(c.Request.Context()).MarkCommandCompleted(commandID, result); err != nil {
+					middleware.Logger(c).Warn("failed to mark command completed", "command_id", commandID, "error", err)
+				}
+
+				// An install that worked but failed the agent's health checks leaves the package degraded
+				packageStatus := "updated"
+				if health, _ := req.Details["health"].(string); health == "failed" {
+					packageStatus = "degraded"
 				}
 
 				// NEW: If this was a successful confirm_dependencies command, mark the package as updated
-				command, err := h.commandQueries.GetCommandByID(commandID)
+				command, err := h.commandQueries.WithContext(c.Request.Context()).GetCommandByID(commandID)
 				if err == nil && command.CommandType == models.CommandTypeConfirmDependencies {
 					// Extract package info from command params
 					if packageName, ok := command.Params["package_name"].(string); ok {
@@ -253,23 +325,39 @@ func (h *UpdateHandler) ReportLog(c *gin.Context) {
 								}
 							}
 
-							// Update package status to 'updated' with actual completion timestamp
-							if err := h.updateQueries.UpdatePackageStatus(agentID, packageType, packageName, "updated", nil, completionTime); err != nil {
-								log.Printf("Warning: Failed to update package status for %s/%s: %v", packageType, packageName, err)
+							// Update package status to 'updated' (or 'degraded') with actual completion timestamp
+							if err := h.updateQueries.UpdatePackageStatus(agentID, packageType, packageName, packageStatus, nil, completionTime); err != nil {
+								middleware.Logger(c).Warn("failed to update package status", "agent_id", agentID, "package", packageName, "package_type", packageType, "error", err)
 							} else {
-								log.Printf("✅ Package %s (%s) marked as updated after successful installation", packageName, packageType)
+								middleware.Logger(c).Info("package installed", "agent_id", agentID, "package", packageName, "package_type", packageType, "status", packageStatus)
 							}
 						}
 					}
 				}
+
+				if err == nil && command.CommandType == models.CommandTypeRollback {
+					h.recordRollbackResult(command, true, "")
+				}
+
+				// Rollout installs advance their ring as results arrive
+				inRollout := h.rolloutService.RecordResult(commandID, packageStatus, healthFailureMessage(packageStatus, req.Stderr))
+				if err == nil && !inRollout && packageStatus == "degraded" && command.CommandType == models.CommandTypeInstallUpdate {
+					h.markDegraded(agentID, command)
+				}
 			} else if req.Result == "failed" || req.Result == "dry_run_failed" {
-				if err := h.commandQueries.MarkCommandFailed(commandID, result); err != nil {
-					fmt.Printf("Warning: Failed to mark command %s as failed: %v\n", commandID, err)
+				if err := h.commandQueries.WithContext(c.Request.Context()).MarkCommandFailed(commandID, result); err != nil {
+					middleware.Logger(c).Warn("failed to mark command failed", "command_id", commandID, "error", err)
 				}
+
+				if command, err := h.commandQueries.WithContext(c.Request.Context()).GetCommandByID(commandID); err == nil && command.CommandType == models.CommandTypeRollback {
+					h.recordRollbackResult(command, false, req.Stderr)
+				}
+
+				h.rolloutService.RecordResult(commandID, "failed", req.Stderr)
 			} else {
 				// For other results, just update the result field
-				if err := h.commandQueries.UpdateCommandResult(commandID, result); err != nil {
-					fmt.Printf("Warning: Failed to update command %s result: %v\n", commandID, err)
+				if err := h.commandQueries.WithContext(c.Request.Context()).UpdateCommandResult(commandID, result); err != nil {
+					middleware.Logger(c).Warn("failed to update command result", "command_id", commandID, "error", err)
 				}
 			}
 		}
@@ -278,6 +366,29 @@ func (h *UpdateHandler) ReportLog(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "log recorded"})
 }
 
+// markDegraded flags the package a single-package install_updates command
+// installed as degraded
+func (h *UpdateHandler) markDegraded(agentID uuid.UUID, command *models.AgentCommand) {
+	packageName, _ := command.Params["package_name"].(string)
+	packageType, _ := command.Params["package_type"].(string)
+	if packageName == "" || packageType == "" {
+		return
+	}
+	if err := h.updateQueries.UpdatePackageStatus(agentID, packageType, packageName, "degraded", nil, nil); err != nil {
+		slog.Error("failed to mark package degraded", "agent_id", agentID, "package", packageName, "package_type", packageType, "error", err)
+		return
+	}
+	slog.Warn("package marked degraded after failed health checks", "agent_id", agentID, "package", packageName, "package_type", packageType)
+}
+
+// healthFailureMessage is the rollout target message for an install result
+func healthFailureMessage(packageStatus, stderr string) string {
+	if packageStatus != "degraded" {
+		return ""
+	}
+	return stderr
+}
+
 // GetPackageHistory returns version history for a specific package
 func (h *UpdateHandler) GetPackageHistory(c *gin.Context) {
 	agentIDStr := c.Param("agent_id")
@@ -445,6 +556,7 @@ func (h *UpdateHandler) InstallUpdate(c *gin.Context) {
 		Status:       models.CommandStatusPending,
 		Source:       models.CommandSourceManual,
 		CreatedAt:     time.Now(),
+		RequestID:    requestIDRef(c),
 	}
 
 	// Check if heartbeat should be enabled (avoid duplicates)
@@ -459,20 +571,21 @@ func (h *UpdateHandler) InstallUpdate(c *gin.Context) {
 			Status:    models.CommandStatusPending,
 			Source:    models.CommandSourceSystem,
 			CreatedAt: time.Now(),
+			RequestID: requestIDRef(c),
 		}
 
-		if err := h.commandQueries.CreateCommand(heartbeatCmd); err != nil {
-			log.Printf("[Heartbeat] Warning: Failed to create heartbeat command for agent %s: %v", update.AgentID, err)
+		if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(heartbeatCmd); err != nil {
+			middleware.Logger(c).Warn("failed to create heartbeat command", "agent_id", update.AgentID, "error", err)
 		} else {
-			log.Printf("[Heartbeat] Command created for agent %s before dry run", update.AgentID)
+			middleware.Logger(c).Debug("created heartbeat command before dry run", "agent_id", update.AgentID)
 		}
 	} else {
-		log.Printf("[Heartbeat] Skipping heartbeat command for agent %s (already active)", update.AgentID)
+		middleware.Logger(c).Debug("skipping heartbeat command, heartbeat already active", "agent_id", update.AgentID)
 	}
 
 	// Store the dry run command in database
-	if err := h.commandQueries.CreateCommand(command); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create dry run command"})
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(command); err != nil {
+		commandCreateFailed(c, err, "failed to create dry run command")
 		return
 	}
 
@@ -488,6 +601,123 @@ func (h *UpdateHandler) InstallUpdate(c *gin.Context) {
 	})
 }
 
+// RollbackUpdate reinstalls the version a package had before its last update.
+// The target comes from update_version_history unless a version is given explicitly.
+func (h *UpdateHandler) RollbackUpdate(c *gin.Context) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid update ID"})
+		return
+	}
+
+	var req struct {
+		Version string `json:"version"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	update, err := h.updateQueries.GetUpdateByID(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "update not found"})
+		return
+	}
+
+	switch update.Status {
+	case "installing", "checking_dependencies", "pending_dependencies":
+		c.JSON(http.StatusConflict, gin.H{"error": "an install is in progress for this package"})
+		return
+	}
+
+	active, err := h.commandQueries.HasActiveCommand(update.AgentID, models.CommandTypeRollback)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to check existing commands"})
+		return
+	}
+	if active {
+		c.JSON(http.StatusConflict, gin.H{"error": "a rollback is already pending for this agent"})
+		return
+	}
+
+	last, err := h.updateQueries.GetLastHistoryEntry(update.AgentID, update.PackageType, update.PackageName)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get package history"})
+		return
+	}
+
+	fromVersion := update.CurrentVersion
+	toVersion := req.Version
+	if last != nil && (last.UpdateStatus == "updated" || last.UpdateStatus == "success" || last.UpdateStatus == "degraded") {
+		fromVersion = last.VersionTo
+		if toVersion == "" {
+			toVersion = last.VersionFrom
+		}
+	}
+	if toVersion == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "no previous version recorded for this package; specify a version"})
+		return
+	}
+	if toVersion == fromVersion {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("package is already at version %s", toVersion)})
+		return
+	}
+
+	command := &models.AgentCommand{
+		ID:          uuid.New(),
+		AgentID:     update.AgentID,
+		CommandType: models.CommandTypeRollback,
+		Params: models.JSONB{
+			"update_id":    id.String(),
+			"package_type": update.PackageType,
+			"package_name": update.PackageName,
+			"version":      toVersion,
+			"version_from": fromVersion,
+		},
+		Status:    models.CommandStatusPending,
+		Source:    models.CommandSourceManual,
+		CreatedAt: time.Now(),
+		RequestID: requestIDRef(c),
+	}
+
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(command); err != nil {
+		commandCreateFailed(c, err, "failed to create rollback command")
+		return
+	}
+
+	if err := h.updateQueries.UpdatePackageStatus(update.AgentID, update.PackageType, update.PackageName, "installing", nil, nil); err != nil {
+		middleware.Logger(c).Warn("failed to mark package as installing for rollback", "agent_id", update.AgentID, "package", update.PackageName, "error", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "rollback command created for agent",
+		"command_id":   command.ID.String(),
+		"version":      toVersion,
+		"version_from": fromVersion,
+	})
+}
+
+// recordRollbackResult writes the history entry for a finished rollback_update command
+func (h *UpdateHandler) recordRollbackResult(command *models.AgentCommand, success bool, failureReason string) {
+	packageType, _ := command.Params["package_type"].(string)
+	packageName, _ := command.Params["package_name"].(string)
+	toVersion, _ := command.Params["version"].(string)
+	fromVersion, _ := command.Params["version_from"].(string)
+	if packageType == "" || packageName == "" {
+		return
+	}
+
+	if err := h.updateQueries.RecordRollback(command.AgentID, packageType, packageName, fromVersion, toVersion, success, failureReason, time.Now()); err != nil {
+		slog.Error("failed to record rollback", "agent_id", command.AgentID, "package", packageName, "package_type", packageType, "error", err)
+		return
+	}
+	if success {
+		slog.Info("package rolled back", "agent_id", command.AgentID, "package", packageName, "package_type", packageType, "from_version", fromVersion, "to_version", toVersion)
+	}
+}
+
 // GetUpdateLogs retrieves installation logs for a specific update
 func (h *UpdateHandler) GetUpdateLogs(c *gin.Context) {
 	idStr := c.Param("id")
@@ -528,6 +758,25 @@ func (h *UpdateHandler) ReportDependencies(c *gin.Context) {
 		return
 	}
 
+	if update, err := h.updateQueries.GetUpdateByPackage(agentID, req.PackageType, req.PackageName); err == nil {
+		switch update.Status {
+		case "checking_dependencies":
+		case "failed":
+			// The dry run outlived the command timeout; take its result after all
+			middleware.Logger(c).Info("accepting late dependency report", "package", req.PackageName, "package_type", req.PackageType)
+			if err := h.updateQueries.ResumeDependencyCheck(update.ID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to update package status"})
+				return
+			}
+		default:
+			// A replay of a report that was already applied
+			if c.GetHeader(outboxReplayHeader) != "" {
+				c.JSON(http.StatusOK, gin.H{"message": "dependencies already reported", "duplicate": true})
+				return
+			}
+		}
+	}
+
 	// If there are NO dependencies, auto-approve and proceed directly to installation
 	// This prevents updates with zero dependencies from getting stuck in "pending_dependencies"
 	if len(req.Dependencies) == 0 {
@@ -552,6 +801,7 @@ func (h *UpdateHandler) ReportDependencies(c *gin.Context) {
 			Status:    models.CommandStatusPending,
 			Source:    models.CommandSourceManual,
 			CreatedAt: time.Now(),
+			RequestID: requestIDRef(c),
 		}
 
 		// Check if heartbeat should be enabled (avoid duplicates)
@@ -566,19 +816,20 @@ func (h *UpdateHandler) ReportDependencies(c *gin.Context) {
 				Status:    models.CommandStatusPending,
 				Source:    models.CommandSourceSystem,
 				CreatedAt: time.Now(),
+				RequestID: requestIDRef(c),
 			}
 
-			if err := h.commandQueries.CreateCommand(heartbeatCmd); err != nil {
-				log.Printf("[Heartbeat] Warning: Failed to create heartbeat command for agent %s: %v", agentID, err)
+			if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(heartbeatCmd); err != nil {
+				middleware.Logger(c).Warn("failed to create heartbeat command", "agent_id", agentID, "error", err)
 			} else {
-				log.Printf("[Heartbeat] Command created for agent %s before installation", agentID)
+				middleware.Logger(c).Debug("created heartbeat command before installation", "agent_id", agentID)
 			}
 		} else {
-			log.Printf("[Heartbeat] Skipping heartbeat command for agent %s (already active)", agentID)
+			middleware.Logger(c).Debug("skipping heartbeat command, heartbeat already active", "agent_id", agentID)
 		}
 
-		if err := h.commandQueries.CreateCommand(command); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create installation command"})
+		if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(command); err != nil {
+			commandCreateFailed(c, err, "failed to create installation command")
 			return
 		}
 
@@ -634,6 +885,7 @@ func (h *UpdateHandler) ConfirmDependencies(c *gin.Context) {
 		Status:       models.CommandStatusPending,
 		Source:       models.CommandSourceManual,
 		CreatedAt:     time.Now(),
+		RequestID:    requestIDRef(c),
 	}
 
 	// Check if heartbeat should be enabled (avoid duplicates)
@@ -648,20 +900,21 @@ func (h *UpdateHandler) ConfirmDependencies(c *gin.Context) {
 			Status:    models.CommandStatusPending,
 			Source:    models.CommandSourceSystem,
 			CreatedAt: time.Now(),
+			RequestID: requestIDRef(c),
 		}
 
-		if err := h.commandQueries.CreateCommand(heartbeatCmd); err != nil {
-			log.Printf("[Heartbeat] Warning: Failed to create heartbeat command for agent %s: %v", update.AgentID, err)
+		if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(heartbeatCmd); err != nil {
+			middleware.Logger(c).Warn("failed to create heartbeat command", "agent_id", update.AgentID, "error", err)
 		} else {
-			log.Printf("[Heartbeat] Command created for agent %s before confirm dependencies", update.AgentID)
+			middleware.Logger(c).Debug("created heartbeat command before confirming dependencies", "agent_id", update.AgentID)
 		}
 	} else {
-		log.Printf("[Heartbeat] Skipping heartbeat command for agent %s (already active)", update.AgentID)
+		middleware.Logger(c).Debug("skipping heartbeat command, heartbeat already active", "agent_id", update.AgentID)
 	}
 
 	// Store the command in database
-	if err := h.commandQueries.CreateCommand(command); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to create confirmation command"})
+	if err := h.commandQueries.WithContext(c.Request.Context()).CreateCommand(command); err != nil {
+		commandCreateFailed(c, err, "failed to create confirmation command")
 		return
 	}
 
@@ -746,7 +999,7 @@ func (h *UpdateHandler) RetryCommand(c *gin.Context) {
 	}
 
 	// Create a new command based on the original
-	newCommand, err := h.commandQueries.RetryCommand(id)
+	newCommand, err := h.commandQueries.WithContext(c.Request.Context()).RetryCommand(id, requestIDRef(c))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to retry command: %v", err)})
 		return
@@ -769,7 +1022,7 @@ func (h *UpdateHandler) CancelCommand(c *gin.Context) {
 	}
 
 	// Cancel the command
-	if err := h.commandQueries.CancelCommand(id); err != nil {
+	if err := h.commandQueries.WithContext(c.Request.Context()).CancelCommand(id); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("failed to cancel command: %v", err)})
 		return
 	}
@@ -777,6 +1030,127 @@ func (h *UpdateHandler) CancelCommand(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "command cancelled"})
 }
 
+const (
+	// maxOutputChunkSize bounds a single live output chunk from an agent
+	maxOutputChunkSize = 64 * 1024
+	// maxCommandOutputSize bounds the live output stored for one command; the
+	// complete output still arrives with the command's final log report
+	maxCommandOutputSize = 4 * 1024 * 1024
+)
+
+// ReportCommandOutput receives a chunk of live output from an agent for a running command
+func (h *UpdateHandler) ReportCommandOutput(c *gin.Context) {
+	agentID := c.MustGet("agent_id").(uuid.UUID)
+
+	commandID, err := uuid.Parse(c.Param("command_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid command ID"})
+		return
+	}
+
+	var req models.CommandOutputRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Data) > maxOutputChunkSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "output chunk too large"})
+		return
+	}
+
+	// Agents may only append output to their own commands
+	command, err := h.commandQueries.WithContext(c.Request.Context()).GetCommandByID(commandID)
+	if err != nil || command.AgentID != agentID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
+		return
+	}
+
+	chunk := &models.CommandOutputChunk{
+		CommandID: commandID,
+		AgentID:   agentID,
+		Seq:       req.Seq,
+		Data:      req.Data,
+		Final:     req.Final,
+	}
+
+	stored, err := h.commandQueries.WithContext(c.Request.Context()).AppendCommandOutput(chunk, maxCommandOutputSize)
+	if errors.Is(err, queries.ErrCommandOutputLimit) && chunk.Final {
+		// Still record the end of the output so live views finish tailing
+		chunk.Data = ""
+		stored, err = h.commandQueries.WithContext(c.Request.Context()).AppendCommandOutput(chunk, maxCommandOutputSize)
+	}
+	if errors.Is(err, queries.ErrCommandOutputLimit) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "command output limit reached"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to store output"})
+		return
+	}
+
+	// Only publish new chunks so retried sends don't duplicate output in live views
+	if stored {
+		h.eventBus.Publish(events.Event{
+			Type:      events.TypeCommandOutput,
+			AgentID:   &agentID,
+			CommandID: &commandID,
+			Data: map[string]interface{}{
+				"seq":   chunk.Seq,
+				"data":  chunk.Data,
+				"final": chunk.Final,
+			},
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "output received"})
+}
+
+// GetCommandOutput returns live output for a command. Clients tail by passing the
+// last seq they've seen as after_seq; "complete" is true once the final chunk arrived.
+func (h *UpdateHandler) GetCommandOutput(c *gin.Context) {
+	commandID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid command ID"})
+		return
+	}
+
+	afterSeq, _ := strconv.Atoi(c.DefaultQuery("after_seq", "0"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "500"))
+	if limit < 1 || limit > 1000 {
+		limit = 500
+	}
+
+	command, err := h.commandQueries.WithContext(c.Request.Context()).GetCommandByID(commandID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "command not found"})
+		return
+	}
+
+	chunks, err := h.commandQueries.GetCommandOutput(commandID, afterSeq, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to get command output"})
+		return
+	}
+
+	lastSeq := afterSeq
+	complete := false
+	for _, chunk := range chunks {
+		lastSeq = chunk.Seq
+		if chunk.Final {
+			complete = true
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"command_id": commandID,
+		"status":     command.Status,
+		"chunks":     chunks,
+		"last_seq":   lastSeq,
+		"complete":   complete,
+	})
+}
+
 // GetActiveCommands retrieves currently active commands for live operations view
 func (h *UpdateHandler) GetActiveCommands(c *gin.Context) {
 	commands, err := h.commandQueries.GetActiveCommands()
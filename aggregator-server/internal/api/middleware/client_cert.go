@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/pki"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// AgentCertificateMiddleware pins an authenticated agent to its client
+// certificate. It runs after AuthMiddleware: the TLS layer has already
+// verified the certificate against the internal CA, so this checks that it
+// was issued to the agent named in the JWT and hasn't been revoked. With
+// required set, agents without a certificate are rejected.
+func AgentCertificateMiddleware(certQueries *queries.AgentCertificateQueries, required bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var agentID uuid.UUID
+		if id, exists := c.Get("agent_id"); exists {
+			agentID, _ = id.(uuid.UUID)
+		}
+
+		if c.Request.TLS == nil || len(c.Request.TLS.VerifiedChains) == 0 {
+			if required {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": "client certificate required"})
+				c.Abort()
+				return
+			}
+			c.Next()
+			return
+		}
+
+		cert := c.Request.TLS.VerifiedChains[0][0]
+		certAgentID, err := pki.AgentID(cert)
+		if err != nil || certAgentID != agentID {
+			Logger(c).Warn("client certificate does not match agent token",
+				"agent_id", agentID, "certificate_cn", cert.Subject.CommonName)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "client certificate does not match agent"})
+			c.Abort()
+			return
+		}
+
+		issued, err := certQueries.GetAgentCertificate(pki.SerialString(cert.SerialNumber))
+		if err != nil || issued.AgentID != agentID || issued.RevokedAt != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "client certificate revoked or unknown"})
+			c.Abort()
+			return
+		}
+
+		c.Set("client_cert_serial", issued.SerialNumber)
+		c.Next()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"log/slog"
 	"os"
 	"strconv"
 )
@@ -19,6 +20,11 @@ type Config struct {
 			CertFile string `env:"REDFLAG_TLS_CERT_FILE"`
 			KeyFile  string `env:"REDFLAG_TLS_KEY_FILE"`
 		}
+		MTLS struct {
+			Mode     string `env:"REDFLAG_MTLS_MODE" default:"off"` // off, optional, required; needs TLS enabled
+			CADir    string `env:"REDFLAG_MTLS_CA_DIR" default:"/app/ca"`
+			CertDays int    `env:"REDFLAG_MTLS_CERT_DAYS" default:"30"`
+		}
 	}
 	Database struct {
 		Host     string `env:"REDFLAG_DB_HOST" default:"localhost"`
@@ -37,6 +43,20 @@ type Config struct {
 		MaxTokens   int    `env:"REDFLAG_MAX_TOKENS" default:"100"`
 		MaxSeats    int    `env:"REDFLAG_MAX_SEATS" default:"50"`
 	}
+	Alerting struct {
+		WebhookURL string `env:"REDFLAG_ALERT_WEBHOOK_URL"` // Optional: POST alert transitions here
+	}
+	Logging struct {
+		Level string `env:"REDFLAG_LOG_LEVEL" default:"info"` // debug, info, warn, error
+	}
+	Updates struct {
+		PublicKey string `env:"REDFLAG_UPDATE_PUBLIC_KEY"` // Base64 Ed25519 key agents verify self-update binaries with
+	}
+	Tracing struct {
+		Exporter    string  `env:"REDFLAG_TRACING_EXPORTER" default:"none"` // none, stdout, otlp
+		Endpoint    string  `env:"REDFLAG_TRACING_ENDPOINT"`                // OTLP/HTTP URL, e.g. http://collector:4318
+		SampleRatio float64 `env:"REDFLAG_TRACING_SAMPLE_RATIO" default:"1.0"`
+	}
 	CheckInInterval  int
 	OfflineThreshold int
 	Timezone         string
@@ -45,7 +65,7 @@ type Config struct {
 
 // Load reads configuration from environment variables only (immutable configuration)
 func Load() (*Config, error) {
-	fmt.Printf("[CONFIG] Loading configuration from environment variables\n")
+	slog.Info("loading configuration from environment variables")
 
 	cfg := &Config{}
 
@@ -57,6 +77,21 @@ func Load() (*Config, error) {
 	cfg.Server.TLS.Enabled = getEnv("REDFLAG_TLS_ENABLED", "false") == "true"
 	cfg.Server.TLS.CertFile = getEnv("REDFLAG_TLS_CERT_FILE", "")
 	cfg.Server.TLS.KeyFile = getEnv("REDFLAG_TLS_KEY_FILE", "")
+	cfg.Server.MTLS.Mode = getEnv("REDFLAG_MTLS_MODE", "off")
+	cfg.Server.MTLS.CADir = getEnv("REDFLAG_MTLS_CA_DIR", "/app/ca")
+	certDays, err := strconv.Atoi(getEnv("REDFLAG_MTLS_CERT_DAYS", "30"))
+	if err != nil || certDays <= 0 {
+		certDays = 30
+	}
+	cfg.Server.MTLS.CertDays = certDays
+	switch cfg.Server.MTLS.Mode {
+	case "off", "optional", "required":
+	default:
+		return nil, fmt.Errorf("invalid REDFLAG_MTLS_MODE %q (expected off, optional or required)", cfg.Server.MTLS.Mode)
+	}
+	if cfg.Server.MTLS.Mode != "off" && !cfg.Server.TLS.Enabled {
+		return nil, fmt.Errorf("REDFLAG_MTLS_MODE=%s requires REDFLAG_TLS_ENABLED=true", cfg.Server.MTLS.Mode)
+	}
 
 	// Parse database configuration
 	cfg.Database.Host = getEnv("REDFLAG_DB_HOST", "localhost")
@@ -78,6 +113,18 @@ func Load() (*Config, error) {
 	maxSeats, _ := strconv.Atoi(getEnv("REDFLAG_MAX_SEATS", "50"))
 	cfg.AgentRegistration.MaxSeats = maxSeats
 
+	// Parse alerting configuration
+	cfg.Alerting.WebhookURL = getEnv("REDFLAG_ALERT_WEBHOOK_URL", "")
+	cfg.Logging.Level = getEnv("REDFLAG_LOG_LEVEL", "info")
+	cfg.Updates.PublicKey = getEnv("REDFLAG_UPDATE_PUBLIC_KEY", "")
+	cfg.Tracing.Exporter = getEnv("REDFLAG_TRACING_EXPORTER", "none")
+	cfg.Tracing.Endpoint = getEnv("REDFLAG_TRACING_ENDPOINT", "")
+	sampleRatio, err := strconv.ParseFloat(getEnv("REDFLAG_TRACING_SAMPLE_RATIO", "1.0"), 64)
+	if err != nil || sampleRatio < 0 || sampleRatio > 1 {
+		sampleRatio = 1.0
+	}
+	cfg.Tracing.SampleRatio = sampleRatio
+
 	// Parse legacy configuration for backwards compatibility
 	checkInInterval, _ := strconv.Atoi(getEnv("CHECK_IN_INTERVAL", "300"))
 	offlineThreshold, _ := strconv.Atoi(getEnv("OFFLINE_THRESHOLD", "600"))
@@ -88,22 +135,22 @@ func Load() (*Config, error) {
 
 	// Handle missing secrets
 	if cfg.Admin.Password == "" || cfg.Admin.JWTSecret == "" || cfg.Database.Password == "" {
-		fmt.Printf("[WARNING] Missing required configuration (admin password, JWT secret, or database password)\n")
-		fmt.Printf("[INFO] Run: ./redflag-server --setup to configure\n")
+		slog.Warn("missing required configuration (admin password, JWT secret, or database password)",
+			"hint", "run ./redflag-server --setup to configure")
 		return nil, fmt.Errorf("missing required configuration")
 	}
 
 	// Check if we're using bootstrap defaults that need to be replaced
 	if cfg.Admin.Password == "changeme" || cfg.Admin.JWTSecret == "bootstrap-jwt-secret-replace-in-setup" || cfg.Database.Password == "redflag_bootstrap" {
-		fmt.Printf("[INFO] Server running with bootstrap configuration - setup required\n")
-		fmt.Printf("[INFO] Configure via web interface at: http://localhost:8080/setup\n")
+		slog.Info("server running with bootstrap configuration - setup required",
+			"hint", "configure via web interface at http://localhost:8080/setup")
 		return nil, fmt.Errorf("bootstrap configuration detected - setup required")
 	}
 
 	// Validate JWT secret is not the development default
 	if cfg.Admin.JWTSecret == "test-secret-for-development-only" {
-		fmt.Printf("[SECURITY WARNING] Using development JWT secret\n")
-		fmt.Printf("[INFO] Run: ./redflag-server --setup to configure production secrets\n")
+		slog.Warn("using development JWT secret",
+			"hint", "run ./redflag-server --setup to configure production secrets")
 	}
 
 	return cfg, nil
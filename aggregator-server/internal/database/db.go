@@ -2,6 +2,7 @@ package database
 
 import (
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
@@ -72,7 +73,7 @@ func (db *DB) Migrate(migrationsPath string) error {
 		}
 
 		if count > 0 {
-			fmt.Printf("→ Skipping migration (already applied): %s\n", filename)
+			slog.Debug("skipping migration (already applied)", "migration", filename)
 			continue
 		}
 
@@ -95,7 +96,7 @@ func (db *DB) Migrate(migrationsPath string) error {
 			if strings.Contains(err.Error(), "already exists") ||
 			   strings.Contains(err.Error(), "duplicate key") ||
 			   strings.Contains(err.Error(), "relation") && strings.Contains(err.Error(), "already exists") {
-				fmt.Printf("⚠ Migration %s failed (objects already exist), marking as applied: %v\n", filename, err)
+				slog.Warn("migration failed (objects already exist), marking as applied", "migration", filename, "error", err)
 				// Rollback current transaction and start a new one for tracking
 				tx.Rollback()
 				// Start new transaction just for migration tracking
@@ -123,7 +124,7 @@ func (db *DB) Migrate(migrationsPath string) error {
 			return fmt.Errorf("failed to commit migration %s: %w", filename, err)
 		}
 
-		fmt.Printf("✓ Executed migration: %s\n", filename)
+		slog.Info("executed migration", "migration", filename)
 	}
 
 	return nil
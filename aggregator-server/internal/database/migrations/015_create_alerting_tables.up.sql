@@ -0,0 +1,73 @@
+-- Threshold alerting on agent metrics
+-- Rules are evaluated on every agent check-in (disk, memory, reboot) and by a
+-- background ticker (missed check-ins)
+
+CREATE TABLE IF NOT EXISTS alert_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    rule_type VARCHAR(50) NOT NULL
+        CHECK (rule_type IN ('disk_usage', 'memory_usage', 'missed_checkin', 'unexpected_reboot')),
+    threshold NUMERIC(6,2) DEFAULT 0,             -- Percent for usage rules
+    duration_seconds INTEGER DEFAULT 0,           -- How long the condition must hold before firing
+    missed_intervals INTEGER DEFAULT 0,           -- Check-in intervals for missed_checkin rules
+    severity VARCHAR(20) DEFAULT 'warning'
+        CHECK (severity IN ('info', 'warning', 'critical')),
+    agent_id UUID REFERENCES agents(id) ON DELETE CASCADE, -- NULL = applies to all agents
+    enabled BOOLEAN DEFAULT TRUE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS alerts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rule_id UUID NOT NULL REFERENCES alert_rules(id) ON DELETE CASCADE,
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'firing', 'resolved')),
+    severity VARCHAR(20) NOT NULL DEFAULT 'warning',
+    message TEXT DEFAULT '',
+    value NUMERIC(10,2) DEFAULT 0,
+    pending_since TIMESTAMP NOT NULL DEFAULT NOW(),
+    fired_at TIMESTAMP,
+    resolved_at TIMESTAMP,
+    acknowledged_at TIMESTAMP,
+    acknowledged_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    last_notified_at TIMESTAMP,
+    metadata JSONB DEFAULT '{}'::jsonb,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+-- Only one open (pending or firing) alert per rule and agent
+CREATE UNIQUE INDEX IF NOT EXISTS idx_alerts_open_rule_agent
+    ON alerts(rule_id, agent_id) WHERE status IN ('pending', 'firing');
+CREATE INDEX IF NOT EXISTS idx_alerts_status ON alerts(status);
+CREATE INDEX IF NOT EXISTS idx_alerts_agent ON alerts(agent_id);
+
+CREATE TABLE IF NOT EXISTS alert_silences (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rule_id UUID REFERENCES alert_rules(id) ON DELETE CASCADE,   -- NULL = any rule
+    agent_id UUID REFERENCES agents(id) ON DELETE CASCADE,       -- NULL = any agent
+    reason TEXT DEFAULT '',
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    starts_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    ends_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_alert_silences_window ON alert_silences(starts_at, ends_at);
+
+-- Default rules so the disk-full case is covered out of the box
+INSERT INTO alert_rules (name, rule_type, threshold, duration_seconds, missed_intervals, severity)
+SELECT * FROM (VALUES
+    ('Disk usage above 90%', 'disk_usage', 90.00, 600, 0, 'critical'),
+    ('Memory usage above 95%', 'memory_usage', 95.00, 600, 0, 'warning'),
+    ('Agent missed 3 check-ins', 'missed_checkin', 0.00, 0, 3, 'warning'),
+    ('Unexpected reboot', 'unexpected_reboot', 0.00, 3600, 0, 'warning')
+) AS defaults(name, rule_type, threshold, duration_seconds, missed_intervals, severity)
+WHERE NOT EXISTS (SELECT 1 FROM alert_rules);
+
+COMMENT ON TABLE alert_rules IS 'Threshold rules evaluated against agent check-in metrics';
+COMMENT ON TABLE alerts IS 'Alert instances with pending/firing/resolved lifecycle';
+COMMENT ON TABLE alert_silences IS 'Time windows during which alert notifications are suppressed';
+COMMENT ON COLUMN alert_rules.duration_seconds IS 'For usage rules: time above threshold before firing. For unexpected_reboot: how long the alert stays firing before auto-resolving';
@@ -0,0 +1,18 @@
+-- Live command output streamed by agents while a command is running
+-- The final, complete output is still recorded in update_logs via ReportLog
+
+CREATE TABLE IF NOT EXISTS command_output_chunks (
+    id BIGSERIAL PRIMARY KEY,
+    command_id UUID NOT NULL REFERENCES agent_commands(id) ON DELETE CASCADE,
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    seq INTEGER NOT NULL,
+    data TEXT NOT NULL DEFAULT '',
+    final BOOLEAN DEFAULT FALSE,
+    created_at TIMESTAMP DEFAULT NOW(),
+    UNIQUE (command_id, seq)  -- Agents may retry a chunk; keep the first copy
+);
+
+CREATE INDEX IF NOT EXISTS idx_command_output_chunks_command ON command_output_chunks(command_id, seq);
+
+COMMENT ON TABLE command_output_chunks IS 'Incremental stdout/stderr from running commands, used for live tail';
+COMMENT ON COLUMN command_output_chunks.seq IS 'Per-command sequence number assigned by the agent, starting at 1';
@@ -0,0 +1,9 @@
+-- Record the ID of the API request that created each command so a command
+-- can be traced back to the request (and its log lines) that issued it
+
+ALTER TABLE agent_commands
+ADD COLUMN request_id VARCHAR(128);
+
+CREATE INDEX idx_agent_commands_request_id ON agent_commands(request_id) WHERE request_id IS NOT NULL;
+
+COMMENT ON COLUMN agent_commands.request_id IS 'X-Request-ID of the API request that created the command';
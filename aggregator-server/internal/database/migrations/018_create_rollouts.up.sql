@@ -0,0 +1,57 @@
+-- Staged rollouts: approved updates are installed one ring of agents at a time
+-- A ring is promoted only after its success criteria hold for the soak period
+
+CREATE TABLE IF NOT EXISTS rollouts (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'running'
+        CHECK (status IN ('running', 'paused', 'halted', 'completed', 'aborted')),
+    current_ring INTEGER NOT NULL DEFAULT 0,
+    min_success_percent NUMERIC(5,2) NOT NULL DEFAULT 100.00, -- Install success rate a ring needs
+    soak_seconds INTEGER NOT NULL DEFAULT 600,                -- Wait after a ring finishes before judging it
+    max_new_failures INTEGER NOT NULL DEFAULT 0,              -- Other failed commands / fired alerts tolerated per ring
+    halt_reason TEXT DEFAULT '',
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL,
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW(),
+    completed_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS rollout_rings (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rollout_id UUID NOT NULL REFERENCES rollouts(id) ON DELETE CASCADE,
+    ring_index INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'installing', 'soaking', 'succeeded', 'failed', 'cancelled')),
+    started_at TIMESTAMP,
+    soak_started_at TIMESTAMP,
+    completed_at TIMESTAMP,
+    summary JSONB DEFAULT '{}'::jsonb,
+    UNIQUE (rollout_id, ring_index)
+);
+
+CREATE TABLE IF NOT EXISTS rollout_targets (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    rollout_id UUID NOT NULL REFERENCES rollouts(id) ON DELETE CASCADE,
+    ring_id UUID NOT NULL REFERENCES rollout_rings(id) ON DELETE CASCADE,
+    agent_id UUID NOT NULL REFERENCES agents(id) ON DELETE CASCADE,
+    update_id UUID REFERENCES current_package_state(id) ON DELETE SET NULL,
+    package_type VARCHAR(50) NOT NULL,
+    package_name TEXT NOT NULL,
+    command_id UUID REFERENCES agent_commands(id) ON DELETE SET NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'dispatched', 'succeeded', 'failed', 'cancelled')),
+    error TEXT DEFAULT '',
+    dispatched_at TIMESTAMP,
+    completed_at TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_rollouts_status ON rollouts(status);
+CREATE INDEX IF NOT EXISTS idx_rollout_targets_ring ON rollout_targets(ring_id);
+CREATE INDEX IF NOT EXISTS idx_rollout_targets_command ON rollout_targets(command_id) WHERE command_id IS NOT NULL;
+
+COMMENT ON TABLE rollouts IS 'Staged installation of approved updates across ordered rings of agents';
+COMMENT ON TABLE rollout_rings IS 'Ordered groups of agents within a rollout (e.g. canary, early, broad)';
+COMMENT ON TABLE rollout_targets IS 'One approved update on one agent, installed via an install_updates command';
+COMMENT ON COLUMN rollouts.max_new_failures IS 'Failed commands (outside the rollout) and fired alerts on ring agents tolerated before halting';
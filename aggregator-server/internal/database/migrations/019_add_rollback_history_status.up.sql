@@ -0,0 +1,11 @@
+-- Allow 'updated' in version history (written by UpdatePackageStatus) alongside
+-- 'rollback' entries recorded by rollback_update commands
+
+ALTER TABLE update_version_history DROP CONSTRAINT IF EXISTS update_version_history_update_status_check;
+
+ALTER TABLE update_version_history ADD CONSTRAINT update_version_history_update_status_check
+  CHECK (update_status IN ('success', 'updated', 'failed', 'rollback'));
+
+-- Rollbacks look up the most recent completed install of a package
+CREATE INDEX IF NOT EXISTS idx_history_agent_package
+  ON update_version_history(agent_id, package_type, package_name, update_completed_at DESC);
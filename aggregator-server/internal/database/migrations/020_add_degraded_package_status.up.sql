@@ -0,0 +1,13 @@
+-- Installs that succeed but fail the agent's post-install health checks leave
+-- the package 'degraded' rather than 'updated'
+
+ALTER TABLE current_package_state DROP CONSTRAINT IF EXISTS current_package_state_status_check;
+
+ALTER TABLE current_package_state ADD CONSTRAINT current_package_state_status_check
+  CHECK (status IN ('pending', 'approved', 'updated', 'failed', 'ignored', 'installing',
+                    'pending_dependencies', 'checking_dependencies', 'degraded'));
+
+ALTER TABLE update_version_history DROP CONSTRAINT IF EXISTS update_version_history_update_status_check;
+
+ALTER TABLE update_version_history ADD CONSTRAINT update_version_history_update_status_check
+  CHECK (update_status IN ('success', 'updated', 'failed', 'rollback', 'degraded'));
@@ -0,0 +1,15 @@
+-- Agent client certificates issued by the internal CA for mutual TLS
+-- agent_id is deliberately not a foreign key: revocations must outlive the agent row
+
+CREATE TABLE IF NOT EXISTS agent_certificates (
+    serial_number VARCHAR(64) PRIMARY KEY,
+    agent_id UUID NOT NULL,
+    fingerprint VARCHAR(64) NOT NULL UNIQUE, -- Hex SHA-256 of the DER certificate
+    not_before TIMESTAMP NOT NULL,
+    not_after TIMESTAMP NOT NULL,
+    issued_at TIMESTAMP NOT NULL DEFAULT NOW(),
+    revoked_at TIMESTAMP,
+    revoke_reason VARCHAR(100) DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_certificates_agent ON agent_certificates(agent_id);
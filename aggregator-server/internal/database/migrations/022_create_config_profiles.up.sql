@@ -0,0 +1,33 @@
+-- Server-managed agent configuration profiles
+-- An agent uses the profile assigned to it, or the default profile if it has none.
+-- The profile version is bumped whenever its settings change; agents acknowledge
+-- the version they applied on check-in.
+
+CREATE TABLE IF NOT EXISTS config_profiles (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name VARCHAR(255) NOT NULL UNIQUE,
+    description TEXT DEFAULT '',
+    version INTEGER NOT NULL DEFAULT 1,
+    is_default BOOLEAN NOT NULL DEFAULT FALSE,
+    check_in_interval INTEGER NOT NULL DEFAULT 0, -- Seconds; 0 = agent default
+    log_level VARCHAR(10) NOT NULL DEFAULT '',    -- '' = agent default
+    scanners JSONB,                               -- Array of scanner names; NULL = every available scanner
+    created_at TIMESTAMP DEFAULT NOW(),
+    updated_at TIMESTAMP DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_config_profiles_default ON config_profiles(is_default) WHERE is_default;
+
+CREATE TABLE IF NOT EXISTS agent_config (
+    agent_id UUID PRIMARY KEY REFERENCES agents(id) ON DELETE CASCADE,
+    profile_id UUID REFERENCES config_profiles(id) ON DELETE SET NULL, -- Assigned profile; NULL = default
+    applied_profile_id UUID,   -- Profile the agent last acknowledged (not a foreign key: it may since have been deleted)
+    applied_version INTEGER NOT NULL DEFAULT 0,
+    applied_at TIMESTAMP,
+    apply_error TEXT DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS idx_agent_config_profile ON agent_config(profile_id);
+
+COMMENT ON TABLE config_profiles IS 'Agent settings pushed to agents with their commands; local agent settings still win';
+COMMENT ON TABLE agent_config IS 'Profile assignment and the profile version each agent has applied';
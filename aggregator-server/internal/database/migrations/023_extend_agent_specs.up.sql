@@ -0,0 +1,25 @@
+-- Structured agent specs with change history
+-- Each row is a snapshot of an agent's hardware and software inventory. A new
+-- row is only written when the inventory changes (detected by fingerprint);
+-- otherwise the latest row's last_seen_at is bumped.
+
+ALTER TABLE agent_specs
+    ADD COLUMN IF NOT EXISTS os_type VARCHAR(50) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS os_version VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS os_architecture VARCHAR(50) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS kernel_version VARCHAR(255) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS cpu_threads INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS disks JSONB NOT NULL DEFAULT '[]',
+    ADD COLUMN IF NOT EXISTS dmi JSONB NOT NULL DEFAULT '{}',
+    ADD COLUMN IF NOT EXISTS virtualization VARCHAR(50) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS fingerprint VARCHAR(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP DEFAULT NOW();
+
+-- Package managers are stored as a JSON array like the other list columns
+ALTER TABLE agent_specs
+    ALTER COLUMN package_managers TYPE JSONB USING to_jsonb(package_managers);
+
+CREATE INDEX IF NOT EXISTS idx_agent_specs_agent_collected ON agent_specs(agent_id, collected_at DESC);
+
+COMMENT ON COLUMN agent_specs.fingerprint IS 'SHA-256 of the fields compared for change detection (excludes free disk space)';
+COMMENT ON COLUMN agent_specs.last_seen_at IS 'Last time the agent reported this unchanged snapshot';
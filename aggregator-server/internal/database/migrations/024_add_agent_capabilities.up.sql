@@ -0,0 +1,9 @@
+-- Agent protocol version and capabilities
+-- Agents report the protocol version they speak and which commands, scanners
+-- and package types they can handle, so the server can refuse commands an
+-- agent would ignore. Version 0 with NULL capabilities is an agent that
+-- predates capability reporting.
+
+ALTER TABLE agents
+    ADD COLUMN IF NOT EXISTS protocol_version INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS capabilities JSONB;
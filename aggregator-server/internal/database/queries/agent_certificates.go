@@ -0,0 +1,73 @@
+package queries
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type AgentCertificateQueries struct {
+	db *sqlx.DB
+}
+
+func NewAgentCertificateQueries(db *sqlx.DB) *AgentCertificateQueries {
+	return &AgentCertificateQueries{db: db}
+}
+
+// AgentCertificate represents a client certificate issued to an agent
+type AgentCertificate struct {
+	SerialNumber string     `db:"serial_number" json:"serial_number"`
+	AgentID      uuid.UUID  `db:"agent_id" json:"agent_id"`
+	Fingerprint  string     `db:"fingerprint" json:"fingerprint"`
+	NotBefore    time.Time  `db:"not_before" json:"not_before"`
+	NotAfter     time.Time  `db:"not_after" json:"not_after"`
+	IssuedAt     time.Time  `db:"issued_at" json:"issued_at"`
+	RevokedAt    *time.Time `db:"revoked_at" json:"revoked_at,omitempty"`
+	RevokeReason string     `db:"revoke_reason" json:"revoke_reason,omitempty"`
+}
+
+// CreateAgentCertificate records a newly issued certificate
+func (q *AgentCertificateQueries) CreateAgentCertificate(cert *AgentCertificate) error {
+	query := `
+		INSERT INTO agent_certificates (serial_number, agent_id, fingerprint, not_before, not_after)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+
+	_, err := q.db.Exec(query, cert.SerialNumber, cert.AgentID, cert.Fingerprint, cert.NotBefore, cert.NotAfter)
+	if err != nil {
+		return fmt.Errorf("failed to record agent certificate: %w", err)
+	}
+	return nil
+}
+
+// GetAgentCertificate looks up an issued certificate by serial number
+func (q *AgentCertificateQueries) GetAgentCertificate(serialNumber string) (*AgentCertificate, error) {
+	query := `
+		SELECT serial_number, agent_id, fingerprint, not_before, not_after, issued_at, revoked_at, COALESCE(revoke_reason, '') AS revoke_reason
+		FROM agent_certificates
+		WHERE serial_number = $1
+	`
+
+	var cert AgentCertificate
+	if err := q.db.Get(&cert, query, serialNumber); err != nil {
+		return nil, fmt.Errorf("agent certificate not found: %w", err)
+	}
+	return &cert, nil
+}
+
+// RevokeAgentCertificates revokes every unrevoked certificate issued to an agent
+func (q *AgentCertificateQueries) RevokeAgentCertificates(agentID uuid.UUID, reason string) (int64, error) {
+	query := `
+		UPDATE agent_certificates
+		SET revoked_at = NOW(), revoke_reason = $2
+		WHERE agent_id = $1 AND revoked_at IS NULL
+	`
+
+	result, err := q.db.Exec(query, agentID, reason)
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke agent certificates: %w", err)
+	}
+	return result.RowsAffected()
+}
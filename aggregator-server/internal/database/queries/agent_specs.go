@@ -0,0 +1,106 @@
+package queries
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type AgentSpecsQueries struct {
+	db *sqlx.DB
+}
+
+func NewAgentSpecsQueries(db *sqlx.DB) *AgentSpecsQueries {
+	return &AgentSpecsQueries{db: db}
+}
+
+// GetLatestAgentSpecs returns an agent's most recent spec snapshot, or nil if it has none
+func (q *AgentSpecsQueries) GetLatestAgentSpecs(agentID uuid.UUID) (*models.AgentSpecs, error) {
+	var specs models.AgentSpecs
+	query := `SELECT * FROM agent_specs WHERE agent_id = $1 ORDER BY collected_at DESC LIMIT 1`
+	if err := q.db.Get(&specs, query, agentID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get agent specs: %w", err)
+	}
+	return &specs, nil
+}
+
+// GetAgentSpecs retrieves one of an agent's spec snapshots
+func (q *AgentSpecsQueries) GetAgentSpecs(agentID, id uuid.UUID) (*models.AgentSpecs, error) {
+	var specs models.AgentSpecs
+	query := `SELECT * FROM agent_specs WHERE id = $1 AND agent_id = $2`
+	if err := q.db.Get(&specs, query, id, agentID); err != nil {
+		return nil, err
+	}
+	return &specs, nil
+}
+
+// ListAgentSpecs returns an agent's spec snapshots, newest first
+func (q *AgentSpecsQueries) ListAgentSpecs(agentID uuid.UUID, limit int) ([]models.AgentSpecs, error) {
+	var specs []models.AgentSpecs
+	query := `SELECT * FROM agent_specs WHERE agent_id = $1 ORDER BY collected_at DESC LIMIT $2`
+	if err := q.db.Select(&specs, query, agentID, limit); err != nil {
+		return nil, fmt.Errorf("failed to list agent specs: %w", err)
+	}
+	return specs, nil
+}
+
+// CreateAgentSpecs stores a new spec snapshot
+func (q *AgentSpecsQueries) CreateAgentSpecs(specs *models.AgentSpecs) error {
+	query := `
+		INSERT INTO agent_specs (
+			id, agent_id, os_type, os_version, os_architecture, kernel_version,
+			cpu_model, cpu_cores, cpu_threads, memory_total_mb, disk_total_gb, disk_free_gb,
+			disks, network_interfaces, docker_installed, docker_version, package_managers,
+			dmi, virtualization, fingerprint, collected_at, last_seen_at
+		) VALUES (
+			:id, :agent_id, :os_type, :os_version, :os_architecture, :kernel_version,
+			:cpu_model, :cpu_cores, :cpu_threads, :memory_total_mb, :disk_total_gb, :disk_free_gb,
+			:disks, :network_interfaces, :docker_installed, :docker_version, :package_managers,
+			:dmi, :virtualization, :fingerprint, :collected_at, :last_seen_at
+		)
+	`
+	if _, err := q.db.NamedExec(query, specs); err != nil {
+		return fmt.Errorf("failed to create agent specs: %w", err)
+	}
+	return nil
+}
+
+// TouchAgentSpecs records that an unchanged snapshot was reported again,
+// refreshing the free space figures that are not part of change detection
+func (q *AgentSpecsQueries) TouchAgentSpecs(specs *models.AgentSpecs) error {
+	query := `
+		UPDATE agent_specs SET
+			disk_free_gb = :disk_free_gb,
+			disks = :disks,
+			last_seen_at = :last_seen_at
+		WHERE id = :id
+	`
+	if _, err := q.db.NamedExec(query, specs); err != nil {
+		return fmt.Errorf("failed to update agent specs: %w", err)
+	}
+	return nil
+}
+
+// GetPreviousAgentSpecs returns the snapshot collected before the given one, or nil if it is the first
+func (q *AgentSpecsQueries) GetPreviousAgentSpecs(specs *models.AgentSpecs) (*models.AgentSpecs, error) {
+	var previous models.AgentSpecs
+	query := `
+		SELECT * FROM agent_specs
+		WHERE agent_id = $1 AND collected_at < $2
+		ORDER BY collected_at DESC
+		LIMIT 1
+	`
+	if err := q.db.Get(&previous, query, specs.AgentID, specs.CollectedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get previous agent specs: %w", err)
+	}
+	return &previous, nil
+}
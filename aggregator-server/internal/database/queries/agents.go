@@ -3,28 +3,37 @@ package queries
 import (
 	"time"
 
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/events"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
 )
 
 type AgentQueries struct {
-	db *sqlx.DB
+	db  *sqlx.DB
+	bus *events.Bus
 }
 
 func NewAgentQueries(db *sqlx.DB) *AgentQueries {
 	return &AgentQueries{db: db}
 }
 
+// SetEventBus enables publishing of agent status change events
+func (q *AgentQueries) SetEventBus(bus *events.Bus) {
+	q.bus = bus
+}
+
 // CreateAgent inserts a new agent into the database
 func (q *AgentQueries) CreateAgent(agent *models.Agent) error {
 	query := `
 		INSERT INTO agents (
 			id, hostname, os_type, os_version, os_architecture,
-			agent_version, last_seen, status, metadata
+			agent_version, last_seen, status, metadata,
+			protocol_version, capabilities
 		) VALUES (
 			:id, :hostname, :os_type, :os_version, :os_architecture,
-			:agent_version, :last_seen, :status, :metadata
+			:agent_version, :last_seen, :status, :metadata,
+			:protocol_version, :capabilities
 		)
 	`
 	_, err := q.db.NamedExec(query, agent)
@@ -44,9 +53,32 @@ func (q *AgentQueries) GetAgentByID(id uuid.UUID) (*models.Agent, error) {
 
 // UpdateAgentLastSeen updates the agent's last_seen timestamp
 func (q *AgentQueries) UpdateAgentLastSeen(id uuid.UUID) error {
-	query := `UPDATE agents SET last_seen = $1, status = 'online' WHERE id = $2`
-	_, err := q.db.Exec(query, time.Now().UTC(), id)
-	return err
+	// Return the previous status so a transition to online can be published
+	query := `
+		UPDATE agents a SET last_seen = $1, status = 'online'
+		FROM (SELECT id, status FROM agents WHERE id = $2) prev
+		WHERE a.id = prev.id
+		RETURNING prev.status, a.hostname
+	`
+	var row struct {
+		PreviousStatus string `db:"status"`
+		Hostname       string `db:"hostname"`
+	}
+	if err := q.db.Get(&row, query, time.Now().UTC(), id); err != nil {
+		return err
+	}
+
+	if row.PreviousStatus != "online" {
+		q.bus.Publish(events.Event{
+			Type:    events.TypeAgentOnline,
+			AgentID: &id,
+			Data: map[string]interface{}{
+				"hostname":        row.Hostname,
+				"previous_status": row.PreviousStatus,
+			},
+		})
+	}
+	return nil
 }
 
 // UpdateAgent updates an agent's full record including metadata
@@ -67,6 +99,17 @@ func (q *AgentQueries) UpdateAgent(agent *models.Agent) error {
 	return err
 }
 
+// UpdateAgentCapabilities records the protocol version and capabilities an
+// agent reported, writing only when they changed
+func (q *AgentQueries) UpdateAgentCapabilities(id uuid.UUID, protocolVersion int, capabilities *models.AgentCapabilities) error {
+	query := `
+		UPDATE agents SET protocol_version = $2, capabilities = $3
+		WHERE id = $1 AND (protocol_version <> $2 OR capabilities IS DISTINCT FROM $3::jsonb)
+	`
+	_, err := q.db.Exec(query, id, protocolVersion, capabilities)
+	return err
+}
+
 // ListAgents returns all agents with optional filtering
 func (q *AgentQueries) ListAgents(status, osType string) ([]models.Agent, error) {
 	var agents []models.Agent
@@ -95,9 +138,29 @@ func (q *AgentQueries) MarkOfflineAgents(threshold time.Duration) error {
 		UPDATE agents
 		SET status = 'offline'
 		WHERE last_seen < $1 AND status = 'online'
+		RETURNING id, hostname, last_seen
 	`
-	_, err := q.db.Exec(query, time.Now().Add(-threshold))
-	return err
+	var agents []struct {
+		ID       uuid.UUID `db:"id"`
+		Hostname string    `db:"hostname"`
+		LastSeen time.Time `db:"last_seen"`
+	}
+	if err := q.db.Select(&agents, query, time.Now().Add(-threshold)); err != nil {
+		return err
+	}
+
+	for _, agent := range agents {
+		agentID := agent.ID
+		q.bus.Publish(events.Event{
+			Type:    events.TypeAgentOffline,
+			AgentID: &agentID,
+			Data: map[string]interface{}{
+				"hostname":  agent.Hostname,
+				"last_seen": agent.LastSeen,
+			},
+		})
+	}
+	return nil
 }
 
 // GetAgentLastScan gets the last scan time from update events
@@ -0,0 +1,330 @@
+package queries
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type AlertQueries struct {
+	db *sqlx.DB
+}
+
+func NewAlertQueries(db *sqlx.DB) *AlertQueries {
+	return &AlertQueries{db: db}
+}
+
+// ListAlertRules returns all alert rules
+func (q *AlertQueries) ListAlertRules() ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	query := `SELECT * FROM alert_rules ORDER BY created_at ASC`
+	if err := q.db.Select(&rules, query); err != nil {
+		return nil, fmt.Errorf("failed to list alert rules: %w", err)
+	}
+	return rules, nil
+}
+
+// GetEnabledAlertRules returns enabled rules, optionally filtered by type
+func (q *AlertQueries) GetEnabledAlertRules(ruleType string) ([]models.AlertRule, error) {
+	var rules []models.AlertRule
+	query := `SELECT * FROM alert_rules WHERE enabled = TRUE`
+	args := []interface{}{}
+	if ruleType != "" {
+		query += ` AND rule_type = $1`
+		args = append(args, ruleType)
+	}
+	if err := q.db.Select(&rules, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to get enabled alert rules: %w", err)
+	}
+	return rules, nil
+}
+
+// GetAlertRule retrieves a rule by ID
+func (q *AlertQueries) GetAlertRule(id uuid.UUID) (*models.AlertRule, error) {
+	var rule models.AlertRule
+	query := `SELECT * FROM alert_rules WHERE id = $1`
+	if err := q.db.Get(&rule, query, id); err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// CreateAlertRule inserts a new alert rule
+func (q *AlertQueries) CreateAlertRule(rule *models.AlertRule) error {
+	query := `
+		INSERT INTO alert_rules (
+			id, name, rule_type, threshold, duration_seconds, missed_intervals,
+			severity, agent_id, enabled
+		) VALUES (
+			:id, :name, :rule_type, :threshold, :duration_seconds, :missed_intervals,
+			:severity, :agent_id, :enabled
+		)
+	`
+	if _, err := q.db.NamedExec(query, rule); err != nil {
+		return fmt.Errorf("failed to create alert rule: %w", err)
+	}
+	return nil
+}
+
+// UpdateAlertRule updates an existing alert rule
+func (q *AlertQueries) UpdateAlertRule(rule *models.AlertRule) error {
+	query := `
+		UPDATE alert_rules SET
+			name = :name,
+			rule_type = :rule_type,
+			threshold = :threshold,
+			duration_seconds = :duration_seconds,
+			missed_intervals = :missed_intervals,
+			severity = :severity,
+			agent_id = :agent_id,
+			enabled = :enabled,
+			updated_at = NOW()
+		WHERE id = :id
+	`
+	result, err := q.db.NamedExec(query, rule)
+	if err != nil {
+		return fmt.Errorf("failed to update alert rule: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("alert rule not found")
+	}
+	return nil
+}
+
+// DeleteAlertRule removes a rule and, via cascade, its alerts and silences
+func (q *AlertQueries) DeleteAlertRule(id uuid.UUID) error {
+	result, err := q.db.Exec(`DELETE FROM alert_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete alert rule: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("alert rule not found")
+	}
+	return nil
+}
+
+// GetOpenAlert returns the pending or firing alert for a rule/agent pair, or nil if none exists
+func (q *AlertQueries) GetOpenAlert(ruleID, agentID uuid.UUID) (*models.Alert, error) {
+	var alert models.Alert
+	query := `
+		SELECT * FROM alerts
+		WHERE rule_id = $1 AND agent_id = $2 AND status IN ('pending', 'firing')
+	`
+	err := q.db.Get(&alert, query, ruleID, agentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get open alert: %w", err)
+	}
+	return &alert, nil
+}
+
+// GetAlertByID retrieves an alert by ID
+func (q *AlertQueries) GetAlertByID(id uuid.UUID) (*models.Alert, error) {
+	var alert models.Alert
+	query := `SELECT * FROM alerts WHERE id = $1`
+	if err := q.db.Get(&alert, query, id); err != nil {
+		return nil, err
+	}
+	return &alert, nil
+}
+
+// GetFiringAlertsByRuleType returns firing alerts for all rules of a given type
+func (q *AlertQueries) GetFiringAlertsByRuleType(ruleType string) ([]models.Alert, error) {
+	var alerts []models.Alert
+	query := `
+		SELECT a.* FROM alerts a
+		JOIN alert_rules r ON r.id = a.rule_id
+		WHERE a.status = 'firing' AND r.rule_type = $1
+	`
+	if err := q.db.Select(&alerts, query, ruleType); err != nil {
+		return nil, fmt.Errorf("failed to get firing alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+// CreateAlert inserts a new alert instance
+func (q *AlertQueries) CreateAlert(alert *models.Alert) error {
+	query := `
+		INSERT INTO alerts (
+			id, rule_id, agent_id, status, severity, message, value,
+			pending_since, fired_at, metadata
+		) VALUES (
+			:id, :rule_id, :agent_id, :status, :severity, :message, :value,
+			:pending_since, :fired_at, :metadata
+		)
+	`
+	if _, err := q.db.NamedExec(query, alert); err != nil {
+		return fmt.Errorf("failed to create alert: %w", err)
+	}
+	return nil
+}
+
+// UpdateAlertValue records the latest observed value for an open alert
+func (q *AlertQueries) UpdateAlertValue(id uuid.UUID, value float64, message string) error {
+	query := `UPDATE alerts SET value = $1, message = $2, updated_at = NOW() WHERE id = $3`
+	if _, err := q.db.Exec(query, value, message, id); err != nil {
+		return fmt.Errorf("failed to update alert value: %w", err)
+	}
+	return nil
+}
+
+// MarkAlertFiring transitions a pending alert to firing
+func (q *AlertQueries) MarkAlertFiring(id uuid.UUID, value float64, message string) error {
+	query := `
+		UPDATE alerts
+		SET status = 'firing', fired_at = $1, value = $2, message = $3, updated_at = NOW()
+		WHERE id = $4 AND status = 'pending'
+	`
+	if _, err := q.db.Exec(query, time.Now(), value, message, id); err != nil {
+		return fmt.Errorf("failed to mark alert firing: %w", err)
+	}
+	return nil
+}
+
+// MarkAlertResolved transitions an open alert to resolved
+func (q *AlertQueries) MarkAlertResolved(id uuid.UUID) error {
+	query := `
+		UPDATE alerts
+		SET status = 'resolved', resolved_at = $1, updated_at = NOW()
+		WHERE id = $2 AND status IN ('pending', 'firing')
+	`
+	if _, err := q.db.Exec(query, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to mark alert resolved: %w", err)
+	}
+	return nil
+}
+
+// DeleteAlert removes an alert (used for pending alerts whose condition cleared before firing)
+func (q *AlertQueries) DeleteAlert(id uuid.UUID) error {
+	if _, err := q.db.Exec(`DELETE FROM alerts WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("failed to delete alert: %w", err)
+	}
+	return nil
+}
+
+// MarkAlertNotified records when a notification was last sent for an alert
+func (q *AlertQueries) MarkAlertNotified(id uuid.UUID) error {
+	query := `UPDATE alerts SET last_notified_at = $1 WHERE id = $2`
+	if _, err := q.db.Exec(query, time.Now(), id); err != nil {
+		return fmt.Errorf("failed to mark alert notified: %w", err)
+	}
+	return nil
+}
+
+// AcknowledgeAlert marks an alert as acknowledged by a user
+func (q *AlertQueries) AcknowledgeAlert(id uuid.UUID, userID *uuid.UUID) error {
+	query := `
+		UPDATE alerts
+		SET acknowledged_at = $1, acknowledged_by = $2, updated_at = NOW()
+		WHERE id = $3 AND acknowledged_at IS NULL
+	`
+	result, err := q.db.Exec(query, time.Now(), userID, id)
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge alert: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("alert not found or already acknowledged")
+	}
+	return nil
+}
+
+// ListAlerts returns alerts with rule/agent context, optionally filtered by status and agent
+func (q *AlertQueries) ListAlerts(status string, agentID *uuid.UUID, limit int) ([]models.AlertWithContext, error) {
+	var alerts []models.AlertWithContext
+	query := `
+		SELECT
+			a.*,
+			r.name as rule_name,
+			r.rule_type as rule_type,
+			ag.hostname as hostname,
+			EXISTS (
+				SELECT 1 FROM alert_silences s
+				WHERE s.starts_at <= NOW() AND s.ends_at > NOW()
+				  AND (s.rule_id IS NULL OR s.rule_id = a.rule_id)
+				  AND (s.agent_id IS NULL OR s.agent_id = a.agent_id)
+			) as silenced
+		FROM alerts a
+		JOIN alert_rules r ON r.id = a.rule_id
+		JOIN agents ag ON ag.id = a.agent_id
+		WHERE 1=1
+	`
+	args := []interface{}{}
+	argIdx := 1
+
+	if status != "" {
+		query += fmt.Sprintf(" AND a.status = $%d", argIdx)
+		args = append(args, status)
+		argIdx++
+	}
+	if agentID != nil {
+		query += fmt.Sprintf(" AND a.agent_id = $%d", argIdx)
+		args = append(args, *agentID)
+		argIdx++
+	}
+
+	query += fmt.Sprintf(" ORDER BY a.pending_since DESC LIMIT $%d", argIdx)
+	args = append(args, limit)
+
+	if err := q.db.Select(&alerts, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list alerts: %w", err)
+	}
+	return alerts, nil
+}
+
+// IsSilenced reports whether an active silence matches the rule/agent pair
+func (q *AlertQueries) IsSilenced(ruleID, agentID uuid.UUID) (bool, error) {
+	var silenced bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM alert_silences
+			WHERE starts_at <= NOW() AND ends_at > NOW()
+			  AND (rule_id IS NULL OR rule_id = $1)
+			  AND (agent_id IS NULL OR agent_id = $2)
+		)
+	`
+	if err := q.db.Get(&silenced, query, ruleID, agentID); err != nil {
+		return false, fmt.Errorf("failed to check alert silences: %w", err)
+	}
+	return silenced, nil
+}
+
+// CreateSilence inserts a new silence window
+func (q *AlertQueries) CreateSilence(silence *models.AlertSilence) error {
+	query := `
+		INSERT INTO alert_silences (id, rule_id, agent_id, reason, created_by, starts_at, ends_at)
+		VALUES (:id, :rule_id, :agent_id, :reason, :created_by, :starts_at, :ends_at)
+	`
+	if _, err := q.db.NamedExec(query, silence); err != nil {
+		return fmt.Errorf("failed to create silence: %w", err)
+	}
+	return nil
+}
+
+// ListActiveSilences returns silences that have not yet ended
+func (q *AlertQueries) ListActiveSilences() ([]models.AlertSilence, error) {
+	var silences []models.AlertSilence
+	query := `SELECT * FROM alert_silences WHERE ends_at > NOW() ORDER BY ends_at ASC`
+	if err := q.db.Select(&silences, query); err != nil {
+		return nil, fmt.Errorf("failed to list silences: %w", err)
+	}
+	return silences, nil
+}
+
+// ExpireSilence ends a silence immediately
+func (q *AlertQueries) ExpireSilence(id uuid.UUID) error {
+	query := `UPDATE alert_silences SET ends_at = NOW() WHERE id = $1 AND ends_at > NOW()`
+	result, err := q.db.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("failed to expire silence: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("silence not found or already expired")
+	}
+	return nil
+}
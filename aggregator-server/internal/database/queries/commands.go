@@ -1,33 +1,174 @@
 package queries
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
 	"time"
 
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/events"
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/tracing"
 	"github.com/google/uuid"
 	"github.com/jmoiron/sqlx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// ErrCommandUnsupported is returned when queuing a command the agent has
+// reported it can't run
+var ErrCommandUnsupported = errors.New("agent does not support this command")
+
+// ErrCommandOutputLimit is returned when a chunk would take a command's stored
+// live output past its size limit
+var ErrCommandOutputLimit = errors.New("command output limit reached")
+
 type CommandQueries struct {
-	db *sqlx.DB
+	db  *sqlx.DB
+	bus *events.Bus
+	ctx context.Context
 }
 
 func NewCommandQueries(db *sqlx.DB) *CommandQueries {
 	return &CommandQueries{db: db}
 }
 
+// SetEventBus enables publishing of command lifecycle events
+func (q *CommandQueries) SetEventBus(bus *events.Bus) {
+	q.bus = bus
+}
+
+// WithContext returns a copy of q whose queries are traced as children of the
+// span in ctx. Queries are not cancelled with ctx, so a client disconnecting
+// can't leave a command half-updated.
+func (q *CommandQueries) WithContext(ctx context.Context) *CommandQueries {
+	c := *q
+	c.ctx = context.WithoutCancel(ctx)
+	return &c
+}
+
+func (q *CommandQueries) context() context.Context {
+	if q.ctx == nil {
+		return context.Background()
+	}
+	return q.ctx
+}
+
+func (q *CommandQueries) startSpan(name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return startQuerySpan(q.context(), "CommandQueries."+name, "agent_commands", attrs...)
+}
+
+// publishStatusChange runs a status UPDATE that returns agent_id and command_type,
+// and publishes an event if a row was changed
+func (q *CommandQueries) publishStatusChange(name, eventType, status string, id uuid.UUID, query string, args ...interface{}) (err error) {
+	ctx, span := q.startSpan(name, attribute.String("redflag.command_id", id.String()))
+	defer func() { tracing.End(span, err) }()
+
+	var row struct {
+		AgentID     uuid.UUID `db:"agent_id"`
+		CommandType string    `db:"command_type"`
+	}
+	err = q.db.GetContext(ctx, &row, query, args...)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	q.bus.Publish(events.Event{
+		Type:      eventType,
+		AgentID:   &row.AgentID,
+		CommandID: &id,
+		Data: map[string]interface{}{
+			"command_type": row.CommandType,
+			"status":       status,
+		},
+	})
+	return nil
+}
+
 // CreateCommand inserts a new command for an agent
 func (q *CommandQueries) CreateCommand(cmd *models.AgentCommand) error {
+	if cmd.Status == models.CommandStatusPending {
+		if err := q.checkAgentSupports(cmd); err != nil {
+			return err
+		}
+	}
+
+	ctx, span := q.startSpan("CreateCommand",
+		attribute.String("redflag.command_id", cmd.ID.String()),
+		attribute.String("redflag.command_type", cmd.CommandType),
+		attribute.String("redflag.agent_id", cmd.AgentID.String()),
+	)
+
+	// Carry the creating request's trace to the agent so its work joins the same trace
+	if q.ctx != nil {
+		if cmd.Params == nil {
+			cmd.Params = models.JSONB{}
+		}
+		tracing.InjectParams(q.ctx, cmd.Params)
+	}
+
 	query := `
 		INSERT INTO agent_commands (
-			id, agent_id, command_type, params, status, source, retried_from_id
+			id, agent_id, command_type, params, status, source, retried_from_id, request_id
 		) VALUES (
-			:id, :agent_id, :command_type, :params, :status, :source, :retried_from_id
+			:id, :agent_id, :command_type, :params, :status, :source, :retried_from_id, :request_id
 		)
 	`
-	_, err := q.db.NamedExec(query, cmd)
-	return err
+	_, err := q.db.NamedExecContext(ctx, query, cmd)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+
+	q.bus.Publish(events.Event{
+		Type:      events.TypeCommandCreated,
+		AgentID:   &cmd.AgentID,
+		CommandID: &cmd.ID,
+		Data: map[string]interface{}{
+			"command_type": cmd.CommandType,
+			"status":       cmd.Status,
+			"source":       cmd.Source,
+			"request_id":   cmd.RequestID,
+		},
+	})
+	return nil
+}
+
+// checkAgentSupports refuses a command the agent has reported it can't run.
+// Agents that predate capability reporting get the command with a warning.
+func (q *CommandQueries) checkAgentSupports(cmd *models.AgentCommand) error {
+	var agent struct {
+		Hostname     string                    `db:"hostname"`
+		Capabilities *models.AgentCapabilities `db:"capabilities"`
+	}
+	err := q.db.Get(&agent, `SELECT hostname, capabilities FROM agents WHERE id = $1`, cmd.AgentID)
+	if err != nil {
+		return fmt.Errorf("failed to get agent capabilities: %w", err)
+	}
+
+	if agent.Capabilities == nil {
+		slog.Warn("agent doesn't report capabilities, queuing command without knowing whether it can run it",
+			"agent_id", cmd.AgentID, "hostname", agent.Hostname, "command_type", cmd.CommandType)
+		return nil
+	}
+
+	if !agent.Capabilities.SupportsCommand(cmd.CommandType) {
+		return fmt.Errorf("%w: agent %s doesn't handle %s commands", ErrCommandUnsupported, agent.Hostname, cmd.CommandType)
+	}
+	if packageType, ok := cmd.Params["package_type"].(string); ok && packageType != "" {
+		switch cmd.CommandType {
+		case models.CommandTypeInstallUpdate, models.CommandTypeDryRunUpdate, models.CommandTypeConfirmDependencies, models.CommandTypeRollback:
+			if !agent.Capabilities.SupportsPackageType(packageType) {
+				return fmt.Errorf("%w: agent %s can't install %s packages", ErrCommandUnsupported, agent.Hostname, packageType)
+			}
+		}
+	}
+	return nil
 }
 
 // GetPendingCommands retrieves pending commands for an agent
@@ -39,7 +180,10 @@ func (q *CommandQueries) GetPendingCommands(agentID uuid.UUID) ([]models.AgentCo
 		ORDER BY created_at ASC
 		LIMIT 10
 	`
-	err := q.db.Select(&commands, query, agentID)
+	ctx, span := q.startSpan("GetPendingCommands", attribute.String("redflag.agent_id", agentID.String()))
+	err := q.db.SelectContext(ctx, &commands, query, agentID)
+	span.SetAttributes(attribute.Int("redflag.command_count", len(commands)))
+	tracing.End(span, err)
 	return commands, err
 }
 
@@ -50,9 +194,9 @@ func (q *CommandQueries) MarkCommandSent(id uuid.UUID) error {
 		UPDATE agent_commands
 		SET status = 'sent', sent_at = $1
 		WHERE id = $2
+		RETURNING agent_id, command_type
 	`
-	_, err := q.db.Exec(query, now, id)
-	return err
+	return q.publishStatusChange("MarkCommandSent", events.TypeCommandSent, models.CommandStatusSent, id, query, now, id)
 }
 
 // MarkCommandCompleted updates a command's status to completed
@@ -62,9 +206,9 @@ func (q *CommandQueries) MarkCommandCompleted(id uuid.UUID, result models.JSONB)
 		UPDATE agent_commands
 		SET status = 'completed', completed_at = $1, result = $2
 		WHERE id = $3
+		RETURNING agent_id, command_type
 	`
-	_, err := q.db.Exec(query, now, result, id)
-	return err
+	return q.publishStatusChange("MarkCommandCompleted", events.TypeCommandCompleted, models.CommandStatusCompleted, id, query, now, result, id)
 }
 
 // MarkCommandFailed updates a command's status to failed
@@ -74,9 +218,9 @@ func (q *CommandQueries) MarkCommandFailed(id uuid.UUID, result models.JSONB) er
 		UPDATE agent_commands
 		SET status = 'failed', completed_at = $1, result = $2
 		WHERE id = $3
+		RETURNING agent_id, command_type
 	`
-	_, err := q.db.Exec(query, now, result, id)
-	return err
+	return q.publishStatusChange("MarkCommandFailed", events.TypeCommandFailed, models.CommandStatusFailed, id, query, now, result, id)
 }
 
 // GetCommandsByStatus retrieves commands with a specific status
@@ -91,15 +235,41 @@ func (q *CommandQueries) GetCommandsByStatus(status string) ([]models.AgentComma
 	return commands, err
 }
 
+// HasCommandSince reports whether a command of the given type was created for an agent after the given time
+func (q *CommandQueries) HasCommandSince(agentID uuid.UUID, commandType string, since time.Time) (bool, error) {
+	var exists bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM agent_commands
+			WHERE agent_id = $1 AND command_type = $2 AND created_at >= $3
+		)
+	`
+	err := q.db.Get(&exists, query, agentID, commandType, since)
+	return exists, err
+}
+
+// HasActiveCommand reports whether an agent has a pending or sent command of the given type
+func (q *CommandQueries) HasActiveCommand(agentID uuid.UUID, commandType string) (bool, error) {
+	var exists bool
+	query := `
+		SELECT EXISTS (
+			SELECT 1 FROM agent_commands
+			WHERE agent_id = $1 AND command_type = $2 AND status IN ('pending', 'sent')
+		)
+	`
+	err := q.db.Get(&exists, query, agentID, commandType)
+	return exists, err
+}
+
 // UpdateCommandStatus updates only the status of a command
 func (q *CommandQueries) UpdateCommandStatus(id uuid.UUID, status string) error {
 	query := `
 		UPDATE agent_commands
 		SET status = $1
 		WHERE id = $2
+		RETURNING agent_id, command_type
 	`
-	_, err := q.db.Exec(query, status, id)
-	return err
+	return q.publishStatusChange("UpdateCommandStatus", events.TypeCommandStatus, status, id, query, status, id)
 }
 
 // UpdateCommandResult updates only the result of a command
@@ -109,7 +279,9 @@ func (q *CommandQueries) UpdateCommandResult(id uuid.UUID, result interface{}) e
 		SET result = $1
 		WHERE id = $2
 	`
-	_, err := q.db.Exec(query, result, id)
+	ctx, span := q.startSpan("UpdateCommandResult", attribute.String("redflag.command_id", id.String()))
+	_, err := q.db.ExecContext(ctx, query, result, id)
+	tracing.End(span, err)
 	return err
 }
 
@@ -120,7 +292,9 @@ func (q *CommandQueries) GetCommandByID(id uuid.UUID) (*models.AgentCommand, err
 		SELECT * FROM agent_commands
 		WHERE id = $1
 	`
-	err := q.db.Get(&command, query, id)
+	ctx, span := q.startSpan("GetCommandByID", attribute.String("redflag.command_id", id.String()))
+	err := q.db.GetContext(ctx, &command, query, id)
+	tracing.End(span, err)
 	if err != nil {
 		return nil, err
 	}
@@ -134,13 +308,74 @@ func (q *CommandQueries) CancelCommand(id uuid.UUID) error {
 		UPDATE agent_commands
 		SET status = 'cancelled', completed_at = $1
 		WHERE id = $2 AND status IN ('pending', 'sent')
+		RETURNING agent_id, command_type
 	`
-	_, err := q.db.Exec(query, now, id)
-	return err
+	return q.publishStatusChange("CancelCommand", events.TypeCommandStatus, models.CommandStatusCancelled, id, query, now, id)
+}
+
+// CancelPendingCommand cancels a command only if the agent hasn't fetched it
+// yet; commands already sent are left to run and report
+func (q *CommandQueries) CancelPendingCommand(id uuid.UUID) error {
+	now := time.Now()
+	query := `
+		UPDATE agent_commands
+		SET status = 'cancelled', completed_at = $1
+		WHERE id = $2 AND status = 'pending'
+		RETURNING agent_id, command_type
+	`
+	return q.publishStatusChange("CancelPendingCommand", events.TypeCommandStatus, models.CommandStatusCancelled, id, query, now, id)
+}
+
+// AppendCommandOutput stores a chunk of live output. Returns false if the chunk
+// was a duplicate (same command and sequence number) and was ignored, and
+// ErrCommandOutputLimit if the command's output would grow past maxBytes.
+func (q *CommandQueries) AppendCommandOutput(chunk *models.CommandOutputChunk, maxBytes int) (stored bool, err error) {
+	ctx, span := startQuerySpan(q.context(), "CommandQueries.AppendCommandOutput", "command_output_chunks",
+		attribute.String("redflag.command_id", chunk.CommandID.String()),
+		attribute.Int("redflag.output_seq", chunk.Seq),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	var total int
+	sizeQuery := `SELECT COALESCE(SUM(octet_length(data)), 0) FROM command_output_chunks WHERE command_id = $1`
+	if err := q.db.GetContext(ctx, &total, sizeQuery, chunk.CommandID); err != nil {
+		return false, fmt.Errorf("failed to get command output size: %w", err)
+	}
+	if total+len(chunk.Data) > maxBytes {
+		return false, ErrCommandOutputLimit
+	}
+
+	query := `
+		INSERT INTO command_output_chunks (command_id, agent_id, seq, data, final)
+		VALUES (:command_id, :agent_id, :seq, :data, :final)
+		ON CONFLICT (command_id, seq) DO NOTHING
+	`
+	result, err := q.db.NamedExecContext(ctx, query, chunk)
+	if err != nil {
+		return false, fmt.Errorf("failed to append command output: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// GetCommandOutput returns output chunks for a command with seq greater than afterSeq
+func (q *CommandQueries) GetCommandOutput(commandID uuid.UUID, afterSeq, limit int) ([]models.CommandOutputChunk, error) {
+	var chunks []models.CommandOutputChunk
+	query := `
+		SELECT * FROM command_output_chunks
+		WHERE command_id = $1 AND seq > $2
+		ORDER BY seq ASC
+		LIMIT $3
+	`
+	if err := q.db.Select(&chunks, query, commandID, afterSeq, limit); err != nil {
+		return nil, fmt.Errorf("failed to get command output: %w", err)
+	}
+	return chunks, nil
 }
 
-// RetryCommand creates a new command based on a failed/timed_out/cancelled command
-func (q *CommandQueries) RetryCommand(originalID uuid.UUID) (*models.AgentCommand, error) {
+// RetryCommand creates a new command based on a failed/timed_out/cancelled command.
+// requestID identifies the API request asking for the retry and may be nil.
+func (q *CommandQueries) RetryCommand(originalID uuid.UUID, requestID *string) (*models.AgentCommand, error) {
 	// Get the original command
 	original, err := q.GetCommandByID(originalID)
 	if err != nil {
@@ -161,6 +396,7 @@ func (q *CommandQueries) RetryCommand(originalID uuid.UUID) (*models.AgentComman
 		Status:        models.CommandStatusPending,
 		CreatedAt:     time.Now(),
 		RetriedFromID: &originalID,
+		RequestID:     requestID,
 	}
 
 	// Store the new command
@@ -188,6 +424,7 @@ func (q *CommandQueries) GetActiveCommands() ([]models.ActiveCommandInfo, error)
 			c.sent_at,
 			c.result,
 			c.retried_from_id,
+			c.request_id,
 			a.hostname as agent_hostname,
 			COALESCE(ups.package_name, 'N/A') as package_name,
 			COALESCE(ups.package_type, 'N/A') as package_type,
@@ -251,6 +488,7 @@ func (q *CommandQueries) GetRecentCommands(limit int) ([]models.ActiveCommandInf
 			c.completed_at,
 			c.result,
 			c.retried_from_id,
+			c.request_id,
 			a.hostname as agent_hostname,
 			COALESCE(ups.package_name, 'N/A') as package_name,
 			COALESCE(ups.package_type, 'N/A') as package_type,
@@ -0,0 +1,222 @@
+package queries
+
+import (
+	"database/sql"
+	"fmt"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type ConfigProfileQueries struct {
+	db *sqlx.DB
+}
+
+func NewConfigProfileQueries(db *sqlx.DB) *ConfigProfileQueries {
+	return &ConfigProfileQueries{db: db}
+}
+
+// ListConfigProfiles returns all profiles with how many agents use and have applied each
+func (q *ConfigProfileQueries) ListConfigProfiles() ([]models.ConfigProfileSummary, error) {
+	var profiles []models.ConfigProfileSummary
+	query := `
+		SELECT p.*,
+			(SELECT COUNT(*) FROM agents a
+				LEFT JOIN agent_config ac ON ac.agent_id = a.id
+				WHERE ac.profile_id = p.id OR (p.is_default AND ac.profile_id IS NULL)) AS agent_count,
+			(SELECT COUNT(*) FROM agent_config ac
+				WHERE ac.applied_profile_id = p.id AND ac.applied_version = p.version) AS applied_count
+		FROM config_profiles p
+		ORDER BY p.name ASC
+	`
+	if err := q.db.Select(&profiles, query); err != nil {
+		return nil, fmt.Errorf("failed to list config profiles: %w", err)
+	}
+	return profiles, nil
+}
+
+// GetConfigProfile retrieves a profile by ID
+func (q *ConfigProfileQueries) GetConfigProfile(id uuid.UUID) (*models.ConfigProfile, error) {
+	var profile models.ConfigProfile
+	query := `SELECT * FROM config_profiles WHERE id = $1`
+	if err := q.db.Get(&profile, query, id); err != nil {
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// GetEffectiveConfigProfile returns the assigned profile, or the default
+// profile when none is assigned. Returns nil if neither exists.
+func (q *ConfigProfileQueries) GetEffectiveConfigProfile(assigned *uuid.UUID) (*models.ConfigProfile, error) {
+	var profile models.ConfigProfile
+	query := `SELECT * FROM config_profiles WHERE is_default`
+	args := []interface{}{}
+	if assigned != nil {
+		query = `SELECT * FROM config_profiles WHERE id = $1`
+		args = append(args, *assigned)
+	}
+	if err := q.db.Get(&profile, query, args...); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get config profile: %w", err)
+	}
+	return &profile, nil
+}
+
+// CreateConfigProfile inserts a profile; a new default profile replaces the old one
+func (q *ConfigProfileQueries) CreateConfigProfile(profile *models.ConfigProfile) error {
+	tx, err := q.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if profile.IsDefault {
+		if _, err := tx.Exec(`UPDATE config_profiles SET is_default = FALSE WHERE is_default`); err != nil {
+			return fmt.Errorf("failed to clear default config profile: %w", err)
+		}
+	}
+
+	query := `
+		INSERT INTO config_profiles (
+			id, name, description, version, is_default, check_in_interval, log_level, scanners
+		) VALUES (
+			:id, :name, :description, :version, :is_default, :check_in_interval, :log_level, :scanners
+		)
+	`
+	if _, err := tx.NamedExec(query, profile); err != nil {
+		return fmt.Errorf("failed to create config profile: %w", err)
+	}
+
+	return tx.Commit()
+}
+
+// UpdateConfigProfile updates a profile; a new default profile replaces the old one
+func (q *ConfigProfileQueries) UpdateConfigProfile(profile *models.ConfigProfile) error {
+	tx, err := q.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if profile.IsDefault {
+		if _, err := tx.Exec(`UPDATE config_profiles SET is_default = FALSE WHERE is_default AND id != $1`, profile.ID); err != nil {
+			return fmt.Errorf("failed to clear default config profile: %w", err)
+		}
+	}
+
+	query := `
+		UPDATE config_profiles SET
+			name = :name,
+			description = :description,
+			version = :version,
+			is_default = :is_default,
+			check_in_interval = :check_in_interval,
+			log_level = :log_level,
+			scanners = :scanners,
+			updated_at = NOW()
+		WHERE id = :id
+	`
+	result, err := tx.NamedExec(query, profile)
+	if err != nil {
+		return fmt.Errorf("failed to update config profile: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("config profile not found")
+	}
+
+	return tx.Commit()
+}
+
+// DeleteConfigProfile removes a profile; agents assigned to it fall back to the default
+func (q *ConfigProfileQueries) DeleteConfigProfile(id uuid.UUID) error {
+	result, err := q.db.Exec(`DELETE FROM config_profiles WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete config profile: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("config profile not found")
+	}
+	return nil
+}
+
+// GetAgentConfigState returns an agent's profile assignment and applied
+// version. An agent with no record yet gets an empty state.
+func (q *ConfigProfileQueries) GetAgentConfigState(agentID uuid.UUID) (*models.AgentConfigState, error) {
+	var state models.AgentConfigState
+	query := `SELECT * FROM agent_config WHERE agent_id = $1`
+	if err := q.db.Get(&state, query, agentID); err != nil {
+		if err == sql.ErrNoRows {
+			return &models.AgentConfigState{AgentID: agentID}, nil
+		}
+		return nil, fmt.Errorf("failed to get agent config state: %w", err)
+	}
+	return &state, nil
+}
+
+// SetAgentConfigProfile assigns a profile to an agent; nil returns it to the default profile
+func (q *ConfigProfileQueries) SetAgentConfigProfile(agentID uuid.UUID, profileID *uuid.UUID) error {
+	query := `
+		INSERT INTO agent_config (agent_id, profile_id)
+		VALUES ($1, $2)
+		ON CONFLICT (agent_id) DO UPDATE SET profile_id = EXCLUDED.profile_id
+	`
+	if _, err := q.db.Exec(query, agentID, profileID); err != nil {
+		return fmt.Errorf("failed to assign config profile: %w", err)
+	}
+	return nil
+}
+
+// RecordAppliedConfig stores the profile version an agent acknowledged, and
+// why it rejected the last one delivered, if it did
+func (q *ConfigProfileQueries) RecordAppliedConfig(agentID uuid.UUID, profileID *uuid.UUID, version int, applyError string) error {
+	query := `
+		INSERT INTO agent_config (agent_id, applied_profile_id, applied_version, applied_at, apply_error)
+		VALUES ($1, $2, $3, NOW(), $4)
+		ON CONFLICT (agent_id) DO UPDATE SET
+			applied_at = CASE
+				WHEN agent_config.applied_profile_id IS NOT DISTINCT FROM EXCLUDED.applied_profile_id
+					AND agent_config.applied_version = EXCLUDED.applied_version
+				THEN agent_config.applied_at
+				ELSE NOW()
+			END,
+			applied_profile_id = EXCLUDED.applied_profile_id,
+			applied_version = EXCLUDED.applied_version,
+			apply_error = EXCLUDED.apply_error
+	`
+	if _, err := q.db.Exec(query, agentID, profileID, version, applyError); err != nil {
+		return fmt.Errorf("failed to record applied config: %w", err)
+	}
+	return nil
+}
+
+// ListAgentConfigStatus returns, for every agent, the profile version it
+// should run and the one it has applied
+func (q *ConfigProfileQueries) ListAgentConfigStatus() ([]models.AgentConfigStatus, error) {
+	var statuses []models.AgentConfigStatus
+	query := `
+		SELECT
+			a.id AS agent_id,
+			a.hostname,
+			ac.profile_id AS assigned_profile_id,
+			p.id AS profile_id,
+			p.name AS profile_name,
+			p.version AS profile_version,
+			ac.applied_profile_id,
+			ac.applied_version,
+			ac.applied_at,
+			ac.apply_error,
+			(ac.applied_profile_id IS NOT DISTINCT FROM p.id
+				AND (p.id IS NULL OR ac.applied_version = p.version)) AS in_sync
+		FROM agents a
+		LEFT JOIN agent_config ac ON ac.agent_id = a.id
+		LEFT JOIN config_profiles p ON p.id = COALESCE(ac.profile_id, (SELECT id FROM config_profiles WHERE is_default))
+		ORDER BY a.hostname ASC
+	`
+	if err := q.db.Select(&statuses, query); err != nil {
+		return nil, fmt.Errorf("failed to list agent config status: %w", err)
+	}
+	return statuses, nil
+}
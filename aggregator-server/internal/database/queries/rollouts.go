@@ -0,0 +1,337 @@
+package queries
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+)
+
+type RolloutQueries struct {
+	db *sqlx.DB
+}
+
+func NewRolloutQueries(db *sqlx.DB) *RolloutQueries {
+	return &RolloutQueries{db: db}
+}
+
+// CreateRollout inserts a rollout with its rings and targets in one transaction
+func (q *RolloutQueries) CreateRollout(rollout *models.Rollout, rings []models.RolloutRing, targets []models.RolloutTarget) error {
+	tx, err := q.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := `
+		INSERT INTO rollouts (
+			id, name, status, current_ring, min_success_percent, soak_seconds,
+			max_new_failures, created_by
+		) VALUES (
+			:id, :name, :status, :current_ring, :min_success_percent, :soak_seconds,
+			:max_new_failures, :created_by
+		)
+	`
+	if _, err := tx.NamedExec(query, rollout); err != nil {
+		return fmt.Errorf("failed to create rollout: %w", err)
+	}
+
+	for i := range rings {
+		query := `
+			INSERT INTO rollout_rings (id, rollout_id, ring_index, name, status)
+			VALUES (:id, :rollout_id, :ring_index, :name, :status)
+		`
+		if _, err := tx.NamedExec(query, &rings[i]); err != nil {
+			return fmt.Errorf("failed to create rollout ring: %w", err)
+		}
+	}
+
+	for i := range targets {
+		query := `
+			INSERT INTO rollout_targets (
+				id, rollout_id, ring_id, agent_id, update_id, package_type, package_name, status
+			) VALUES (
+				:id, :rollout_id, :ring_id, :agent_id, :update_id, :package_type, :package_name, :status
+			)
+		`
+		if _, err := tx.NamedExec(query, &targets[i]); err != nil {
+			return fmt.Errorf("failed to create rollout target: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// ListRollouts returns rollouts, newest first, optionally filtered by status
+func (q *RolloutQueries) ListRollouts(status string) ([]models.Rollout, error) {
+	var rollouts []models.Rollout
+	query := `SELECT * FROM rollouts`
+	args := []interface{}{}
+	if status != "" {
+		query += ` WHERE status = $1`
+		args = append(args, status)
+	}
+	query += ` ORDER BY created_at DESC`
+	if err := q.db.Select(&rollouts, query, args...); err != nil {
+		return nil, fmt.Errorf("failed to list rollouts: %w", err)
+	}
+	return rollouts, nil
+}
+
+// GetRollout retrieves a rollout by ID
+func (q *RolloutQueries) GetRollout(id uuid.UUID) (*models.Rollout, error) {
+	var rollout models.Rollout
+	query := `SELECT * FROM rollouts WHERE id = $1`
+	if err := q.db.Get(&rollout, query, id); err != nil {
+		return nil, err
+	}
+	return &rollout, nil
+}
+
+// GetRolloutDetail retrieves a rollout with its rings and targets
+func (q *RolloutQueries) GetRolloutDetail(id uuid.UUID) (*models.RolloutDetail, error) {
+	rollout, err := q.GetRollout(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var rings []models.RolloutRing
+	if err := q.db.Select(&rings, `SELECT * FROM rollout_rings WHERE rollout_id = $1 ORDER BY ring_index ASC`, id); err != nil {
+		return nil, fmt.Errorf("failed to get rollout rings: %w", err)
+	}
+
+	var targets []models.RolloutTargetWithAgent
+	query := `
+		SELECT t.*, a.hostname
+		FROM rollout_targets t
+		JOIN agents a ON t.agent_id = a.id
+		WHERE t.rollout_id = $1
+		ORDER BY a.hostname ASC, t.package_name ASC
+	`
+	if err := q.db.Select(&targets, query, id); err != nil {
+		return nil, fmt.Errorf("failed to get rollout targets: %w", err)
+	}
+
+	detail := &models.RolloutDetail{Rollout: *rollout, Rings: make([]models.RolloutRingDetail, len(rings))}
+	for i, ring := range rings {
+		detail.Rings[i] = models.RolloutRingDetail{RolloutRing: ring, Targets: []models.RolloutTargetWithAgent{}}
+		for _, target := range targets {
+			if target.RingID == ring.ID {
+				detail.Rings[i].Targets = append(detail.Rings[i].Targets, target)
+			}
+		}
+	}
+	return detail, nil
+}
+
+// GetRing retrieves a rollout's ring by index. Returns nil if there is no such ring.
+func (q *RolloutQueries) GetRing(rolloutID uuid.UUID, index int) (*models.RolloutRing, error) {
+	var ring models.RolloutRing
+	query := `SELECT * FROM rollout_rings WHERE rollout_id = $1 AND ring_index = $2`
+	if err := q.db.Get(&ring, query, rolloutID, index); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get rollout ring: %w", err)
+	}
+	return &ring, nil
+}
+
+// GetRingTargets returns the targets in a ring
+func (q *RolloutQueries) GetRingTargets(ringID uuid.UUID) ([]models.RolloutTarget, error) {
+	var targets []models.RolloutTarget
+	query := `SELECT * FROM rollout_targets WHERE ring_id = $1`
+	if err := q.db.Select(&targets, query, ringID); err != nil {
+		return nil, fmt.Errorf("failed to get ring targets: %w", err)
+	}
+	return targets, nil
+}
+
+// GetTargetByCommandID returns the rollout target an install command belongs to,
+// or nil if the command wasn't created by a rollout
+func (q *RolloutQueries) GetTargetByCommandID(commandID uuid.UUID) (*models.RolloutTarget, error) {
+	var target models.RolloutTarget
+	query := `SELECT * FROM rollout_targets WHERE command_id = $1`
+	if err := q.db.Get(&target, query, commandID); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get rollout target: %w", err)
+	}
+	return &target, nil
+}
+
+// UpdateRolloutStatus changes a rollout's status and halt reason
+func (q *RolloutQueries) UpdateRolloutStatus(id uuid.UUID, status, haltReason string) error {
+	query := `
+		UPDATE rollouts SET
+			status = $1,
+			halt_reason = $2,
+			completed_at = CASE WHEN $3 THEN NOW() ELSE completed_at END,
+			updated_at = NOW()
+		WHERE id = $4
+	`
+	finished := status == models.RolloutStatusCompleted || status == models.RolloutStatusAborted
+	if _, err := q.db.Exec(query, status, haltReason, finished, id); err != nil {
+		return fmt.Errorf("failed to update rollout status: %w", err)
+	}
+	return nil
+}
+
+// SetCurrentRing moves a rollout to the given ring
+func (q *RolloutQueries) SetCurrentRing(id uuid.UUID, index int) error {
+	query := `UPDATE rollouts SET current_ring = $1, updated_at = NOW() WHERE id = $2`
+	if _, err := q.db.Exec(query, index, id); err != nil {
+		return fmt.Errorf("failed to update current ring: %w", err)
+	}
+	return nil
+}
+
+// StartRing marks a ring as installing
+func (q *RolloutQueries) StartRing(ringID uuid.UUID) error {
+	query := `UPDATE rollout_rings SET status = 'installing', started_at = NOW() WHERE id = $1`
+	if _, err := q.db.Exec(query, ringID); err != nil {
+		return fmt.Errorf("failed to start ring: %w", err)
+	}
+	return nil
+}
+
+// StartSoak marks a ring as soaking once all of its installs have finished
+func (q *RolloutQueries) StartSoak(ringID uuid.UUID) error {
+	query := `UPDATE rollout_rings SET status = 'soaking', soak_started_at = NOW() WHERE id = $1`
+	if _, err := q.db.Exec(query, ringID); err != nil {
+		return fmt.Errorf("failed to start ring soak: %w", err)
+	}
+	return nil
+}
+
+// FinishRing records a ring's final status and the criteria it was judged on
+func (q *RolloutQueries) FinishRing(ringID uuid.UUID, status string, summary models.JSONB) error {
+	query := `UPDATE rollout_rings SET status = $1, summary = $2, completed_at = NOW() WHERE id = $3`
+	if _, err := q.db.Exec(query, status, summary, ringID); err != nil {
+		return fmt.Errorf("failed to finish ring: %w", err)
+	}
+	return nil
+}
+
+// CancelPendingRings cancels rings that never started
+func (q *RolloutQueries) CancelPendingRings(rolloutID uuid.UUID) error {
+	query := `UPDATE rollout_rings SET status = 'cancelled', completed_at = NOW() WHERE rollout_id = $1 AND status = 'pending'`
+	if _, err := q.db.Exec(query, rolloutID); err != nil {
+		return fmt.Errorf("failed to cancel rollout rings: %w", err)
+	}
+	return nil
+}
+
+// CancelUndeliveredTargets cancels dispatched targets whose install command was
+// cancelled before the agent fetched it. Targets whose command was delivered
+// are left to finish through their result.
+func (q *RolloutQueries) CancelUndeliveredTargets(rolloutID uuid.UUID) error {
+	query := `
+		UPDATE rollout_targets t
+		SET status = 'cancelled', completed_at = NOW()
+		FROM agent_commands c
+		WHERE t.rollout_id = $1 AND t.status = 'dispatched'
+		  AND c.id = t.command_id AND c.status = 'cancelled'
+	`
+	if _, err := q.db.Exec(query, rolloutID); err != nil {
+		return fmt.Errorf("failed to cancel undelivered rollout targets: %w", err)
+	}
+	return nil
+}
+
+// MarkTargetDispatched links a target to the install command created for it
+func (q *RolloutQueries) MarkTargetDispatched(targetID, commandID uuid.UUID) error {
+	query := `
+		UPDATE rollout_targets
+		SET status = 'dispatched', command_id = $1, dispatched_at = NOW()
+		WHERE id = $2
+	`
+	if _, err := q.db.Exec(query, commandID, targetID); err != nil {
+		return fmt.Errorf("failed to mark target dispatched: %w", err)
+	}
+	return nil
+}
+
+// FinishTarget records the outcome of a target's install. Only pending or
+// dispatched targets change, so a late result can't overwrite an outcome.
+func (q *RolloutQueries) FinishTarget(targetID uuid.UUID, status, errMsg string) (bool, error) {
+	query := `
+		UPDATE rollout_targets
+		SET status = $1, error = $2, completed_at = NOW()
+		WHERE id = $3 AND status IN ('pending', 'dispatched')
+	`
+	result, err := q.db.Exec(query, status, errMsg, targetID)
+	if err != nil {
+		return false, fmt.Errorf("failed to finish rollout target: %w", err)
+	}
+	rows, _ := result.RowsAffected()
+	return rows > 0, nil
+}
+
+// CountNewFailures counts problems on a ring's agents since the ring started
+// that the rollout's own installs don't account for: failed or timed out
+// commands, and alerts that fired
+func (q *RolloutQueries) CountNewFailures(ringID uuid.UUID, since time.Time) (int, error) {
+	var count int
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM agent_commands c
+			 WHERE c.agent_id IN (SELECT agent_id FROM rollout_targets WHERE ring_id = $1)
+			   AND c.status IN ('failed', 'timed_out')
+			   AND COALESCE(c.completed_at, c.sent_at, c.created_at) >= $2
+			   AND c.id NOT IN (SELECT command_id FROM rollout_targets WHERE ring_id = $1 AND command_id IS NOT NULL))
+			+
+			(SELECT COUNT(*) FROM alerts a
+			 WHERE a.agent_id IN (SELECT agent_id FROM rollout_targets WHERE ring_id = $1)
+			   AND a.fired_at >= $2)
+	`
+	if err := q.db.Get(&count, query, ringID, since); err != nil {
+		return 0, fmt.Errorf("failed to count new failures: %w", err)
+	}
+	return count, nil
+}
+
+// CountAgentsNotCheckedIn counts ring agents that haven't checked in since their
+// last successful install finished
+func (q *RolloutQueries) CountAgentsNotCheckedIn(ringID uuid.UUID) (int, error) {
+	var count int
+	query := `
+		SELECT COUNT(*) FROM (
+			SELECT agent_id, MAX(completed_at) AS finished_at
+			FROM rollout_targets
+			WHERE ring_id = $1 AND status = 'succeeded'
+			GROUP BY agent_id
+		) done
+		JOIN agents a ON a.id = done.agent_id
+		WHERE a.last_seen <= done.finished_at
+	`
+	if err := q.db.Get(&count, query, ringID); err != nil {
+		return 0, fmt.Errorf("failed to count agents not checked in: %w", err)
+	}
+	return count, nil
+}
+
+// CancelPendingTargets cancels targets that were never dispatched and returns
+// the install commands of dispatched targets so the ones agents haven't
+// fetched yet can be cancelled too
+func (q *RolloutQueries) CancelPendingTargets(rolloutID uuid.UUID) ([]uuid.UUID, error) {
+	query := `
+		UPDATE rollout_targets
+		SET status = 'cancelled', completed_at = NOW()
+		WHERE rollout_id = $1 AND status = 'pending'
+	`
+	if _, err := q.db.Exec(query, rolloutID); err != nil {
+		return nil, fmt.Errorf("failed to cancel rollout targets: %w", err)
+	}
+
+	var commandIDs []uuid.UUID
+	query = `SELECT command_id FROM rollout_targets WHERE rollout_id = $1 AND status = 'dispatched' AND command_id IS NOT NULL`
+	if err := q.db.Select(&commandIDs, query, rolloutID); err != nil {
+		return nil, fmt.Errorf("failed to get dispatched rollout commands: %w", err)
+	}
+	return commandIDs, nil
+}
@@ -0,0 +1,18 @@
+package queries
+
+import (
+	"context"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startQuerySpan starts a client span for a database operation under ctx
+func startQuerySpan(ctx context.Context, name, table string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append(attrs,
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.collection.name", table),
+	)
+	return tracing.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
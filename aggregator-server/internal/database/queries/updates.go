@@ -1,8 +1,10 @@
 package queries
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
+	"log/slog"
 	"strings"
 	"time"
 
@@ -149,6 +151,50 @@ func (q *UpdateQueries) ApproveUpdateByPackage(agentID uuid.UUID, packageType, p
 	return err
 }
 
+// ListComposeStacks groups outstanding Docker updates by agent and compose
+// project, optionally for a single agent
+func (q *UpdateQueries) ListComposeStacks(agentID uuid.UUID) ([]models.DockerComposeStack, error) {
+	query := `
+		SELECT
+			s.agent_id,
+			COALESCE(a.hostname, '') AS agent_hostname,
+			s.metadata->>'compose_project' AS project,
+			COALESCE(MAX(s.metadata->>'compose_working_dir'), '') AS working_dir,
+			ARRAY_REMOVE(ARRAY_AGG(DISTINCT s.metadata->>'compose_service'), NULL) AS services,
+			COUNT(*) AS updates,
+			COUNT(*) FILTER (WHERE s.status = 'pending') AS pending_approval
+		FROM current_package_state s
+		LEFT JOIN agents a ON a.id = s.agent_id
+		WHERE s.package_type = 'docker_image'
+		  AND s.metadata->>'compose_project' IS NOT NULL
+		  AND s.status NOT IN ('updated', 'ignored')
+		  AND ($1 = '00000000-0000-0000-0000-000000000000'::uuid OR s.agent_id = $1)
+		GROUP BY s.agent_id, a.hostname, s.metadata->>'compose_project'
+		ORDER BY agent_hostname, project
+	`
+	var stacks []models.DockerComposeStack
+	if err := q.db.Select(&stacks, query, agentID); err != nil {
+		return nil, fmt.Errorf("failed to list compose stacks: %w", err)
+	}
+	return stacks, nil
+}
+
+// ApproveComposeStack approves every pending Docker update in an agent's
+// compose project and returns how many were approved
+func (q *UpdateQueries) ApproveComposeStack(agentID uuid.UUID, project, approvedBy string) (int64, error) {
+	query := `
+		UPDATE current_package_state
+		SET status = 'approved', last_updated_at = NOW()
+		WHERE agent_id = $1 AND package_type = 'docker_image'
+		  AND metadata->>'compose_project' = $2 AND status = 'pending'
+	`
+	result, err := q.db.Exec(query, agentID, project)
+	if err != nil {
+		return 0, fmt.Errorf("failed to approve compose stack: %w", err)
+	}
+	return result.RowsAffected()
+}
+
 // BulkApproveUpdates approves multiple updates by their IDs
 func (q *UpdateQueries) BulkApproveUpdates(updateIDs []uuid.UUID, approvedBy string) error {
 	if len(updateIDs) == 0 {
@@ -222,6 +268,18 @@ func (q *UpdateQueries) SetCheckingDependencies(id uuid.UUID) error {
 	return err
 }
 
+// ResumeDependencyCheck returns an update whose dry run timed out to
+// checking_dependencies, so a dependency report that arrives late still applies
+func (q *UpdateQueries) ResumeDependencyCheck(id uuid.UUID) error {
+	query := `
+		UPDATE current_package_state
+		SET status = 'checking_dependencies', last_updated_at = NOW()
+		WHERE id = $1 AND status = 'failed'
+	`
+	_, err := q.db.Exec(query, id)
+	return err
+}
+
 // SetPendingDependencies stores dependency information and sets status based on whether dependencies exist
 // If dependencies array is empty, this function only updates metadata without changing status
 // (the handler should auto-approve and proceed to installation in this case)
@@ -371,7 +429,7 @@ func (q *UpdateQueries) CreateUpdateEventsBatch(events []models.UpdateEvent) err
 			// Update current state
 			if err := q.updateCurrentStateInTx(tx, &event); err != nil {
 				// Log error but don't fail the entire batch
-				fmt.Printf("Warning: failed to update current state for %s: %v\n", event.PackageName, err)
+				slog.Warn("failed to update current state", "package", event.PackageName, "error", err)
 			}
 		}
 	}
@@ -463,6 +521,13 @@ func (q *UpdateQueries) ListUpdatesFromState(filters *models.UpdateFilters) ([]m
 		argIdx++
 	}
 
+	if filters.ComposeProject != "" {
+		baseQuery += fmt.Sprintf(" AND metadata->>'compose_project' = $%d", argIdx)
+		countQuery += fmt.Sprintf(" AND metadata->>'compose_project' = $%d", argIdx)
+		args = append(args, filters.ComposeProject)
+		argIdx++
+	}
+
 	if filters.Severity != "" {
 		baseQuery += fmt.Sprintf(" AND severity = $%d", argIdx)
 		countQuery += fmt.Sprintf(" AND severity = $%d", argIdx)
@@ -526,6 +591,88 @@ func (q *UpdateQueries) GetPackageHistory(agentID uuid.UUID, packageType, packag
 	return history, nil
 }
 
+// GetLastHistoryEntry returns the most recent version history entry for a package, or nil if there is none
+func (q *UpdateQueries) GetLastHistoryEntry(agentID uuid.UUID, packageType, packageName string) (*models.UpdateHistory, error) {
+	var entry models.UpdateHistory
+
+	query := `
+		SELECT
+			id, agent_id, package_type, package_name, version_from, version_to,
+			severity, COALESCE(repository_source, '') AS repository_source, metadata,
+			update_initiated_at, update_completed_at, update_status,
+			COALESCE(failure_reason, '') AS failure_reason
+		FROM update_version_history
+		WHERE agent_id = $1 AND package_type = $2 AND package_name = $3
+		ORDER BY update_completed_at DESC
+		LIMIT 1
+	`
+
+	err := q.db.Get(&entry, query, agentID, packageType, packageName)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get package history: %w", err)
+	}
+
+	return &entry, nil
+}
+
+// RecordRollback records the outcome of a rollback_update command as its own history entry.
+// On success the package's current version becomes toVersion and the update it replaced is
+// offered again as pending.
+func (q *UpdateQueries) RecordRollback(agentID uuid.UUID, packageType, packageName, fromVersion, toVersion string, success bool, failureReason string, completedAt time.Time) error {
+	tx, err := q.db.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var currentState models.UpdateState
+	query := `SELECT * FROM current_package_state WHERE agent_id = $1 AND package_type = $2 AND package_name = $3`
+	if err := tx.Get(&currentState, query, agentID, packageType, packageName); err != nil {
+		return fmt.Errorf("failed to get current state: %w", err)
+	}
+
+	historyStatus := "rollback"
+	if !success {
+		historyStatus = "failed"
+	}
+
+	historyQuery := `
+		INSERT INTO update_version_history (
+			agent_id, package_type, package_name, version_from, version_to,
+			severity, repository_source, metadata, update_completed_at, update_status, failure_reason
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+	`
+	_, err = tx.Exec(historyQuery,
+		agentID, packageType, packageName, fromVersion, toVersion,
+		currentState.Severity, currentState.RepositorySource,
+		models.JSONB{"action": "rollback"}, completedAt, historyStatus, failureReason)
+	if err != nil {
+		return fmt.Errorf("failed to record version history: %w", err)
+	}
+
+	if success {
+		_, err = tx.Exec(`
+			UPDATE current_package_state
+			SET current_version = $1, status = 'pending', last_updated_at = $2
+			WHERE agent_id = $3 AND package_type = $4 AND package_name = $5
+		`, toVersion, completedAt, agentID, packageType, packageName)
+	} else {
+		_, err = tx.Exec(`
+			UPDATE current_package_state
+			SET status = 'failed', last_updated_at = $1
+			WHERE agent_id = $2 AND package_type = $3 AND package_name = $4
+		`, completedAt, agentID, packageType, packageName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update package state: %w", err)
+	}
+
+	return tx.Commit()
+}
+
 // UpdatePackageStatus updates the status of a package and records history
 // completedAt is optional - if nil, uses time.Now(). Pass actual completion time for accurate audit trails.
 func (q *UpdateQueries) UpdatePackageStatus(agentID uuid.UUID, packageType, packageName, status string, metadata models.JSONB, completedAt *time.Time) error {
@@ -561,7 +708,7 @@ func (q *UpdateQueries) UpdatePackageStatus(agentID uuid.UUID, packageType, pack
 	}
 
 	// Record in history if this is an update completion
-	if status == "updated" || status == "failed" {
+	if status == "updated" || status == "failed" || status == "degraded" {
 		historyQuery := `
 			INSERT INTO update_version_history (
 				agent_id, package_type, package_name, version_from, version_to,
@@ -589,7 +736,7 @@ func (q *UpdateQueries) CleanupOldEvents(olderThan time.Duration) error {
 	}
 
 	rowsAffected, _ := result.RowsAffected()
-	fmt.Printf("Cleaned up %d old update events\n", rowsAffected)
+	slog.Info("cleaned up old update events", "count", rowsAffected)
 	return nil
 }
 
@@ -0,0 +1,208 @@
+package events
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event types published on the bus
+const (
+	TypeCommandCreated   = "command.created"
+	TypeCommandSent      = "command.sent"
+	TypeCommandCompleted = "command.completed"
+	TypeCommandFailed    = "command.failed"
+	TypeCommandStatus    = "command.status" // Any other status change (cancelled, timed_out, ...)
+	TypeCommandOutput    = "command.output" // Live output chunk from a running command
+	TypeLogReported      = "log.reported"
+	TypeAgentOnline      = "agent.online"
+	TypeAgentOffline     = "agent.offline"
+	TypeRolloutStatus    = "rollout.status" // Rollout or ring status change
+)
+
+// Event is a single operational event delivered to stream subscribers
+type Event struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	AgentID   *uuid.UUID             `json:"agent_id,omitempty"`
+	CommandID *uuid.UUID             `json:"command_id,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+
+	seq uint64
+}
+
+// Filter selects which events a subscriber receives. Zero values match everything.
+type Filter struct {
+	AgentID   *uuid.UUID
+	CommandID *uuid.UUID
+	Types     map[string]bool
+}
+
+// Matches reports whether an event passes the filter
+func (f Filter) Matches(e *Event) bool {
+	if f.AgentID != nil && (e.AgentID == nil || *e.AgentID != *f.AgentID) {
+		return false
+	}
+	if f.CommandID != nil && (e.CommandID == nil || *e.CommandID != *f.CommandID) {
+		return false
+	}
+	if len(f.Types) > 0 && !f.Types[e.Type] {
+		return false
+	}
+	return true
+}
+
+// Subscription receives matching events until it is closed
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	filter Filter
+	bus    *Bus
+	closed bool
+}
+
+// Close unsubscribes from the bus
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Bus is an in-memory publish/subscribe hub that keeps a bounded history so
+// clients can resume from a Last-Event-ID after reconnecting.
+//
+// Event IDs are "<epoch>-<seq>"; the epoch changes on every server start so a
+// client resuming across a restart is told to resync instead of silently
+// missing events.
+type Bus struct {
+	mu          sync.RWMutex
+	epoch       int64
+	seq         uint64
+	history     []Event
+	historySize int
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBus creates a bus retaining the last historySize events for replay
+func NewBus(historySize int) *Bus {
+	if historySize < 1 {
+		historySize = 1000
+	}
+	return &Bus{
+		epoch:       time.Now().Unix(),
+		historySize: historySize,
+		history:     make([]Event, 0, historySize),
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish stamps and fans out an event. Never blocks: subscribers that can't
+// keep up are disconnected and are expected to reconnect with Last-Event-ID.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e.seq = b.seq
+	e.ID = fmt.Sprintf("%d-%d", b.epoch, b.seq)
+	if e.Timestamp.IsZero() {
+		e.Timestamp = time.Now().UTC()
+	}
+
+	if len(b.history) >= b.historySize {
+		copy(b.history, b.history[1:])
+		b.history = b.history[:len(b.history)-1]
+	}
+	b.history = append(b.history, e)
+
+	for sub := range b.subscribers {
+		if !sub.filter.Matches(&e) {
+			continue
+		}
+		select {
+		case sub.ch <- e:
+		default:
+			b.closeLocked(sub)
+		}
+	}
+}
+
+// Subscribe registers a subscriber. If lastEventID is set, matching events
+// published after it are returned for replay; resumed is false when the ID is
+// unknown or has aged out of history, in which case the client should resync.
+func (b *Bus) Subscribe(filter Filter, lastEventID string) (sub *Subscription, replay []Event, resumed bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan Event, 256)
+	sub = &Subscription{C: ch, ch: ch, filter: filter, bus: b}
+	b.subscribers[sub] = struct{}{}
+
+	if lastEventID == "" {
+		return sub, nil, true
+	}
+
+	epoch, seq, ok := parseEventID(lastEventID)
+	if !ok || epoch != b.epoch {
+		return sub, nil, false
+	}
+
+	// History is contiguous; if the oldest retained event is past seq+1 we've lost some
+	if len(b.history) > 0 && b.history[0].seq > seq+1 {
+		resumed = false
+	} else {
+		resumed = true
+	}
+
+	for _, e := range b.history {
+		if e.seq > seq && filter.Matches(&e) {
+			replay = append(replay, e)
+		}
+	}
+	return sub, replay, resumed
+}
+
+// SubscriberCount returns the number of connected subscribers
+func (b *Bus) SubscriberCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers)
+}
+
+func (b *Bus) unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closeLocked(sub)
+}
+
+func (b *Bus) closeLocked(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	delete(b.subscribers, sub)
+	close(sub.ch)
+}
+
+func parseEventID(id string) (int64, uint64, bool) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	epoch, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return epoch, seq, true
+}
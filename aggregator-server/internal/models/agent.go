@@ -25,6 +25,8 @@ type Agent struct {
 	RebootRequired bool       `json:"reboot_required" db:"reboot_required"`
 	LastRebootAt   *time.Time `json:"last_reboot_at,omitempty" db:"last_reboot_at"`
 	RebootReason   *string    `json:"reboot_reason,omitempty" db:"reboot_reason"`
+	ProtocolVersion int                `json:"protocol_version" db:"protocol_version"` // 0 = predates capability reporting
+	Capabilities    *AgentCapabilities `json:"capabilities,omitempty" db:"capabilities"`
 	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
 }
@@ -46,25 +48,38 @@ type AgentWithLastScan struct {
 	RebootRequired bool       `json:"reboot_required" db:"reboot_required"`
 	LastRebootAt   *time.Time `json:"last_reboot_at,omitempty" db:"last_reboot_at"`
 	RebootReason   *string    `json:"reboot_reason,omitempty" db:"reboot_reason"`
+	ProtocolVersion int                `json:"protocol_version" db:"protocol_version"` // 0 = predates capability reporting
+	Capabilities    *AgentCapabilities `json:"capabilities,omitempty" db:"capabilities"`
 	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
 	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
 	LastScan       *time.Time `json:"last_scan" db:"last_scan"`
 }
 
-// AgentSpecs represents system specifications for an agent
+// AgentSpecs is a snapshot of an agent's hardware and software inventory.
+// A new snapshot is only stored when the inventory changes.
 type AgentSpecs struct {
-	ID                uuid.UUID       `json:"id" db:"id"`
-	AgentID           uuid.UUID       `json:"agent_id" db:"agent_id"`
-	CPUModel          string          `json:"cpu_model" db:"cpu_model"`
-	CPUCores          int             `json:"cpu_cores" db:"cpu_cores"`
-	MemoryTotalMB     int             `json:"memory_total_mb" db:"memory_total_mb"`
-	DiskTotalGB       int             `json:"disk_total_gb" db:"disk_total_gb"`
-	DiskFreeGB        int             `json:"disk_free_gb" db:"disk_free_gb"`
-	NetworkInterfaces JSONB           `json:"network_interfaces" db:"network_interfaces"`
-	DockerInstalled   bool            `json:"docker_installed" db:"docker_installed"`
-	DockerVersion     string          `json:"docker_version" db:"docker_version"`
-	PackageManagers   StringArray     `json:"package_managers" db:"package_managers"`
-	CollectedAt       time.Time       `json:"collected_at" db:"collected_at"`
+	ID                uuid.UUID             `json:"id" db:"id"`
+	AgentID           uuid.UUID             `json:"agent_id" db:"agent_id"`
+	OSType            string                `json:"os_type" db:"os_type"`
+	OSVersion         string                `json:"os_version" db:"os_version"`
+	OSArchitecture    string                `json:"os_architecture" db:"os_architecture"`
+	KernelVersion     string                `json:"kernel_version" db:"kernel_version"`
+	CPUModel          string                `json:"cpu_model" db:"cpu_model"`
+	CPUCores          int                   `json:"cpu_cores" db:"cpu_cores"`
+	CPUThreads        int                   `json:"cpu_threads" db:"cpu_threads"`
+	MemoryTotalMB     int                   `json:"memory_total_mb" db:"memory_total_mb"`
+	DiskTotalGB       int                   `json:"disk_total_gb" db:"disk_total_gb"`
+	DiskFreeGB        int                   `json:"disk_free_gb" db:"disk_free_gb"`
+	Disks             SpecDisks             `json:"disks" db:"disks"`
+	NetworkInterfaces SpecNetworkInterfaces `json:"network_interfaces" db:"network_interfaces"`
+	DockerInstalled   bool                  `json:"docker_installed" db:"docker_installed"`
+	DockerVersion     string                `json:"docker_version" db:"docker_version"`
+	PackageManagers   StringArray           `json:"package_managers" db:"package_managers"`
+	DMI               SpecDMI               `json:"dmi" db:"dmi"`
+	Virtualization    string                `json:"virtualization" db:"virtualization"`
+	Fingerprint       string                `json:"fingerprint" db:"fingerprint"`
+	CollectedAt       time.Time             `json:"collected_at" db:"collected_at"`  // When this snapshot was first reported
+	LastSeenAt        time.Time             `json:"last_seen_at" db:"last_seen_at"` // Last time the agent reported it unchanged
 }
 
 // AgentRegistrationRequest is the payload for agent registration
@@ -76,6 +91,9 @@ type AgentRegistrationRequest struct {
 	AgentVersion     string            `json:"agent_version" binding:"required"`
 	RegistrationToken string           `json:"registration_token"` // Optional, for fallback method
 	Metadata         map[string]string `json:"metadata"`
+	CSR              string            `json:"csr,omitempty"` // PEM certificate request, for mTLS
+	ProtocolVersion  int                `json:"protocol_version,omitempty"`
+	Capabilities     *AgentCapabilities `json:"capabilities,omitempty"`
 }
 
 // AgentRegistrationResponse is returned after successful registration
@@ -84,17 +102,31 @@ type AgentRegistrationResponse struct {
 	Token        string                 `json:"token"`          // Short-lived access token (24h)
 	RefreshToken string                 `json:"refresh_token"`  // Long-lived refresh token (90d)
 	Config       map[string]interface{} `json:"config"`
+	Certificate  *AgentCertificate      `json:"certificate,omitempty"` // Issued when the agent sent a CSR
 }
 
 // TokenRenewalRequest is the payload for token renewal using refresh token
 type TokenRenewalRequest struct {
 	AgentID      uuid.UUID `json:"agent_id" binding:"required"`
 	RefreshToken string    `json:"refresh_token" binding:"required"`
+	CSR          string    `json:"csr,omitempty"` // Replaces an expired client certificate
 }
 
 // TokenRenewalResponse is returned after successful token renewal
 type TokenRenewalResponse struct {
-	Token string `json:"token"`  // New short-lived access token (24h)
+	Token       string            `json:"token"`  // New short-lived access token (24h)
+	Certificate *AgentCertificate `json:"certificate,omitempty"`
+}
+
+// CertificateRenewalRequest is the payload for renewing an agent's client certificate
+type CertificateRenewalRequest struct {
+	CSR string `json:"csr" binding:"required"`
+}
+
+// AgentCertificate is a client certificate issued to an agent by the server's CA
+type AgentCertificate struct {
+	Certificate string    `json:"certificate"` // PEM
+	NotAfter    time.Time `json:"not_after"`
 }
 
 // UTCTime is a time.Time that marshals to ISO format with UTC timezone
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+)
+
+// AgentProtocolVersion is the newest agent-server protocol the server speaks.
+// Version 1 added capability reporting.
+const AgentProtocolVersion = 1
+
+// AgentCapabilities is what an agent reports it can run, at registration and
+// with each full check-in
+type AgentCapabilities struct {
+	Commands   []string `json:"commands"`   // Command types the agent handles
+	Scanners   []string `json:"scanners"`   // Scanners available on the host
+	Installers []string `json:"installers"` // Package types it can install
+}
+
+// SupportsCommand reports whether the agent handles a command type
+func (c *AgentCapabilities) SupportsCommand(commandType string) bool {
+	return containsString(c.Commands, commandType)
+}
+
+// SupportsPackageType reports whether the agent can install a package type
+func (c *AgentCapabilities) SupportsPackageType(packageType string) bool {
+	return containsString(c.Installers, packageType)
+}
+
+// Value implements driver.Valuer
+func (c AgentCapabilities) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+// Scan implements sql.Scanner
+func (c *AgentCapabilities) Scan(value interface{}) error {
+	return scanJSON(value, c)
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// SpecDisk is a mounted filesystem in an agent's specs
+type SpecDisk struct {
+	Mountpoint string `json:"mountpoint"`
+	Device     string `json:"device"`
+	DiskType   string `json:"disk_type"`
+	TotalGB    int    `json:"total_gb"`
+	FreeGB     int    `json:"free_gb"`
+}
+
+// SpecNetworkInterface is a network interface in an agent's specs
+type SpecNetworkInterface struct {
+	Name string `json:"name"`
+	MAC  string `json:"mac"`
+	MTU  int    `json:"mtu"`
+}
+
+// SpecDMI holds the SMBIOS system, board and BIOS identification
+type SpecDMI struct {
+	SystemVendor  string `json:"system_vendor,omitempty"`
+	SystemProduct string `json:"system_product,omitempty"`
+	SystemSerial  string `json:"system_serial,omitempty"`
+	BoardVendor   string `json:"board_vendor,omitempty"`
+	BoardProduct  string `json:"board_product,omitempty"`
+	BoardSerial   string `json:"board_serial,omitempty"`
+	BIOSVendor    string `json:"bios_vendor,omitempty"`
+	BIOSVersion   string `json:"bios_version,omitempty"`
+	BIOSDate      string `json:"bios_date,omitempty"`
+}
+
+// SpecDisks type for the agent_specs disks JSONB column
+type SpecDisks []SpecDisk
+
+// Value implements driver.Valuer
+func (d SpecDisks) Value() (driver.Value, error) {
+	if d == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(d)
+}
+
+// Scan implements sql.Scanner
+func (d *SpecDisks) Scan(value interface{}) error {
+	return scanJSON(value, d)
+}
+
+// SpecNetworkInterfaces type for the agent_specs network_interfaces JSONB column
+type SpecNetworkInterfaces []SpecNetworkInterface
+
+// Value implements driver.Valuer
+func (n SpecNetworkInterfaces) Value() (driver.Value, error) {
+	if n == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(n)
+}
+
+// Scan implements sql.Scanner
+func (n *SpecNetworkInterfaces) Scan(value interface{}) error {
+	return scanJSON(value, n)
+}
+
+// Value implements driver.Valuer
+func (d SpecDMI) Value() (driver.Value, error) {
+	return json.Marshal(d)
+}
+
+// Scan implements sql.Scanner
+func (d *SpecDMI) Scan(value interface{}) error {
+	return scanJSON(value, d)
+}
+
+func scanJSON(value interface{}, dest interface{}) error {
+	if value == nil {
+		return nil
+	}
+	bytes, ok := value.([]byte)
+	if !ok {
+		return fmt.Errorf("unexpected type %T for JSON column", value)
+	}
+	return json.Unmarshal(bytes, dest)
+}
+
+// AgentSpecsReport is the inventory an agent sends for collect_specs
+type AgentSpecsReport struct {
+	OSType            string                 `json:"os_type"`
+	OSVersion         string                 `json:"os_version"`
+	OSArchitecture    string                 `json:"os_architecture"`
+	KernelVersion     string                 `json:"kernel_version"`
+	CPUModel          string                 `json:"cpu_model"`
+	CPUCores          int                    `json:"cpu_cores"`
+	CPUThreads        int                    `json:"cpu_threads"`
+	MemoryTotalMB     int                    `json:"memory_total_mb"`
+	Disks             []SpecDisk             `json:"disks"`
+	NetworkInterfaces []SpecNetworkInterface `json:"network_interfaces"`
+	DockerVersion     string                 `json:"docker_version"`
+	PackageManagers   []string               `json:"package_managers"`
+	DMI               SpecDMI                `json:"dmi"`
+	Virtualization    string                 `json:"virtualization"`
+}
+
+// SpecChange is a single field that differs between two spec snapshots. From
+// is nil for something added and To is nil for something removed.
+type SpecChange struct {
+	Field string      `json:"field"`
+	From  interface{} `json:"from"`
+	To    interface{} `json:"to"`
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AlertRule defines a condition evaluated against agent metrics
+type AlertRule struct {
+	ID              uuid.UUID  `json:"id" db:"id"`
+	Name            string     `json:"name" db:"name"`
+	RuleType        string     `json:"rule_type" db:"rule_type"`
+	Threshold       float64    `json:"threshold" db:"threshold"`               // Percent for usage rules
+	DurationSeconds int        `json:"duration_seconds" db:"duration_seconds"` // Time condition must hold before firing
+	MissedIntervals int        `json:"missed_intervals" db:"missed_intervals"` // For missed_checkin rules
+	Severity        string     `json:"severity" db:"severity"`
+	AgentID         *uuid.UUID `json:"agent_id,omitempty" db:"agent_id"` // nil = all agents
+	Enabled         bool       `json:"enabled" db:"enabled"`
+	CreatedAt       time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// AppliesTo reports whether the rule is scoped to the given agent
+func (r *AlertRule) AppliesTo(agentID uuid.UUID) bool {
+	return r.AgentID == nil || *r.AgentID == agentID
+}
+
+// Alert is a single instance of a rule firing for an agent
+type Alert struct {
+	ID             uuid.UUID  `json:"id" db:"id"`
+	RuleID         uuid.UUID  `json:"rule_id" db:"rule_id"`
+	AgentID        uuid.UUID  `json:"agent_id" db:"agent_id"`
+	Status         string     `json:"status" db:"status"`
+	Severity       string     `json:"severity" db:"severity"`
+	Message        string     `json:"message" db:"message"`
+	Value          float64    `json:"value" db:"value"`
+	PendingSince   time.Time  `json:"pending_since" db:"pending_since"`
+	FiredAt        *time.Time `json:"fired_at,omitempty" db:"fired_at"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty" db:"resolved_at"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty" db:"acknowledged_at"`
+	AcknowledgedBy *uuid.UUID `json:"acknowledged_by,omitempty" db:"acknowledged_by"`
+	LastNotifiedAt *time.Time `json:"last_notified_at,omitempty" db:"last_notified_at"`
+	Metadata       JSONB      `json:"metadata" db:"metadata"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// AlertWithContext extends Alert with rule and agent information for display
+type AlertWithContext struct {
+	Alert
+	RuleName string `json:"rule_name" db:"rule_name"`
+	RuleType string `json:"rule_type" db:"rule_type"`
+	Hostname string `json:"hostname" db:"hostname"`
+	Silenced bool   `json:"silenced" db:"silenced"`
+}
+
+// AlertSilence suppresses notifications for matching alerts during a time window
+type AlertSilence struct {
+	ID        uuid.UUID  `json:"id" db:"id"`
+	RuleID    *uuid.UUID `json:"rule_id,omitempty" db:"rule_id"`   // nil = any rule
+	AgentID   *uuid.UUID `json:"agent_id,omitempty" db:"agent_id"` // nil = any agent
+	Reason    string     `json:"reason" db:"reason"`
+	CreatedBy *uuid.UUID `json:"created_by,omitempty" db:"created_by"`
+	StartsAt  time.Time  `json:"starts_at" db:"starts_at"`
+	EndsAt    time.Time  `json:"ends_at" db:"ends_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
+
+// AlertRuleRequest is the payload for creating or updating an alert rule
+type AlertRuleRequest struct {
+	Name            string     `json:"name" binding:"required"`
+	RuleType        string     `json:"rule_type" binding:"required"`
+	Threshold       float64    `json:"threshold"`
+	DurationSeconds int        `json:"duration_seconds"`
+	MissedIntervals int        `json:"missed_intervals"`
+	Severity        string     `json:"severity"`
+	AgentID         *uuid.UUID `json:"agent_id"`
+	Enabled         *bool      `json:"enabled"`
+}
+
+// AlertSilenceRequest is the payload for creating a silence
+type AlertSilenceRequest struct {
+	RuleID          *uuid.UUID `json:"rule_id"`
+	AgentID         *uuid.UUID `json:"agent_id"`
+	Reason          string     `json:"reason"`
+	DurationMinutes int        `json:"duration_minutes" binding:"required,min=1"`
+}
+
+// Alert rule types
+const (
+	AlertRuleDiskUsage        = "disk_usage"
+	AlertRuleMemoryUsage      = "memory_usage"
+	AlertRuleMissedCheckIn    = "missed_checkin"
+	AlertRuleUnexpectedReboot = "unexpected_reboot"
+)
+
+// Alert statuses
+const (
+	AlertStatusPending  = "pending"
+	AlertStatusFiring   = "firing"
+	AlertStatusResolved = "resolved"
+)
+
+// Alert severities
+const (
+	AlertSeverityInfo     = "info"
+	AlertSeverityWarning  = "warning"
+	AlertSeverityCritical = "critical"
+)
@@ -19,12 +19,15 @@ type AgentCommand struct {
 	CompletedAt    *time.Time `json:"completed_at,omitempty" db:"completed_at"`
 	Result         JSONB      `json:"result,omitempty" db:"result"`
 	RetriedFromID  *uuid.UUID `json:"retried_from_id,omitempty" db:"retried_from_id"`
+	RequestID      *string    `json:"request_id,omitempty" db:"request_id"` // API request that created the command
 }
 
 // CommandsResponse is returned when an agent checks in for commands
 type CommandsResponse struct {
 	Commands       []CommandItem `json:"commands"`
 	RapidPolling   *RapidPollingConfig `json:"rapid_polling,omitempty"`
+	Config         *AgentConfig        `json:"config,omitempty"` // Until the agent acknowledges the profile version
+	LongPoll       int                 `json:"long_poll,omitempty"` // Seconds the server was willing to hold the request; set only for long polls
 }
 
 // RapidPollingConfig contains rapid polling configuration for the agent
@@ -90,4 +93,23 @@ type ActiveCommandInfo struct {
 	IsRetry       bool       `json:"is_retry" db:"is_retry"`
 	HasBeenRetried bool      `json:"has_been_retried" db:"has_been_retried"`
 	RetryCount    int        `json:"retry_count" db:"retry_count"`
+	RequestID     *string    `json:"request_id,omitempty" db:"request_id"`
+}
+
+// CommandOutputChunk is a piece of live output streamed by an agent while a command runs
+type CommandOutputChunk struct {
+	ID        int64     `json:"-" db:"id"`
+	CommandID uuid.UUID `json:"command_id" db:"command_id"`
+	AgentID   uuid.UUID `json:"agent_id" db:"agent_id"`
+	Seq       int       `json:"seq" db:"seq"`
+	Data      string    `json:"data" db:"data"`
+	Final     bool      `json:"final" db:"final"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// CommandOutputRequest is sent by agents to append live output for a command
+type CommandOutputRequest struct {
+	Seq   int    `json:"seq" binding:"required,min=1"`
+	Data  string `json:"data"`
+	Final bool   `json:"final"`
 }
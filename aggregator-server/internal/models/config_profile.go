@@ -0,0 +1,83 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ConfigProfileScanners are the scanner names a config profile can enable
+var ConfigProfileScanners = []string{"apt", "dnf", "docker", "podman", "windows_update", "winget"}
+
+// ConfigProfile is a set of agent settings managed from the server. Zero
+// values leave the agent's own default in place.
+type ConfigProfile struct {
+	ID              uuid.UUID   `json:"id" db:"id"`
+	Name            string      `json:"name" db:"name"`
+	Description     string      `json:"description" db:"description"`
+	Version         int         `json:"version" db:"version"`
+	IsDefault       bool        `json:"is_default" db:"is_default"`
+	CheckInInterval int         `json:"check_in_interval" db:"check_in_interval"` // Seconds
+	LogLevel        string      `json:"log_level" db:"log_level"`
+	Scanners        StringArray `json:"scanners" db:"scanners"`
+	CreatedAt       time.Time   `json:"created_at" db:"created_at"`
+	UpdatedAt       time.Time   `json:"updated_at" db:"updated_at"`
+}
+
+// ConfigProfileSummary extends ConfigProfile with how many agents use it
+type ConfigProfileSummary struct {
+	ConfigProfile
+	AgentCount   int `json:"agent_count" db:"agent_count"`     // Agents assigned, or defaulting, to the profile
+	AppliedCount int `json:"applied_count" db:"applied_count"` // Agents that acknowledged the current version
+}
+
+// ConfigProfileRequest is the payload for creating or updating a config profile
+type ConfigProfileRequest struct {
+	Name            string   `json:"name" binding:"required"`
+	Description     string   `json:"description"`
+	IsDefault       bool     `json:"is_default"`
+	CheckInInterval int      `json:"check_in_interval"`
+	LogLevel        string   `json:"log_level"`
+	Scanners        []string `json:"scanners"`
+}
+
+// AgentConfigProfileRequest assigns a profile to an agent; a nil profile
+// returns the agent to the default profile
+type AgentConfigProfileRequest struct {
+	ProfileID *uuid.UUID `json:"profile_id"`
+}
+
+// AgentConfigState is an agent's profile assignment and what it has applied
+type AgentConfigState struct {
+	AgentID          uuid.UUID  `json:"agent_id" db:"agent_id"`
+	ProfileID        *uuid.UUID `json:"profile_id,omitempty" db:"profile_id"` // Assigned profile; nil = default
+	AppliedProfileID *uuid.UUID `json:"applied_profile_id,omitempty" db:"applied_profile_id"`
+	AppliedVersion   int        `json:"applied_version" db:"applied_version"`
+	AppliedAt        *time.Time `json:"applied_at,omitempty" db:"applied_at"`
+	ApplyError       string     `json:"apply_error" db:"apply_error"`
+}
+
+// AgentConfigStatus shows which profile version an agent should run and which it has applied
+type AgentConfigStatus struct {
+	AgentID           uuid.UUID  `json:"agent_id" db:"agent_id"`
+	Hostname          string     `json:"hostname" db:"hostname"`
+	AssignedProfileID *uuid.UUID `json:"assigned_profile_id,omitempty" db:"assigned_profile_id"`
+	ProfileID         *uuid.UUID `json:"profile_id,omitempty" db:"profile_id"` // Effective profile
+	ProfileName       *string    `json:"profile_name,omitempty" db:"profile_name"`
+	ProfileVersion    *int       `json:"profile_version,omitempty" db:"profile_version"`
+	AppliedProfileID  *uuid.UUID `json:"applied_profile_id,omitempty" db:"applied_profile_id"`
+	AppliedVersion    *int       `json:"applied_version,omitempty" db:"applied_version"`
+	AppliedAt         *time.Time `json:"applied_at,omitempty" db:"applied_at"`
+	ApplyError        *string    `json:"apply_error,omitempty" db:"apply_error"`
+	InSync            bool       `json:"in_sync" db:"in_sync"`
+}
+
+// AgentConfig is a config profile as delivered to an agent with its commands
+type AgentConfig struct {
+	ProfileID       string   `json:"profile_id"` // Empty tells the agent to drop a profile it applied earlier
+	ProfileName     string   `json:"profile_name,omitempty"`
+	Version         int      `json:"version"`
+	CheckInInterval int      `json:"check_in_interval,omitempty"`
+	LogLevel        string   `json:"log_level,omitempty"`
+	Scanners        []string `json:"scanners,omitempty"`
+}
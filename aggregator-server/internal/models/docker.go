@@ -2,6 +2,9 @@ package models
 
 import (
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 // DockerPort represents a port mapping in a Docker container
@@ -29,6 +32,19 @@ type DockerContainer struct {
 	UpdateAvailable  bool         `json:"update_available"`
 	CurrentVersion   string       `json:"current_version,omitempty"`
 	AvailableVersion string       `json:"available_version,omitempty"`
+	ComposeProject   string       `json:"compose_project,omitempty"`
+	ComposeService   string       `json:"compose_service,omitempty"`
+}
+
+// DockerComposeStack groups an agent's Docker updates by compose project
+type DockerComposeStack struct {
+	AgentID         uuid.UUID      `json:"agent_id" db:"agent_id"`
+	AgentHostname   string         `json:"agent_hostname" db:"agent_hostname"`
+	Project         string         `json:"project" db:"project"`
+	WorkingDir      string         `json:"working_dir" db:"working_dir"`
+	Services        pq.StringArray `json:"services" db:"services"`
+	Updates         int            `json:"updates" db:"updates"`
+	PendingApproval int            `json:"pending_approval" db:"pending_approval"`
 }
 
 // DockerContainerListResponse represents the response for container listing
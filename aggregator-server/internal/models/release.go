@@ -0,0 +1,26 @@
+package models
+
+import "time"
+
+// AgentRelease describes an agent binary available for self-update
+type AgentRelease struct {
+	Version     string `json:"version"`
+	Platform    string `json:"platform"`     // e.g. linux-amd64
+	DownloadURL string `json:"download_url"` // Path relative to the server URL
+	SHA256      string `json:"sha256"`       // Hex-encoded SHA-256 of the binary
+	Signature   string `json:"signature"`    // Base64 Ed25519 signature over the binary
+	// Base64 Ed25519 signature over the release statement (version, platform
+	// and sha256) - what agents check before self-updating
+	ReleaseSignature string `json:"release_signature"`
+	Size             int64  `json:"size"`
+	Verified         bool   `json:"verified"` // Both signatures check out against the server's public key
+}
+
+// ReleaseManifest lists the agent binaries the server publishes
+type ReleaseManifest struct {
+	Version            string         `json:"version"`
+	PublicKey          string         `json:"public_key,omitempty"` // Base64 Ed25519 key signatures verify against
+	SignatureAlgorithm string         `json:"signature_algorithm"`
+	Releases           []AgentRelease `json:"releases"`
+	GeneratedAt        time.Time      `json:"generated_at"`
+}
@@ -0,0 +1,116 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Rollout statuses
+const (
+	RolloutStatusRunning   = "running"
+	RolloutStatusPaused    = "paused"
+	RolloutStatusHalted    = "halted"
+	RolloutStatusCompleted = "completed"
+	RolloutStatusAborted   = "aborted"
+)
+
+// Rollout ring statuses
+const (
+	RingStatusPending    = "pending"
+	RingStatusInstalling = "installing"
+	RingStatusSoaking    = "soaking"
+	RingStatusSucceeded  = "succeeded"
+	RingStatusFailed     = "failed"
+	RingStatusCancelled  = "cancelled"
+)
+
+// Rollout target statuses
+const (
+	TargetStatusPending    = "pending"
+	TargetStatusDispatched = "dispatched"
+	TargetStatusSucceeded  = "succeeded"
+	TargetStatusFailed     = "failed"
+	TargetStatusCancelled  = "cancelled"
+)
+
+// Rollout installs a set of approved updates ring by ring
+type Rollout struct {
+	ID                uuid.UUID  `json:"id" db:"id"`
+	Name              string     `json:"name" db:"name"`
+	Status            string     `json:"status" db:"status"`
+	CurrentRing       int        `json:"current_ring" db:"current_ring"`
+	MinSuccessPercent float64    `json:"min_success_percent" db:"min_success_percent"`
+	SoakSeconds       int        `json:"soak_seconds" db:"soak_seconds"`
+	MaxNewFailures    int        `json:"max_new_failures" db:"max_new_failures"`
+	HaltReason        string     `json:"halt_reason" db:"halt_reason"`
+	CreatedBy         *uuid.UUID `json:"created_by,omitempty" db:"created_by"`
+	CreatedAt         time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at" db:"updated_at"`
+	CompletedAt       *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+// RolloutRing is an ordered group of agents within a rollout
+type RolloutRing struct {
+	ID            uuid.UUID  `json:"id" db:"id"`
+	RolloutID     uuid.UUID  `json:"rollout_id" db:"rollout_id"`
+	RingIndex     int        `json:"ring_index" db:"ring_index"`
+	Name          string     `json:"name" db:"name"`
+	Status        string     `json:"status" db:"status"`
+	StartedAt     *time.Time `json:"started_at,omitempty" db:"started_at"`
+	SoakStartedAt *time.Time `json:"soak_started_at,omitempty" db:"soak_started_at"`
+	CompletedAt   *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+	Summary       JSONB      `json:"summary" db:"summary"` // Success criteria as last evaluated
+}
+
+// RolloutTarget is one approved update on one agent
+type RolloutTarget struct {
+	ID           uuid.UUID  `json:"id" db:"id"`
+	RolloutID    uuid.UUID  `json:"rollout_id" db:"rollout_id"`
+	RingID       uuid.UUID  `json:"ring_id" db:"ring_id"`
+	AgentID      uuid.UUID  `json:"agent_id" db:"agent_id"`
+	UpdateID     *uuid.UUID `json:"update_id,omitempty" db:"update_id"`
+	PackageType  string     `json:"package_type" db:"package_type"`
+	PackageName  string     `json:"package_name" db:"package_name"`
+	CommandID    *uuid.UUID `json:"command_id,omitempty" db:"command_id"`
+	Status       string     `json:"status" db:"status"`
+	Error        string     `json:"error" db:"error"`
+	DispatchedAt *time.Time `json:"dispatched_at,omitempty" db:"dispatched_at"`
+	CompletedAt  *time.Time `json:"completed_at,omitempty" db:"completed_at"`
+}
+
+// RolloutTargetWithAgent adds the agent hostname for display
+type RolloutTargetWithAgent struct {
+	RolloutTarget
+	Hostname string `json:"hostname" db:"hostname"`
+}
+
+// RolloutRingDetail is a ring with its targets
+type RolloutRingDetail struct {
+	RolloutRing
+	Targets []RolloutTargetWithAgent `json:"targets"`
+}
+
+// RolloutDetail is a rollout with its rings and targets
+type RolloutDetail struct {
+	Rollout
+	Rings []RolloutRingDetail `json:"rings"`
+}
+
+// RolloutRingRequest defines one ring when creating a rollout. Agents are
+// either listed explicitly or allocated by percentage of the affected agents.
+type RolloutRingRequest struct {
+	Name     string      `json:"name" binding:"required"`
+	AgentIDs []uuid.UUID `json:"agent_ids"`
+	Percent  float64     `json:"percent"`
+}
+
+// RolloutRequest is the payload for creating a rollout
+type RolloutRequest struct {
+	Name              string               `json:"name" binding:"required"`
+	UpdateIDs         []uuid.UUID          `json:"update_ids" binding:"required"`
+	Rings             []RolloutRingRequest `json:"rings"`
+	MinSuccessPercent *float64             `json:"min_success_percent"`
+	SoakMinutes       *int                 `json:"soak_minutes"`
+	MaxNewFailures    *int                 `json:"max_new_failures"`
+}
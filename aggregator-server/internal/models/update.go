@@ -75,6 +75,9 @@ type UpdateLogRequest struct {
 	Stderr          string    `json:"stderr"`
 	ExitCode        int       `json:"exit_code"`
 	DurationSeconds int       `json:"duration_seconds"`
+
+	// Structured extras reported by the agent (e.g. "snapshot"), stored in the command result
+	Details map[string]interface{} `json:"details"`
 }
 
 // DependencyReportRequest is used by agents to report dependencies after dry run
@@ -109,6 +112,9 @@ type UpdateFilters struct {
 	PackageType string
 	Page        int
 	PageSize    int
+
+	// ComposeProject limits Docker updates to one compose project
+	ComposeProject string
 }
 
 // EVENT SOURCING MODELS
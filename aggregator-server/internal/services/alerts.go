@@ -0,0 +1,377 @@
+package services
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/google/uuid"
+)
+
+// AgentMetricsSample is the subset of check-in metrics that alert rules evaluate
+type AgentMetricsSample struct {
+	HasMetrics     bool // False when the check-in carried no usage metrics
+	DiskPercent    float64
+	MemoryPercent  float64
+	Uptime         time.Duration // Zero if the agent did not report a parseable uptime
+	PreviousUptime time.Duration // Uptime from the previous check-in, zero if unknown
+}
+
+// AlertService evaluates alert rules and hands state changes off to notifiers
+type AlertService struct {
+	alertQueries    *queries.AlertQueries
+	agentQueries    *queries.AgentQueries
+	commandQueries  *queries.CommandQueries
+	notifiers       []Notifier
+	checkInInterval time.Duration
+	ticker          *time.Ticker
+	stopChan        chan bool
+}
+
+// NewAlertService creates a new alert service
+func NewAlertService(alq *queries.AlertQueries, aq *queries.AgentQueries, cq *queries.CommandQueries, checkInInterval int, notifiers ...Notifier) *AlertService {
+	return &AlertService{
+		alertQueries:    alq,
+		agentQueries:    aq,
+		commandQueries:  cq,
+		notifiers:       notifiers,
+		checkInInterval: time.Duration(checkInInterval) * time.Second,
+		stopChan:        make(chan bool),
+	}
+}
+
+// Start begins periodic evaluation of rules that don't depend on check-ins
+func (s *AlertService) Start() {
+	slog.Info("starting alert service", "notifiers", len(s.notifiers))
+
+	s.ticker = time.NewTicker(1 * time.Minute)
+
+	go func() {
+		for {
+			select {
+			case <-s.ticker.C:
+				s.evaluateMissedCheckIns()
+				s.expireRebootAlerts()
+			case <-s.stopChan:
+				s.ticker.Stop()
+				slog.Info("alert service stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the alert service
+func (s *AlertService) Stop() {
+	close(s.stopChan)
+}
+
+// EvaluateCheckIn evaluates metric-based rules against an agent check-in
+func (s *AlertService) EvaluateCheckIn(agentID uuid.UUID, sample AgentMetricsSample) {
+	rules, err := s.alertQueries.GetEnabledAlertRules("")
+	if err != nil {
+		slog.Error("failed to load alert rules", "error", err)
+		return
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		if !rule.AppliesTo(agentID) {
+			continue
+		}
+
+		switch rule.RuleType {
+		case models.AlertRuleDiskUsage:
+			if sample.HasMetrics {
+				s.evaluateThreshold(rule, agentID, sample.DiskPercent, "Disk usage")
+			}
+		case models.AlertRuleMemoryUsage:
+			if sample.HasMetrics {
+				s.evaluateThreshold(rule, agentID, sample.MemoryPercent, "Memory usage")
+			}
+		case models.AlertRuleMissedCheckIn:
+			// The agent just checked in, so any missed check-in alert is over
+			s.resolveOpenAlert(rule, agentID)
+		case models.AlertRuleUnexpectedReboot:
+			s.evaluateReboot(rule, agentID, sample)
+		}
+	}
+}
+
+// evaluateThreshold drives the pending -> firing -> resolved lifecycle for usage rules
+func (s *AlertService) evaluateThreshold(rule *models.AlertRule, agentID uuid.UUID, value float64, label string) {
+	open, err := s.alertQueries.GetOpenAlert(rule.ID, agentID)
+	if err != nil {
+		slog.Error("failed to evaluate alert rule", "rule", rule.Name, "agent_id", agentID, "error", err)
+		return
+	}
+
+	breached := value >= rule.Threshold
+	message := fmt.Sprintf("%s at %.1f%% (threshold %.1f%%)", label, value, rule.Threshold)
+
+	if !breached {
+		if open == nil {
+			return
+		}
+		if open.Status == models.AlertStatusPending {
+			// Condition cleared before the duration elapsed - never fired, nothing to notify
+			if err := s.alertQueries.DeleteAlert(open.ID); err != nil {
+				slog.Error("failed to clear pending alert", "alert_id", open.ID, "error", err)
+			}
+			return
+		}
+		s.resolve(rule, open)
+		return
+	}
+
+	if open == nil {
+		open = &models.Alert{
+			ID:           uuid.New(),
+			RuleID:       rule.ID,
+			AgentID:      agentID,
+			Status:       models.AlertStatusPending,
+			Severity:     rule.Severity,
+			Message:      message,
+			Value:        value,
+			PendingSince: time.Now(),
+			Metadata:     models.JSONB{},
+		}
+		if err := s.alertQueries.CreateAlert(open); err != nil {
+			slog.Error("failed to create alert", "rule", rule.Name, "agent_id", agentID, "error", err)
+			return
+		}
+	}
+
+	switch open.Status {
+	case models.AlertStatusPending:
+		if time.Since(open.PendingSince) >= time.Duration(rule.DurationSeconds)*time.Second {
+			s.fire(rule, open, value, message)
+		} else if err := s.alertQueries.UpdateAlertValue(open.ID, value, message); err != nil {
+			slog.Error("failed to update alert", "alert_id", open.ID, "error", err)
+		}
+	case models.AlertStatusFiring:
+		if err := s.alertQueries.UpdateAlertValue(open.ID, value, message); err != nil {
+			slog.Error("failed to update alert", "alert_id", open.ID, "error", err)
+		}
+	}
+}
+
+// evaluateReboot fires when uptime goes backwards without a reboot command having been issued
+func (s *AlertService) evaluateReboot(rule *models.AlertRule, agentID uuid.UUID, sample AgentMetricsSample) {
+	if sample.Uptime == 0 || sample.PreviousUptime == 0 || sample.Uptime >= sample.PreviousUptime {
+		return
+	}
+
+	// A reboot we asked for is expected; allow an hour for the command to be picked up and run
+	rebootedAt := time.Now().Add(-sample.Uptime)
+	expected, err := s.commandQueries.HasCommandSince(agentID, models.CommandTypeReboot, rebootedAt.Add(-1*time.Hour))
+	if err != nil {
+		slog.Error("failed to check reboot commands", "agent_id", agentID, "error", err)
+		return
+	}
+	if expected {
+		return
+	}
+
+	open, err := s.alertQueries.GetOpenAlert(rule.ID, agentID)
+	if err != nil {
+		slog.Error("failed to evaluate alert rule", "rule", rule.Name, "agent_id", agentID, "error", err)
+		return
+	}
+	if open != nil {
+		// Still firing from an earlier reboot; replace it so the window restarts
+		s.resolve(rule, open)
+	}
+
+	message := fmt.Sprintf("Unexpected reboot detected at %s (uptime reset from %s to %s)",
+		rebootedAt.UTC().Format(time.RFC3339), sample.PreviousUptime, sample.Uptime)
+	alert := &models.Alert{
+		ID:           uuid.New(),
+		RuleID:       rule.ID,
+		AgentID:      agentID,
+		Status:       models.AlertStatusPending,
+		Severity:     rule.Severity,
+		Message:      message,
+		PendingSince: time.Now(),
+		Metadata: models.JSONB{
+			"rebooted_at":     rebootedAt.UTC().Format(time.RFC3339),
+			"previous_uptime": sample.PreviousUptime.String(),
+			"uptime":          sample.Uptime.String(),
+		},
+	}
+	if err := s.alertQueries.CreateAlert(alert); err != nil {
+		slog.Error("failed to create reboot alert", "rule", rule.Name, "agent_id", agentID, "error", err)
+		return
+	}
+	s.fire(rule, alert, 0, message)
+}
+
+// evaluateMissedCheckIns fires alerts for agents that have gone quiet
+func (s *AlertService) evaluateMissedCheckIns() {
+	rules, err := s.alertQueries.GetEnabledAlertRules(models.AlertRuleMissedCheckIn)
+	if err != nil {
+		slog.Error("failed to load missed check-in rules", "error", err)
+		return
+	}
+	if len(rules) == 0 {
+		return
+	}
+
+	agents, err := s.agentQueries.ListAgents("", "")
+	if err != nil {
+		slog.Error("failed to list agents", "error", err)
+		return
+	}
+
+	for i := range rules {
+		rule := &rules[i]
+		intervals := rule.MissedIntervals
+		if intervals < 1 {
+			intervals = 1
+		}
+		threshold := time.Duration(intervals) * s.checkInInterval
+
+		for _, agent := range agents {
+			if !rule.AppliesTo(agent.ID) {
+				continue
+			}
+
+			silentFor := time.Since(agent.LastSeen)
+			open, err := s.alertQueries.GetOpenAlert(rule.ID, agent.ID)
+			if err != nil {
+				slog.Error("failed to evaluate alert rule", "rule", rule.Name, "agent_id", agent.ID, "error", err)
+				continue
+			}
+
+			if silentFor < threshold {
+				if open != nil {
+					s.resolve(rule, open)
+				}
+				continue
+			}
+			if open != nil {
+				continue
+			}
+
+			message := fmt.Sprintf("No check-in for %s (last seen %s, expected every %s)",
+				silentFor.Round(time.Minute), agent.LastSeen.UTC().Format(time.RFC3339), s.checkInInterval)
+			alert := &models.Alert{
+				ID:           uuid.New(),
+				RuleID:       rule.ID,
+				AgentID:      agent.ID,
+				Status:       models.AlertStatusPending,
+				Severity:     rule.Severity,
+				Message:      message,
+				Value:        silentFor.Minutes(),
+				PendingSince: agent.LastSeen.Add(threshold),
+				Metadata:     models.JSONB{"last_seen": agent.LastSeen.UTC().Format(time.RFC3339)},
+			}
+			if err := s.alertQueries.CreateAlert(alert); err != nil {
+				slog.Error("failed to create missed check-in alert", "rule", rule.Name, "agent_id", agent.ID, "error", err)
+				continue
+			}
+			s.fire(rule, alert, alert.Value, message)
+		}
+	}
+}
+
+// expireRebootAlerts resolves reboot alerts once their rule's window has passed
+func (s *AlertService) expireRebootAlerts() {
+	alerts, err := s.alertQueries.GetFiringAlertsByRuleType(models.AlertRuleUnexpectedReboot)
+	if err != nil {
+		slog.Error("failed to load reboot alerts", "error", err)
+		return
+	}
+
+	for i := range alerts {
+		alert := &alerts[i]
+		rule, err := s.alertQueries.GetAlertRule(alert.RuleID)
+		if err != nil {
+			continue
+		}
+		window := time.Duration(rule.DurationSeconds) * time.Second
+		if alert.FiredAt != nil && time.Since(*alert.FiredAt) >= window {
+			s.resolve(rule, alert)
+		}
+	}
+}
+
+// resolveOpenAlert resolves whatever alert is open for the rule/agent pair
+func (s *AlertService) resolveOpenAlert(rule *models.AlertRule, agentID uuid.UUID) {
+	open, err := s.alertQueries.GetOpenAlert(rule.ID, agentID)
+	if err != nil {
+		slog.Error("failed to evaluate alert rule", "rule", rule.Name, "agent_id", agentID, "error", err)
+		return
+	}
+	if open != nil {
+		s.resolve(rule, open)
+	}
+}
+
+// fire transitions an alert to firing and notifies
+func (s *AlertService) fire(rule *models.AlertRule, alert *models.Alert, value float64, message string) {
+	if err := s.alertQueries.MarkAlertFiring(alert.ID, value, message); err != nil {
+		slog.Error("failed to fire alert", "alert_id", alert.ID, "error", err)
+		return
+	}
+
+	now := time.Now()
+	alert.Status = models.AlertStatusFiring
+	alert.FiredAt = &now
+	alert.Value = value
+	alert.Message = message
+	s.notify(rule, alert, models.AlertStatusFiring)
+}
+
+// resolve transitions an alert to resolved, notifying only if it had fired
+func (s *AlertService) resolve(rule *models.AlertRule, alert *models.Alert) {
+	wasFiring := alert.Status == models.AlertStatusFiring
+	if err := s.alertQueries.MarkAlertResolved(alert.ID); err != nil {
+		slog.Error("failed to resolve alert", "alert_id", alert.ID, "error", err)
+		return
+	}
+
+	now := time.Now()
+	alert.Status = models.AlertStatusResolved
+	alert.ResolvedAt = &now
+	if wasFiring {
+		s.notify(rule, alert, models.AlertStatusResolved)
+	}
+}
+
+// notify hands an alert transition to every configured channel unless silenced
+func (s *AlertService) notify(rule *models.AlertRule, alert *models.Alert, transition string) {
+	silenced, err := s.alertQueries.IsSilenced(rule.ID, alert.AgentID)
+	if err != nil {
+		slog.Warn("failed to check alert silences", "alert_id", alert.ID, "error", err)
+	}
+	if silenced {
+		slog.Info("alert notification suppressed by silence", "alert_id", alert.ID, "transition", transition)
+		return
+	}
+
+	hostname := alert.AgentID.String()
+	if agent, err := s.agentQueries.GetAgentByID(alert.AgentID); err == nil {
+		hostname = agent.Hostname
+	}
+
+	notification := AlertNotification{
+		Transition: transition,
+		Alert:      *alert,
+		Rule:       *rule,
+		Hostname:   hostname,
+		SentAt:     time.Now(),
+	}
+
+	for _, notifier := range s.notifiers {
+		if err := notifier.Notify(notification); err != nil {
+			slog.Warn("failed to deliver alert", "alert_id", alert.ID, "notifier", notifier.Name(), "error", err)
+		}
+	}
+
+	if err := s.alertQueries.MarkAlertNotified(alert.ID); err != nil {
+		slog.Warn("failed to mark alert notified", "alert_id", alert.ID, "error", err)
+	}
+}
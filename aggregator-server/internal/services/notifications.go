@@ -0,0 +1,82 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+)
+
+// AlertNotification is the payload handed to notification channels when an alert changes state
+type AlertNotification struct {
+	Transition string           `json:"transition"` // "firing" or "resolved"
+	Alert      models.Alert     `json:"alert"`
+	Rule       models.AlertRule `json:"rule"`
+	Hostname   string           `json:"hostname"`
+	SentAt     time.Time        `json:"sent_at"`
+}
+
+// Notifier delivers alert notifications to a channel
+type Notifier interface {
+	Name() string
+	Notify(notification AlertNotification) error
+}
+
+// LogNotifier writes alert transitions to the server log
+type LogNotifier struct{}
+
+// NewLogNotifier creates a notifier that logs alert transitions
+func NewLogNotifier() *LogNotifier {
+	return &LogNotifier{}
+}
+
+func (n *LogNotifier) Name() string {
+	return "log"
+}
+
+func (n *LogNotifier) Notify(notification AlertNotification) error {
+	slog.Warn("alert", "rule", notification.Rule.Name, "transition", notification.Transition,
+		"agent_id", notification.Alert.AgentID, "hostname", notification.Hostname,
+		"severity", notification.Alert.Severity, "message", notification.Alert.Message)
+	return nil
+}
+
+// WebhookNotifier POSTs alert transitions as JSON to a configured URL
+type WebhookNotifier struct {
+	url        string
+	httpClient *http.Client
+}
+
+// NewWebhookNotifier creates a notifier that posts to the given URL
+func NewWebhookNotifier(url string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:        url,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (n *WebhookNotifier) Name() string {
+	return "webhook"
+}
+
+func (n *WebhookNotifier) Notify(notification AlertNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal notification: %w", err)
+	}
+
+	resp, err := n.httpClient.Post(n.url, "application/json", bytes.NewBuffer(body))
+	if err != nil {
+		return fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
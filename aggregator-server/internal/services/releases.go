@@ -0,0 +1,279 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/signing"
+)
+
+var (
+	// ErrReleaseNotFound is returned when no binary exists for a platform
+	ErrReleaseNotFound = errors.New("agent binary not found")
+	// ErrReleaseUnsigned is returned when a binary has no detached signature
+	ErrReleaseUnsigned = errors.New("agent binary is not signed")
+	// ErrReleaseBadSignature is returned when a signature doesn't match the configured key
+	ErrReleaseBadSignature = errors.New("agent binary signature does not verify")
+)
+
+// SupportedPlatforms are the platforms agent binaries are published for
+var SupportedPlatforms = []string{"linux-amd64", "linux-arm64", "windows-amd64", "windows-arm64"}
+
+// ReleaseService describes the agent binaries served from binaries/{platform}/.
+// Each binary must have two detached base64 Ed25519 signatures alongside it
+// for agents to accept it: redflag-agent.sig over the binary itself (checked
+// by the install scripts with openssl) and redflag-agent.release.sig over the
+// release statement binding the binary to its version and platform (checked
+// by agents before self-updating).
+type ReleaseService struct {
+	agentDir      string
+	latestVersion string
+	publicKey     string
+
+	mu     sync.Mutex
+	hashes map[string]cachedHash
+}
+
+type cachedHash struct {
+	modTime          time.Time
+	size             int64
+	signature        string
+	releaseSignature string
+	sum              string
+	verified         bool
+}
+
+// NewReleaseService creates a release service for binaries under agentDir.
+// publicKey is the base64 Ed25519 key release signatures verify against; it
+// is handed to agents at registration.
+func NewReleaseService(agentDir, latestVersion, publicKey string) *ReleaseService {
+	return &ReleaseService{
+		agentDir:      agentDir,
+		latestVersion: latestVersion,
+		publicKey:     publicKey,
+		hashes:        make(map[string]cachedHash),
+	}
+}
+
+// LatestVersion returns the agent version the server advertises
+func (s *ReleaseService) LatestVersion() string {
+	return s.latestVersion
+}
+
+// PublicKey returns the base64 Ed25519 release signing public key, if configured
+func (s *ReleaseService) PublicKey() string {
+	return s.publicKey
+}
+
+// PlatformFor maps an agent's reported OS type and architecture to a download platform
+func PlatformFor(osType, arch string) string {
+	osType = strings.ToLower(osType)
+	switch strings.ToLower(arch) {
+	case "x86_64", "amd64":
+		arch = "amd64"
+	case "aarch64", "arm64":
+		arch = "arm64"
+	}
+	return osType + "-" + arch
+}
+
+// BinaryPath returns where the agent binary for a platform is stored
+func (s *ReleaseService) BinaryPath(platform string) string {
+	filename := "redflag-agent"
+	if strings.HasPrefix(platform, "windows") {
+		filename += ".exe"
+	}
+	return filepath.Join(s.agentDir, "binaries", platform, filename)
+}
+
+// GetRelease returns version, checksum and signature for a platform's binary.
+// Binaries without a signature, or whose signature doesn't verify against the
+// configured public key, are not offered to agents.
+func (s *ReleaseService) GetRelease(platform string) (*models.AgentRelease, error) {
+	release, err := s.Describe(platform)
+	if err != nil {
+		return nil, err
+	}
+	if release.Signature == "" || release.ReleaseSignature == "" {
+		return nil, ErrReleaseUnsigned
+	}
+	if s.publicKey != "" && !release.Verified {
+		return nil, ErrReleaseBadSignature
+	}
+	return release, nil
+}
+
+// Manifest lists every published agent binary. Unsigned binaries are included
+// with an empty signature so clients can see - and refuse - them.
+func (s *ReleaseService) Manifest() (*models.ReleaseManifest, error) {
+	manifest := &models.ReleaseManifest{
+		Version:            s.latestVersion,
+		PublicKey:          s.publicKey,
+		SignatureAlgorithm: "ed25519",
+		Releases:           []models.AgentRelease{},
+		GeneratedAt:        time.Now().UTC(),
+	}
+
+	for _, platform := range SupportedPlatforms {
+		release, err := s.Describe(platform)
+		if errors.Is(err, ErrReleaseNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		manifest.Releases = append(manifest.Releases, *release)
+	}
+	return manifest, nil
+}
+
+// SignBinaries writes the binary and release signatures next to every
+// published binary, for the service's latest version
+func (s *ReleaseService) SignBinaries(priv ed25519.PrivateKey) ([]models.AgentRelease, error) {
+	if s.latestVersion == "" {
+		return nil, errors.New("no release version to sign")
+	}
+
+	var signed []models.AgentRelease
+	for _, platform := range SupportedPlatforms {
+		path := s.BinaryPath(platform)
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return signed, fmt.Errorf("failed to read agent binary: %w", err)
+		}
+
+		sum := sha256.Sum256(data)
+		checksum := hex.EncodeToString(sum[:])
+
+		sig := signing.Sign(priv, data)
+		if err := os.WriteFile(path+".sig", []byte(sig+"\n"), 0644); err != nil {
+			return signed, fmt.Errorf("failed to write signature for %s: %w", platform, err)
+		}
+		releaseSig := signing.Sign(priv, signing.ReleaseStatement(s.latestVersion, platform, checksum))
+		if err := os.WriteFile(releaseSignaturePath(path), []byte(releaseSig+"\n"), 0644); err != nil {
+			return signed, fmt.Errorf("failed to write release signature for %s: %w", platform, err)
+		}
+
+		signed = append(signed, models.AgentRelease{
+			Version:          s.latestVersion,
+			Platform:         platform,
+			DownloadURL:      "/api/v1/downloads/" + platform,
+			SHA256:           checksum,
+			Signature:        sig,
+			ReleaseSignature: releaseSig,
+			Size:             int64(len(data)),
+			Verified:         true,
+		})
+	}
+	return signed, nil
+}
+
+// Describe returns release metadata for a platform's binary, signed or not
+func (s *ReleaseService) Describe(platform string) (*models.AgentRelease, error) {
+	if !isSupportedPlatform(platform) {
+		return nil, fmt.Errorf("unsupported platform: %s", platform)
+	}
+
+	path := s.BinaryPath(platform)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, ErrReleaseNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat agent binary: %w", err)
+	}
+
+	signature, err := readSignature(path + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent binary signature: %w", err)
+	}
+	releaseSignature, err := readSignature(releaseSignaturePath(path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read agent release signature: %w", err)
+	}
+
+	sum, verified, err := s.inspect(path, platform, info, signature, releaseSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AgentRelease{
+		Version:          s.latestVersion,
+		Platform:         platform,
+		DownloadURL:      "/api/v1/downloads/" + platform,
+		SHA256:           sum,
+		Signature:        signature,
+		ReleaseSignature: releaseSignature,
+		Size:             info.Size(),
+		Verified:         verified,
+	}, nil
+}
+
+// releaseSignaturePath returns where a binary's release signature is stored
+func releaseSignaturePath(binary string) string {
+	return strings.TrimSuffix(binary, ".exe") + ".release.sig"
+}
+
+// readSignature reads a detached signature, returning "" when there is none
+func readSignature(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// inspect hashes a binary and checks its signatures, recomputing only when the
+// binary or a signature changes
+func (s *ReleaseService) inspect(path, platform string, info os.FileInfo, signature, releaseSignature string) (string, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cached, ok := s.hashes[path]; ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() &&
+		cached.signature == signature && cached.releaseSignature == releaseSignature {
+		return cached.sum, cached.verified, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read agent binary: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+
+	verified := false
+	if signature != "" && releaseSignature != "" && s.publicKey != "" {
+		if key, err := signing.ParsePublicKey(s.publicKey); err == nil {
+			verified = signing.Verify(key, data, signature) &&
+				signing.Verify(key, signing.ReleaseStatement(s.latestVersion, platform, checksum), releaseSignature)
+		}
+	}
+
+	entry := cachedHash{modTime: info.ModTime(), size: info.Size(), signature: signature, releaseSignature: releaseSignature, sum: checksum, verified: verified}
+	s.hashes[path] = entry
+	return entry.sum, entry.verified, nil
+}
+
+func isSupportedPlatform(platform string) bool {
+	for _, p := range SupportedPlatforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,749 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/events"
+	"github.com/Fimeg/RedFlag/aggregator-server/internal/models"
+	"github.com/google/uuid"
+)
+
+var (
+	// ErrRolloutNotFound is returned for unknown rollout IDs
+	ErrRolloutNotFound = errors.New("rollout not found")
+	// ErrRolloutState is returned when an action doesn't apply to the rollout's current status
+	ErrRolloutState = errors.New("action not allowed in the rollout's current state")
+)
+
+// DefaultRolloutRings are used when a rollout is created without rings
+var DefaultRolloutRings = []models.RolloutRingRequest{
+	{Name: "canary", Percent: 10},
+	{Name: "early", Percent: 30},
+	{Name: "broad", Percent: 100},
+}
+
+// RolloutService installs approved updates ring by ring. Each ring's installs
+// are queued as install_updates commands; once they have all reported back
+// (via ReportLog, or the timeout service) the ring soaks, and is then judged on:
+//   - install success rate >= min_success_percent
+//   - every agent with a successful install has checked in again since
+//   - no more than max_new_failures other failed commands or fired alerts
+//
+// Passing rings promote the next ring automatically; a failing ring halts the rollout.
+type RolloutService struct {
+	rolloutQueries *queries.RolloutQueries
+	updateQueries  *queries.UpdateQueries
+	agentQueries   *queries.AgentQueries
+	commandQueries *queries.CommandQueries
+	eventBus       *events.Bus
+	ticker         *time.Ticker
+	stopChan       chan bool
+
+	// Serialises state transitions between the ticker and agent reports
+	mu sync.Mutex
+}
+
+// NewRolloutService creates a new rollout service
+func NewRolloutService(rq *queries.RolloutQueries, uq *queries.UpdateQueries, aq *queries.AgentQueries, cq *queries.CommandQueries, bus *events.Bus) *RolloutService {
+	return &RolloutService{
+		rolloutQueries: rq,
+		updateQueries:  uq,
+		agentQueries:   aq,
+		commandQueries: cq,
+		eventBus:       bus,
+		stopChan:       make(chan bool),
+	}
+}
+
+// Start begins periodic evaluation of running rollouts
+func (s *RolloutService) Start() {
+	slog.Info("starting rollout service")
+
+	s.ticker = time.NewTicker(30 * time.Second)
+
+	go func() {
+		for {
+			select {
+			case <-s.ticker.C:
+				s.evaluateRunning()
+			case <-s.stopChan:
+				s.ticker.Stop()
+				slog.Info("rollout service stopped")
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the rollout service
+func (s *RolloutService) Stop() {
+	close(s.stopChan)
+}
+
+// CreateRollout plans a rollout of approved updates and starts its first ring
+func (s *RolloutService) CreateRollout(req *models.RolloutRequest, createdBy *uuid.UUID) (*models.RolloutDetail, error) {
+	if len(req.UpdateIDs) == 0 {
+		return nil, fmt.Errorf("at least one update is required")
+	}
+
+	rollout := &models.Rollout{
+		ID:                uuid.New(),
+		Name:              req.Name,
+		Status:            models.RolloutStatusRunning,
+		MinSuccessPercent: 100,
+		SoakSeconds:       600,
+		MaxNewFailures:    0,
+		CreatedBy:         createdBy,
+	}
+	if req.MinSuccessPercent != nil {
+		if *req.MinSuccessPercent < 0 || *req.MinSuccessPercent > 100 {
+			return nil, fmt.Errorf("min_success_percent must be between 0 and 100")
+		}
+		rollout.MinSuccessPercent = *req.MinSuccessPercent
+	}
+	if req.SoakMinutes != nil {
+		if *req.SoakMinutes < 0 {
+			return nil, fmt.Errorf("soak_minutes cannot be negative")
+		}
+		rollout.SoakSeconds = *req.SoakMinutes * 60
+	}
+	if req.MaxNewFailures != nil {
+		if *req.MaxNewFailures < 0 {
+			return nil, fmt.Errorf("max_new_failures cannot be negative")
+		}
+		rollout.MaxNewFailures = *req.MaxNewFailures
+	}
+
+	// Only approved updates are rolled out
+	updatesByAgent := make(map[uuid.UUID][]*models.UpdateState)
+	seen := make(map[uuid.UUID]bool)
+	for _, id := range req.UpdateIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		update, err := s.updateQueries.GetUpdateByID(id)
+		if err == sql.ErrNoRows {
+			return nil, fmt.Errorf("update %s not found", id)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get update %s: %w", id, err)
+		}
+		if update.Status != "approved" {
+			return nil, fmt.Errorf("update %s (%s) is %s, not approved", id, update.PackageName, update.Status)
+		}
+		updatesByAgent[update.AgentID] = append(updatesByAgent[update.AgentID], update)
+	}
+
+	// Order agents by hostname so percentage rings are predictable
+	agents := make([]models.Agent, 0, len(updatesByAgent))
+	for agentID := range updatesByAgent {
+		agent, err := s.agentQueries.GetAgentByID(agentID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get agent %s: %w", agentID, err)
+		}
+		agents = append(agents, *agent)
+	}
+	sort.Slice(agents, func(i, j int) bool { return agents[i].Hostname < agents[j].Hostname })
+
+	ringRequests := req.Rings
+	if len(ringRequests) == 0 {
+		ringRequests = DefaultRolloutRings
+	}
+	assignments, err := assignRings(ringRequests, agents)
+	if err != nil {
+		return nil, err
+	}
+
+	var rings []models.RolloutRing
+	var targets []models.RolloutTarget
+	for i, agentIDs := range assignments {
+		if len(agentIDs) == 0 {
+			continue // Too few agents to fill every ring
+		}
+		ring := models.RolloutRing{
+			ID:        uuid.New(),
+			RolloutID: rollout.ID,
+			RingIndex: len(rings),
+			Name:      ringRequests[i].Name,
+			Status:    models.RingStatusPending,
+		}
+		rings = append(rings, ring)
+
+		for _, agentID := range agentIDs {
+			for _, update := range updatesByAgent[agentID] {
+				updateID := update.ID
+				targets = append(targets, models.RolloutTarget{
+					ID:          uuid.New(),
+					RolloutID:   rollout.ID,
+					RingID:      ring.ID,
+					AgentID:     agentID,
+					UpdateID:    &updateID,
+					PackageType: update.PackageType,
+					PackageName: update.PackageName,
+					Status:      models.TargetStatusPending,
+				})
+			}
+		}
+	}
+
+	if err := s.rolloutQueries.CreateRollout(rollout, rings, targets); err != nil {
+		return nil, err
+	}
+	slog.Info("created rollout", "rollout", rollout.Name, "rollout_id", rollout.ID,
+		"updates", len(targets), "agents", len(agents), "rings", len(rings))
+
+	s.mu.Lock()
+	s.advance(rollout.ID)
+	s.mu.Unlock()
+
+	return s.rolloutQueries.GetRolloutDetail(rollout.ID)
+}
+
+// assignRings splits agents across rings. Explicitly listed agents are placed
+// first; the rest are allocated to percentage rings in order, at least one
+// agent per ring, with the last ring taking whatever remains.
+func assignRings(ringRequests []models.RolloutRingRequest, agents []models.Agent) ([][]uuid.UUID, error) {
+	affected := make(map[uuid.UUID]bool, len(agents))
+	for _, agent := range agents {
+		affected[agent.ID] = true
+	}
+
+	assignments := make([][]uuid.UUID, len(ringRequests))
+	assigned := make(map[uuid.UUID]bool)
+	for i, ring := range ringRequests {
+		for _, agentID := range ring.AgentIDs {
+			if !affected[agentID] {
+				return nil, fmt.Errorf("agent %s in ring %q has none of the selected updates", agentID, ring.Name)
+			}
+			if assigned[agentID] {
+				return nil, fmt.Errorf("agent %s is listed in more than one ring", agentID)
+			}
+			assigned[agentID] = true
+			assignments[i] = append(assignments[i], agentID)
+		}
+	}
+
+	var remaining []uuid.UUID
+	for _, agent := range agents {
+		if !assigned[agent.ID] {
+			remaining = append(remaining, agent.ID)
+		}
+	}
+
+	// Rings without an explicit agent list share the remaining agents
+	var percentRings []int
+	for i, ring := range ringRequests {
+		if len(ring.AgentIDs) == 0 {
+			percentRings = append(percentRings, i)
+		}
+	}
+	if len(percentRings) == 0 {
+		if len(remaining) > 0 {
+			return nil, fmt.Errorf("%d affected agent(s) are not in any ring", len(remaining))
+		}
+		return assignments, nil
+	}
+
+	total := len(remaining)
+	for n, i := range percentRings {
+		if len(remaining) == 0 {
+			break
+		}
+		count := len(remaining)
+		if n < len(percentRings)-1 {
+			count = int(math.Round(float64(total) * ringRequests[i].Percent / 100))
+			if count < 1 {
+				count = 1
+			}
+			if count > len(remaining) {
+				count = len(remaining)
+			}
+		}
+		assignments[i] = append(assignments[i], remaining[:count]...)
+		remaining = remaining[count:]
+	}
+	return assignments, nil
+}
+
+// PauseRollout stops a running rollout from dispatching or promoting further.
+// Installs already sent to agents still complete.
+func (s *RolloutService) PauseRollout(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rollout, err := s.getRollout(id)
+	if err != nil {
+		return err
+	}
+	if rollout.Status != models.RolloutStatusRunning {
+		return ErrRolloutState
+	}
+	return s.setStatus(rollout, models.RolloutStatusPaused, "paused by user")
+}
+
+// ResumeRollout continues a paused rollout. A halted rollout resumes with the
+// next ring: the operator accepts the failed ring and overrides the halt.
+func (s *RolloutService) ResumeRollout(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rollout, err := s.getRollout(id)
+	if err != nil {
+		return err
+	}
+
+	switch rollout.Status {
+	case models.RolloutStatusPaused:
+		if err := s.setStatus(rollout, models.RolloutStatusRunning, ""); err != nil {
+			return err
+		}
+	case models.RolloutStatusHalted:
+		if err := s.setStatus(rollout, models.RolloutStatusRunning, ""); err != nil {
+			return err
+		}
+		if err := s.promote(rollout); err != nil {
+			return err
+		}
+	default:
+		return ErrRolloutState
+	}
+
+	s.advance(id)
+	return nil
+}
+
+// AbortRollout stops a rollout for good, cancelling installs that haven't run yet
+func (s *RolloutService) AbortRollout(id uuid.UUID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rollout, err := s.getRollout(id)
+	if err != nil {
+		return err
+	}
+	if rollout.Status == models.RolloutStatusCompleted || rollout.Status == models.RolloutStatusAborted {
+		return ErrRolloutState
+	}
+
+	commandIDs, err := s.rolloutQueries.CancelPendingTargets(id)
+	if err != nil {
+		return err
+	}
+	// Commands the agent has already picked up can't be recalled; they finish
+	// and report through RecordResult as usual
+	for _, commandID := range commandIDs {
+		if err := s.commandQueries.CancelPendingCommand(commandID); err != nil {
+			slog.Warn("failed to cancel rollout command", "rollout_id", id, "command_id", commandID, "error", err)
+		}
+	}
+	if err := s.rolloutQueries.CancelUndeliveredTargets(id); err != nil {
+		return err
+	}
+	if err := s.rolloutQueries.CancelPendingRings(id); err != nil {
+		return err
+	}
+	return s.setStatus(rollout, models.RolloutStatusAborted, "aborted by user")
+}
+
+// RecordResult applies an install result reported by an agent, where
+// packageStatus is "updated", "degraded" (installed but failed health checks)
+// or "failed". Only an "updated" target counts towards a ring's success rate.
+// Returns false for commands that weren't created by a rollout.
+func (s *RolloutService) RecordResult(commandID uuid.UUID, packageStatus, message string) bool {
+	target, err := s.rolloutQueries.GetTargetByCommandID(commandID)
+	if err != nil {
+		slog.Error("failed to look up rollout target", "command_id", commandID, "error", err)
+		return false
+	}
+	if target == nil {
+		return false
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.finishTarget(target, packageStatus, message) {
+		s.advance(target.RolloutID)
+	}
+	return true
+}
+
+// evaluateRunning advances every running rollout
+func (s *RolloutService) evaluateRunning() {
+	rollouts, err := s.rolloutQueries.ListRollouts(models.RolloutStatusRunning)
+	if err != nil {
+		slog.Error("failed to list running rollouts", "error", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, rollout := range rollouts {
+		s.advance(rollout.ID)
+	}
+}
+
+// advance moves a running rollout's current ring through
+// pending -> installing -> soaking -> succeeded/failed, promoting or halting
+// as it goes. Caller must hold s.mu.
+func (s *RolloutService) advance(id uuid.UUID) {
+	for {
+		rollout, err := s.rolloutQueries.GetRollout(id)
+		if err != nil {
+			slog.Error("failed to load rollout", "rollout_id", id, "error", err)
+			return
+		}
+		if rollout.Status != models.RolloutStatusRunning {
+			return
+		}
+
+		ring, err := s.rolloutQueries.GetRing(id, rollout.CurrentRing)
+		if err != nil {
+			slog.Error("failed to load rollout ring", "rollout_id", id, "ring_index", rollout.CurrentRing, "error", err)
+			return
+		}
+		if ring == nil {
+			s.setStatus(rollout, models.RolloutStatusCompleted, "")
+			return
+		}
+
+		targets, err := s.rolloutQueries.GetRingTargets(ring.ID)
+		if err != nil {
+			slog.Error("failed to load ring targets", "rollout", rollout.Name, "ring", ring.Name, "error", err)
+			return
+		}
+
+		switch ring.Status {
+		case models.RingStatusPending:
+			s.startRing(rollout, ring, targets)
+			continue
+
+		case models.RingStatusInstalling:
+			targets = s.syncTargets(targets)
+			counts := countTargets(targets)
+			if !counts.meetsSuccessRate(rollout.MinSuccessPercent, true) {
+				s.failRing(rollout, ring, counts.summary(), fmt.Sprintf("ring %q cannot reach %.0f%% install success (%d of %d failed)",
+					ring.Name, rollout.MinSuccessPercent, counts.failed, counts.total()))
+				return
+			}
+			if counts.inFlight() > 0 {
+				return
+			}
+			if err := s.rolloutQueries.StartSoak(ring.ID); err != nil {
+				slog.Error("failed to start ring soak", "rollout", rollout.Name, "ring", ring.Name, "error", err)
+				return
+			}
+			slog.Info("ring installed, soaking", "rollout", rollout.Name, "ring", ring.Name, "soak_seconds", rollout.SoakSeconds)
+			s.publish(rollout, ring, models.RingStatusSoaking, "")
+			continue
+
+		case models.RingStatusSoaking:
+			if ring.SoakStartedAt != nil && time.Since(*ring.SoakStartedAt) < time.Duration(rollout.SoakSeconds)*time.Second {
+				return
+			}
+			if !s.judgeRing(rollout, ring, targets) {
+				return
+			}
+			continue
+
+		default:
+			// Succeeded, failed or cancelled ring still current - move on
+			if err := s.promote(rollout); err != nil {
+				slog.Error("failed to promote rollout", "rollout", rollout.Name, "error", err)
+				return
+			}
+			continue
+		}
+	}
+}
+
+// startRing queues an install command for every target in the ring
+func (s *RolloutService) startRing(rollout *models.Rollout, ring *models.RolloutRing, targets []models.RolloutTarget) {
+	if err := s.rolloutQueries.StartRing(ring.ID); err != nil {
+		slog.Error("failed to start ring", "rollout", rollout.Name, "ring", ring.Name, "error", err)
+		return
+	}
+	slog.Info("starting ring", "rollout", rollout.Name, "ring", ring.Name, "installs", len(targets))
+	s.publish(rollout, ring, models.RingStatusInstalling, "")
+
+	for i := range targets {
+		target := &targets[i]
+		if target.Status != models.TargetStatusPending {
+			continue
+		}
+
+		cmd := &models.AgentCommand{
+			ID:          uuid.New(),
+			AgentID:     target.AgentID,
+			CommandType: models.CommandTypeInstallUpdate,
+			Params: models.JSONB{
+				"package_type": target.PackageType,
+				"package_name": target.PackageName,
+			},
+			Status:    models.CommandStatusPending,
+			Source:    models.CommandSourceSystem,
+			CreatedAt: time.Now(),
+		}
+		if err := s.commandQueries.CreateCommand(cmd); err != nil {
+			slog.Error("failed to queue rollout install", "rollout", rollout.Name, "package", target.PackageName, "agent_id", target.AgentID, "error", err)
+			s.finishTarget(target, "failed", fmt.Sprintf("failed to queue install command: %v", err))
+			continue
+		}
+		if err := s.rolloutQueries.MarkTargetDispatched(target.ID, cmd.ID); err != nil {
+			slog.Error("failed to record rollout install command", "target_id", target.ID, "command_id", cmd.ID, "error", err)
+			continue
+		}
+		if target.UpdateID != nil {
+			if err := s.updateQueries.InstallUpdate(*target.UpdateID); err != nil {
+				slog.Warn("failed to mark package installing", "package", target.PackageName, "agent_id", target.AgentID, "error", err)
+			}
+		}
+	}
+}
+
+// syncTargets picks up outcomes that never arrive through ReportLog: timed out
+// or cancelled commands. Returns the refreshed targets.
+func (s *RolloutService) syncTargets(targets []models.RolloutTarget) []models.RolloutTarget {
+	for i := range targets {
+		target := &targets[i]
+		if target.Status != models.TargetStatusDispatched || target.CommandID == nil {
+			continue
+		}
+		cmd, err := s.commandQueries.GetCommandByID(*target.CommandID)
+		if err != nil {
+			continue
+		}
+		switch cmd.Status {
+		case models.CommandStatusCompleted:
+			if health, _ := cmd.Result["health"].(string); health == "failed" {
+				s.finishTarget(target, "degraded", "installed but failed health checks")
+			} else {
+				s.finishTarget(target, "updated", "")
+			}
+		case models.CommandStatusFailed, models.CommandStatusTimedOut:
+			s.finishTarget(target, "failed", fmt.Sprintf("install command %s", cmd.Status))
+		case models.CommandStatusCancelled:
+			if ok, _ := s.rolloutQueries.FinishTarget(target.ID, models.TargetStatusCancelled, "install command cancelled"); ok {
+				target.Status = models.TargetStatusCancelled
+			}
+		}
+	}
+	return targets
+}
+
+// finishTarget records a target's outcome and the package's new state.
+// Returns false if the target already had an outcome.
+func (s *RolloutService) finishTarget(target *models.RolloutTarget, packageStatus, message string) bool {
+	status := models.TargetStatusFailed
+	if packageStatus == "updated" {
+		status = models.TargetStatusSucceeded
+	}
+
+	ok, err := s.rolloutQueries.FinishTarget(target.ID, status, message)
+	if err != nil {
+		slog.Error("failed to record rollout target result", "target_id", target.ID, "error", err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	target.Status = status
+
+	if err := s.updateQueries.UpdatePackageStatus(target.AgentID, target.PackageType, target.PackageName, packageStatus, nil, nil); err != nil {
+		slog.Warn("failed to update package status", "package", target.PackageName, "agent_id", target.AgentID, "error", err)
+	}
+	return true
+}
+
+// judgeRing evaluates a soaked ring's success criteria. Returns true if the
+// rollout moved on (promoted or completed), false if it halted.
+func (s *RolloutService) judgeRing(rollout *models.Rollout, ring *models.RolloutRing, targets []models.RolloutTarget) bool {
+	counts := countTargets(targets)
+	summary := counts.summary()
+
+	since := time.Now()
+	if ring.StartedAt != nil {
+		since = *ring.StartedAt
+	}
+	notCheckedIn, err := s.rolloutQueries.CountAgentsNotCheckedIn(ring.ID)
+	if err != nil {
+		slog.Error("failed to evaluate ring", "rollout", rollout.Name, "ring", ring.Name, "error", err)
+		return false
+	}
+	newFailures, err := s.rolloutQueries.CountNewFailures(ring.ID, since)
+	if err != nil {
+		slog.Error("failed to evaluate ring", "rollout", rollout.Name, "ring", ring.Name, "error", err)
+		return false
+	}
+	summary["agents_not_checked_in"] = notCheckedIn
+	summary["new_failures"] = newFailures
+
+	var reason string
+	switch {
+	case !counts.meetsSuccessRate(rollout.MinSuccessPercent, false):
+		reason = fmt.Sprintf("ring %q install success %.1f%% is below %.0f%%", ring.Name, counts.successPercent(), rollout.MinSuccessPercent)
+	case notCheckedIn > 0:
+		reason = fmt.Sprintf("%d agent(s) in ring %q have not checked in since installing", notCheckedIn, ring.Name)
+	case newFailures > rollout.MaxNewFailures:
+		reason = fmt.Sprintf("%d new failure(s) on ring %q agents (limit %d)", newFailures, ring.Name, rollout.MaxNewFailures)
+	}
+	if reason != "" {
+		s.failRing(rollout, ring, summary, reason)
+		return false
+	}
+
+	if err := s.rolloutQueries.FinishRing(ring.ID, models.RingStatusSucceeded, summary); err != nil {
+		slog.Error("failed to finish ring", "rollout", rollout.Name, "ring", ring.Name, "error", err)
+		return false
+	}
+	slog.Info("ring passed", "rollout", rollout.Name, "ring", ring.Name, "success_percent", counts.successPercent())
+	s.publish(rollout, ring, models.RingStatusSucceeded, "")
+
+	if err := s.promote(rollout); err != nil {
+		slog.Error("failed to promote rollout", "rollout", rollout.Name, "error", err)
+		return false
+	}
+	return true
+}
+
+// failRing marks a ring failed and halts the rollout
+func (s *RolloutService) failRing(rollout *models.Rollout, ring *models.RolloutRing, summary models.JSONB, reason string) {
+	if err := s.rolloutQueries.FinishRing(ring.ID, models.RingStatusFailed, summary); err != nil {
+		slog.Error("failed to finish ring", "rollout", rollout.Name, "ring", ring.Name, "error", err)
+		return
+	}
+	slog.Warn("rollout halted", "rollout", rollout.Name, "ring", ring.Name, "reason", reason)
+	s.publish(rollout, ring, models.RingStatusFailed, reason)
+	s.setStatus(rollout, models.RolloutStatusHalted, reason)
+}
+
+// promote moves the rollout to its next ring, completing it after the last one
+func (s *RolloutService) promote(rollout *models.Rollout) error {
+	next, err := s.rolloutQueries.GetRing(rollout.ID, rollout.CurrentRing+1)
+	if err != nil {
+		return err
+	}
+	if next == nil {
+		return s.setStatus(rollout, models.RolloutStatusCompleted, "")
+	}
+	if err := s.rolloutQueries.SetCurrentRing(rollout.ID, next.RingIndex); err != nil {
+		return err
+	}
+	rollout.CurrentRing = next.RingIndex
+	return nil
+}
+
+func (s *RolloutService) getRollout(id uuid.UUID) (*models.Rollout, error) {
+	rollout, err := s.rolloutQueries.GetRollout(id)
+	if err == sql.ErrNoRows {
+		return nil, ErrRolloutNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rollout: %w", err)
+	}
+	return rollout, nil
+}
+
+func (s *RolloutService) setStatus(rollout *models.Rollout, status, reason string) error {
+	if err := s.rolloutQueries.UpdateRolloutStatus(rollout.ID, status, reason); err != nil {
+		return err
+	}
+	rollout.Status = status
+	rollout.HaltReason = reason
+	if status == models.RolloutStatusCompleted {
+		slog.Info("rollout completed", "rollout", rollout.Name)
+	}
+	s.publish(rollout, nil, "", reason)
+	return nil
+}
+
+func (s *RolloutService) publish(rollout *models.Rollout, ring *models.RolloutRing, ringStatus, reason string) {
+	data := map[string]interface{}{
+		"rollout_id":   rollout.ID,
+		"name":         rollout.Name,
+		"status":       rollout.Status,
+		"current_ring": rollout.CurrentRing,
+	}
+	if ring != nil {
+		data["ring"] = ring.Name
+		data["ring_status"] = ringStatus
+	}
+	if reason != "" {
+		data["reason"] = reason
+	}
+	s.eventBus.Publish(events.Event{Type: events.TypeRolloutStatus, Data: data})
+}
+
+// targetCounts tallies a ring's targets by outcome
+type targetCounts struct {
+	succeeded, failed, cancelled, pending, dispatched int
+}
+
+func countTargets(targets []models.RolloutTarget) targetCounts {
+	var c targetCounts
+	for _, t := range targets {
+		switch t.Status {
+		case models.TargetStatusSucceeded:
+			c.succeeded++
+		case models.TargetStatusFailed:
+			c.failed++
+		case models.TargetStatusCancelled:
+			c.cancelled++
+		case models.TargetStatusPending:
+			c.pending++
+		case models.TargetStatusDispatched:
+			c.dispatched++
+		}
+	}
+	return c
+}
+
+// total counts targets that take part in the success rate (cancelled ones don't)
+func (c targetCounts) total() int {
+	return c.succeeded + c.failed + c.pending + c.dispatched
+}
+
+func (c targetCounts) inFlight() int {
+	return c.pending + c.dispatched
+}
+
+func (c targetCounts) successPercent() float64 {
+	if c.total() == 0 {
+		return 100
+	}
+	return float64(c.succeeded) * 100 / float64(c.total())
+}
+
+// meetsSuccessRate reports whether the ring meets (or, with optimistic set,
+// can still reach, counting in-flight installs as successes) the threshold
+func (c targetCounts) meetsSuccessRate(minPercent float64, optimistic bool) bool {
+	if c.total() == 0 {
+		return true
+	}
+	succeeded := c.succeeded
+	if optimistic {
+		succeeded += c.inFlight()
+	}
+	return float64(succeeded)*100/float64(c.total()) >= minPercent
+}
+
+func (c targetCounts) summary() models.JSONB {
+	return models.JSONB{
+		"succeeded":       c.succeeded,
+		"failed":          c.failed,
+		"cancelled":       c.cancelled,
+		"in_flight":       c.inFlight(),
+		"success_percent": math.Round(c.successPercent()*10) / 10,
+	}
+}
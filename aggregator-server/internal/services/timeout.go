@@ -2,7 +2,7 @@ package services
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/Fimeg/RedFlag/aggregator-server/internal/database/queries"
@@ -31,7 +31,7 @@ func NewTimeoutService(cq *queries.CommandQueries, uq *queries.UpdateQueries) *T
 
 // Start begins the timeout monitoring service
 func (ts *TimeoutService) Start() {
-	log.Printf("Starting timeout service with %v timeout duration", ts.timeoutDuration)
+	slog.Info("starting timeout service", "timeout", ts.timeoutDuration)
 
 	// Create a ticker that runs every 5 minutes
 	ts.ticker = time.NewTicker(5 * time.Minute)
@@ -43,7 +43,7 @@ func (ts *TimeoutService) Start() {
 				ts.checkForTimeouts()
 			case <-ts.stopChan:
 				ts.ticker.Stop()
-				log.Println("Timeout service stopped")
+				slog.Info("timeout service stopped")
 				return
 			}
 		}
@@ -57,12 +57,12 @@ func (ts *TimeoutService) Stop() {
 
 // checkForTimeouts checks for commands that have been running too long
 func (ts *TimeoutService) checkForTimeouts() {
-	log.Println("Checking for timed out operations...")
+	slog.Debug("checking for timed out operations")
 
 	// Get all commands that are in 'sent' status
 	commands, err := ts.commandQueries.GetCommandsByStatus(models.CommandStatusSent)
 	if err != nil {
-		log.Printf("Error getting sent commands: %v", err)
+		slog.Error("failed to get sent commands", "error", err)
 		return
 	}
 
@@ -77,22 +77,22 @@ func (ts *TimeoutService) checkForTimeouts() {
 	}
 
 	if len(timedOutCommands) > 0 {
-		log.Printf("Found %d timed out commands", len(timedOutCommands))
+		slog.Info("found timed out commands", "count", len(timedOutCommands))
 
 		for _, command := range timedOutCommands {
 			if err := ts.timeoutCommand(&command); err != nil {
-				log.Printf("Error timing out command %s: %v", command.ID, err)
+				slog.Error("failed to time out command", "command_id", command.ID, "error", err)
 			}
 		}
 	} else {
-		log.Println("No timed out operations found")
+		slog.Debug("no timed out operations found")
 	}
 }
 
 // timeoutCommand marks a specific command as timed out and updates related entities
 func (ts *TimeoutService) timeoutCommand(command *models.AgentCommand) error {
-	log.Printf("Timing out command %s (type: %s, agent: %s)",
-		command.ID, command.CommandType, command.AgentID)
+	slog.Info("timing out command", "command_id", command.ID, "command_type", command.CommandType,
+		"agent_id", command.AgentID)
 
 	// Update command status to timed_out
 	if err := ts.commandQueries.UpdateCommandStatus(command.ID, models.CommandStatusTimedOut); err != nil {
@@ -113,7 +113,7 @@ func (ts *TimeoutService) timeoutCommand(command *models.AgentCommand) error {
 
 	// Update related update package status if applicable
 	if err := ts.updateRelatedPackageStatus(command); err != nil {
-		log.Printf("Warning: failed to update related package status: %v", err)
+		slog.Warn("failed to update related package status", "command_id", command.ID, "error", err)
 		// Don't return error here as the main timeout operation succeeded
 	}
 
@@ -132,11 +132,11 @@ func (ts *TimeoutService) timeoutCommand(command *models.AgentCommand) error {
 	}
 
 	if err := ts.updateQueries.CreateUpdateLog(logEntry); err != nil {
-		log.Printf("Warning: failed to create timeout log entry: %v", err)
+		slog.Warn("failed to create timeout log entry", "command_id", command.ID, "error", err)
 		// Don't return error here as the main timeout operation succeeded
 	}
 
-	log.Printf("Successfully timed out command %s", command.ID)
+	slog.Info("timed out command", "command_id", command.ID)
 	return nil
 }
 
@@ -216,5 +216,5 @@ func (ts *TimeoutService) GetTimeoutStatus() (map[string]interface{}, error) {
 // SetTimeoutDuration allows changing the timeout duration
 func (ts *TimeoutService) SetTimeoutDuration(duration time.Duration) {
 	ts.timeoutDuration = duration
-	log.Printf("Timeout duration updated to %v", duration)
+	slog.Info("timeout duration updated", "timeout", duration)
 }
\ No newline at end of file
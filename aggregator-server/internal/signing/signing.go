@@ -0,0 +1,119 @@
+// Package signing manages the Ed25519 key pair agent binaries are signed with.
+//
+// Public keys are exchanged as base64 of the raw 32-byte key (the form agents
+// store in update_public_key); private keys are stored as PKCS#8 PEM files so
+// they can also be used with openssl.
+package signing
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// GenerateKey creates a key pair and writes the private key to path (mode 0600).
+// It returns the base64 public key.
+func GenerateKey(path string) (string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate signing key: %w", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode signing key: %w", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return "", fmt.Errorf("failed to create signing key file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return "", fmt.Errorf("failed to write signing key file: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("failed to write signing key file: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(pub), nil
+}
+
+// LoadPrivateKey reads a PKCS#8 PEM Ed25519 private key
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "PRIVATE KEY" {
+		return nil, errors.New("signing key is not a PEM private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, errors.New("signing key is not an Ed25519 key")
+	}
+	return priv, nil
+}
+
+// PublicKeyString returns the base64 public key for a private key
+func PublicKeyString(priv ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(priv.Public().(ed25519.PublicKey))
+}
+
+// ParsePublicKey decodes a base64 public key
+func ParsePublicKey(publicKey string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("invalid Ed25519 public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// PublicKeyPEM converts a base64 public key to a PKIX PEM block, the form
+// openssl expects for verification
+func PublicKeyPEM(publicKey string) (string, error) {
+	key, err := ParsePublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// Sign returns the base64 signature over data
+func Sign(priv ed25519.PrivateKey, data []byte) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(priv, data))
+}
+
+// Verify checks a base64 signature over data
+func Verify(publicKey ed25519.PublicKey, data []byte, signature string) bool {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return false
+	}
+	return ed25519.Verify(publicKey, data, sig)
+}
+
+// ReleaseStatement is the message a release signature covers. Binding the
+// version and platform to the checksum stops a validly signed binary from
+// being replayed as a different version (a downgrade) or to another platform.
+// The agent builds the same statement byte for byte.
+func ReleaseStatement(version, platform, sha256 string) []byte {
+	return []byte(fmt.Sprintf("redflag-agent-release\nversion=%s\nplatform=%s\nsha256=%s\n",
+		strings.TrimSpace(version), strings.TrimSpace(platform), strings.ToLower(strings.TrimSpace(sha256))))
+}
@@ -0,0 +1,83 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTest(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	if _, err := Setup(context.Background(), Config{Exporter: ExporterNone}); err != nil {
+		t.Fatal(err)
+	}
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func TestInjectExtractParamsRoundTrip(t *testing.T) {
+	exporter := setupTest(t)
+
+	ctx, span := Start(context.Background(), "create")
+	params := map[string]interface{}{"package_name": "nginx"}
+	InjectParams(ctx, params)
+	span.End()
+
+	if _, ok := params[ParamKey].(map[string]interface{}); !ok {
+		t.Fatalf("InjectParams stored no trace context: %v", params)
+	}
+
+	// Params are stored as JSONB and read back before the agent sees them
+	data, err := json.Marshal(params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored map[string]interface{}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		t.Fatal(err)
+	}
+
+	extracted := trace.SpanContextFromContext(ExtractParams(context.Background(), stored))
+	want := span.SpanContext()
+	if !extracted.IsRemote() || extracted.TraceID() != want.TraceID() || extracted.SpanID() != want.SpanID() {
+		t.Fatalf("extracted %v, want remote %v", extracted, want)
+	}
+	if extracted.TraceFlags() != want.TraceFlags() {
+		t.Errorf("trace flags %v, want %v", extracted.TraceFlags(), want.TraceFlags())
+	}
+
+	_, child := Start(ExtractParams(context.Background(), stored), "dispatch")
+	child.End()
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[1].Parent.SpanID() != want.SpanID() {
+		t.Fatalf("span started from extracted params isn't a child of the creating span")
+	}
+}
+
+func TestInjectParamsWithoutSpan(t *testing.T) {
+	setupTest(t)
+
+	params := map[string]interface{}{}
+	InjectParams(context.Background(), params)
+	if _, ok := params[ParamKey]; ok {
+		t.Fatalf("InjectParams stored trace context without a span: %v", params)
+	}
+	InjectParams(context.Background(), nil)
+
+	ctx := ExtractParams(context.Background(), map[string]interface{}{ParamKey: "garbage"})
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		t.Fatalf("ExtractParams returned a span context from malformed params")
+	}
+}
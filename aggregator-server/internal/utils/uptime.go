@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseUptime converts the human-readable uptime strings reported by agents into a duration.
+// Handles the formats agents send:
+//
+//	Linux   "up 2 weeks, 3 days, 4 hours, 5 minutes" (uptime -p)
+//	Windows "3 days, 2 hours" / "45 minutes"
+//	macOS   "10:15  up 3 days,  2:04, 2 users, load averages: ..." (uptime)
+//
+// Returns false if no duration could be extracted.
+func ParseUptime(uptime string) (time.Duration, bool) {
+	s := strings.ToLower(strings.TrimSpace(uptime))
+	if s == "" || s == "unknown" {
+		return 0, false
+	}
+
+	// macOS: only the part between "up" and the user count carries uptime
+	if idx := strings.Index(s, " up "); idx >= 0 {
+		s = s[idx+4:]
+	}
+	s = strings.TrimPrefix(s, "up ")
+	if idx := strings.Index(s, "user"); idx >= 0 {
+		s = s[:idx]
+	}
+
+	var total time.Duration
+	found := false
+	fields := strings.Fields(strings.ReplaceAll(s, ",", " "))
+	for i := 0; i < len(fields); i++ {
+		// "H:MM" clock-style component (macOS)
+		if parts := strings.Split(fields[i], ":"); len(parts) == 2 {
+			h, errH := strconv.Atoi(parts[0])
+			m, errM := strconv.Atoi(parts[1])
+			if errH == nil && errM == nil {
+				total += time.Duration(h)*time.Hour + time.Duration(m)*time.Minute
+				found = true
+			}
+			continue
+		}
+
+		n, err := strconv.Atoi(fields[i])
+		if err != nil || i+1 >= len(fields) {
+			continue
+		}
+
+		unit := strings.TrimSuffix(fields[i+1], "s")
+		switch unit {
+		case "year":
+			total += time.Duration(n) * 365 * 24 * time.Hour
+		case "week":
+			total += time.Duration(n) * 7 * 24 * time.Hour
+		case "day":
+			total += time.Duration(n) * 24 * time.Hour
+		case "hour", "hr":
+			total += time.Duration(n) * time.Hour
+		case "minute", "min":
+			total += time.Duration(n) * time.Minute
+		case "second", "sec":
+			total += time.Duration(n) * time.Second
+		default:
+			continue
+		}
+		found = true
+		i++
+	}
+
+	return total, found
+}
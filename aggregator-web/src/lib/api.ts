@@ -13,6 +13,7 @@ import {
   DockerImage,
   DockerContainerListResponse,
   DockerStats,
+  DockerComposeStack,
   DockerUpdateRequest,
   BulkDockerUpdateRequest,
   RegistrationToken,
@@ -146,6 +147,12 @@ export const updateApi = {
     await api.post(`/updates/${id}/install`);
   },
 
+  // Roll back to the version installed before the last update (or a specific version)
+  rollbackUpdate: async (id: string, version?: string): Promise<{ command_id: string; version: string; version_from: string }> => {
+    const response = await api.post(`/updates/${id}/rollback`, version ? { version } : undefined);
+    return response.data;
+  },
+
   // Get update logs
   getUpdateLogs: async (id: string, limit?: number): Promise<{ logs: any[]; count: number }> => {
     const response = await api.get(`/updates/${id}/logs`, {
@@ -377,6 +384,7 @@ export const dockerApi = {
     agent?: string;
     status?: string;
     search?: string;
+    compose_project?: string;
   }): Promise<DockerContainerListResponse> => {
     const response = await api.get('/docker/containers', { params });
     return response.data;
@@ -399,6 +407,18 @@ export const dockerApi = {
     return response.data;
   },
 
+  // Get outstanding updates grouped by compose project
+  getComposeStacks: async (agentId?: string): Promise<{ stacks: DockerComposeStack[]; total: number }> => {
+    const response = await api.get('/docker/stacks', { params: agentId ? { agent: agentId } : undefined });
+    return response.data;
+  },
+
+  // Approve every pending update in an agent's compose project
+  approveComposeStack: async (agentId: string, project: string): Promise<{ count: number }> => {
+    const response = await api.post(`/docker/stacks/${agentId}/${encodeURIComponent(project)}/approve`);
+    return response.data;
+  },
+
   // Approve Docker image update
   approveUpdate: async (containerId: string, imageId: string, scheduledAt?: string): Promise<void> => {
     await api.post(`/docker/containers/${containerId}/images/${imageId}/approve`, {
@@ -134,6 +134,8 @@ export const getStatusColor = (status: string): string => {
       return 'text-indigo-600 bg-indigo-100';
     case 'installed':
       return 'text-success-600 bg-success-100';
+    case 'degraded':
+      return 'text-orange-600 bg-orange-100';
     case 'failed':
       return 'text-danger-600 bg-danger-100';
     default:
@@ -165,6 +167,8 @@ export const getPackageTypeIcon = (type: string): string => {
       return '📦';
     case 'docker':
       return '🐳';
+    case 'podman_image':
+      return '🦭';
     case 'yum':
     case 'dnf':
       return '🐧';
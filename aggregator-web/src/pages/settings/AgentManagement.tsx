@@ -355,12 +355,46 @@ Logs:       journalctl -u redflag-agent`}
 /usr/bin/dnf makecache
 /usr/bin/dnf install -y *
 /usr/bin/dnf upgrade -y *
+/usr/bin/dnf downgrade -y *
 /usr/bin/dnf install --assumeno --downloadonly *
 
 # Docker
 /usr/bin/docker pull *
 /usr/bin/docker image inspect *
-/usr/bin/docker manifest inspect *`}
+/usr/bin/docker manifest inspect *
+/usr/bin/docker tag *
+
+# Compose-managed containers (the helper only runs pull / up -d --no-deps
+# for a service with the compose files it was deployed from)
+/usr/local/bin/redflag-agent update-compose-service *
+
+# Podman systemd-managed containers (restarted onto updated images)
+# The helper only restarts units that run a Podman container
+/usr/local/bin/redflag-agent restart-podman-unit *
+
+# Filesystem snapshots (btrfs, LVM, ZFS)
+# Names are matched exactly as redflag-YYYYMMDDTHHMMSSZ (written out as
+# [0-9] classes in the real file); LVM and ZFS rules name the root
+# volume or dataset, and LVM sizes are whole gigabytes (1G-999G)
+/usr/bin/mkdir -p /.redflag-snapshots
+/usr/bin/btrfs subvolume snapshot -r / /.redflag-snapshots/redflag-YYYYMMDDTHHMMSSZ
+/usr/sbin/btrfs subvolume snapshot -r / /.redflag-snapshots/redflag-YYYYMMDDTHHMMSSZ
+/usr/bin/btrfs subvolume delete /.redflag-snapshots/redflag-YYYYMMDDTHHMMSSZ
+/usr/sbin/btrfs subvolume delete /.redflag-snapshots/redflag-YYYYMMDDTHHMMSSZ
+/usr/sbin/lvs --noheadings --separator / -o vg_name,lv_name <root device>
+/usr/sbin/lvs --noheadings -o lv_name <volume group>
+/usr/sbin/lvcreate --snapshot --name redflag-YYYYMMDDTHHMMSSZ --size <N>G <vg/lv>
+/usr/sbin/lvremove -y --select lv_name=redflag-YYYYMMDDTHHMMSSZ
+/usr/sbin/zfs snapshot <root dataset>@redflag-YYYYMMDDTHHMMSSZ
+/usr/sbin/zfs destroy <root dataset>@redflag-YYYYMMDDTHHMMSSZ
+/usr/sbin/zfs list -H -t snapshot -o name <root dataset>
+
+# Hardware serial numbers for spec collection
+/usr/sbin/dmidecode -s *
+/usr/bin/dmidecode -s *
+
+# Agent self-update (runs the swap in a separate root unit)
+/usr/bin/systemctl start --no-block redflag-agent-update.service`}
                   </pre>
                 </div>
               </div>
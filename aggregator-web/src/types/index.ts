@@ -25,10 +25,19 @@ export interface Agent {
   reboot_required?: boolean;
   last_reboot_at?: string | null;
   reboot_reason?: string;
+  protocol_version?: number; // 0 = agent predates capability reporting
+  capabilities?: AgentCapabilities | null;
   metadata?: Record<string, any>;
   // Note: ip_address not available from API yet
 }
 
+// What an agent reports it can run; the server refuses other commands
+export interface AgentCapabilities {
+  commands: string[];
+  scanners: string[];
+  installers: string[]; // Package types
+}
+
 export interface AgentSpec {
   id: string;
   agent_id: string;
@@ -45,12 +54,12 @@ export interface AgentSpec {
 export interface UpdatePackage {
   id: string;
   agent_id: string;
-  package_type: 'apt' | 'docker' | 'yum' | 'dnf' | 'windows' | 'winget';
+  package_type: 'apt' | 'docker' | 'podman_image' | 'yum' | 'dnf' | 'windows' | 'winget';
   package_name: string;
   current_version: string;
   available_version: string;
   severity: 'low' | 'medium' | 'high' | 'critical';
-  status: 'pending' | 'approved' | 'scheduled' | 'installing' | 'installed' | 'failed' | 'checking_dependencies' | 'pending_dependencies';
+  status: 'pending' | 'approved' | 'scheduled' | 'installing' | 'installed' | 'failed' | 'degraded' | 'checking_dependencies' | 'pending_dependencies';
   // Timestamp fields - matching backend API response
   last_discovered_at: string;  // When package was first discovered
   last_updated_at: string;     // When package status was last updated
@@ -133,6 +142,16 @@ export interface DockerContainerListResponse {
   total_pages: number;
 }
 
+export interface DockerComposeStack {
+  agent_id: string;
+  agent_hostname: string;
+  project: string;
+  working_dir: string;
+  services: string[];
+  updates: number;
+  pending_approval: number;
+}
+
 export interface DockerStats {
   total_containers: number;
   running_containers: number;